@@ -51,6 +51,8 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	rm := cmd.Bool([]string{"#rm", "-rm"}, true, "Remove intermediate containers after a successful build")
 	forceRm := cmd.Bool([]string{"-force-rm"}, false, "Always remove intermediate containers")
 	pull := cmd.Bool([]string{"-pull"}, false, "Always attempt to pull a newer version of the image")
+	squash := cmd.Bool([]string{"-squash"}, false, "Squash the layers since the base image into a single layer")
+	squashParent := cmd.String([]string{"-squash-parent"}, "", "Squash the layers since this image instead of the base image")
 	dockerfileName := cmd.String([]string{"f", "-file"}, "", "Name of the Dockerfile (Default is 'PATH/Dockerfile')")
 	flMemoryString := cmd.String([]string{"m", "-memory"}, "", "Memory limit")
 	flMemorySwap := cmd.String([]string{"-memory-swap"}, "", "Total memory (memory + swap), '-1' to disable swap")
@@ -277,6 +279,13 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 		v.Set("pull", "1")
 	}
 
+	if *squash {
+		v.Set("squash", "1")
+	}
+	if *squashParent != "" {
+		v.Set("squashparent", *squashParent)
+	}
+
 	v.Set("cpusetcpus", *flCPUSetCpus)
 	v.Set("cpushares", strconv.FormatInt(*flCPUShares, 10))
 	v.Set("memory", strconv.FormatInt(memory, 10))
@@ -285,6 +294,7 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	v.Set("dockerfile", *dockerfileName)
 
 	cli.LoadConfigFile()
+	cli.configFile.LoadHelperCredentials()
 
 	headers := http.Header(make(map[string][]string))
 	buf, err := json.Marshal(cli.configFile)
@@ -115,6 +115,16 @@ func (cli *DockerCli) LoadConfigFile() (err error) {
 	return err
 }
 
+// resolveAuthConfig returns the credentials for the registry of the index,
+// getting them from the credential helper of the registry if it has one.
+func (cli *DockerCli) resolveAuthConfig(index *registry.IndexInfo) (registry.AuthConfig, error) {
+	authConfig := cli.configFile.ResolveAuthConfig(index)
+	if err := registry.GetHelperCredentials(&authConfig); err != nil && err != registry.ErrCredentialsNotFound {
+		return authConfig, err
+	}
+	return authConfig, nil
+}
+
 func (cli *DockerCli) CheckTtyInput(attachStdin, ttyMode bool) error {
 	// In order to attach to a container tty, input stream for the client must
 	// be a tty itself: redirecting or piping the client standard input is
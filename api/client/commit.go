@@ -23,6 +23,8 @@ func (cli *DockerCli) CmdCommit(args ...string) error {
 	flAuthor := cmd.String([]string{"a", "#author", "-author"}, "", "Author (e.g., \"John Hannibal Smith <hannibal@a-team.com>\")")
 	flChanges := opts.NewListOpts(nil)
 	cmd.Var(&flChanges, []string{"c", "-change"}, "Apply Dockerfile instruction to the created image")
+	flSquash := cmd.Bool([]string{"-squash"}, false, "Squash the layers of the container into a single layer")
+	flSquashParent := cmd.String([]string{"-squash-parent"}, "", "Squash the layers since this image, a parent of the container's image")
 	// FIXME: --run is deprecated, it will be replaced with inline Dockerfile commands.
 	flConfig := cmd.String([]string{"#run", "#-run"}, "", "This option is deprecated and will be removed in a future version in favor of inline Dockerfile-compatible commands")
 	cmd.Require(flag.Max, 2)
@@ -54,6 +56,12 @@ func (cli *DockerCli) CmdCommit(args ...string) error {
 	if *flPause != true {
 		v.Set("pause", "0")
 	}
+	if *flSquash {
+		v.Set("squash", "1")
+	}
+	if *flSquashParent != "" {
+		v.Set("squashparent", *flSquashParent)
+	}
 
 	var (
 		config   *runconfig.Config
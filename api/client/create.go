@@ -41,7 +41,10 @@ func (cli *DockerCli) pullImageCustomOut(image string, out io.Writer) error {
 	cli.LoadConfigFile()
 
 	// Resolve the Auth config relevant for this server
-	authConfig := cli.configFile.ResolveAuthConfig(repoInfo.Index)
+	authConfig, err := cli.resolveAuthConfig(repoInfo.Index)
+	if err != nil {
+		return err
+	}
 	buf, err := json.Marshal(authConfig)
 	if err != nil {
 		return err
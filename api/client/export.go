@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/docker/docker/pkg/archive"
 	flag "github.com/docker/docker/pkg/mflag"
 )
 
@@ -17,10 +18,15 @@ import (
 func (cli *DockerCli) CmdExport(args ...string) error {
 	cmd := cli.Subcmd("export", "CONTAINER", "Export a filesystem as a tar archive (streamed to STDOUT by default)", true)
 	outfile := cmd.String([]string{"o", "-output"}, "", "Write to a file, instead of STDOUT")
+	compress := cmd.String([]string{"-compress"}, "none", "Compress the archive (none, gzip or zstd)")
 	cmd.Require(flag.Exact, 1)
 
 	cmd.ParseFlags(args, true)
 
+	if _, err := archive.ParseCompression(*compress); err != nil {
+		return err
+	}
+
 	var (
 		output io.Writer = cli.out
 		err    error
@@ -34,13 +40,16 @@ func (cli *DockerCli) CmdExport(args ...string) error {
 		return errors.New("Cowardly refusing to save to a terminal. Use the -o flag or redirect.")
 	}
 
+	v := url.Values{}
+	if *compress != "none" {
+		v.Set("compress", *compress)
+	}
 	if len(cmd.Args()) == 1 {
 		image := cmd.Arg(0)
-		if err := cli.stream("GET", "/containers/"+image+"/export", nil, output, nil); err != nil {
+		if err := cli.stream("GET", "/containers/"+image+"/export?"+v.Encode(), nil, output, nil); err != nil {
 			return err
 		}
 	} else {
-		v := url.Values{}
 		for _, arg := range cmd.Args() {
 			v.Add("names", arg)
 		}
@@ -9,6 +9,7 @@ import (
 	"github.com/docker/docker/engine"
 	flag "github.com/docker/docker/pkg/mflag"
 	"github.com/docker/docker/pkg/units"
+	"github.com/docker/docker/registry"
 )
 
 // CmdInfo displays system-wide information.
@@ -122,8 +123,9 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 	}
 	if len(remoteInfo.GetList("IndexServerAddress")) != 0 {
 		cli.LoadConfigFile()
-		u := cli.configFile.Configs[remoteInfo.Get("IndexServerAddress")].Username
-		if len(u) > 0 {
+		authConfig := cli.configFile.Configs[remoteInfo.Get("IndexServerAddress")]
+		registry.GetHelperCredentials(&authConfig)
+		if u := authConfig.Username; len(u) > 0 {
 			fmt.Fprintf(cli.out, "Username: %v\n", u)
 			fmt.Fprintf(cli.out, "Registry: %v\n", remoteInfo.GetList("IndexServerAddress"))
 		}
@@ -25,11 +25,12 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 	cmd := cli.Subcmd("login", "[SERVER]", "Register or log in to a Docker registry server, if no server is\nspecified \""+registry.IndexServerAddress()+"\" is the default.", true)
 	cmd.Require(flag.Max, 1)
 
-	var username, password, email string
+	var username, password, email, credsHelper string
 
 	cmd.StringVar(&username, []string{"u", "-username"}, "", "Username")
 	cmd.StringVar(&password, []string{"p", "-password"}, "", "Password")
 	cmd.StringVar(&email, []string{"e", "-email"}, "", "Email")
+	cmd.StringVar(&credsHelper, []string{"-credential-helper"}, "", "Store the credentials with docker-credential-<helper>")
 
 	cmd.ParseFlags(args, true)
 
@@ -59,7 +60,13 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 	cli.LoadConfigFile()
 	authconfig, ok := cli.configFile.Configs[serverAddress]
 	if !ok {
-		authconfig = registry.AuthConfig{}
+		authconfig = registry.AuthConfig{ServerAddress: serverAddress}
+	}
+	if err := registry.GetHelperCredentials(&authconfig); err != nil && err != registry.ErrCredentialsNotFound {
+		return err
+	}
+	if credsHelper != "" {
+		authconfig.CredsHelper = credsHelper
 	}
 
 	if username == "" {
@@ -117,7 +124,13 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 
 	stream, statusCode, err := cli.call("POST", "/auth", cli.configFile.Configs[serverAddress], nil)
 	if statusCode == 401 {
-		delete(cli.configFile.Configs, serverAddress)
+		if authconfig.CredsHelper != "" {
+			// Keep using the credential helper for the registry.
+			registry.EraseHelperCredentials(&authconfig)
+			cli.configFile.Configs[serverAddress] = registry.AuthConfig{ServerAddress: serverAddress, CredsHelper: authconfig.CredsHelper}
+		} else {
+			delete(cli.configFile.Configs, serverAddress)
+		}
 		registry.SaveConfig(cli.configFile)
 		return err
 	}
@@ -131,8 +144,16 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 		return err
 	}
 
-	registry.SaveConfig(cli.configFile)
-	fmt.Fprintf(cli.out, "WARNING: login credentials saved in %s.\n", path.Join(homedir.Get(), registry.CONFIGFILE))
+	if authconfig.CredsHelper != "" {
+		if err := registry.StoreHelperCredentials(&authconfig); err != nil {
+			return err
+		}
+		registry.SaveConfig(cli.configFile)
+		fmt.Fprintf(cli.out, "Login credentials stored with credential helper %s.\n", authconfig.CredsHelper)
+	} else {
+		registry.SaveConfig(cli.configFile)
+		fmt.Fprintf(cli.out, "WARNING: login credentials saved in %s.\n", path.Join(homedir.Get(), registry.CONFIGFILE))
+	}
 
 	if response.Status != "" {
 		fmt.Fprintf(cli.out, "%s\n", response.Status)
@@ -23,7 +23,16 @@ func (cli *DockerCli) CmdLogout(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
-	if _, ok := cli.configFile.Configs[serverAddress]; !ok {
+	if authConfig, ok := cli.configFile.Configs[serverAddress]; ok && authConfig.CredsHelper != "" {
+		// The registry keeps using its credential helper.
+		if err := registry.EraseHelperCredentials(&authConfig); err == registry.ErrCredentialsNotFound {
+			fmt.Fprintf(cli.out, "Not logged in to %s\n", serverAddress)
+		} else if err != nil {
+			return err
+		} else {
+			fmt.Fprintf(cli.out, "Remove login credentials for %s\n", serverAddress)
+		}
+	} else if !ok {
 		fmt.Fprintf(cli.out, "Not logged in to %s\n", serverAddress)
 	} else {
 		fmt.Fprintf(cli.out, "Remove login credentials for %s\n", serverAddress)
@@ -2,19 +2,22 @@ package client
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/nat"
 	flag "github.com/docker/docker/pkg/mflag"
+	"github.com/docker/docker/pkg/parsers"
 )
 
 // CmdPort lists port mappings for a container.
 // If a private port is specified, it also shows the public-facing port that is NATed to the private port.
+// Consecutive ports published to consecutive public ports are shown as ranges.
 //
-// Usage: docker port CONTAINER [PRIVATE_PORT[/PROTO]]
+// Usage: docker port CONTAINER [PRIVATE_PORT[-PRIVATE_PORT][/PROTO]]
 func (cli *DockerCli) CmdPort(args ...string) error {
-	cmd := cli.Subcmd("port", "CONTAINER [PRIVATE_PORT[/PROTO]]", "List port mappings for the CONTAINER, or lookup the public-facing port that\nis NAT-ed to the PRIVATE_PORT", true)
+	cmd := cli.Subcmd("port", "CONTAINER [PRIVATE_PORT[-PRIVATE_PORT][/PROTO]]", "List port mappings for the CONTAINER, or lookup the public-facing port that\nis NAT-ed to the PRIVATE_PORT", true)
 	cmd.Require(flag.Min, 1)
 	cmd.ParseFlags(args, true)
 
@@ -44,6 +47,22 @@ func (cli *DockerCli) CmdPort(args ...string) error {
 			proto = parts[1]
 		}
 		natPort := port + "/" + proto
+		if strings.Contains(port, "-") {
+			start, end, err := parsers.ParsePortRange(port)
+			if err != nil {
+				return err
+			}
+			ranges := portRanges(ports, func(p nat.Port) bool {
+				return p.Proto() == proto && p.Int() >= int(start) && p.Int() <= int(end)
+			})
+			if len(ranges) == 0 {
+				return fmt.Errorf("Error: No public port '%s' published for %s", natPort, cmd.Arg(0))
+			}
+			for _, r := range ranges {
+				fmt.Fprintf(cli.out, "%s:%s\n", r.hostIP, r.hostPorts())
+			}
+			return nil
+		}
 		if frontends, exists := ports[nat.Port(port+"/"+proto)]; exists && frontends != nil {
 			for _, frontend := range frontends {
 				fmt.Fprintf(cli.out, "%s:%s\n", frontend.HostIp, frontend.HostPort)
@@ -53,11 +72,85 @@ func (cli *DockerCli) CmdPort(args ...string) error {
 		return fmt.Errorf("Error: No public port '%s' published for %s", natPort, cmd.Arg(0))
 	}
 
-	for from, frontends := range ports {
-		for _, frontend := range frontends {
-			fmt.Fprintf(cli.out, "%s -> %s:%s\n", from, frontend.HostIp, frontend.HostPort)
-		}
+	for _, r := range portRanges(ports, nil) {
+		fmt.Fprintf(cli.out, "%s/%s -> %s:%s\n", r.containerPorts(), r.proto, r.hostIP, r.hostPorts())
 	}
 
 	return nil
 }
+
+// portRange is a run of consecutive container ports published to consecutive
+// host ports on the same host IP.
+type portRange struct {
+	proto                     string
+	hostIP                    string
+	containerStart, hostStart int
+	count                     int
+}
+
+func (r *portRange) containerPorts() string {
+	return formatPortRange(r.containerStart, r.count)
+}
+
+func (r *portRange) hostPorts() string {
+	return formatPortRange(r.hostStart, r.count)
+}
+
+func formatPortRange(start, count int) string {
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d-%d", start, start+count-1)
+}
+
+// portRanges collapses the port mappings of the container ports matching
+// filter (all of them if filter is nil) into ranges, ordered by protocol and
+// container port.
+func portRanges(ports nat.PortMap, filter func(nat.Port) bool) []*portRange {
+	keys := make([]nat.Port, 0, len(ports))
+	for port := range ports {
+		if filter == nil || filter(port) {
+			keys = append(keys, port)
+		}
+	}
+	nat.Sort(keys, func(i, j nat.Port) bool {
+		if i.Proto() != j.Proto() {
+			return i.Proto() < j.Proto()
+		}
+		return i.Int() < j.Int()
+	})
+
+	var (
+		ranges []*portRange
+		// open ranges, keyed by the mapping which would extend them
+		next = map[string]*portRange{}
+	)
+	key := func(proto, hostIP string, containerPort, hostPort int) string {
+		return fmt.Sprintf("%s/%s:%d:%d", proto, hostIP, containerPort, hostPort)
+	}
+	for _, port := range keys {
+		for _, frontend := range ports[port] {
+			hostPort, err := strconv.Atoi(frontend.HostPort)
+			if err != nil {
+				continue
+			}
+			k := key(port.Proto(), frontend.HostIp, port.Int(), hostPort)
+			r, ok := next[k]
+			if ok {
+				delete(next, k)
+				r.count++
+			} else {
+				r = &portRange{
+					proto:          port.Proto(),
+					hostIP:         frontend.HostIp,
+					containerStart: port.Int(),
+					hostStart:      hostPort,
+					count:          1,
+				}
+				ranges = append(ranges, r)
+			}
+			next[key(port.Proto(), frontend.HostIp, port.Int()+1, hostPort+1)] = r
+		}
+	}
+	return ranges
+}
@@ -34,6 +34,7 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 		size     = cmd.Bool([]string{"s", "-size"}, false, "Display total file sizes")
 		all      = cmd.Bool([]string{"a", "-all"}, false, "Show all containers (default shows just running)")
 		noTrunc  = cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
+		digests  = cmd.Bool([]string{"-digests"}, false, "Show the digests of the images of the containers")
 		nLatest  = cmd.Bool([]string{"l", "-latest"}, false, "Show the latest created container, include non-running")
 		since    = cmd.String([]string{"#sinceId", "#-since-id", "-since"}, "", "Show created since Id or Name, include non-running")
 		before   = cmd.String([]string{"#beforeId", "#-before-id", "-before"}, "", "Show only container created before Id or Name")
@@ -99,7 +100,11 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 
 	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
 	if !*quiet {
-		fmt.Fprint(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPORTS\tNAMES")
+		fmt.Fprint(w, "CONTAINER ID\tIMAGE")
+		if *digests {
+			fmt.Fprint(w, "\tDIGEST")
+		}
+		fmt.Fprint(w, "\tCOMMAND\tCREATED\tSTATUS\tPORTS\tNAMES")
 
 		if *size {
 			fmt.Fprintln(w, "\tSIZE")
@@ -151,6 +156,14 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 			image = "<no image>"
 		}
 
+		if *digests {
+			dgst := container.ImageDigest
+			if dgst == "" {
+				dgst = "<none>"
+			}
+			image += "\t" + dgst
+		}
+
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t%s\t", ID, image, command,
 			units.HumanDuration(time.Now().UTC().Sub(time.Unix(int64(container.Created), 0))),
 			container.Status, api.NewDisplayablePorts(container.Ports), strings.Join(names, ","))
@@ -2,10 +2,12 @@ package client
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"os"
 
+	"github.com/docker/docker/pkg/archive"
 	flag "github.com/docker/docker/pkg/mflag"
 )
 
@@ -17,10 +19,20 @@ import (
 func (cli *DockerCli) CmdSave(args ...string) error {
 	cmd := cli.Subcmd("save", "IMAGE [IMAGE...]", "Save an image(s) to a tar archive (streamed to STDOUT by default)", true)
 	outfile := cmd.String([]string{"o", "-output"}, "", "Write to an file, instead of STDOUT")
+	compress := cmd.String([]string{"-compress"}, "none", "Compress the archive (none, gzip or zstd)")
+	excludeFrom := cmd.String([]string{"-exclude-layers-from"}, "", "Omit the layers of an image the receiver already has, and of its parents")
+	format := cmd.String([]string{"-format"}, "legacy", "Lay out the images in the legacy format or as an OCI image layout (legacy or oci)")
 	cmd.Require(flag.Min, 1)
 
 	cmd.ParseFlags(args, true)
 
+	if _, err := archive.ParseCompression(*compress); err != nil {
+		return err
+	}
+	if *format != "legacy" && *format != "oci" {
+		return fmt.Errorf("Unknown save format %q", *format)
+	}
+
 	var (
 		output io.Writer = cli.out
 		err    error
@@ -34,13 +46,22 @@ func (cli *DockerCli) CmdSave(args ...string) error {
 		return errors.New("Cowardly refusing to save to a terminal. Use the -o flag or redirect.")
 	}
 
+	v := url.Values{}
+	if *compress != "none" {
+		v.Set("compress", *compress)
+	}
+	if *format != "legacy" {
+		v.Set("format", *format)
+	}
+	if *excludeFrom != "" {
+		v.Set("excludelayersfrom", *excludeFrom)
+	}
 	if len(cmd.Args()) == 1 {
 		image := cmd.Arg(0)
-		if err := cli.stream("GET", "/images/"+image+"/get", nil, output, nil); err != nil {
+		if err := cli.stream("GET", "/images/"+image+"/get?"+v.Encode(), nil, output, nil); err != nil {
 			return err
 		}
 	} else {
-		v := url.Values{}
 		for _, arg := range cmd.Args() {
 			v.Add("names", arg)
 		}
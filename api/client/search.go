@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/docker/docker/opts"
 	flag "github.com/docker/docker/pkg/mflag"
 	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/parsers/filters"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/utils"
 )
@@ -20,15 +23,18 @@ func (r ByStars) Len() int           { return len(r) }
 func (r ByStars) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 func (r ByStars) Less(i, j int) bool { return r[i].StarCount < r[j].StarCount }
 
-// CmdSearch searches the Docker Hub for images.
+// CmdSearch searches the Docker Hub or a registry for images.
 //
 // Usage: docker search [OPTIONS] TERM
 func (cli *DockerCli) CmdSearch(args ...string) error {
-	cmd := cli.Subcmd("search", "TERM", "Search the Docker Hub for images", true)
+	cmd := cli.Subcmd("search", "TERM", "Search the Docker Hub or a registry for images", true)
 	noTrunc := cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
 	trusted := cmd.Bool([]string{"#t", "#trusted", "#-trusted"}, false, "Only show trusted builds")
 	automated := cmd.Bool([]string{"-automated"}, false, "Only show automated builds")
 	stars := cmd.Uint([]string{"s", "#stars", "-stars"}, 0, "Only displays with at least x stars")
+	limit := cmd.Int([]string{"-limit"}, registry.DefaultSearchLimit, "Max number of search results")
+	flFilter := opts.NewListOpts(nil)
+	cmd.Var(&flFilter, []string{"f", "-filter"}, "Filter output based on conditions provided")
 	cmd.Require(flag.Exact, 1)
 
 	cmd.ParseFlags(args, true)
@@ -36,6 +42,28 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 	name := cmd.Arg(0)
 	v := url.Values{}
 	v.Set("term", name)
+	v.Set("limit", strconv.Itoa(*limit))
+
+	searchFilters := filters.Args{}
+	for _, f := range flFilter.GetAll() {
+		var err error
+		if searchFilters, err = filters.ParseFlag(f, searchFilters); err != nil {
+			return err
+		}
+	}
+	if *automated || *trusted {
+		searchFilters["is-automated"] = append(searchFilters["is-automated"], "true")
+	}
+	if *stars > 0 {
+		searchFilters["stars"] = append(searchFilters["stars"], strconv.Itoa(int(*stars)))
+	}
+	if len(searchFilters) > 0 {
+		filterJSON, err := filters.ToParam(searchFilters)
+		if err != nil {
+			return err
+		}
+		v.Set("filters", filterJSON)
+	}
 
 	// Resolve the Repository name from fqn to hostname + name
 	taglessRemote, _ := parsers.ParseRepositoryTag(name)
@@ -62,6 +90,7 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 	w := tabwriter.NewWriter(cli.out, 10, 1, 3, ' ', 0)
 	fmt.Fprintf(w, "NAME\tDESCRIPTION\tSTARS\tOFFICIAL\tAUTOMATED\n")
 	for _, res := range results {
+		// Daemons older than the filters parameter return unfiltered results
 		if ((*automated || *trusted) && (!res.IsTrusted && !res.IsAutomated)) || (int(*stars) > res.StarCount) {
 			continue
 		}
@@ -141,14 +141,19 @@ func (cli *DockerCli) clientRequestAttemptLogin(method, path string, in io.Reade
 	}
 
 	// Resolve the Auth config relevant for this server
-	authConfig := cli.configFile.ResolveAuthConfig(index)
+	authConfig, err := cli.resolveAuthConfig(index)
+	if err != nil {
+		return nil, -1, err
+	}
 	body, statusCode, err := cmdAttempt(authConfig)
 	if statusCode == http.StatusUnauthorized {
 		fmt.Fprintf(cli.out, "\nPlease login prior to %s:\n", cmdName)
 		if err = cli.CmdLogin(index.GetAuthConfigKey()); err != nil {
 			return nil, -1, err
 		}
-		authConfig = cli.configFile.ResolveAuthConfig(index)
+		if authConfig, err = cli.resolveAuthConfig(index); err != nil {
+			return nil, -1, err
+		}
 		return cmdAttempt(authConfig)
 	}
 	return body, statusCode, err
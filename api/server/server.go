@@ -251,7 +251,11 @@ func getContainersExport(eng *engine.Engine, version version.Version, w http.Res
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
 	job := eng.Job("export", vars["name"])
+	job.Setenv("compress", r.Form.Get("compress"))
 	job.Stdout.Add(w)
 	if err := job.Run(); err != nil {
 		return err
@@ -626,6 +630,8 @@ func postCommit(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	job.Setenv("tag", r.Form.Get("tag"))
 	job.Setenv("author", r.Form.Get("author"))
 	job.Setenv("comment", r.Form.Get("comment"))
+	job.Setenv("squash", r.Form.Get("squash"))
+	job.Setenv("squashparent", r.Form.Get("squashparent"))
 	job.SetenvList("changes", r.Form["changes"])
 	job.SetenvSubEnv("config", &config)
 
@@ -723,8 +729,20 @@ func getImagesSearch(eng *engine.Engine, version version.Version, w http.Respons
 			headers[k] = v
 		}
 	}
+	limit := registry.DefaultSearchLimit
+	if r.Form.Get("limit") != "" {
+		l, err := strconv.Atoi(r.Form.Get("limit"))
+		if err != nil || l < 1 || l > registry.MaxSearchLimit {
+			return fmt.Errorf("Bad parameter: limit %q is outside the range of [1, %d]", r.Form.Get("limit"), registry.MaxSearchLimit)
+		}
+		limit = l
+	}
+	searchFilters, err := filters.FromParam(r.Form.Get("filters"))
+	if err != nil {
+		return err
+	}
 	d := getDaemon(eng)
-	query, err := d.RegistryService.Search(r.Form.Get("term"), config, headers)
+	query, err := d.RegistryService.Search(r.Form.Get("term"), limit, searchFilters, config, headers)
 	if err != nil {
 		return err
 	}
@@ -799,6 +817,9 @@ func getImagesGet(eng *engine.Engine, version version.Version, w http.ResponseWr
 	} else {
 		job = eng.Job("image_export", r.Form["names"]...)
 	}
+	job.Setenv("compress", r.Form.Get("compress"))
+	job.Setenv("format", r.Form.Get("format"))
+	job.Setenv("excludelayersfrom", r.Form.Get("excludelayersfrom"))
 	job.Stdout.Add(w)
 	return job.Run()
 }
@@ -914,6 +935,17 @@ func deleteImages(eng *engine.Engine, version version.Version, w http.ResponseWr
 	return job.Run()
 }
 
+func postImagesGC(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	var job = eng.Job("image_gc")
+	streamJSON(job, w, false)
+	job.Setenv("dryrun", r.Form.Get("dryrun"))
+
+	return job.Run()
+}
+
 func postContainersStart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1167,6 +1199,8 @@ func postBuild(eng *engine.Engine, version version.Version, w http.ResponseWrite
 	job.Setenv("q", r.FormValue("q"))
 	job.Setenv("nocache", r.FormValue("nocache"))
 	job.Setenv("forcerm", r.FormValue("forcerm"))
+	job.Setenv("squash", r.FormValue("squash"))
+	job.Setenv("squashparent", r.FormValue("squashparent"))
 	job.SetenvJson("authConfig", authConfig)
 	job.SetenvJson("configFile", configFile)
 	job.Setenv("memswap", r.FormValue("memswap"))
@@ -1425,6 +1459,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			"/build":                        postBuild,
 			"/images/create":                postImagesCreate,
 			"/images/load":                  postImagesLoad,
+			"/images/gc":                    postImagesGC,
 			"/images/{name:.*}/push":        postImagesPush,
 			"/images/{name:.*}/tag":         postImagesTag,
 			"/containers/create":            postContainersCreate,
@@ -345,6 +345,16 @@ func TestLogsNoStreams(t *testing.T) {
 	}
 }
 
+func TestGetImagesSearchInvalidLimit(t *testing.T) {
+	eng := engine.New()
+	for _, limit := range []string{"abc", "0", "101"} {
+		r := serveRequest("GET", "/images/search?term=busybox&limit="+limit, nil, eng, t)
+		if r.Code != http.StatusBadRequest {
+			t.Fatalf("Got status %d for limit %s, expected %d", r.Code, limit, http.StatusBadRequest)
+		}
+	}
+}
+
 func TestGetImagesHistory(t *testing.T) {
 	eng := engine.New()
 	imageName := "docker-test-image"
@@ -78,14 +78,15 @@ type Port struct {
 }
 
 type Container struct {
-	ID         string            `json:"Id"`
-	Names      []string          `json:,omitempty"`
-	Image      string            `json:,omitempty"`
-	Command    string            `json:,omitempty"`
-	Created    int               `json:,omitempty"`
-	Ports      []Port            `json:,omitempty"`
-	SizeRw     int               `json:,omitempty"`
-	SizeRootFs int               `json:,omitempty"`
-	Labels     map[string]string `json:,omitempty"`
-	Status     string            `json:,omitempty"`
+	ID          string            `json:"Id"`
+	Names       []string          `json:,omitempty"`
+	Image       string            `json:,omitempty"`
+	ImageDigest string            `json:",omitempty"`
+	Command     string            `json:,omitempty"`
+	Created     int               `json:,omitempty"`
+	Ports       []Port            `json:,omitempty"`
+	SizeRw      int               `json:,omitempty"`
+	SizeRootFs  int               `json:,omitempty"`
+	Labels      map[string]string `json:,omitempty"`
+	Status      string            `json:,omitempty"`
 }
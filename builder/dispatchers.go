@@ -154,6 +154,7 @@ func from(b *Builder, args []string, attributes map[string]bool, original string
 
 	if name == NoBaseImageSpecifier {
 		b.image = ""
+		b.baseImage = ""
 		b.noBaseImage = true
 		return nil
 	}
@@ -96,6 +96,11 @@ type Builder struct {
 	ForceRemove bool
 	Pull        bool
 
+	// squash the layers of the build since the base image, or since
+	// SquashParent if set, into a single layer.
+	Squash       bool
+	SquashParent string
+
 	// set this to true if we want the builder to not commit between steps.
 	// This is useful when we only want to use the evaluator table to generate
 	// the final configs of the Dockerfile but dont want the layers
@@ -116,6 +121,7 @@ type Builder struct {
 	dockerfileName string        // name of Dockerfile
 	dockerfile     *parser.Node  // the syntax tree of the dockerfile
 	image          string        // image name for commit processing
+	baseImage      string        // image the build starts from, empty without base image
 	maintainer     string        // maintainer name. could probably be removed.
 	cmdSet         bool          // indicates is CMD was set in current Dockerfile
 	context        tarsum.TarSum // the context is a tarball that is uploaded by the client
@@ -188,6 +194,20 @@ func (b *Builder) Run(context io.Reader) (string, error) {
 		return "", fmt.Errorf("No image was generated. Is your Dockerfile empty?")
 	}
 
+	if b.Squash || b.SquashParent != "" {
+		parent := b.SquashParent
+		if parent == "" {
+			parent = b.baseImage
+		}
+		fmt.Fprintf(b.OutStream, "Squashing the layers\n")
+		img, err := b.Daemon.Squash(b.image, parent)
+		if err != nil {
+			return "", err
+		}
+		b.image = img.ID
+		fmt.Fprintf(b.OutStream, " ---> %s\n", stringid.TruncateID(b.image))
+	}
+
 	fmt.Fprintf(b.OutStream, "Successfully built %s\n", stringid.TruncateID(b.image))
 	return b.image, nil
 }
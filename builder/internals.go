@@ -462,6 +462,7 @@ func (b *Builder) pullImage(name string) (*imagepkg.Image, error) {
 
 func (b *Builder) processImageFrom(img *imagepkg.Image) error {
 	b.image = img.ID
+	b.baseImage = img.ID
 
 	if img.Config != nil {
 		b.Config = img.Config
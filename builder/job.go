@@ -59,6 +59,8 @@ func (b *BuilderJob) CmdBuild(job *engine.Job) error {
 		rm             = job.GetenvBool("rm")
 		forceRm        = job.GetenvBool("forcerm")
 		pull           = job.GetenvBool("pull")
+		squash         = job.GetenvBool("squash")
+		squashParent   = job.Getenv("squashparent")
 		memory         = job.GetenvInt64("memory")
 		memorySwap     = job.GetenvInt64("memswap")
 		cpuShares      = job.GetenvInt64("cpushares")
@@ -146,6 +148,8 @@ func (b *BuilderJob) CmdBuild(job *engine.Job) error {
 		Remove:          rm,
 		ForceRemove:     forceRm,
 		Pull:            pull,
+		Squash:          squash,
+		SquashParent:    squashParent,
 		OutOld:          job.Stdout,
 		StreamFormatter: sf,
 		AuthConfig:      authConfig,
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/runconfig"
@@ -44,14 +45,71 @@ func (daemon *Daemon) ContainerCommit(job *engine.Job) error {
 		return err
 	}
 
-	img, err := daemon.Commit(container, job.Getenv("repo"), job.Getenv("tag"), job.Getenv("comment"), job.Getenv("author"), job.GetenvBool("pause"), &newConfig)
+	var (
+		repo, tag    = job.Getenv("repo"), job.Getenv("tag")
+		squashParent = job.Getenv("squashparent")
+		squash       = job.GetenvBool("squash") || squashParent != ""
+	)
+	if squash {
+		// The squashed image is tagged instead
+		repo, tag = "", ""
+	}
+	img, err := daemon.Commit(container, repo, tag, job.Getenv("comment"), job.Getenv("author"), job.GetenvBool("pause"), &newConfig)
 	if err != nil {
 		return err
 	}
+	if squash {
+		committed := img
+		if img, err = daemon.Squash(committed.ID, squashParent); err != nil {
+			return err
+		}
+		if committed.ID != img.ID {
+			daemon.removeUnusedImage(committed.ID)
+		}
+		if repo, tag = job.Getenv("repo"), job.Getenv("tag"); repo != "" {
+			if err := daemon.repositories.Set(repo, tag, img.ID, true); err != nil {
+				return err
+			}
+		}
+	}
 	job.Printf("%s\n", img.ID)
 	return nil
 }
 
+// Squash squashes the layers of the image with the given ID since the image
+// parent, a name or ID, or all its layers if parent is empty, into a single
+// layer, see graph.Squash.
+func (daemon *Daemon) Squash(id, parent string) (*image.Image, error) {
+	if parent != "" {
+		parentImg, err := daemon.repositories.LookupImage(parent)
+		if err != nil {
+			return nil, err
+		}
+		parent = parentImg.ID
+	}
+	return daemon.graph.Squash(id, parent)
+}
+
+// removeUnusedImage removes the image with the given ID if it has no tags
+// and no children and no container uses it.
+func (daemon *Daemon) removeUnusedImage(id string) {
+	if len(daemon.repositories.ByID()[id]) > 0 {
+		return
+	}
+	byParent, err := daemon.graph.ByParent()
+	if err != nil || len(byParent[id]) > 0 {
+		return
+	}
+	if err := daemon.canDeleteImage(id, false); err != nil {
+		return
+	}
+	if err := daemon.graph.Delete(id); err != nil {
+		logrus.Errorf("Error removing image %s: %s", id, err)
+		return
+	}
+	daemon.EventsService.Log("delete", id, "")
+}
+
 // Commit creates a new filesystem image from the current state of a container.
 // The image can optionally be tagged into a repository
 func (daemon *Daemon) Commit(container *Container, repository, tag, comment, author string, pause bool, config *runconfig.Config) (*image.Image, error) {
@@ -2,6 +2,7 @@ package daemon
 
 import (
 	"net"
+	"time"
 
 	"github.com/docker/docker/daemon/networkdriver"
 	"github.com/docker/docker/opts"
@@ -25,11 +26,14 @@ type Config struct {
 	AutoRestart                 bool
 	Dns                         []string
 	DnsSearch                   []string
+	EmbeddedDns                 bool
 	EnableIPv6                  bool
 	EnableIptables              bool
 	EnableIpForward             bool
 	EnableIpMasq                bool
+	EnableUserlandProxy         bool
 	DefaultIp                   net.IP
+	EphemeralPortRange          string
 	BridgeIface                 string
 	BridgeIP                    string
 	FixedCIDR                   string
@@ -49,6 +53,17 @@ type Config struct {
 	Labels                      []string
 	Ulimits                     map[string]*ulimit.Ulimit
 	LogConfig                   runconfig.LogConfig
+	ImageGCInterval             time.Duration
+	ImageGCThreshold            int
+	ImageGCKeep                 []string
+	ImageGCEvictTagged          bool
+	TrustPolicy                 string
+	SigningKey                  string
+	SigningChain                string
+	MaxConcurrentDownloads      int
+	MaxConcurrentUploads        int
+	MaxBandwidth                string
+	LazyLayers                  bool
 }
 
 // InstallFlags adds command-line options to the top-level flag parser for
@@ -63,6 +78,7 @@ func (config *Config) InstallFlags() {
 	flag.BoolVar(&config.EnableIpForward, []string{"#ip-forward", "-ip-forward"}, true, "Enable net.ipv4.ip_forward")
 	flag.BoolVar(&config.EnableIpMasq, []string{"-ip-masq"}, true, "Enable IP masquerading")
 	flag.BoolVar(&config.EnableIPv6, []string{"-ipv6"}, false, "Enable IPv6 networking")
+	flag.BoolVar(&config.EnableUserlandProxy, []string{"-userland-proxy"}, true, "Use userland proxy for loopback traffic")
 	flag.StringVar(&config.BridgeIP, []string{"#bip", "-bip"}, "", "Specify network bridge IP")
 	flag.StringVar(&config.BridgeIface, []string{"b", "-bridge"}, "", "Attach containers to a network bridge")
 	flag.StringVar(&config.FixedCIDR, []string{"-fixed-cidr"}, "", "IPv4 subnet for fixed IPs")
@@ -76,14 +92,27 @@ func (config *Config) InstallFlags() {
 	flag.BoolVar(&config.EnableCors, []string{"#api-enable-cors", "#-api-enable-cors"}, false, "Enable CORS headers in the remote API, this is deprecated by --api-cors-header")
 	flag.StringVar(&config.CorsHeaders, []string{"-api-cors-header"}, "", "Set CORS headers in the remote API")
 	opts.IPVar(&config.DefaultIp, []string{"#ip", "-ip"}, "0.0.0.0", "Default IP when binding container ports")
+	flag.StringVar(&config.EphemeralPortRange, []string{"-ephemeral-port-range"}, "", "Host port range to publish container ports to when no host port is given (e.g. 49153-65535)")
 	opts.ListVar(&config.GraphOptions, []string{"-storage-opt"}, "Set storage driver options")
 	// FIXME: why the inconsistency between "hosts" and "sockets"?
 	opts.IPListVar(&config.Dns, []string{"#dns", "-dns"}, "DNS server to use")
 	opts.DnsSearchListVar(&config.DnsSearch, []string{"-dns-search"}, "DNS search domains to use")
+	flag.BoolVar(&config.EmbeddedDns, []string{"-embedded-dns"}, false, "Resolve container names with the embedded DNS server")
 	opts.LabelListVar(&config.Labels, []string{"-label"}, "Set key=value labels to the daemon")
 	config.Ulimits = make(map[string]*ulimit.Ulimit)
 	opts.UlimitMapVar(config.Ulimits, []string{"-default-ulimit"}, "Set default ulimits for containers")
 	flag.StringVar(&config.LogConfig.Type, []string{"-log-driver"}, "json-file", "Containers logging driver")
+	flag.DurationVar(&config.ImageGCInterval, []string{"-image-gc-interval"}, 0, "Remove unused images periodically, 0 disables")
+	flag.IntVar(&config.ImageGCThreshold, []string{"-image-gc-threshold"}, 0, "Remove unused images when the disk usage of the graph root exceeds this percentage, 0 disables")
+	flag.BoolVar(&config.ImageGCEvictTagged, []string{"-image-gc-evict-tagged"}, false, "Also remove unused tagged images while the disk usage of the graph root exceeds --image-gc-threshold")
+	opts.ImageGCKeepListVar(&config.ImageGCKeep, []string{"-image-gc-keep"}, "Never remove images matching label=KEY[=VALUE] or repo=PATTERN when collecting images")
+	flag.StringVar(&config.TrustPolicy, []string{"-trust-policy"}, "", "Path to the policy of keys images of repositories must be signed by")
+	flag.StringVar(&config.SigningKey, []string{"-signing-key"}, "", "Path to a private key to also sign pushed images with")
+	flag.StringVar(&config.SigningChain, []string{"-signing-chain"}, "", "Path to the certificate chain of the signing key")
+	flag.IntVar(&config.MaxConcurrentDownloads, []string{"-max-concurrent-downloads"}, 3, "Max number of layers downloaded at the same time, 0 for no limit")
+	flag.IntVar(&config.MaxConcurrentUploads, []string{"-max-concurrent-uploads"}, 5, "Max number of layers uploaded at the same time, 0 for no limit")
+	flag.StringVar(&config.MaxBandwidth, []string{"-max-bandwidth"}, "", "Max bandwidth of all the image transfers per second, e.g. 10m")
+	flag.BoolVar(&config.LazyLayers, []string{"-lazy-layers"}, false, "Push indexed layers and run images before their indexed layers are fully pulled (experimental)")
 }
 
 func getDefaultNetworkMtu() int {
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -72,6 +73,9 @@ type Container struct {
 
 	Config  *runconfig.Config
 	ImageID string `json:"Image"`
+	// ImageDigest is the digest of the manifest of the image the container
+	// was created from, if the image was pulled or pushed by digest.
+	ImageDigest string
 
 	NetworkSettings *NetworkSettings
 
@@ -280,6 +284,7 @@ func populateCommand(c *Container, env []string) error {
 				GlobalIPv6Address:    network.GlobalIPv6Address,
 				GlobalIPv6PrefixLen:  network.GlobalIPv6PrefixLen,
 				IPv6Gateway:          network.IPv6Gateway,
+				HairpinMode:          !c.daemon.config.EnableUserlandProxy,
 			}
 		}
 	case "container":
@@ -578,6 +583,9 @@ func (container *Container) AllocateNetwork() error {
 
 	job := eng.Job("allocate_interface", container.ID)
 	job.Setenv("RequestedMac", container.Config.MacAddress)
+	job.Setenv("RequestedIP", container.hostConfig.IPv4Address)
+	job.Setenv("RequestedIPv6", container.hostConfig.IPv6Address)
+	job.SetenvList("EgressRules", container.hostConfig.EgressRules)
 	if env, err = job.Stdout.AddEnv(); err != nil {
 		return err
 	}
@@ -624,8 +632,13 @@ func (container *Container) AllocateNetwork() error {
 
 	container.NetworkSettings.PortMapping = nil
 
-	for port := range portSpecs {
-		if err = container.allocatePort(eng, port, bindings); err != nil {
+	for _, ports := range container.portRanges(portSpecs, bindings) {
+		if len(ports) == 1 {
+			err = container.allocatePort(eng, ports[0], bindings)
+		} else {
+			err = container.allocatePortRange(eng, ports, bindings)
+		}
+		if err != nil {
 			eng.Job("release_interface", container.ID).Run()
 			return err
 		}
@@ -674,10 +687,12 @@ func (container *Container) RestoreNetwork() error {
 
 	eng := container.daemon.eng
 
-	// Re-allocate the interface with the same IP and MAC address.
+	// Re-allocate the interface with the same IP addresses and MAC address.
 	job := eng.Job("allocate_interface", container.ID)
 	job.Setenv("RequestedIP", container.NetworkSettings.IPAddress)
+	job.Setenv("RequestedIPv6", container.NetworkSettings.GlobalIPv6Address)
 	job.Setenv("RequestedMac", container.NetworkSettings.MacAddress)
+	job.SetenvList("EgressRules", container.hostConfig.EgressRules)
 	if err := job.Run(); err != nil {
 		return err
 	}
@@ -864,12 +879,12 @@ func (container *Container) ExportRw() (archive.Archive, error) {
 		nil
 }
 
-func (container *Container) Export() (archive.Archive, error) {
+func (container *Container) Export(compression archive.Compression) (archive.Archive, error) {
 	if err := container.Mount(); err != nil {
 		return nil, err
 	}
 
-	archive, err := archive.Tar(container.basefs, archive.Uncompressed)
+	archive, err := archive.Tar(container.basefs, compression)
 	if err != nil {
 		container.Unmount()
 		return nil, err
@@ -1111,6 +1126,18 @@ func (container *Container) setupContainerDns() error {
 	}
 
 	if config.NetworkMode != "host" {
+		// point the container at the embedded DNS server, which forwards
+		// anything that isn't a container name to the host's nameservers
+		if container.usesEmbeddedDns() {
+			dnsSearch := resolvconf.GetSearchDomains(resolvConf)
+			if len(config.DnsSearch) > 0 {
+				dnsSearch = config.DnsSearch
+			} else if len(daemon.config.DnsSearch) > 0 {
+				dnsSearch = daemon.config.DnsSearch
+			}
+			return resolvconf.Build(container.ResolvConfPath, []string{daemon.resolverIP.String()}, dnsSearch)
+		}
+
 		// check configurations for any container/daemon dns settings
 		if len(config.Dns) > 0 || len(daemon.config.Dns) > 0 || len(config.DnsSearch) > 0 || len(daemon.config.DnsSearch) > 0 {
 			var (
@@ -1151,7 +1178,7 @@ func (container *Container) setupContainerDns() error {
 // container's resolv.conf will be updated to match the host's new resolv.conf
 func (container *Container) updateResolvConf(updatedResolvConf []byte, newResolvHash string) error {
 
-	if container.ResolvConfPath == "" {
+	if container.ResolvConfPath == "" || container.usesEmbeddedDns() {
 		return nil
 	}
 	if container.Running {
@@ -1505,6 +1532,86 @@ func (container *Container) allocatePort(eng *engine.Engine, port nat.Port, bind
 	return nil
 }
 
+// portRanges groups the exposed ports into runs of consecutive ports of the
+// same protocol which are published to a single ephemeral host port on the
+// same host IP, so that they can be published to contiguous host ports. All
+// other ports end up in a group of their own.
+func (container *Container) portRanges(portSpecs nat.PortSet, bindings nat.PortMap) [][]nat.Port {
+	ports := make([]nat.Port, 0, len(portSpecs))
+	for port := range portSpecs {
+		ports = append(ports, port)
+	}
+	nat.Sort(ports, func(i, j nat.Port) bool {
+		if i.Proto() != j.Proto() {
+			return i.Proto() < j.Proto()
+		}
+		return i.Int() < j.Int()
+	})
+
+	ephemeral := func(port nat.Port) (string, bool) {
+		binding := bindings[port]
+		if len(binding) == 0 && container.hostConfig.PublishAllPorts {
+			return "", true
+		}
+		if len(binding) == 1 && binding[0].HostPort == "" {
+			return binding[0].HostIp, true
+		}
+		return "", false
+	}
+
+	var (
+		groups [][]nat.Port
+		prev   nat.Port
+		prevIP string
+		prevOk bool
+	)
+	for _, port := range ports {
+		hostIP, ok := ephemeral(port)
+		if ok && prevOk && prev.Proto() == port.Proto() && prev.Int()+1 == port.Int() && prevIP == hostIP {
+			groups[len(groups)-1] = append(groups[len(groups)-1], port)
+		} else {
+			groups = append(groups, []nat.Port{port})
+		}
+		prev, prevIP, prevOk = port, hostIP, ok
+	}
+	return groups
+}
+
+// allocatePortRange publishes a run of consecutive container ports to as many
+// contiguous ephemeral host ports.
+func (container *Container) allocatePortRange(eng *engine.Engine, ports []nat.Port, bindings nat.PortMap) error {
+	var (
+		first  = ports[0]
+		hostIP string
+	)
+	if binding := bindings[first]; len(binding) > 0 {
+		hostIP = binding[0].HostIp
+	}
+
+	job := eng.Job("allocate_port", container.ID)
+	job.Setenv("HostIP", hostIP)
+	job.Setenv("Proto", first.Proto())
+	job.Setenv("ContainerPort", first.Port())
+	job.SetenvInt("PortCount", len(ports))
+
+	portEnv, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	hostPort := portEnv.GetInt("HostPort")
+	for i, port := range ports {
+		bindings[port] = []nat.PortBinding{{
+			HostIp:   portEnv.Get("HostIP"),
+			HostPort: strconv.Itoa(hostPort + i),
+		}}
+	}
+	return nil
+}
+
 func (container *Container) GetProcessLabel() string {
 	// even if we have a process label return "" if we are running
 	// in privileged mode
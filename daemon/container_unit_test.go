@@ -2,6 +2,7 @@ package daemon
 
 import (
 	"github.com/docker/docker/nat"
+	"github.com/docker/docker/runconfig"
 	"testing"
 )
 
@@ -166,6 +167,52 @@ func TestParseNetworkOptsUdp(t *testing.T) {
 	}
 }
 
+func TestPortRanges(t *testing.T) {
+	ports, bindings, err := nat.ParsePortSpecs([]string{
+		"7000-7002",
+		"127.0.0.1::7003",
+		"8080:80",
+		"81",
+		"53-54/udp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &Container{hostConfig: &runconfig.HostConfig{}}
+
+	groups := container.portRanges(ports, bindings)
+	expected := [][]string{
+		{"7000/tcp", "7001/tcp", "7002/tcp"},
+		{"7003/tcp"},
+		{"80/tcp"},
+		{"81/tcp"},
+		{"53/udp", "54/udp"},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("Expected %d groups, got %v", len(expected), groups)
+	}
+	for _, group := range groups {
+		found := false
+		for _, e := range expected {
+			if len(e) != len(group) {
+				continue
+			}
+			found = true
+			for i := range e {
+				if string(group[i]) != e[i] {
+					found = false
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Unexpected group %v, expected one of %v", group, expected)
+		}
+	}
+}
+
 func TestGetFullName(t *testing.T) {
 	name, err := GetFullContainerName("testing")
 	if err != nil {
@@ -2,12 +2,18 @@ package daemon
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"strings"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/graph"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/nat"
 	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/units"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/libcontainer/label"
 )
@@ -44,6 +50,13 @@ func (daemon *Daemon) ContainerCreate(job *engine.Job) error {
 		return fmt.Errorf("You should always set the Memory limit when using Memoryswap limit, see usage.\n")
 	}
 
+	if err := daemon.verifyStaticIPs(hostConfig); err != nil {
+		return err
+	}
+	if err := daemon.verifyEgressRules(hostConfig); err != nil {
+		return err
+	}
+
 	container, buildWarnings, err := daemon.Create(config, hostConfig, name)
 	if err != nil {
 		if daemon.Graph().IsNotExist(err, config.Image) {
@@ -70,13 +83,15 @@ func (daemon *Daemon) ContainerCreate(job *engine.Job) error {
 }
 
 // Create creates a new container from the given configuration with a given name.
-func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.HostConfig, name string) (*Container, []string, error) {
+func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.HostConfig, name string) (_ *Container, _ []string, retErr error) {
 	var (
-		container *Container
-		warnings  []string
-		img       *image.Image
-		imgID     string
-		err       error
+		container  *Container
+		warnings   []string
+		img        *image.Image
+		imgID      string
+		imgDigest  string
+		rootfsSize uint64
+		err        error
 	)
 
 	if config.Image != "" {
@@ -87,7 +102,17 @@ func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.Hos
 		if err = img.CheckDepth(); err != nil {
 			return nil, nil, err
 		}
+		if err = daemon.graph.LayerError(img.ID); err != nil {
+			return nil, nil, err
+		}
+		if err = daemon.checkImageTrust(img); err != nil {
+			return nil, nil, err
+		}
 		imgID = img.ID
+		imgDigest = daemon.repositories.ImageDigest(config.Image, imgID)
+		if err := daemon.graph.Touch(imgID); err != nil {
+			logrus.Debugf("Unable to record the use of image %s: %s", imgID, err)
+		}
 	}
 
 	if warnings, err = daemon.mergeAndVerifyConfig(config, img); err != nil {
@@ -102,13 +127,22 @@ func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.Hos
 			return nil, nil, err
 		}
 	}
+	if rootfsSize, err = daemon.verifyStorageOpt(hostConfig); err != nil {
+		return nil, nil, err
+	}
 	if container, err = daemon.newContainer(name, config, imgID); err != nil {
 		return nil, nil, err
 	}
+	container.ImageDigest = imgDigest
 	if err := daemon.Register(container); err != nil {
 		return nil, nil, err
 	}
-	if err := daemon.createRootfs(container); err != nil {
+	defer func() {
+		if retErr != nil {
+			daemon.unregisterFailed(container)
+		}
+	}()
+	if err := daemon.createRootfs(container, rootfsSize); err != nil {
 		return nil, nil, err
 	}
 	if hostConfig != nil {
@@ -129,6 +163,142 @@ func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.Hos
 	return container, warnings, nil
 }
 
+// unregisterFailed removes a registered container which failed to be
+// created, with its name and what was created of its root filesystem.
+func (daemon *Daemon) unregisterFailed(container *Container) {
+	container.derefVolumes()
+	daemon.containers.Delete(container.ID)
+	daemon.idIndex.Delete(container.ID)
+	if _, err := daemon.containerGraph.Purge(container.ID); err != nil {
+		logrus.Debugf("Unable to remove container %s from link graph: %s", container.ID, err)
+	}
+	// The layers may not have been created
+	daemon.driver.Remove(container.ID)
+	daemon.driver.Remove(fmt.Sprintf("%s-init", container.ID))
+	if err := os.RemoveAll(container.root); err != nil {
+		logrus.Errorf("Unable to remove the directory of container %s: %s", container.ID, err)
+	}
+}
+
+// verifyStaticIPs checks that the addresses requested with --ip and --ip6 are
+// within the daemon's fixed subnets and not reserved by another container.
+func (daemon *Daemon) verifyStaticIPs(hostConfig *runconfig.HostConfig) error {
+	if hostConfig.IPv4Address == "" && hostConfig.IPv6Address == "" {
+		return nil
+	}
+	if !hostConfig.NetworkMode.IsPrivate() {
+		return runconfig.ErrConflictNetworkAndIP
+	}
+	if daemon.config.DisableNetwork {
+		return fmt.Errorf("Cannot assign a static IP address with networking disabled in the daemon")
+	}
+
+	if hostConfig.IPv4Address != "" {
+		ip := net.ParseIP(hostConfig.IPv4Address)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("Invalid IPv4 address: %s", hostConfig.IPv4Address)
+		}
+		if daemon.config.FixedCIDR != "" {
+			_, subnet, err := net.ParseCIDR(daemon.config.FixedCIDR)
+			if err != nil {
+				return err
+			}
+			if !subnet.Contains(ip) {
+				return fmt.Errorf("Requested IP address %s is not within the fixed subnet %s", ip, subnet)
+			}
+		}
+	}
+	if hostConfig.IPv6Address != "" {
+		ip := net.ParseIP(hostConfig.IPv6Address)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("Invalid IPv6 address: %s", hostConfig.IPv6Address)
+		}
+		if daemon.config.FixedCIDRv6 == "" {
+			return fmt.Errorf("Cannot assign a static IPv6 address without --fixed-cidr-v6 set in the daemon")
+		}
+		_, subnet, err := net.ParseCIDR(daemon.config.FixedCIDRv6)
+		if err != nil {
+			return err
+		}
+		if !subnet.Contains(ip) {
+			return fmt.Errorf("Requested IPv6 address %s is not within the fixed subnet %s", ip, subnet)
+		}
+	}
+
+	for _, c := range daemon.List() {
+		if c.hostConfig == nil {
+			continue
+		}
+		if hostConfig.IPv4Address != "" && c.hostConfig.IPv4Address == hostConfig.IPv4Address {
+			return fmt.Errorf("Requested IP address %s is already assigned to container %s", hostConfig.IPv4Address, c.Name[1:])
+		}
+		if hostConfig.IPv6Address != "" && c.hostConfig.IPv6Address == hostConfig.IPv6Address {
+			return fmt.Errorf("Requested IPv6 address %s is already assigned to container %s", hostConfig.IPv6Address, c.Name[1:])
+		}
+	}
+	return nil
+}
+
+// verifyEgressRules checks that the outgoing traffic of the container can be
+// restricted to the destinations requested with --egress.
+func (daemon *Daemon) verifyEgressRules(hostConfig *runconfig.HostConfig) error {
+	if len(hostConfig.EgressRules) == 0 {
+		return nil
+	}
+	if !hostConfig.NetworkMode.IsPrivate() {
+		return runconfig.ErrConflictNetworkAndEgress
+	}
+	if !daemon.config.EnableIptables {
+		return fmt.Errorf("Cannot restrict outgoing traffic with --iptables=false set in the daemon")
+	}
+	if daemon.config.EnableIPv6 {
+		return fmt.Errorf("Cannot restrict outgoing traffic with --ipv6 set in the daemon")
+	}
+	for _, rule := range hostConfig.EgressRules {
+		if _, err := nat.ParseEgressRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyStorageOpt checks the storage options of the container and returns
+// the size its writable layer is limited to with --storage-opt size, 0 if
+// it isn't limited.
+func (daemon *Daemon) verifyStorageOpt(hostConfig *runconfig.HostConfig) (uint64, error) {
+	var size uint64
+	for key, val := range hostConfig.StorageOpt {
+		switch strings.ToLower(key) {
+		case "size":
+			n, err := units.RAMInBytes(val)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("Invalid storage option size: %s", val)
+			}
+			size = uint64(n)
+		default:
+			return 0, fmt.Errorf("Unknown storage option: %s", key)
+		}
+	}
+	if size == 0 {
+		return 0, nil
+	}
+	// The size is checked before the container is registered
+	quotaDriver, ok := daemon.driver.(graphdriver.QuotaDriver)
+	if !ok {
+		return 0, daemon.errQuotaNotSupported()
+	}
+	if err := quotaDriver.ValidateQuota(size); err == graphdriver.ErrQuotaNotSupported {
+		return 0, daemon.errQuotaNotSupported()
+	} else if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (daemon *Daemon) errQuotaNotSupported() error {
+	return fmt.Errorf("The %s storage driver doesn't support limiting the size of containers with --storage-opt size", daemon.driver)
+}
+
 func (daemon *Daemon) GenerateSecurityOpt(ipcMode runconfig.IpcMode, pidMode runconfig.PidMode) ([]string, error) {
 	if ipcMode.IsHost() || pidMode.IsHost() {
 		return label.DisableSecOpt(), nil
@@ -2,9 +2,11 @@ package daemon
 
 import (
 	"bytes"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -31,6 +33,7 @@ import (
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/broadcastwriter"
+	"github.com/docker/docker/pkg/dnsserver"
 	"github.com/docker/docker/pkg/graphdb"
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/namesgenerator"
@@ -41,11 +44,13 @@ import (
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/pkg/sysinfo"
 	"github.com/docker/docker/pkg/truncindex"
+	"github.com/docker/docker/pkg/units"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/docker/trust"
 	"github.com/docker/docker/utils"
 	"github.com/docker/docker/volumes"
+	"github.com/docker/libtrust"
 
 	"github.com/go-fsnotify/fsnotify"
 )
@@ -111,6 +116,9 @@ type Daemon struct {
 	defaultLogConfig runconfig.LogConfig
 	RegistryService  *registry.Service
 	EventsService    *events.Events
+	resolver         *dnsserver.Server
+	resolverIP       net.IP
+	imageGCLock      sync.Mutex
 }
 
 // Install installs daemon capabilities to eng.
@@ -138,6 +146,7 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"unpause":           daemon.ContainerUnpause,
 		"wait":              daemon.ContainerWait,
 		"image_delete":      daemon.ImageDelete, // FIXME: see above
+		"image_gc":          daemon.ImageGC,
 		"execCreate":        daemon.ContainerExecCreate,
 		"execStart":         daemon.ContainerExecStart,
 		"execResize":        daemon.ContainerExecResize,
@@ -351,6 +360,14 @@ func (daemon *Daemon) restore() error {
 		if (container.Driver == "" && currentDriver == "aufs") || container.Driver == currentDriver {
 			logrus.Debugf("Loaded container %v", container.ID)
 
+			// The image may have been migrated to a content-addressable ID
+			if img, err := daemon.graph.Get(container.ImageID); err == nil && img.ID != container.ImageID {
+				container.ImageID = img.ID
+				if err := container.ToDisk(); err != nil {
+					logrus.Errorf("Failed to update image of container %v: %v", container.ID, err)
+				}
+			}
+
 			containers[container.ID] = container
 		} else {
 			logrus.Debugf("Cannot load container %s because it was created with another graph driver.", container.ID)
@@ -445,6 +462,9 @@ func (daemon *Daemon) setupResolvconfWatcher() error {
 					if err != nil {
 						logrus.Debugf("Error retrieving updated host resolv.conf: %v", err)
 					} else if updatedResolvConf != nil {
+						if daemon.resolver != nil {
+							daemon.resolver.SetNameservers(daemon.upstreamNameservers(updatedResolvConf))
+						}
 						// because the new host resolv.conf might have localhost nameservers..
 						updatedResolvConf, modified := resolvconf.FilterResolvDns(updatedResolvConf, daemon.config.EnableIPv6)
 						if modified {
@@ -665,14 +685,24 @@ func (daemon *Daemon) newContainer(name string, config *runconfig.Config, imgID
 	return container, err
 }
 
-func (daemon *Daemon) createRootfs(container *Container) error {
+// createRootfs creates the layers of the container, limiting the size of its
+// writable layer to size bytes unless size is 0.
+func (daemon *Daemon) createRootfs(container *Container, size uint64) error {
 	// Step 1: create the container directory.
 	// This doubles as a barrier to avoid race conditions.
 	if err := os.Mkdir(container.root, 0700); err != nil {
 		return err
 	}
+	var parentLayerID string
+	if container.ImageID != "" {
+		img, err := daemon.graph.Get(container.ImageID)
+		if err != nil {
+			return err
+		}
+		parentLayerID = img.LayerID()
+	}
 	initID := fmt.Sprintf("%s-init", container.ID)
-	if err := daemon.driver.Create(initID, container.ImageID); err != nil {
+	if err := daemon.driver.Create(initID, parentLayerID); err != nil {
 		return err
 	}
 	initPath, err := daemon.driver.Get(initID, "")
@@ -685,10 +715,14 @@ func (daemon *Daemon) createRootfs(container *Container) error {
 		return err
 	}
 
-	if err := daemon.driver.Create(container.ID, initID); err != nil {
-		return err
+	if size == 0 {
+		return daemon.driver.Create(container.ID, initID)
 	}
-	return nil
+	err = daemon.driver.(graphdriver.QuotaDriver).CreateWithQuota(container.ID, initID, size)
+	if err == graphdriver.ErrQuotaNotSupported {
+		return daemon.errQuotaNotSupported()
+	}
+	return err
 }
 
 func GetFullContainerName(name string) (string, error) {
@@ -818,6 +852,12 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine, registryService
 		config.EnableIpMasq = false
 	}
 	config.DisableNetwork = config.BridgeIface == disableNetworkBridge
+	if config.ImageGCThreshold < 0 || config.ImageGCThreshold > 100 {
+		return nil, fmt.Errorf("Invalid --image-gc-threshold %d, must be a percentage between 0 and 100", config.ImageGCThreshold)
+	}
+	if config.ImageGCEvictTagged && config.ImageGCThreshold == 0 {
+		return nil, fmt.Errorf("--image-gc-evict-tagged requires --image-gc-threshold")
+	}
 
 	// Claim the pidfile first, to avoid any and all unexpected race conditions.
 	// Some of the init doesn't need a pidfile lock - but let's not try to be smart.
@@ -933,11 +973,25 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine, registryService
 	}
 
 	eventsService := events.New()
+	if driver, ok := driver.(graphdriver.EventDriver); ok {
+		driver.SetEventLogger(eventsService.Log)
+	}
 	logrus.Debug("Creating repository list")
 	repositories, err := graph.NewTagStore(path.Join(config.Root, "repositories-"+driver.String()), g, trustKey, registryService, eventsService)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create Tag store: %s", err)
 	}
+	if config.MaxConcurrentDownloads < 0 || config.MaxConcurrentUploads < 0 {
+		return nil, fmt.Errorf("The max number of concurrent downloads and uploads can't be negative")
+	}
+	var bandwidth int64
+	if config.MaxBandwidth != "" {
+		if bandwidth, err = units.RAMInBytes(config.MaxBandwidth); err != nil || bandwidth < 0 {
+			return nil, fmt.Errorf("Invalid max bandwidth %s", config.MaxBandwidth)
+		}
+	}
+	repositories.SetTransferLimits(config.MaxConcurrentDownloads, config.MaxConcurrentUploads, bandwidth)
+	repositories.SetLazyLayers(config.LazyLayers)
 
 	trustDir := path.Join(config.Root, "trust")
 	if err := os.MkdirAll(trustDir, 0700); err != nil && !os.IsExist(err) {
@@ -947,6 +1001,28 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine, registryService
 	if err != nil {
 		return nil, fmt.Errorf("could not create trust store: %s", err)
 	}
+	if config.TrustPolicy != "" {
+		policy, err := trust.LoadPolicy(config.TrustPolicy)
+		if err != nil {
+			return nil, err
+		}
+		t.SetPolicy(policy)
+	}
+	if config.SigningKey != "" {
+		signingKey, err := libtrust.LoadKeyFile(config.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading signing key %s: %s", config.SigningKey, err)
+		}
+		var chain []*x509.Certificate
+		if config.SigningChain != "" {
+			if chain, err = libtrust.LoadCertificateBundle(config.SigningChain); err != nil {
+				return nil, fmt.Errorf("Error loading signing chain %s: %s", config.SigningChain, err)
+			}
+		}
+		repositories.SetSigningKey(signingKey, chain)
+	} else if config.SigningChain != "" {
+		return nil, fmt.Errorf("--signing-chain requires --signing-key")
+	}
 
 	if !config.DisableNetwork {
 		job := eng.Job("init_networkdriver")
@@ -956,11 +1032,13 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine, registryService
 		job.SetenvBool("EnableIpForward", config.EnableIpForward)
 		job.SetenvBool("EnableIpMasq", config.EnableIpMasq)
 		job.SetenvBool("EnableIPv6", config.EnableIPv6)
+		job.SetenvBool("EnableUserlandProxy", config.EnableUserlandProxy)
 		job.Setenv("BridgeIface", config.BridgeIface)
 		job.Setenv("BridgeIP", config.BridgeIP)
 		job.Setenv("FixedCIDR", config.FixedCIDR)
 		job.Setenv("FixedCIDRv6", config.FixedCIDRv6)
 		job.Setenv("DefaultBindingIP", config.DefaultIp.String())
+		job.Setenv("EphemeralPortRange", config.EphemeralPortRange)
 
 		if err := job.Run(); err != nil {
 			return nil, err
@@ -1035,6 +1113,12 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine, registryService
 		}
 	})
 
+	if config.EmbeddedDns && !config.DisableNetwork {
+		if err := daemon.initResolver(); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := daemon.restore(); err != nil {
 		return nil, err
 	}
@@ -1044,6 +1128,8 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine, registryService
 		return nil, err
 	}
 
+	daemon.startImageGC()
+
 	return daemon, nil
 }
 
@@ -1,8 +1,10 @@
 package daemon
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/runconfig"
 )
 
@@ -37,3 +39,58 @@ func TestParseSecurityOpt(t *testing.T) {
 		t.Fatal("Expected parseSecurityOpt error, got nil")
 	}
 }
+
+type testProtoDriver struct {
+	graphdriver.ProtoDriver
+}
+
+func (d *testProtoDriver) String() string {
+	return "test"
+}
+
+// quotaProtoDriver is a ProtoDriver able to limit the size of layers.
+type quotaProtoDriver struct {
+	testProtoDriver
+	unsupported bool // Whether the setup doesn't allow limiting the size
+}
+
+func (d *quotaProtoDriver) ValidateQuota(size uint64) error {
+	if d.unsupported {
+		return graphdriver.ErrQuotaNotSupported
+	}
+	return nil
+}
+
+func (d *quotaProtoDriver) CreateWithQuota(id, parent string, size uint64) error {
+	return d.ValidateQuota(size)
+}
+
+func TestVerifyStorageOpt(t *testing.T) {
+	daemon := &Daemon{driver: graphdriver.NaiveDiffDriver(&quotaProtoDriver{})}
+
+	for opt, expected := range map[string]uint64{"": 0, "20G": 20 * 1024 * 1024 * 1024, "512m": 512 * 1024 * 1024} {
+		hostConfig := &runconfig.HostConfig{}
+		if opt != "" {
+			hostConfig.StorageOpt = map[string]string{"size": opt}
+		}
+		if size, err := daemon.verifyStorageOpt(hostConfig); err != nil || size != expected {
+			t.Fatalf("Expected size %d for %q, got %d, %v", expected, opt, size, err)
+		}
+	}
+
+	for _, opts := range []map[string]string{{"size": "big"}, {"size": "0"}, {"inodes": "1000"}} {
+		if _, err := daemon.verifyStorageOpt(&runconfig.HostConfig{StorageOpt: opts}); err == nil {
+			t.Fatalf("Expected %v to be refused", opts)
+		}
+	}
+
+	// Drivers which can't limit the size of layers, at all or on this host,
+	// refuse it.
+	for _, driver := range []graphdriver.ProtoDriver{&testProtoDriver{}, &quotaProtoDriver{unsupported: true}} {
+		daemon.driver = graphdriver.NaiveDiffDriver(driver)
+		_, err := daemon.verifyStorageOpt(&runconfig.HostConfig{StorageOpt: map[string]string{"size": "20G"}})
+		if err == nil || !strings.Contains(err.Error(), "doesn't support limiting the size of containers") {
+			t.Fatalf("Expected an unsupported size to be refused, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// +build !exclude_graphdriver_zfs
+
+package daemon
+
+import (
+	_ "github.com/docker/docker/daemon/graphdriver/zfs"
+)
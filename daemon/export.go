@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/archive"
 )
 
 func (daemon *Daemon) ContainerExport(job *engine.Job) error {
@@ -18,7 +19,17 @@ func (daemon *Daemon) ContainerExport(job *engine.Job) error {
 		return err
 	}
 
-	data, err := container.Export()
+	compression, err := archive.ParseCompression(job.Getenv("compress"))
+	if err != nil {
+		return err
+	}
+
+	// The image may not be fully extracted yet
+	if err := daemon.Graph().WaitLayers(container.ImageID); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	data, err := container.Export(compression)
 	if err != nil {
 		return fmt.Errorf("%s: %s", name, err)
 	}
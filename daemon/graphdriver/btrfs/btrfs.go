@@ -6,13 +6,16 @@ package btrfs
 #include <stdlib.h>
 #include <dirent.h>
 #include <btrfs/ioctl.h>
+#include <btrfs/ctree.h>
 */
 import "C"
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path"
+	"sync"
 	"syscall"
 	"unsafe"
 
@@ -52,7 +55,9 @@ func Init(home string, options []string) (graphdriver.Driver, error) {
 }
 
 type Driver struct {
-	home string
+	home         string
+	quotaLock    sync.Mutex // Protects quotaEnabled
+	quotaEnabled bool
 }
 
 func (d *Driver) String() string {
@@ -166,6 +171,55 @@ func subvolDelete(path, name string) error {
 	return nil
 }
 
+// isQuotaEnabled returns whether quotas are enabled on the filesystem, i.e.
+// whether it has a quota tree with a status item.
+func isQuotaEnabled(path string) (bool, error) {
+	dir, err := openDir(path)
+	if err != nil {
+		return false, err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_search_args
+	args.key.tree_id = C.BTRFS_QUOTA_TREE_OBJECTID
+	args.key.min_type = C.BTRFS_QGROUP_STATUS_KEY
+	args.key.max_type = C.BTRFS_QGROUP_STATUS_KEY
+	args.key.max_objectid = C.__u64(math.MaxUint64)
+	args.key.max_offset = C.__u64(math.MaxUint64)
+	args.key.max_transid = C.__u64(math.MaxUint64)
+	args.key.nr_items = 1
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_TREE_SEARCH,
+		uintptr(unsafe.Pointer(&args)))
+	if errno == syscall.ENOENT {
+		return false, nil
+	}
+	if errno != 0 {
+		return false, fmt.Errorf("Failed to search btrfs quota tree for %s: %v", path, errno.Error())
+	}
+	return args.key.nr_items > 0, nil
+}
+
+// subvolLimitQgroup limits the data exclusive to the subvolume, i.e. not
+// shared with the subvolume it is a snapshot of, to size bytes.
+func subvolLimitQgroup(path string, size uint64) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	// A zero qgroupid is the qgroup of the subvolume of the file descriptor.
+	var args C.struct_btrfs_ioctl_qgroup_limit_args
+	args.lim.max_excl = C.__u64(size)
+	args.lim.flags = C.BTRFS_QGROUP_LIMIT_MAX_EXCL
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QGROUP_LIMIT,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to limit qgroup for %s: %v", path, errno.Error())
+	}
+	return nil
+}
+
 func (d *Driver) subvolumesDir() string {
 	return path.Join(d.home, "subvolumes")
 }
@@ -195,6 +249,42 @@ func (d *Driver) Create(id string, parent string) error {
 	return nil
 }
 
+// ValidateQuota checks that quotas are enabled on the filesystem, any size
+// can then be limited. Quotas are never enabled by the driver: enabling them
+// affects the performance of the whole filesystem, which is the decision of
+// the administrator.
+func (d *Driver) ValidateQuota(size uint64) error {
+	d.quotaLock.Lock()
+	defer d.quotaLock.Unlock()
+	if !d.quotaEnabled {
+		enabled, err := isQuotaEnabled(d.home)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return fmt.Errorf("Quotas are not enabled on the btrfs filesystem of %s, run `btrfs quota enable %s` to limit the size of containers", d.home, d.home)
+		}
+		d.quotaEnabled = true
+	}
+	return nil
+}
+
+// CreateWithQuota creates the subvolume like Create and limits the data
+// written to it.
+func (d *Driver) CreateWithQuota(id, parent string, size uint64) error {
+	if err := d.ValidateQuota(size); err != nil {
+		return err
+	}
+	if err := d.Create(id, parent); err != nil {
+		return err
+	}
+	if err := subvolLimitQgroup(d.subvolumesDirId(id), size); err != nil {
+		d.Remove(id)
+		return err
+	}
+	return nil
+}
+
 func (d *Driver) Remove(id string) error {
 	dir := d.subvolumesDirId(id)
 	if _, err := os.Stat(dir); err != nil {
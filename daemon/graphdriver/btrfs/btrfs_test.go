@@ -1,8 +1,10 @@
 package btrfs
 
 import (
-	"github.com/docker/docker/daemon/graphdriver/graphtest"
 	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/daemon/graphdriver/graphtest"
 )
 
 // This avoids creating a new driver for each test if all tests are run
@@ -23,6 +25,16 @@ func TestBtrfsCreateSnap(t *testing.T) {
 	graphtest.DriverTestCreateSnap(t, "btrfs")
 }
 
+func TestBtrfsCreateWithQuota(t *testing.T) {
+	// Quotas are left for the administrator to enable
+	if driver, ok := graphtest.GetDriver(t, "btrfs").(graphdriver.QuotaDriver); ok {
+		if err := driver.ValidateQuota(1024 * 1024); err != nil {
+			t.Skip(err)
+		}
+	}
+	graphtest.DriverTestCreateWithQuota(t, "btrfs")
+}
+
 func TestBtrfsTeardown(t *testing.T) {
 	graphtest.PutDriver(t)
 }
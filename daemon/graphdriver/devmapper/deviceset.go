@@ -30,7 +30,13 @@ var (
 	DefaultDataLoopbackSize     int64  = 100 * 1024 * 1024 * 1024
 	DefaultMetaDataLoopbackSize int64  = 2 * 1024 * 1024 * 1024
 	DefaultBaseFsSize           uint64 = 10 * 1024 * 1024 * 1024
-	DefaultThinpBlockSize       uint32 = 128      // 64K = 128 512b sectors
+	DefaultThinpBlockSize       uint32 = 128 // 64K = 128 512b sectors
+	DefaultMinFreeSpacePercent  uint64 = 10
+	DefaultAutoextendThreshold  uint64 = 80
+	DefaultAutoextendPercent    uint64 = 20
+	// poolMonitorInterval is how often the free space of the thin pool is
+	// checked, besides when devices are created
+	poolMonitorInterval = time.Minute
 	MaxDeviceId                 int    = 0xffffff // 24 bit, pool limit
 	DeviceIdMapSz               int    = (MaxDeviceId + 1) / 8
 	// We retry device removal so many a times that even error messages
@@ -100,7 +106,21 @@ type DeviceSet struct {
 	doBlkDiscard         bool
 	thinpBlockSize       uint32
 	thinPoolDevice       string
+	minFreeSpacePercent  uint64 // percentage of the data and metadata space to keep free
+	autoextendVG         string // volume group the thin pool is extended from, if any
+	autoextendThreshold  uint64 // percentage of the space used past which the pool is extended
+	autoextendPercent    uint64 // percentage of its size the pool is extended by
 	Transaction          `json:"-"`
+
+	// extendPool extends the thin pool by the given percentage of its
+	// size, if it is extended automatically.
+	extendPool func(percent uint64) error
+	logEvent   graphdriver.EventLogger
+	// poolLowSpace is whether the last check of the thin pool found less
+	// than the minimum free space.
+	poolLowSpace bool
+	// stopPoolMonitor is closed to stop checking the thin pool periodically.
+	stopPoolMonitor chan struct{}
 }
 
 type DiskUsage struct {
@@ -110,15 +130,19 @@ type DiskUsage struct {
 }
 
 type Status struct {
-	PoolName          string
-	DataFile          string // actual block device for data
-	DataLoopback      string // loopback file, if used
-	MetadataFile      string // actual block device for metadata
-	MetadataLoopback  string // loopback file, if used
-	Data              DiskUsage
-	Metadata          DiskUsage
-	SectorSize        uint64
-	UdevSyncSupported bool
+	PoolName            string
+	DataFile            string // actual block device for data
+	DataLoopback        string // loopback file, if used
+	MetadataFile        string // actual block device for metadata
+	MetadataLoopback    string // loopback file, if used
+	Data                DiskUsage
+	Metadata            DiskUsage
+	SectorSize          uint64
+	UdevSyncSupported   bool
+	MinFreeSpace        uint64 // bytes of data space kept free
+	AutoextendVG        string
+	AutoextendThreshold uint64
+	AutoextendPercent   uint64
 }
 
 type DevStatus struct {
@@ -540,6 +564,10 @@ func (devices *DeviceSet) getNextFreeDeviceId() (int, error) {
 }
 
 func (devices *DeviceSet) createRegisterDevice(hash string) (*DevInfo, error) {
+	if err := devices.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+
 	deviceId, err := devices.getNextFreeDeviceId()
 	if err != nil {
 		return nil, err
@@ -591,7 +619,13 @@ func (devices *DeviceSet) createRegisterDevice(hash string) (*DevInfo, error) {
 	return info, nil
 }
 
-func (devices *DeviceSet) createRegisterSnapDevice(hash string, baseInfo *DevInfo) error {
+// createRegisterSnapDevice creates a snapshot of the base device, of the
+// size of the base device if size is 0.
+func (devices *DeviceSet) createRegisterSnapDevice(hash string, baseInfo *DevInfo, size uint64) error {
+	if err := devices.checkFreeSpace(); err != nil {
+		return err
+	}
+
 	deviceId, err := devices.getNextFreeDeviceId()
 	if err != nil {
 		return err
@@ -626,7 +660,10 @@ func (devices *DeviceSet) createRegisterSnapDevice(hash string, baseInfo *DevInf
 		break
 	}
 
-	if _, err := devices.registerDevice(deviceId, hash, baseInfo.Size, devices.OpenTransactionId); err != nil {
+	if size == 0 {
+		size = baseInfo.Size
+	}
+	if _, err := devices.registerDevice(deviceId, hash, size, devices.OpenTransactionId); err != nil {
 		devicemapper.DeleteDevice(devices.getPoolDevName(), deviceId)
 		devices.markDeviceIdFree(deviceId)
 		logrus.Debugf("Error registering device: %s", err)
@@ -1118,8 +1155,15 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 }
 
 func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
-	logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s)", hash, baseHash)
-	defer logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s) END", hash, baseHash)
+	return devices.AddDeviceWithSize(hash, baseHash, 0)
+}
+
+// AddDeviceWithSize adds a snapshot of the base device of the given size,
+// growing its filesystem, or of the size of the base device if size is 0.
+// The size can't be smaller than the size of the base device.
+func (devices *DeviceSet) AddDeviceWithSize(hash, baseHash string, size uint64) error {
+	logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s size=%d)", hash, baseHash, size)
+	defer logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s size=%d) END", hash, baseHash, size)
 
 	baseInfo, err := devices.lookupDevice(baseHash)
 	if err != nil {
@@ -1136,10 +1180,69 @@ func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
 		return fmt.Errorf("device %s already exists", hash)
 	}
 
-	if err := devices.createRegisterSnapDevice(hash, baseInfo); err != nil {
+	if size != 0 && size < baseInfo.Size {
+		return fmt.Errorf("Device size %s can't be smaller than the size of its base device %s", units.HumanSize(float64(size)), units.HumanSize(float64(baseInfo.Size)))
+	}
+
+	if err := devices.createRegisterSnapDevice(hash, baseInfo, size); err != nil {
 		return err
 	}
 
+	if size > baseInfo.Size {
+		info, err := devices.lookupDevice(hash)
+		if err != nil {
+			return err
+		}
+		if err := devices.growFS(info); err != nil {
+			devices.deleteDevice(info)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// growFS grows the filesystem of the device to the size of the device.
+func (devices *DeviceSet) growFS(info *DevInfo) error {
+	if err := devices.activateDeviceIfNeeded(info); err != nil {
+		return fmt.Errorf("Error activating devmapper device for '%s': %s", info.Hash, err)
+	}
+	defer devices.deactivateDevice(info)
+
+	fstype, err := ProbeFsType(info.DevName())
+	if err != nil {
+		return err
+	}
+
+	mountPath, err := ioutil.TempDir(devices.root, "grow-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(mountPath)
+
+	options := ""
+	if fstype == "xfs" {
+		// XFS needs nouuid or it can't mount filesystems with the same fs
+		options = joinMountOptions(options, "nouuid")
+	}
+	options = joinMountOptions(options, devices.mountOptions)
+	if err := syscall.Mount(info.DevName(), mountPath, fstype, syscall.MS_MGC_VAL, options); err != nil {
+		return fmt.Errorf("Error mounting '%s' on '%s': %s", info.DevName(), mountPath, err)
+	}
+	defer syscall.Unmount(mountPath, 0)
+
+	var out []byte
+	switch fstype {
+	case "ext4":
+		out, err = exec.Command("resize2fs", info.DevName()).CombinedOutput()
+	case "xfs":
+		out, err = exec.Command("xfs_growfs", mountPath).CombinedOutput()
+	default:
+		return fmt.Errorf("Unsupported filesystem type %s", fstype)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to grow the filesystem of '%s': %s (%s)", info.DevName(), err, strings.TrimSpace(string(out)))
+	}
 	return nil
 }
 
@@ -1269,6 +1372,11 @@ func (devices *DeviceSet) Shutdown() error {
 	logrus.Debugf("[devmapper] Shutting down DeviceSet: %s", devices.root)
 	defer logrus.Debugf("[deviceset %s] Shutdown() END", devices.devicePrefix)
 
+	if devices.stopPoolMonitor != nil {
+		close(devices.stopPoolMonitor)
+		devices.stopPoolMonitor = nil
+	}
+
 	var devs []*DevInfo
 
 	devices.devicesLock.Lock()
@@ -1510,6 +1618,117 @@ func (devices *DeviceSet) poolStatus() (totalSizeInSectors, transactionId, dataU
 	return
 }
 
+// SetEventLogger sets the function the events about the thin pool, like it
+// running low on space or being extended, are logged with.
+func (devices *DeviceSet) SetEventLogger(logEvent graphdriver.EventLogger) {
+	devices.Lock()
+	defer devices.Unlock()
+	devices.logEvent = logEvent
+}
+
+func (devices *DeviceSet) logPoolEvent(action string) {
+	if devices.logEvent != nil {
+		devices.logEvent(action, devices.getPoolName(), "devicemapper")
+	}
+}
+
+// usedPercent returns the percentage of total used by used.
+func usedPercent(used, total uint64) uint64 {
+	if total == 0 {
+		return 100
+	}
+	return used * 100 / total
+}
+
+// checkFreeSpace extends the thin pool if it is extended automatically and
+// the data or metadata space used passes the autoextend threshold, and
+// returns an error if less than the minimum free space is left, so that no
+// device is created until more space is made available. The pool-low-space
+// event is logged when the pool runs low on space, not at every check.
+func (devices *DeviceSet) checkFreeSpace() error {
+	_, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err := devices.poolStatus()
+	if err != nil {
+		return err
+	}
+
+	if devices.extendPool != nil && (dataUsed*100 >= devices.autoextendThreshold*dataTotal || metadataUsed*100 >= devices.autoextendThreshold*metadataTotal) {
+		logrus.Infof("Extending thin pool %s by %d%%, %d%% of its data and %d%% of its metadata space are used", devices.getPoolName(), devices.autoextendPercent, usedPercent(dataUsed, dataTotal), usedPercent(metadataUsed, metadataTotal))
+		if err := devices.extendPool(devices.autoextendPercent); err != nil {
+			logrus.Errorf("Error extending thin pool %s: %s", devices.getPoolName(), err)
+		} else {
+			devices.logPoolEvent("pool-extend")
+			if _, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err = devices.poolStatus(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if (dataTotal-dataUsed)*100 < devices.minFreeSpacePercent*dataTotal || (metadataTotal-metadataUsed)*100 < devices.minFreeSpacePercent*metadataTotal {
+		if !devices.poolLowSpace {
+			devices.logPoolEvent("pool-low-space")
+		}
+		devices.poolLowSpace = true
+		return fmt.Errorf("Thin pool %s has %d%% of its data and %d%% of its metadata space free, less than the minimum of %d%%: free some space in the pool or change the minimum with the dm.min_free_space option",
+			devices.getPoolName(), 100-usedPercent(dataUsed, dataTotal), 100-usedPercent(metadataUsed, metadataTotal), devices.minFreeSpacePercent)
+	}
+	devices.poolLowSpace = false
+	return nil
+}
+
+// monitorPool checks the free space of the thin pool periodically until stop
+// is closed, so that the pool is extended, and running low on space is
+// reported, even when no device is created.
+func (devices *DeviceSet) monitorPool(stop chan struct{}) {
+	ticker := time.NewTicker(poolMonitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		devices.Lock()
+		if err := devices.checkFreeSpace(); err != nil {
+			logrus.Debugf("Checking thin pool %s: %s", devices.getPoolName(), err)
+		}
+		devices.Unlock()
+	}
+}
+
+// lvmVolumeName returns the name of the LVM logical volume of the volume
+// group vg whose device mapper device is named dmName. LVM joins the names
+// of the volume group and of the logical volume with a dash, doubling the
+// dashes they contain.
+func lvmVolumeName(vg, dmName string) (string, error) {
+	prefix := strings.Replace(vg, "-", "--", -1) + "-"
+	if !strings.HasPrefix(dmName, prefix) || len(dmName) == len(prefix) {
+		return "", fmt.Errorf("Thin pool %s is not a logical volume of the volume group %s", dmName, vg)
+	}
+	return strings.Replace(strings.TrimPrefix(dmName, prefix), "--", "-", -1), nil
+}
+
+// extendLVMPool extends the thin pool, the logical volume lv of the volume
+// group vg, and its metadata by the given percentage of their size with
+// lvextend.
+func (devices *DeviceSet) extendLVMPool(vg, lv string, percent uint64) error {
+	_, _, _, _, _, metadataTotal, err := devices.poolStatus()
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("lvextend", "--extents", fmt.Sprintf("+%d%%LV", percent), vg+"/"+lv).CombinedOutput(); err != nil {
+		return fmt.Errorf("Error extending the data of %s/%s: %s (%s)", vg, lv, err, strings.TrimSpace(string(out)))
+	}
+	// metadata blocks are always 4k
+	metadataExtent := metadataTotal * 4 * percent / 100
+	if metadataExtent == 0 {
+		return nil
+	}
+	if out, err := exec.Command("lvextend", "--poolmetadatasize", fmt.Sprintf("+%dk", metadataExtent), vg+"/"+lv).CombinedOutput(); err != nil {
+		return fmt.Errorf("Error extending the metadata of %s/%s: %s (%s)", vg, lv, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // DataDevicePath returns the path to the data storage for this deviceset,
 // regardless of loopback or block device
 func (devices *DeviceSet) DataDevicePath() string {
@@ -1557,6 +1776,9 @@ func (devices *DeviceSet) Status() *Status {
 	status.MetadataFile = devices.MetadataDevicePath()
 	status.MetadataLoopback = devices.metadataLoopFile
 	status.UdevSyncSupported = devicemapper.UdevSyncSupported()
+	status.AutoextendVG = devices.autoextendVG
+	status.AutoextendThreshold = devices.autoextendThreshold
+	status.AutoextendPercent = devices.autoextendPercent
 
 	totalSizeInSectors, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err := devices.poolStatus()
 	if err == nil {
@@ -1573,6 +1795,7 @@ func (devices *DeviceSet) Status() *Status {
 		status.Metadata.Available = status.Metadata.Total - status.Metadata.Used
 
 		status.SectorSize = blockSizeInSectors * 512
+		status.MinFreeSpace = status.Data.Total * devices.minFreeSpacePercent / 100
 
 		if check, _ := devices.isRealFile(devices.dataLoopFile); check {
 			actualSpace, err := devices.getUnderlyingAvailableSpace(devices.dataLoopFile)
@@ -1592,6 +1815,16 @@ func (devices *DeviceSet) Status() *Status {
 	return status
 }
 
+// parsePercent parses a percentage like 10%, the percent sign being
+// optional.
+func parsePercent(val string) (uint64, error) {
+	percent, err := strconv.ParseUint(strings.TrimSuffix(val, "%"), 10, 64)
+	if err != nil || percent > 100 {
+		return 0, fmt.Errorf("Invalid percentage %s\n", val)
+	}
+	return percent, nil
+}
+
 func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error) {
 	devicemapper.SetDevDir("/dev")
 
@@ -1604,6 +1837,9 @@ func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error
 		filesystem:           "ext4",
 		doBlkDiscard:         true,
 		thinpBlockSize:       DefaultThinpBlockSize,
+		minFreeSpacePercent:  DefaultMinFreeSpacePercent,
+		autoextendThreshold:  DefaultAutoextendThreshold,
+		autoextendPercent:    DefaultAutoextendPercent,
 		deviceIdMap:          make([]byte, DeviceIdMapSz),
 	}
 
@@ -1661,6 +1897,20 @@ func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error
 			}
 			// convert to 512b sectors
 			devices.thinpBlockSize = uint32(size) >> 9
+		case "dm.min_free_space":
+			if devices.minFreeSpacePercent, err = parsePercent(val); err != nil || devices.minFreeSpacePercent == 100 {
+				return nil, fmt.Errorf("Invalid min free space %s, it must be a percentage below 100%%\n", val)
+			}
+		case "dm.autoextend_vg":
+			devices.autoextendVG = val
+		case "dm.autoextend_threshold":
+			if devices.autoextendThreshold, err = parsePercent(val); err != nil {
+				return nil, err
+			}
+		case "dm.autoextend_percent":
+			if devices.autoextendPercent, err = parsePercent(val); err != nil || devices.autoextendPercent == 0 {
+				return nil, fmt.Errorf("Invalid autoextend percent %s, it must be a percentage above 0%%\n", val)
+			}
 		default:
 			return nil, fmt.Errorf("Unknown option %s\n", key)
 		}
@@ -1671,9 +1921,25 @@ func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error
 		devices.doBlkDiscard = false
 	}
 
+	if devices.autoextendVG != "" {
+		if devices.thinPoolDevice == "" {
+			return nil, fmt.Errorf("dm.autoextend_vg requires the thin pool of dm.thinpooldev")
+		}
+		lv, err := lvmVolumeName(devices.autoextendVG, devices.thinPoolDevice)
+		if err != nil {
+			return nil, err
+		}
+		vg := devices.autoextendVG
+		devices.extendPool = func(percent uint64) error {
+			return devices.extendLVMPool(vg, lv, percent)
+		}
+	}
+
 	if err := devices.initDevmapper(doInit); err != nil {
 		return nil, err
 	}
 
+	devices.stopPoolMonitor = make(chan struct{})
+	go devices.monitorPool(devices.stopPoolMonitor)
 	return devices, nil
 }
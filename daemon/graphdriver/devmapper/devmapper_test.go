@@ -3,8 +3,14 @@
 package devmapper
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/daemon/graphdriver/graphtest"
 )
 
@@ -36,6 +42,154 @@ func TestDevmapperCreateSnap(t *testing.T) {
 	graphtest.DriverTestCreateSnap(t, "devicemapper")
 }
 
+func TestDevmapperCreateWithSize(t *testing.T) {
+	driver := graphtest.GetDriver(t, "devicemapper")
+	defer graphtest.PutDriver(t)
+	quotaDriver := driver.(graphdriver.QuotaDriver)
+
+	if err := quotaDriver.CreateWithQuota("Small", "", DefaultBaseFsSize/2); err == nil {
+		driver.Remove("Small")
+		t.Fatal("Expected a device smaller than the base device to be refused")
+	}
+
+	if err := quotaDriver.CreateWithQuota("Big", "", DefaultBaseFsSize*2); err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Remove("Big")
+	dir, err := driver.Get("Big", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Put("Big")
+
+	var buf syscall.Statfs_t
+	if err := syscall.Statfs(dir, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if size := buf.Blocks * uint64(buf.Bsize); size <= DefaultBaseFsSize {
+		t.Fatalf("Expected the filesystem to be grown past %d bytes, got %d", DefaultBaseFsSize, size)
+	}
+}
+
+// newTestDeviceSet returns a device set on a new thin pool of loopback
+// devices, recording the events it logs in events.
+func newTestDeviceSet(t *testing.T, events *[]string, options ...string) (*DeviceSet, string) {
+	home, err := ioutil.TempDir("/var/tmp", "docker-devmapper-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := NewDeviceSet(home, true, options)
+	if err != nil {
+		os.RemoveAll(home)
+		t.Fatal(err)
+	}
+	devices.SetEventLogger(func(action, id, from string) {
+		*events = append(*events, action)
+	})
+	return devices, home
+}
+
+func TestDevmapperMinFreeSpace(t *testing.T) {
+	var events []string
+	devices, home := newTestDeviceSet(t, &events, "dm.min_free_space=10%")
+	defer os.RemoveAll(home)
+	defer devices.Shutdown()
+
+	if err := devices.AddDevice("first", ""); err != nil {
+		t.Fatal(err)
+	}
+	// Any block used leaves less free space than the minimum
+	devices.minFreeSpacePercent = 100
+	if err := devices.AddDevice("second", ""); err == nil || !strings.Contains(err.Error(), "dm.min_free_space") {
+		t.Fatalf("Expected the creation of a device to be refused, got %v", err)
+	}
+	if devices.HasDevice("second") {
+		t.Fatal("Expected the refused device not to be registered")
+	}
+	if len(events) != 1 || events[0] != "pool-low-space" {
+		t.Fatalf("Expected a pool-low-space event, got %v", events)
+	}
+}
+
+func TestDevmapperMonitorPool(t *testing.T) {
+	defer func(interval time.Duration) { poolMonitorInterval = interval }(poolMonitorInterval)
+	poolMonitorInterval = 10 * time.Millisecond
+
+	var events []string
+	devices, home := newTestDeviceSet(t, &events)
+	defer os.RemoveAll(home)
+	defer devices.Shutdown()
+
+	// The pool runs low on space without any device being created
+	devices.Lock()
+	devices.minFreeSpacePercent = 100
+	devices.Unlock()
+	for i := 0; ; i++ {
+		devices.Lock()
+		reported := len(events) > 0
+		devices.Unlock()
+		if reported {
+			break
+		} else if i == 100 {
+			t.Fatal("Expected the pool to be reported low on space")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// It is only reported once while it stays low on space
+	time.Sleep(10 * poolMonitorInterval)
+	devices.Lock()
+	defer devices.Unlock()
+	if len(events) != 1 || events[0] != "pool-low-space" {
+		t.Fatalf("Expected a single pool-low-space event, got %v", events)
+	}
+}
+
+func TestDevmapperAutoextend(t *testing.T) {
+	var events []string
+	devices, home := newTestDeviceSet(t, &events)
+	defer os.RemoveAll(home)
+	defer devices.Shutdown()
+
+	// Grow the loopback file of the data of the pool rather than a logical
+	// volume, whenever a device is created
+	devices.autoextendThreshold = 0
+	devices.extendPool = func(percent uint64) error {
+		return devices.ResizePool(DefaultDataLoopbackSize * int64(100+percent) / 100)
+	}
+	before := devices.Status().Data.Total
+	if err := devices.AddDevice("extended", ""); err != nil {
+		t.Fatal(err)
+	}
+	if after := devices.Status().Data.Total; after <= before {
+		t.Fatalf("Expected the pool to be extended past %d bytes, got %d", before, after)
+	}
+	if len(events) != 1 || events[0] != "pool-extend" {
+		t.Fatalf("Expected a pool-extend event, got %v", events)
+	}
+}
+
+func TestLvmVolumeName(t *testing.T) {
+	for _, c := range []struct {
+		vg, dmName, lv string
+	}{
+		{"docker", "docker-thinpool", "thinpool"},
+		{"docker-vg", "docker--vg-thin--pool", "thin-pool"},
+		{"docker", "other-thinpool", ""},
+		{"docker", "docker-", ""},
+	} {
+		lv, err := lvmVolumeName(c.vg, c.dmName)
+		if c.lv == "" {
+			if err == nil {
+				t.Fatalf("Expected %s not to be a logical volume of %s, got %s", c.dmName, c.vg, lv)
+			}
+			continue
+		}
+		if err != nil || lv != c.lv {
+			t.Fatalf("Expected the logical volume %s for %s, got %q, %v", c.lv, c.dmName, lv, err)
+		}
+	}
+}
+
 func TestDevmapperTeardown(t *testing.T) {
 	graphtest.PutDriver(t)
 }
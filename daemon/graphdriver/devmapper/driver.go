@@ -76,6 +76,7 @@ func (d *Driver) Status() [][2]string {
 		{"Metadata Space Used", fmt.Sprintf("%s", units.HumanSize(float64(s.Metadata.Used)))},
 		{"Metadata Space Total", fmt.Sprintf("%s", units.HumanSize(float64(s.Metadata.Total)))},
 		{"Metadata Space Available", fmt.Sprintf("%s", units.HumanSize(float64(s.Metadata.Available)))},
+		{"Thin Pool Minimum Free Space", fmt.Sprintf("%s", units.HumanSize(float64(s.MinFreeSpace)))},
 		{"Udev Sync Supported", fmt.Sprintf("%v", s.UdevSyncSupported)},
 	}
 	if len(s.DataLoopback) > 0 {
@@ -84,6 +85,12 @@ func (d *Driver) Status() [][2]string {
 	if len(s.MetadataLoopback) > 0 {
 		status = append(status, [2]string{"Metadata loop file", s.MetadataLoopback})
 	}
+	if len(s.AutoextendVG) > 0 {
+		status = append(status,
+			[2]string{"Thin Pool Autoextend Volume Group", s.AutoextendVG},
+			[2]string{"Thin Pool Autoextend Threshold", fmt.Sprintf("%d%%", s.AutoextendThreshold)},
+			[2]string{"Thin Pool Autoextend Percent", fmt.Sprintf("%d%%", s.AutoextendPercent)})
+	}
 	if vStr, err := devicemapper.GetLibraryVersion(); err == nil {
 		status = append(status, [2]string{"Library Version", vStr})
 	}
@@ -108,6 +115,21 @@ func (d *Driver) Create(id, parent string) error {
 	return nil
 }
 
+// CreateWithQuota creates the device like Create, with the given size. The
+// size can't be smaller than the base device size.
+func (d *Driver) CreateWithQuota(id, parent string, size uint64) error {
+	return d.DeviceSet.AddDeviceWithSize(id, parent, size)
+}
+
+// ValidateQuota checks that the size isn't smaller than the base device
+// size.
+func (d *Driver) ValidateQuota(size uint64) error {
+	if size < d.DeviceSet.baseFsSize {
+		return fmt.Errorf("Device size %s can't be smaller than the base device size %s", units.HumanSize(float64(size)), units.HumanSize(float64(d.DeviceSet.baseFsSize)))
+	}
+	return nil
+}
+
 func (d *Driver) Remove(id string) error {
 	if !d.DeviceSet.HasDevice(id) {
 		// Consider removing a non-existing device a no-op
@@ -38,14 +38,16 @@ var (
 	priority = []string{
 		"aufs",
 		"btrfs",
+		"zfs",
 		"devicemapper",
 		"overlay",
 		"vfs",
 	}
 
-	ErrNotSupported   = errors.New("driver not supported")
-	ErrPrerequisites  = errors.New("prerequisites for driver not satisfied (wrong filesystem?)")
-	ErrIncompatibleFS = fmt.Errorf("backing file system is unsupported for this graph driver")
+	ErrNotSupported      = errors.New("driver not supported")
+	ErrPrerequisites     = errors.New("prerequisites for driver not satisfied (wrong filesystem?)")
+	ErrIncompatibleFS    = fmt.Errorf("backing file system is unsupported for this graph driver")
+	ErrQuotaNotSupported = errors.New("limiting the size of layers is not supported by this graph driver")
 
 	FsNames = map[FsMagic]string{
 		FsMagicAufs:        "aufs",
@@ -119,6 +121,30 @@ type Driver interface {
 	DiffSize(id, parent string) (size int64, err error)
 }
 
+// QuotaDriver is implemented by drivers which can limit the size of the
+// layers they create, e.g. the writable layers of containers.
+type QuotaDriver interface {
+	// CreateWithQuota creates a new filesystem layer like Create,
+	// limiting it to size bytes. It returns ErrQuotaNotSupported if the
+	// setup of the driver doesn't allow it.
+	CreateWithQuota(id, parent string, size uint64) error
+	// ValidateQuota checks that layers limited to size bytes can be
+	// created, before creating any. It returns ErrQuotaNotSupported if the
+	// setup of the driver doesn't allow it.
+	ValidateQuota(size uint64) error
+}
+
+// EventLogger logs an event about the storage of a driver, like the events
+// of the daemon: the action, the ID of the storage and where it is from.
+type EventLogger func(action, id, from string)
+
+// EventDriver is implemented by drivers which log events about their
+// storage, e.g. when it runs low on space.
+type EventDriver interface {
+	// SetEventLogger sets the function the driver logs its events with.
+	SetEventLogger(logEvent EventLogger)
+}
+
 func init() {
 	drivers = make(map[string]InitFunc)
 }
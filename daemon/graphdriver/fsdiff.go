@@ -20,6 +20,12 @@ type naiveDiffDriver struct {
 	ProtoDriver
 }
 
+// naiveDiffQuotaDriver is a naiveDiffDriver wrapping a ProtoDriver which
+// is also a QuotaDriver.
+type naiveDiffQuotaDriver struct {
+	naiveDiffDriver
+}
+
 // NaiveDiffDriver returns a fully functional driver that wraps the
 // given ProtoDriver and adds the capability of the following methods which
 // it may or may not support on its own:
@@ -27,10 +33,33 @@ type naiveDiffDriver struct {
 //     Changes(id, parent string) ([]archive.Change, error)
 //     ApplyDiff(id, parent string, diff archive.ArchiveReader) (size int64, err error)
 //     DiffSize(id, parent string) (size int64, err error)
+// The returned driver is a QuotaDriver if the given ProtoDriver is one, and
+// passes the event logger to the given ProtoDriver if it is an EventDriver.
 func NaiveDiffDriver(driver ProtoDriver) Driver {
+	if _, ok := driver.(QuotaDriver); ok {
+		return &naiveDiffQuotaDriver{naiveDiffDriver{ProtoDriver: driver}}
+	}
 	return &naiveDiffDriver{ProtoDriver: driver}
 }
 
+// CreateWithQuota creates a layer of limited size with the wrapped driver.
+func (gdw *naiveDiffQuotaDriver) CreateWithQuota(id, parent string, size uint64) error {
+	return gdw.ProtoDriver.(QuotaDriver).CreateWithQuota(id, parent, size)
+}
+
+// ValidateQuota checks the size of layers with the wrapped driver.
+func (gdw *naiveDiffQuotaDriver) ValidateQuota(size uint64) error {
+	return gdw.ProtoDriver.(QuotaDriver).ValidateQuota(size)
+}
+
+// SetEventLogger sets the event logger of the wrapped driver, if it logs
+// events.
+func (gdw *naiveDiffDriver) SetEventLogger(logEvent EventLogger) {
+	if driver, ok := gdw.ProtoDriver.(EventDriver); ok {
+		driver.SetEventLogger(logEvent)
+	}
+}
+
 // Diff produces an archive of the changes between the specified
 // layer and its parent layer which may be "".
 func (gdw *naiveDiffDriver) Diff(id, parent string) (arch archive.Archive, err error) {
@@ -2,13 +2,21 @@ package graphtest
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
+
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
 
 	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/archive"
 )
 
 var (
@@ -61,7 +69,7 @@ func getBaseLoopStats() (*syscall.Stat_t, error) {
 	return loop0.Sys().(*syscall.Stat_t), nil
 }
 
-func newDriver(t *testing.T, name string) *Driver {
+func newDriver(t *testing.T, name string, options []string) *Driver {
 	root, err := ioutil.TempDir("/var/tmp", "docker-graphtest-")
 	if err != nil {
 		t.Fatal(err)
@@ -71,7 +79,7 @@ func newDriver(t *testing.T, name string) *Driver {
 		t.Fatal(err)
 	}
 
-	d, err := graphdriver.GetDriver(name, root, nil)
+	d, err := graphdriver.GetDriver(name, root, options)
 	if err != nil {
 		t.Logf("graphdriver: %v\n", err)
 		if err == graphdriver.ErrNotSupported || err == graphdriver.ErrPrerequisites || err == graphdriver.ErrIncompatibleFS {
@@ -89,9 +97,11 @@ func cleanup(t *testing.T, d *Driver) {
 	os.RemoveAll(d.root)
 }
 
-func GetDriver(t *testing.T, name string) graphdriver.Driver {
+// GetDriver returns the driver shared by the tests, creating it with the
+// options if needed.
+func GetDriver(t *testing.T, name string, options ...string) graphdriver.Driver {
 	if drv == nil {
-		drv = newDriver(t, name)
+		drv = newDriver(t, name, options)
 	} else {
 		drv.refCount++
 	}
@@ -269,3 +279,231 @@ func DriverTestCreateSnap(t *testing.T, drivername string) {
 		t.Fatal(err)
 	}
 }
+
+// DriverTestCreateWithQuota verifies that the files written to a layer
+// limited to 1MB can't grow past the limit, for drivers which limit the
+// data written to layers.
+func DriverTestCreateWithQuota(t *testing.T, drivername string) {
+	driver := GetDriver(t, drivername)
+	defer PutDriver(t)
+
+	// The driver is wrapped by GetDriver
+	quotaDriver, ok := drv.Driver.(graphdriver.QuotaDriver)
+	if !ok {
+		t.Skipf("Driver %s can't limit the size of layers", drivername)
+	}
+
+	createBase(t, driver, "Base")
+	defer driver.Remove("Base")
+
+	// Layers are only created once their size is known to be supported
+	if err := quotaDriver.ValidateQuota(1024 * 1024); err == graphdriver.ErrQuotaNotSupported {
+		t.Skipf("Driver %s can't limit the size of layers on this host", drivername)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if err := quotaDriver.CreateWithQuota("Quota", "Base", 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Remove("Quota")
+
+	verifyBase(t, driver, "Quota")
+
+	dir, err := driver.Get("Quota", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Put("Quota")
+
+	f, err := os.Create(path.Join(dir, "big file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	chunk := make([]byte, 64*1024)
+	for written := 0; ; written += len(chunk) {
+		if written >= 8*1024*1024 {
+			t.Fatalf("Expected writing more than 1MB to fail, wrote %d bytes", written)
+		}
+		if _, err := f.Write(chunk); err != nil {
+			break
+		}
+		if err := f.Sync(); err != nil {
+			break
+		}
+	}
+}
+
+// DriverTestDiffNaive verifies that the Changes, DiffSize and Diff of the
+// driver are the same as the naive ones computed on the mounted layers, for
+// a layer on a base layer and for a layer on that layer.
+func DriverTestDiffNaive(t *testing.T, drivername string) {
+	driver := GetDriver(t, drivername)
+	defer PutDriver(t)
+
+	// The naive implementations use Get and Put of the driver
+	naive := graphdriver.NaiveDiffDriver(driver)
+
+	oldmask := syscall.Umask(0)
+	defer syscall.Umask(oldmask)
+
+	createBase(t, driver, "Base")
+	defer driver.Remove("Base")
+	changeLayer(t, driver, "Base", func(dir string) {
+		mkdir(t, dir, "dir", 0755)
+		writeFile(t, dir, "dir/keep", "kept", 0644)
+		writeFile(t, dir, "dir/remove", "removed", 0644)
+		mkdir(t, dir, "dir/sub", 0700)
+		writeFile(t, dir, "dir/sub/file", "file", 0600)
+		mkdir(t, dir, "opaque", 0755)
+		writeFile(t, dir, "opaque/replaced", "replaced", 0644)
+		writeFile(t, dir, "opaque/hidden", "hidden", 0644)
+	})
+
+	if err := driver.Create("Init", "Base"); err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Remove("Init")
+	changeLayer(t, driver, "Init", func(dir string) {
+		writeFile(t, dir, "init file", "init", 0644)
+		writeFile(t, dir, "init only", "init only", 0644)
+		if err := os.Chmod(path.Join(dir, "a file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		remove(t, dir, "dir/remove")
+		writeFile(t, dir, "dir/sub/new", "new", 0600)
+		remove(t, dir, "opaque")
+		mkdir(t, dir, "opaque", 0755)
+		writeFile(t, dir, "opaque/replaced", "replacement", 0644)
+		if err := os.Link(path.Join(dir, "init file"), path.Join(dir, "init link")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	compareDiffs(t, driver, naive, "Init", "Base")
+
+	if err := driver.Create("Container", "Init"); err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Remove("Container")
+	changeLayer(t, driver, "Container", func(dir string) {
+		remove(t, dir, "init only")
+		remove(t, dir, "a subdir")
+		writeFile(t, dir, "dir/keep", "modified", 0644)
+		writeFile(t, dir, "dir/remove", "created again", 0644)
+		if err := os.Chown(path.Join(dir, "dir/sub"), 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("dir/keep", path.Join(dir, "symlink")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	compareDiffs(t, driver, naive, "Container", "Init")
+}
+
+func changeLayer(t *testing.T, driver graphdriver.Driver, id string, change func(dir string)) {
+	dir, err := driver.Get(id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Put(id)
+	change(dir)
+}
+
+func mkdir(t *testing.T, dir, name string, mode os.FileMode) {
+	if err := os.Mkdir(path.Join(dir, name), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, data string, mode os.FileMode) {
+	if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, dir, name string) {
+	if err := os.RemoveAll(path.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareDiffs(t *testing.T, driver, naive graphdriver.Driver, id, parent string) {
+	changes, err := driver.Changes(id, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	naiveChanges, err := naive.Changes(id, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := sortedChanges(changes), sortedChanges(naiveChanges); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Changes of %s: expected %v, got %v", id, expected, got)
+	}
+
+	size, err := driver.DiffSize(id, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	naiveSize, err := naive.DiffSize(id, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != naiveSize {
+		t.Fatalf("DiffSize of %s: expected %d, got %d", id, naiveSize, size)
+	}
+
+	entries := readDiff(t, driver, id, parent)
+	naiveEntries := readDiff(t, naive, id, parent)
+	if len(entries) != len(naiveEntries) {
+		t.Fatalf("Diff of %s: expected %d entries, got %d", id, len(naiveEntries), len(entries))
+	}
+	for i := range entries {
+		if !reflect.DeepEqual(entries[i], naiveEntries[i]) {
+			t.Fatalf("Diff of %s: expected %+v, got %+v", id, naiveEntries[i], entries[i])
+		}
+	}
+}
+
+func sortedChanges(changes []archive.Change) []string {
+	var s []string
+	for _, change := range changes {
+		s = append(s, change.String())
+	}
+	sort.Strings(s)
+	return s
+}
+
+type diffEntry struct {
+	header tar.Header
+	data   string
+}
+
+func readDiff(t *testing.T, driver graphdriver.Driver, id, parent string) []diffEntry {
+	arch, err := driver.Diff(id, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arch.Close()
+
+	var entries []diffEntry
+	tr := tar.NewReader(arch)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(path.Base(hdr.Name), ".wh.") {
+			// Whiteouts are timestamped when they are written
+			hdr.ModTime, hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}, time.Time{}
+		}
+		entries = append(entries, diffEntry{*hdr, string(data)})
+	}
+	return entries
+}
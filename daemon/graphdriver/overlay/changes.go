@@ -0,0 +1,336 @@
+// +build linux
+
+package overlay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/pkg/system"
+)
+
+// The changes of a layer with an "upper" directory are found without
+// walking the whole root filesystem. Both the layer and its parent are seen
+// as their upper directory (none if the parent is the lower layer itself)
+// over the "root" directory of the lower layer, and only the directories
+// which are in one of the upper directories are walked, as the others are
+// the same on both sides.
+
+// In the upper directories, deleted files are whiteouts (character devices
+// with device number 0/0), and directories which were removed and created
+// again are opaque (they have the "trusted.overlay.opaque" xattr) and hide
+// the files of the lower directory. These are reported as deleted files,
+// which archive.ExportChanges writes as aufs-style ".wh." whiteouts.
+
+// Files are compared like archive.ChangesDirs does, so that the changes are
+// the same as the naive ones computed on the mounted layers.
+
+// overlayEntry is a file as seen in an overlay mount.
+type overlayEntry struct {
+	path  string // Path of the file in an upper or the lower directory
+	stat  *system.Stat_t
+	upper string // For directories, the upper directory, if any
+	lower string // For directories, the lower directory merged in it, if any
+}
+
+func (e *overlayEntry) isDir() bool {
+	return e.stat.Mode()&syscall.S_IFMT == syscall.S_IFDIR
+}
+
+func isWhiteout(stat *system.Stat_t) bool {
+	return stat.Mode()&syscall.S_IFMT == syscall.S_IFCHR && stat.Rdev() == 0
+}
+
+func isOpaque(dir string) (bool, error) {
+	opaque, err := system.Lgetxattr(dir, "trusted.overlay.opaque")
+	if err != nil {
+		return false, err
+	}
+	return string(opaque) == "y", nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// newOverlayRoot returns the root of an overlay of upper over lower, or of
+// lower alone if upper is "".
+func newOverlayRoot(upper, lower string) (*overlayEntry, error) {
+	root := &overlayEntry{path: lower, lower: lower}
+	if upper != "" {
+		root.path = upper
+		root.upper = upper
+		opaque, err := isOpaque(upper)
+		if err != nil {
+			return nil, err
+		}
+		if opaque {
+			root.lower = ""
+		}
+	}
+	stat, err := system.Lstat(root.path)
+	if err != nil {
+		return nil, err
+	}
+	root.stat = stat
+	return root, nil
+}
+
+// readOverlayDir returns the files of a directory by name, as they are
+// merged by overlay.
+func readOverlayDir(dir *overlayEntry) (map[string]*overlayEntry, error) {
+	children := make(map[string]*overlayEntry)
+	whiteouts := make(map[string]bool)
+
+	if dir.upper != "" {
+		names, err := readDirNames(dir.upper)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			p := path.Join(dir.upper, name)
+			stat, err := system.Lstat(p)
+			if err != nil {
+				return nil, err
+			}
+			if isWhiteout(stat) {
+				whiteouts[name] = true
+				continue
+			}
+			child := &overlayEntry{path: p, stat: stat}
+			if child.isDir() {
+				child.upper = p
+			}
+			children[name] = child
+		}
+	}
+
+	if dir.lower != "" {
+		names, err := readDirNames(dir.lower)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if whiteouts[name] {
+				continue
+			}
+			p := path.Join(dir.lower, name)
+			stat, err := system.Lstat(p)
+			if err != nil {
+				return nil, err
+			}
+			child := children[name]
+			if child == nil {
+				child = &overlayEntry{path: p, stat: stat}
+				if child.isDir() {
+					child.lower = p
+				}
+				children[name] = child
+				continue
+			}
+			// Only directories are merged, unless the upper one is opaque
+			if child.upper == "" || stat.Mode()&syscall.S_IFMT != syscall.S_IFDIR {
+				continue
+			}
+			opaque, err := isOpaque(child.upper)
+			if err != nil {
+				return nil, err
+			}
+			if !opaque {
+				child.lower = p
+			}
+		}
+	}
+
+	return children, nil
+}
+
+// entryChanged compares two files like archive.ChangesDirs does.
+func entryChanged(oldEntry, newEntry *overlayEntry) bool {
+	oldStat := oldEntry.stat
+	newStat := newEntry.stat
+	if oldStat.Mode() != newStat.Mode() ||
+		oldStat.Uid() != newStat.Uid() ||
+		oldStat.Gid() != newStat.Gid() ||
+		oldStat.Rdev() != newStat.Rdev() ||
+		// Don't look at size for dirs, its not a good measure of change
+		(oldStat.Mode()&syscall.S_IFDIR != syscall.S_IFDIR &&
+			(!sameFsTimeSpec(oldStat.Mtim(), newStat.Mtim()) || (oldStat.Size() != newStat.Size()))) {
+		return true
+	}
+	oldCapability, _ := system.Lgetxattr(oldEntry.path, "security.capability")
+	newCapability, _ := system.Lgetxattr(newEntry.path, "security.capability")
+	return !bytes.Equal(oldCapability, newCapability)
+}
+
+func sameFsTimeSpec(a, b syscall.Timespec) bool {
+	return a.Sec == b.Sec &&
+		(a.Nsec == b.Nsec || a.Nsec == 0 || b.Nsec == 0)
+}
+
+// addChanges appends the changes of the file at p and of the files under
+// it, in the same order as archive.ChangesDirs. oldEntry is nil if the file
+// is new, added is true if the file has already been reported as modified.
+func addChanges(p string, newEntry, oldEntry *overlayEntry, added bool, changes *[]archive.Change) error {
+	sizeAtEntry := len(*changes)
+
+	if oldEntry == nil {
+		*changes = append(*changes, archive.Change{Path: p, Kind: archive.ChangeAdd})
+		added = true
+	}
+	if !newEntry.isDir() {
+		return nil
+	}
+
+	newChildren, err := readOverlayDir(newEntry)
+	if err != nil {
+		return err
+	}
+	oldChildren := make(map[string]*overlayEntry)
+	if oldEntry != nil && oldEntry.isDir() {
+		if oldChildren, err = readOverlayDir(oldEntry); err != nil {
+			return err
+		}
+	}
+
+	for name, newChild := range newChildren {
+		oldChild := oldChildren[name]
+		delete(oldChildren, name)
+
+		childAdded := false
+		if oldChild != nil {
+			if newChild.path == oldChild.path {
+				// The same file of the lower layer, with the same contents
+				continue
+			}
+			if entryChanged(oldChild, newChild) {
+				*changes = append(*changes, archive.Change{Path: path.Join(p, name), Kind: archive.ChangeModify})
+				childAdded = true
+			}
+		}
+
+		if err := addChanges(path.Join(p, name), newChild, oldChild, childAdded, changes); err != nil {
+			return err
+		}
+	}
+	for name := range oldChildren {
+		*changes = append(*changes, archive.Change{Path: path.Join(p, name), Kind: archive.ChangeDelete})
+	}
+
+	// Directories with changes inside are reported as modified, so that
+	// their permissions are restored.
+	if len(*changes) > sizeAtEntry && !added && p != "/" {
+		*changes = append(*changes, archive.Change{})
+		copy((*changes)[sizeAtEntry+1:], (*changes)[sizeAtEntry:])
+		(*changes)[sizeAtEntry] = archive.Change{Path: p, Kind: archive.ChangeModify}
+	}
+	return nil
+}
+
+// diffRoots returns the roots of the layer and of its parent to compare, or
+// ErrDiffFallback if the layer isn't an overlay on the lower layer of its
+// parent.
+func (d *Driver) diffRoots(id, parent string) (newRoot, oldRoot *overlayEntry, err error) {
+	if parent == "" {
+		return nil, nil, ErrDiffFallback
+	}
+
+	dir := d.dir(id)
+	lowerId, err := ioutil.ReadFile(path.Join(dir, "lower-id"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The layer has a "root" dir
+			return nil, nil, ErrDiffFallback
+		}
+		return nil, nil, err
+	}
+	lowerDir := path.Join(d.dir(string(lowerId)), "root")
+
+	parentDir := d.dir(parent)
+	parentUpperDir := ""
+	if string(lowerId) != parent {
+		parentLowerId, err := ioutil.ReadFile(path.Join(parentDir, "lower-id"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		if err != nil || !bytes.Equal(parentLowerId, lowerId) {
+			return nil, nil, ErrDiffFallback
+		}
+		parentUpperDir = path.Join(parentDir, "upper")
+	}
+
+	if newRoot, err = newOverlayRoot(path.Join(dir, "upper"), lowerDir); err != nil {
+		return nil, nil, err
+	}
+	if oldRoot, err = newOverlayRoot(parentUpperDir, lowerDir); err != nil {
+		return nil, nil, err
+	}
+	return newRoot, oldRoot, nil
+}
+
+// Changes returns the changes of the layer from the upper directories, or
+// ErrDiffFallback if they can't be used.
+func (d *Driver) Changes(id, parent string) ([]archive.Change, error) {
+	newRoot, oldRoot, err := d.diffRoots(id, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []archive.Change
+	if err := addChanges("/", newRoot, oldRoot, false, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Diff exports the changes of the layer found in the upper directories from
+// the mounted layer, or returns ErrDiffFallback if they can't be used.
+func (d *Driver) Diff(id, parent string) (archive.Archive, error) {
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	layerFs, err := d.Get(id, "")
+	if err != nil {
+		return nil, err
+	}
+
+	arch, err := archive.ExportChanges(layerFs, changes)
+	if err != nil {
+		d.Put(id)
+		return nil, err
+	}
+	return ioutils.NewReadCloserWrapper(arch, func() error {
+		err := arch.Close()
+		d.Put(id)
+		return err
+	}), nil
+}
+
+// DiffSize returns the size of the changes of the layer found in the upper
+// directories, or ErrDiffFallback if they can't be used.
+func (d *Driver) DiffSize(id, parent string) (int64, error) {
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return 0, err
+	}
+
+	layerFs, err := d.Get(id, "")
+	if err != nil {
+		return 0, err
+	}
+	defer d.Put(id)
+
+	return archive.ChangesSize(layerFs, changes), nil
+}
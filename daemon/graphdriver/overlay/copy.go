@@ -105,7 +105,8 @@ func copyDir(srcDir, dstDir string, flags CopyFlags) error {
 				return err
 			}
 
-		case os.ModeDevice:
+		case os.ModeDevice, os.ModeDevice | os.ModeCharDevice:
+			// Character devices include the whiteouts of upper layers
 			if err := syscall.Mknod(dstPath, stat.Mode, int(stat.Rdev)); err != nil {
 				return err
 			}
@@ -14,21 +14,28 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/daemon/graphdriver/quota"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/docker/libcontainer/label"
 )
 
-// This is a small wrapper over the NaiveDiffWriter that lets us have a custom
-// implementation of ApplyDiff()
+// This is a small wrapper over the NaiveDiffWriter that lets us have custom
+// implementations of ApplyDiff(), Diff(), Changes() and DiffSize(), which
+// fall back to the naive ones when they can't be used
 
 var (
 	ErrApplyDiffFallback = fmt.Errorf("Fall back to normal ApplyDiff")
+	ErrDiffFallback      = fmt.Errorf("Fall back to naive Diff")
 )
 
 type ApplyDiffProtoDriver interface {
 	graphdriver.ProtoDriver
+	graphdriver.QuotaDriver
 	ApplyDiff(id, parent string, diff archive.ArchiveReader) (size int64, err error)
+	Diff(id, parent string) (archive.Archive, error)
+	Changes(id, parent string) ([]archive.Change, error)
+	DiffSize(id, parent string) (size int64, err error)
 }
 
 type naiveDiffDriverWithApply struct {
@@ -51,6 +58,38 @@ func (d *naiveDiffDriverWithApply) ApplyDiff(id, parent string, diff archive.Arc
 	return b, err
 }
 
+func (d *naiveDiffDriverWithApply) Diff(id, parent string) (archive.Archive, error) {
+	arch, err := d.applyDiff.Diff(id, parent)
+	if err == ErrDiffFallback {
+		return d.Driver.Diff(id, parent)
+	}
+	return arch, err
+}
+
+func (d *naiveDiffDriverWithApply) Changes(id, parent string) ([]archive.Change, error) {
+	changes, err := d.applyDiff.Changes(id, parent)
+	if err == ErrDiffFallback {
+		return d.Driver.Changes(id, parent)
+	}
+	return changes, err
+}
+
+func (d *naiveDiffDriverWithApply) DiffSize(id, parent string) (int64, error) {
+	size, err := d.applyDiff.DiffSize(id, parent)
+	if err == ErrDiffFallback {
+		return d.Driver.DiffSize(id, parent)
+	}
+	return size, err
+}
+
+func (d *naiveDiffDriverWithApply) CreateWithQuota(id, parent string, size uint64) error {
+	return d.applyDiff.CreateWithQuota(id, parent, size)
+}
+
+func (d *naiveDiffDriverWithApply) ValidateQuota(size uint64) error {
+	return d.applyDiff.ValidateQuota(size)
+}
+
 // This backend uses the overlay union filesystem for containers
 // plus hard link file sharing for images.
 
@@ -79,6 +118,13 @@ func (d *naiveDiffDriverWithApply) ApplyDiff(id, parent string, diff archive.Arc
 // of that. This means all child images share file (but not directory)
 // data with the parent.
 
+// The changes of layers with an "upper" directory are read from the upper
+// directories instead of comparing the mounted layers, see changes.go.
+
+// When the driver home is on XFS mounted with the pquota option, the size of
+// layers can be limited with project quotas, e.g. to limit the files written
+// by a container to its upper directory.
+
 type ActiveMount struct {
 	count   int
 	path    string
@@ -88,6 +134,7 @@ type Driver struct {
 	home       string
 	sync.Mutex // Protects concurrent modification to active
 	active     map[string]*ActiveMount
+	quotaCtl   *quota.Control
 }
 
 var backingFs = "<unknown>"
@@ -133,6 +180,12 @@ func Init(home string, options []string) (graphdriver.Driver, error) {
 		active: make(map[string]*ActiveMount),
 	}
 
+	if fsMagic == graphdriver.FsMagicXfs {
+		if d.quotaCtl, err = quota.NewControl(home); err != nil {
+			logrus.Debugf("overlay: quotas are not supported: %v", err)
+		}
+	}
+
 	return NaiveDiffDriverWithApply(d), nil
 }
 
@@ -252,6 +305,31 @@ func (d *Driver) Create(id string, parent string) (retErr error) {
 	return copyDir(parentUpperDir, upperDir, 0)
 }
 
+// ValidateQuota returns ErrQuotaNotSupported unless the driver home is on a
+// filesystem with project quotas.
+func (d *Driver) ValidateQuota(size uint64) error {
+	if d.quotaCtl == nil {
+		return graphdriver.ErrQuotaNotSupported
+	}
+	return nil
+}
+
+// CreateWithQuota creates the layer like Create, limiting the size of the
+// files written to it with a project quota.
+func (d *Driver) CreateWithQuota(id, parent string, size uint64) error {
+	if err := d.ValidateQuota(size); err != nil {
+		return err
+	}
+	if err := d.Create(id, parent); err != nil {
+		return err
+	}
+	if err := d.quotaCtl.SetQuota(d.dir(id), size); err != nil {
+		d.Remove(id)
+		return err
+	}
+	return nil
+}
+
 func (d *Driver) dir(id string) string {
 	return path.Join(d.home, id)
 }
@@ -261,6 +339,9 @@ func (d *Driver) Remove(id string) error {
 	if _, err := os.Stat(dir); err != nil {
 		return err
 	}
+	if d.quotaCtl != nil {
+		d.quotaCtl.RemoveQuota(dir)
+	}
 	return os.RemoveAll(dir)
 }
 
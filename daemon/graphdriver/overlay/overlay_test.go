@@ -23,6 +23,14 @@ func TestOverlayCreateSnap(t *testing.T) {
 	graphtest.DriverTestCreateSnap(t, "overlay")
 }
 
+func TestOverlayCreateWithQuota(t *testing.T) {
+	graphtest.DriverTestCreateWithQuota(t, "overlay")
+}
+
+func TestOverlayDiffNaive(t *testing.T) {
+	graphtest.DriverTestDiffNaive(t, "overlay")
+}
+
 func TestOverlayTeardown(t *testing.T) {
 	graphtest.PutDriver(t)
 }
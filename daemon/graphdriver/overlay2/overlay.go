@@ -0,0 +1,399 @@
+// +build linux
+
+package overlay2
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/daemon/graphdriver/quota"
+	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/docker/libcontainer/label"
+)
+
+// This backend uses the overlay union filesystem with multiple lower
+// directories, which is supported since Linux 4.0, so that every layer
+// only stores its own changes instead of a copy of its parent.
+
+// Each layer has a "diff" directory with its changes and a "link" file
+// with a short random identifier. The "l" directory of the driver home has
+// a symlink named after the identifier of every layer to its "diff"
+// directory, so that the mount options of a layer with many parents stay
+// short. Layers with a parent also have a "lower" file with the links of
+// the diffs of their parents, from the closest to the farthest, separated
+// by colons, as well as the "work" and "merged" directories needed to
+// mount the overlay in "merged".
+
+// When the mount options are still longer than a page with absolute paths,
+// the overlay is mounted with paths relative to the driver home by a child
+// process running in it, see mount.go.
+
+// When the driver home is on XFS mounted with the pquota option, the size of
+// layers can be limited with project quotas, like with the overlay driver.
+
+const (
+	linkDir  = "l"
+	idLength = 26
+	// The number of parents of a layer is limited so that its lower
+	// directories fit in the mount options
+	maxDepth = 128
+)
+
+type activeMount struct {
+	count   int
+	path    string
+	mounted bool
+}
+
+type Driver struct {
+	home       string
+	sync.Mutex // Protects concurrent modification to active
+	active     map[string]*activeMount
+	quotaCtl   *quota.Control
+}
+
+var backingFs = "<unknown>"
+
+func init() {
+	graphdriver.Register("overlay2", Init)
+}
+
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	if err := supportsOverlay(); err != nil {
+		return nil, graphdriver.ErrNotSupported
+	}
+
+	// Multiple lower directories are supported since Linux 4.0
+	v, err := kernel.GetKernelVersion()
+	if err != nil {
+		return nil, err
+	}
+	if kernel.CompareKernelVersion(v, &kernel.KernelVersionInfo{Kernel: 4, Major: 0, Minor: 0}) < 0 {
+		logrus.Errorf("'overlay2' requires kernel 4.0 to use multiple lower directories, found %s", v)
+		return nil, graphdriver.ErrNotSupported
+	}
+
+	fsMagic, err := graphdriver.GetFSMagic(home)
+	if err != nil {
+		return nil, err
+	}
+	if fsName, ok := graphdriver.FsNames[fsMagic]; ok {
+		backingFs = fsName
+	}
+
+	// check if they are running over btrfs, aufs or zfs
+	switch fsMagic {
+	case graphdriver.FsMagicBtrfs:
+		logrus.Error("'overlay2' is not supported over btrfs.")
+		return nil, graphdriver.ErrIncompatibleFS
+	case graphdriver.FsMagicAufs:
+		logrus.Error("'overlay2' is not supported over aufs.")
+		return nil, graphdriver.ErrIncompatibleFS
+	case graphdriver.FsMagicZfs:
+		logrus.Error("'overlay2' is not supported over zfs.")
+		return nil, graphdriver.ErrIncompatibleFS
+	}
+
+	// Create the driver home dir and the dir of the links
+	if err := os.MkdirAll(path.Join(home, linkDir), 0700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	d := &Driver{
+		home:   home,
+		active: make(map[string]*activeMount),
+	}
+
+	if fsMagic == graphdriver.FsMagicXfs {
+		if d.quotaCtl, err = quota.NewControl(home); err != nil {
+			logrus.Debugf("overlay2: quotas are not supported: %v", err)
+		}
+	}
+
+	return graphdriver.NaiveDiffDriver(d), nil
+}
+
+func supportsOverlay() error {
+	// We can try to modprobe overlay first before looking at
+	// proc/filesystems for when overlay is supported
+	exec.Command("modprobe", "overlay").Run()
+
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if s.Text() == "nodev\toverlay" {
+			return nil
+		}
+	}
+	logrus.Error("'overlay' not found as a supported filesystem on this host. Please ensure kernel is new enough and has overlay support loaded.")
+	return graphdriver.ErrNotSupported
+}
+
+func (d *Driver) String() string {
+	return "overlay2"
+}
+
+func (d *Driver) Status() [][2]string {
+	status := [][2]string{
+		{"Backing Filesystem", backingFs},
+	}
+	// The layers of the overlay driver can't be used by this one
+	overlayHome := path.Join(path.Dir(d.home), "overlay")
+	if fis, err := ioutil.ReadDir(overlayHome); err == nil && len(fis) > 0 {
+		status = append(status, [2]string{"Migration", fmt.Sprintf("the images and containers of the overlay driver in %s are not available with overlay2, pull or load the images again and remove %s once they are no longer needed", overlayHome, overlayHome)})
+	}
+	return status
+}
+
+func (d *Driver) Cleanup() error {
+	return nil
+}
+
+func (d *Driver) dir(id string) string {
+	return path.Join(d.home, id)
+}
+
+// generateID returns a random identifier of idLength characters for the
+// link of a layer.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "=")[:idLength], nil
+}
+
+// getLower returns the lower directories of a layer on top of parent.
+func (d *Driver) getLower(parent string) (string, error) {
+	parentDir := d.dir(parent)
+
+	// Ensure parent exists
+	if _, err := os.Lstat(parentDir); err != nil {
+		return "", err
+	}
+
+	parentLink, err := ioutil.ReadFile(path.Join(parentDir, "link"))
+	if err != nil {
+		return "", err
+	}
+	lowers := []string{path.Join(linkDir, string(parentLink))}
+
+	parentLower, err := ioutil.ReadFile(path.Join(parentDir, "lower"))
+	if err == nil {
+		lowers = append(lowers, strings.Split(string(parentLower), ":")...)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(lowers) > maxDepth {
+		return "", fmt.Errorf("Cannot create a layer on top of %s: more than %d parents", parent, maxDepth)
+	}
+	return strings.Join(lowers, ":"), nil
+}
+
+func (d *Driver) Create(id string, parent string) (retErr error) {
+	dir := d.dir(id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+
+	defer func() {
+		// Clean up on failure
+		if retErr != nil {
+			d.Remove(id)
+		}
+	}()
+
+	// The root of the layer has the mode of the root of its parent
+	var mode os.FileMode = 0755
+	if parent != "" {
+		s, err := os.Lstat(path.Join(d.dir(parent), "diff"))
+		if err != nil {
+			return err
+		}
+		mode = s.Mode()
+	}
+	if err := os.Mkdir(path.Join(dir, "diff"), mode); err != nil {
+		return err
+	}
+
+	lid, err := generateID()
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(path.Join("..", id, "diff"), path.Join(d.home, linkDir, lid)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "link"), []byte(lid), 0644); err != nil {
+		return err
+	}
+
+	// Toplevel images are just a "diff" dir
+	if parent == "" {
+		return nil
+	}
+
+	lower, err := d.getLower(parent)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(path.Join(dir, "work"), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path.Join(dir, "merged"), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, "lower"), []byte(lower), 0666)
+}
+
+// ValidateQuota returns ErrQuotaNotSupported unless the driver home is on a
+// filesystem with project quotas.
+func (d *Driver) ValidateQuota(size uint64) error {
+	if d.quotaCtl == nil {
+		return graphdriver.ErrQuotaNotSupported
+	}
+	return nil
+}
+
+// CreateWithQuota creates the layer like Create, limiting the size of the
+// files written to it with a project quota.
+func (d *Driver) CreateWithQuota(id, parent string, size uint64) error {
+	if err := d.ValidateQuota(size); err != nil {
+		return err
+	}
+	if err := d.Create(id, parent); err != nil {
+		return err
+	}
+	if err := d.quotaCtl.SetQuota(d.dir(id), size); err != nil {
+		d.Remove(id)
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) Remove(id string) error {
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	if lid, err := ioutil.ReadFile(path.Join(dir, "link")); err == nil && len(lid) > 0 {
+		if err := os.Remove(path.Join(d.home, linkDir, string(lid))); err != nil && !os.IsNotExist(err) {
+			logrus.Debugf("Failed to remove the link of %s: %v", id, err)
+		}
+	}
+	if d.quotaCtl != nil {
+		d.quotaCtl.RemoveQuota(dir)
+	}
+	return os.RemoveAll(dir)
+}
+
+func (d *Driver) Get(id string, mountLabel string) (string, error) {
+	// Protect the d.active from concurrent access
+	d.Lock()
+	defer d.Unlock()
+
+	mount := d.active[id]
+	if mount != nil {
+		mount.count++
+		return mount.path, nil
+	}
+
+	mount = &activeMount{count: 1}
+
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+
+	// If id has no lower, just return its diff
+	lower, err := ioutil.ReadFile(path.Join(dir, "lower"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		mount.path = path.Join(dir, "diff")
+		d.active[id] = mount
+		return mount.path, nil
+	}
+
+	lowers := strings.Split(string(lower), ":")
+	absLowers := make([]string, len(lowers))
+	for i, l := range lowers {
+		absLowers[i] = path.Join(d.home, l)
+		if _, err := os.Stat(absLowers[i]); err != nil {
+			return "", err
+		}
+	}
+
+	mergedDir := path.Join(dir, "merged")
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(absLowers, ":"), path.Join(dir, "diff"), path.Join(dir, "work"))
+	mountData := label.FormatMountLabel(opts, mountLabel)
+	pageSize := syscall.Getpagesize()
+	if len(mountData) < pageSize {
+		err = syscall.Mount("overlay", mergedDir, "overlay", 0, mountData)
+	} else {
+		// Paths relative to the driver home are much shorter
+		opts = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", string(lower), path.Join(id, "diff"), path.Join(id, "work"))
+		mountData = label.FormatMountLabel(opts, mountLabel)
+		if len(mountData) >= pageSize {
+			return "", fmt.Errorf("error creating overlay mount to %s: mount options too long (%d bytes)", mergedDir, len(mountData))
+		}
+		err = mountFrom(d.home, "overlay", path.Join(id, "merged"), "overlay", 0, mountData)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error creating overlay mount to %s: %v", mergedDir, err)
+	}
+	mount.path = mergedDir
+	mount.mounted = true
+	d.active[id] = mount
+
+	return mount.path, nil
+}
+
+func (d *Driver) Put(id string) error {
+	// Protect the d.active from concurrent access
+	d.Lock()
+	defer d.Unlock()
+
+	mount := d.active[id]
+	if mount == nil {
+		logrus.Debugf("Put on a non-mounted device %s", id)
+		return nil
+	}
+
+	mount.count--
+	if mount.count > 0 {
+		return nil
+	}
+
+	defer delete(d.active, id)
+	if mount.mounted {
+		err := syscall.Unmount(mount.path, 0)
+		if err != nil {
+			logrus.Debugf("Failed to unmount %s overlay: %v", id, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
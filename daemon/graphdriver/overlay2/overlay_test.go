@@ -0,0 +1,127 @@
+// +build linux
+
+package overlay2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver/graphtest"
+	"github.com/docker/docker/pkg/reexec"
+)
+
+func init() {
+	reexec.Init()
+}
+
+// This avoids creating a new driver for each test if all tests are run
+// Make sure to put new tests between TestOverlaySetup and TestOverlayTeardown
+func TestOverlaySetup(t *testing.T) {
+	graphtest.GetDriver(t, "overlay2")
+}
+
+func TestOverlayCreateEmpty(t *testing.T) {
+	graphtest.DriverTestCreateEmpty(t, "overlay2")
+}
+
+func TestOverlayCreateBase(t *testing.T) {
+	graphtest.DriverTestCreateBase(t, "overlay2")
+}
+
+func TestOverlayCreateSnap(t *testing.T) {
+	graphtest.DriverTestCreateSnap(t, "overlay2")
+}
+
+func TestOverlayCreateWithQuota(t *testing.T) {
+	graphtest.DriverTestCreateWithQuota(t, "overlay2")
+}
+
+func TestOverlayDiffNaive(t *testing.T) {
+	graphtest.DriverTestDiffNaive(t, "overlay2")
+}
+
+// TestOverlayDeepLayers verifies that the files of all the parents of a
+// layer are visible in it, with mount options too long for absolute paths.
+func TestOverlayDeepLayers(t *testing.T) {
+	driver := graphtest.GetDriver(t, "overlay2")
+	defer graphtest.PutDriver(t)
+
+	parent := ""
+	for i := 0; i <= maxDepth; i++ {
+		id := fmt.Sprintf("layer-%03d-%s", i, "0123456789abcdef0123456789abcdef0123456789abcdef")
+		if err := driver.Create(id, parent); err != nil {
+			t.Fatal(err)
+		}
+		defer driver.Remove(id)
+
+		dir, err := driver.Get(id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path.Join(dir, id), []byte(id), 0644)
+		driver.Put(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = id
+	}
+
+	if err := driver.Create("too deep", parent); err == nil {
+		driver.Remove("too deep")
+		t.Fatalf("Expected creating a layer with more than %d parents to fail", maxDepth)
+	}
+
+	dir, err := driver.Get(parent, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Put(parent)
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != maxDepth+1 {
+		t.Fatalf("Expected %d files, got %d", maxDepth+1, len(fis))
+	}
+}
+
+func TestOverlayRemoveLink(t *testing.T) {
+	driver := graphtest.GetDriver(t, "overlay2")
+	defer graphtest.PutDriver(t)
+
+	if err := driver.Create("Link", ""); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := driver.Get("Link", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver.Put("Link")
+	home := path.Dir(path.Dir(dir))
+
+	lid, err := ioutil.ReadFile(path.Join(home, "Link", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lid) != idLength {
+		t.Fatalf("Expected a link of %d characters, got %q", idLength, lid)
+	}
+	if target, err := os.Readlink(path.Join(home, linkDir, string(lid))); err != nil || target != "../Link/diff" {
+		t.Fatalf("Expected a link to ../Link/diff, got %q, %v", target, err)
+	}
+
+	if err := driver.Remove("Link"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path.Join(home, linkDir, string(lid))); !os.IsNotExist(err) {
+		t.Fatalf("Expected the link to be removed, got %v", err)
+	}
+}
+
+func TestOverlayTeardown(t *testing.T) {
+	graphtest.PutDriver(t)
+}
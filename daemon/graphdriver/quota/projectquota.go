@@ -0,0 +1,237 @@
+// +build linux,cgo
+
+// Package quota limits the size of directories with the project quotas of
+// XFS. Every limited directory gets a project ID, inherited by the files
+// created in it, and the quota of the project is set on the block device of
+// the filesystem. Project IDs are allocated above the one of the directory
+// holding the limited directories, so that they don't collide with projects
+// set up on the host.
+package quota
+
+/*
+#include <stdlib.h>
+#include <linux/fs.h>
+#include <linux/quota.h>
+#include <linux/dqblk_xfs.h>
+
+#ifndef FS_XFLAG_PROJINHERIT
+struct fsxattr {
+	__u32		fsx_xflags;
+	__u32		fsx_extsize;
+	__u32		fsx_nextents;
+	__u32		fsx_projid;
+	unsigned char	fsx_pad[12];
+};
+#define FS_XFLAG_PROJINHERIT	0x00000200
+#endif
+#ifndef FS_IOC_FSGETXATTR
+#define FS_IOC_FSGETXATTR		_IOR ('X', 31, struct fsxattr)
+#endif
+#ifndef FS_IOC_FSSETXATTR
+#define FS_IOC_FSSETXATTR		_IOW ('X', 32, struct fsxattr)
+#endif
+
+#ifndef PRJQUOTA
+#define PRJQUOTA	2
+#endif
+#ifndef XFS_PROJ_QUOTA
+#define XFS_PROJ_QUOTA	2
+#endif
+#ifndef Q_XSETPQLIM
+#define Q_XSETPQLIM QCMD(Q_XSETQLIM, PRJQUOTA)
+#endif
+*/
+import "C"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Control sets the quotas of the directories under a home directory.
+type Control struct {
+	sync.Mutex        // Protects nextProjectID and projects
+	backingFsBlockDev string
+	nextProjectID     uint32
+	projects          map[string]uint32
+}
+
+// NewControl returns a Control for the directories under home, or an error
+// if the filesystem of home doesn't support project quotas, e.g. because it
+// isn't XFS mounted with the pquota option.
+func NewControl(home string) (*Control, error) {
+	minProjectID, err := getProjectID(home)
+	if err != nil {
+		return nil, err
+	}
+	minProjectID++
+
+	backingFsBlockDev, err := makeBackingFsDev(home)
+	if err != nil {
+		return nil, err
+	}
+	// Setting an unlimited quota fails if project quotas aren't enabled.
+	if err := setProjectQuota(backingFsBlockDev, minProjectID, 0); err != nil {
+		return nil, err
+	}
+
+	q := &Control{
+		backingFsBlockDev: backingFsBlockDev,
+		nextProjectID:     minProjectID + 1,
+		projects:          make(map[string]uint32),
+	}
+	if err := q.loadProjects(home); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetQuota limits the size of the files of the directory to size bytes.
+// The files already in the directory are accounted for.
+func (q *Control) SetQuota(dir string, size uint64) error {
+	q.Lock()
+	projectID, ok := q.projects[dir]
+	if !ok {
+		projectID = q.nextProjectID
+		q.nextProjectID++
+		q.projects[dir] = projectID
+	}
+	q.Unlock()
+
+	if !ok {
+		err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() && !fi.Mode().IsRegular() {
+				return nil
+			}
+			return setProjectID(p, projectID, fi.IsDir())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return setProjectQuota(q.backingFsBlockDev, projectID, size)
+}
+
+// RemoveQuota forgets the quota of the directory once it has been removed.
+func (q *Control) RemoveQuota(dir string) {
+	q.Lock()
+	delete(q.projects, dir)
+	q.Unlock()
+}
+
+// loadProjects records the project IDs of the directories of home, so that
+// they aren't given to other directories.
+func (q *Control) loadProjects(home string) error {
+	fis, err := ioutil.ReadDir(home)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		dir := path.Join(home, fi.Name())
+		projectID, err := getProjectID(dir)
+		if err != nil {
+			return err
+		}
+		if projectID > 0 {
+			q.projects[dir] = projectID
+		}
+		if projectID >= q.nextProjectID {
+			q.nextProjectID = projectID + 1
+		}
+	}
+	return nil
+}
+
+func setProjectQuota(backingFsBlockDev string, projectID uint32, size uint64) error {
+	var d C.fs_disk_quota_t
+	d.d_version = C.FS_DQUOT_VERSION
+	d.d_id = C.__u32(projectID)
+	d.d_flags = C.XFS_PROJ_QUOTA
+	d.d_fieldmask = C.FS_DQ_BHARD | C.FS_DQ_BSOFT
+	// Limits are in basic blocks of 512 bytes.
+	d.d_blk_hardlimit = C.__u64(size / 512)
+	d.d_blk_softlimit = d.d_blk_hardlimit
+
+	dev := C.CString(backingFsBlockDev)
+	defer C.free(unsafe.Pointer(dev))
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, C.Q_XSETPQLIM,
+		uintptr(unsafe.Pointer(dev)), uintptr(d.d_id),
+		uintptr(unsafe.Pointer(&d)), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("Failed to set the quota of project %d on %s: %v", projectID, backingFsBlockDev, errno.Error())
+	}
+	return nil
+}
+
+func getProjectID(p string) (uint32, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var fsx C.struct_fsxattr
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), C.FS_IOC_FSGETXATTR,
+		uintptr(unsafe.Pointer(&fsx)))
+	if errno != 0 {
+		return 0, fmt.Errorf("Failed to get the project ID of %s: %v", p, errno.Error())
+	}
+	return uint32(fsx.fsx_projid), nil
+}
+
+// setProjectID sets the project ID of the file, inherited by the files
+// created in it if it is a directory.
+func setProjectID(p string, projectID uint32, dir bool) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var fsx C.struct_fsxattr
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), C.FS_IOC_FSGETXATTR,
+		uintptr(unsafe.Pointer(&fsx)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to get the project ID of %s: %v", p, errno.Error())
+	}
+	fsx.fsx_projid = C.__u32(projectID)
+	if dir {
+		fsx.fsx_xflags |= C.FS_XFLAG_PROJINHERIT
+	}
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), C.FS_IOC_FSSETXATTR,
+		uintptr(unsafe.Pointer(&fsx)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to set the project ID of %s: %v", p, errno.Error())
+	}
+	return nil
+}
+
+// makeBackingFsDev creates a block device node of the filesystem of home in
+// home, to pass to quotactl.
+func makeBackingFsDev(home string) (string, error) {
+	fi, err := os.Stat(home)
+	if err != nil {
+		return "", err
+	}
+
+	backingFsBlockDev := path.Join(home, "backingFsBlockDev")
+	syscall.Unlink(backingFsBlockDev)
+	stat := fi.Sys().(*syscall.Stat_t)
+	if err := syscall.Mknod(backingFsBlockDev, syscall.S_IFBLK|0600, int(stat.Dev)); err != nil {
+		return "", fmt.Errorf("Failed to create the block device of %s: %v", home, err)
+	}
+	return backingFsBlockDev, nil
+}
@@ -0,0 +1,26 @@
+// +build !linux !cgo
+
+package quota
+
+import (
+	"errors"
+)
+
+var errNotSupported = errors.New("project quotas are not supported on this platform")
+
+// Control sets the quotas of the directories under a home directory.
+type Control struct{}
+
+// NewControl returns an error, project quotas are only supported on Linux.
+func NewControl(home string) (*Control, error) {
+	return nil, errNotSupported
+}
+
+// SetQuota limits the size of the files of the directory to size bytes.
+func (q *Control) SetQuota(dir string, size uint64) error {
+	return errNotSupported
+}
+
+// RemoveQuota forgets the quota of the directory once it has been removed.
+func (q *Control) RemoveQuota(dir string) {
+}
@@ -0,0 +1,67 @@
+// +build linux
+
+package zfs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// run runs the zfs or zpool command and returns its output, or its error
+// output as error.
+func run(command string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logrus.Debugf("[zfs] %s %s", command, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s failed: %s", command, strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func zfs(args ...string) error {
+	_, err := run("zfs", args...)
+	return err
+}
+
+// zfsGet returns the exact values of the properties of the dataset.
+func zfsGet(name string, properties ...string) ([]string, error) {
+	out, err := run("zfs", "get", "-H", "-p", "-o", "value", strings.Join(properties, ","), name)
+	if err != nil {
+		return nil, err
+	}
+	values := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(values) != len(properties) {
+		return nil, fmt.Errorf("unexpected output of zfs get: %q", out)
+	}
+	return values, nil
+}
+
+// zfsList returns the names of the filesystems under the dataset, itself
+// included.
+func zfsList(name string) ([]string, error) {
+	out, err := run("zfs", "list", "-H", "-r", "-t", "filesystem", "-o", "name", name)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// zpoolList returns the values of the properties of the pool.
+func zpoolList(pool string, properties ...string) ([]string, error) {
+	out, err := run("zpool", "list", "-H", "-o", strings.Join(properties, ","), pool)
+	if err != nil {
+		return nil, err
+	}
+	values := strings.Split(strings.TrimSpace(out), "\t")
+	if len(values) != len(properties) {
+		return nil, fmt.Errorf("unexpected output of zpool list: %q", out)
+	}
+	return values, nil
+}
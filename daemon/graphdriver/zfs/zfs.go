@@ -0,0 +1,311 @@
+// +build linux
+
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/mount"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/units"
+	"github.com/docker/libcontainer/label"
+)
+
+// This backend stores every layer in a ZFS dataset under a parent dataset,
+// by default the one holding the graph root. Layers without a parent are
+// new filesystems, other layers are clones of a snapshot of their parent.
+// The snapshot is destroyed together with the clone. Datasets have a legacy
+// mountpoint and are mounted under the "graph" directory of the driver home
+// while in use.
+
+type activeMount struct {
+	count int
+	path  string
+}
+
+type Driver struct {
+	home        string
+	fsName      string
+	sync.Mutex  // Protects concurrent modification to active and filesystems
+	active      map[string]*activeMount
+	filesystems map[string]bool
+}
+
+func init() {
+	graphdriver.Register("zfs", Init)
+}
+
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	if _, err := exec.LookPath("zfs"); err != nil {
+		logrus.Debugf("zfs command is not available: %v", err)
+		return nil, graphdriver.ErrPrerequisites
+	}
+	if _, err := os.Stat("/dev/zfs"); err != nil {
+		logrus.Debugf("Cannot open /dev/zfs: %v", err)
+		return nil, graphdriver.ErrPrerequisites
+	}
+
+	fsName, err := parseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	if fsName == "" {
+		fsMagic, err := graphdriver.GetFSMagic(home)
+		if err != nil {
+			return nil, err
+		}
+		if fsMagic != graphdriver.FsMagicZfs {
+			return nil, graphdriver.ErrPrerequisites
+		}
+		if fsName, err = lookupDataset(path.Dir(home)); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := zfsGet(fsName, "type"); err != nil {
+		return nil, fmt.Errorf("Cannot open %s: %v", fsName, err)
+	}
+	filesystems, err := zfsList(fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return nil, err
+	}
+	if err := mount.MakePrivate(home); err != nil {
+		return nil, err
+	}
+
+	d := &Driver{
+		home:        home,
+		fsName:      fsName,
+		active:      make(map[string]*activeMount),
+		filesystems: make(map[string]bool),
+	}
+	for _, name := range filesystems {
+		d.filesystems[name] = true
+	}
+
+	return graphdriver.NaiveDiffDriver(d), nil
+}
+
+func parseOptions(options []string) (string, error) {
+	var fsName string
+	for _, option := range options {
+		key, val, err := parsers.ParseKeyValueOpt(option)
+		if err != nil {
+			return "", err
+		}
+		key = strings.ToLower(key)
+		switch key {
+		case "zfs.fsname":
+			if val == "" || strings.Contains(val, "@") {
+				return "", fmt.Errorf("Invalid zfs.fsname %s", val)
+			}
+			fsName = strings.TrimSuffix(val, "/")
+		default:
+			return "", fmt.Errorf("Unknown option %s", key)
+		}
+	}
+	return fsName, nil
+}
+
+// lookupDataset returns the ZFS dataset mounted at dir or at the closest of
+// its parents.
+func lookupDataset(dir string) (string, error) {
+	mounts, err := mount.GetMounts()
+	if err != nil {
+		return "", err
+	}
+	var found *mount.MountInfo
+	for _, m := range mounts {
+		if m.Fstype != "zfs" {
+			continue
+		}
+		if dir != m.Mountpoint && !strings.HasPrefix(dir, strings.TrimSuffix(m.Mountpoint, "/")+"/") {
+			continue
+		}
+		if found == nil || len(m.Mountpoint) > len(found.Mountpoint) {
+			found = m
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("Failed to find the zfs dataset mounted on %s, use zfs.fsname to set it", dir)
+	}
+	return found.Source, nil
+}
+
+func (d *Driver) String() string {
+	return "zfs"
+}
+
+func (d *Driver) Status() [][2]string {
+	pool := strings.SplitN(d.fsName, "/", 2)[0]
+	status := [][2]string{{"Zpool", pool}}
+	if values, err := zpoolList(pool, "health", "size", "allocated", "free"); err == nil {
+		status = append(status,
+			[2]string{"Zpool Health", values[0]},
+			[2]string{"Zpool Size", values[1]},
+			[2]string{"Zpool Used", values[2]},
+			[2]string{"Zpool Free", values[3]},
+		)
+	} else {
+		logrus.Debugf("Failed to get the usage of zpool %s: %v", pool, err)
+	}
+	status = append(status, [2]string{"Parent Dataset", d.fsName})
+	if values, err := zfsGet(d.fsName, "used", "available", "quota", "compression"); err == nil {
+		quota := "no"
+		if values[2] != "0" {
+			quota = humanSize(values[2])
+		}
+		status = append(status,
+			[2]string{"Space Used By Parent", humanSize(values[0])},
+			[2]string{"Space Available", humanSize(values[1])},
+			[2]string{"Parent Quota", quota},
+			[2]string{"Compression", values[3]},
+		)
+	} else {
+		logrus.Debugf("Failed to get the usage of %s: %v", d.fsName, err)
+	}
+	return status
+}
+
+func humanSize(bytes string) string {
+	size, err := strconv.ParseFloat(bytes, 64)
+	if err != nil {
+		return bytes
+	}
+	return units.HumanSize(size)
+}
+
+func (d *Driver) Cleanup() error {
+	return mount.Unmount(d.home)
+}
+
+func (d *Driver) dataset(id string) string {
+	return d.fsName + "/" + id
+}
+
+func (d *Driver) mountpoint(id string) string {
+	return path.Join(d.home, "graph", id)
+}
+
+func (d *Driver) Create(id string, parent string) error {
+	return d.create(id, parent, 0)
+}
+
+// CreateWithQuota creates the dataset like Create, with a quota on the data
+// written to it.
+func (d *Driver) CreateWithQuota(id, parent string, size uint64) error {
+	return d.create(id, parent, size)
+}
+
+// ValidateQuota accepts any size, datasets can always be limited.
+func (d *Driver) ValidateQuota(size uint64) error {
+	return nil
+}
+
+func (d *Driver) create(id, parent string, size uint64) error {
+	name := d.dataset(id)
+	properties := []string{"-o", "mountpoint=legacy"}
+	if size > 0 {
+		properties = append(properties, "-o", fmt.Sprintf("quota=%d", size))
+	}
+	if parent == "" {
+		args := append([]string{"create"}, properties...)
+		if err := zfs(append(args, name)...); err != nil {
+			return err
+		}
+	} else {
+		snapshot := d.dataset(parent) + "@" + id
+		if err := zfs("snapshot", snapshot); err != nil {
+			return err
+		}
+		args := append([]string{"clone"}, properties...)
+		if err := zfs(append(args, snapshot, name)...); err != nil {
+			zfs("destroy", snapshot)
+			return err
+		}
+		// The snapshot lives as long as the clone needs it.
+		if err := zfs("destroy", "-d", snapshot); err != nil {
+			return err
+		}
+	}
+
+	d.Lock()
+	d.filesystems[name] = true
+	d.Unlock()
+	return nil
+}
+
+func (d *Driver) Remove(id string) error {
+	name := d.dataset(id)
+	if err := zfs("destroy", "-r", name); err != nil {
+		return err
+	}
+
+	d.Lock()
+	delete(d.filesystems, name)
+	d.Unlock()
+	return nil
+}
+
+func (d *Driver) Get(id, mountLabel string) (string, error) {
+	// Protect the d.active from concurrent access
+	d.Lock()
+	defer d.Unlock()
+
+	if m := d.active[id]; m != nil {
+		m.count++
+		return m.path, nil
+	}
+
+	mountpoint := d.mountpoint(id)
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return "", err
+	}
+	if err := mount.Mount(d.dataset(id), mountpoint, "zfs", label.FormatMountLabel("", mountLabel)); err != nil {
+		return "", fmt.Errorf("error creating zfs mount of %s to %s: %v", d.dataset(id), mountpoint, err)
+	}
+	d.active[id] = &activeMount{count: 1, path: mountpoint}
+	return mountpoint, nil
+}
+
+func (d *Driver) Put(id string) error {
+	// Protect the d.active from concurrent access
+	d.Lock()
+	defer d.Unlock()
+
+	m := d.active[id]
+	if m == nil {
+		logrus.Debugf("Put on a non-mounted dataset %s", id)
+		return nil
+	}
+
+	m.count--
+	if m.count > 0 {
+		return nil
+	}
+
+	delete(d.active, id)
+	if err := mount.Unmount(m.path); err != nil {
+		logrus.Debugf("Failed to unmount %s zfs dataset: %v", id, err)
+		return err
+	}
+	return os.Remove(m.path)
+}
+
+func (d *Driver) Exists(id string) bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.filesystems[d.dataset(id)]
+}
@@ -0,0 +1,102 @@
+// +build linux
+
+package zfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver/graphtest"
+)
+
+var (
+	testPool    = fmt.Sprintf("docker-graphtest-%d", os.Getpid())
+	testPoolDir string
+)
+
+// createTestPool creates a zpool backed by a file so that the tests don't
+// need the graph root to be on ZFS.
+func createTestPool(t *testing.T) {
+	if _, err := exec.LookPath("zpool"); err != nil {
+		t.Skip("zpool command is not available")
+	}
+	if _, err := os.Stat("/dev/zfs"); err != nil {
+		t.Skip("ZFS is not supported")
+	}
+	dir, err := ioutil.TempDir("/var/tmp", "docker-zfs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, "pool")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Truncate(256 * 1024 * 1024)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("zpool", "create", "-m", "none", testPool, file); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	testPoolDir = dir
+}
+
+func destroyTestPool(t *testing.T) {
+	if testPoolDir == "" {
+		return
+	}
+	defer os.RemoveAll(testPoolDir)
+	if _, err := run("zpool", "destroy", testPool); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// This avoids creating a new driver for each test if all tests are run
+// Make sure to put new tests between TestZfsSetup and TestZfsTeardown
+func TestZfsSetup(t *testing.T) {
+	createTestPool(t)
+	graphtest.GetDriver(t, "zfs", "zfs.fsname="+testPool)
+}
+
+func TestZfsCreateEmpty(t *testing.T) {
+	graphtest.DriverTestCreateEmpty(t, "zfs")
+}
+
+func TestZfsCreateBase(t *testing.T) {
+	graphtest.DriverTestCreateBase(t, "zfs")
+}
+
+func TestZfsCreateSnap(t *testing.T) {
+	graphtest.DriverTestCreateSnap(t, "zfs")
+}
+
+func TestZfsCreateWithQuota(t *testing.T) {
+	graphtest.DriverTestCreateWithQuota(t, "zfs")
+}
+
+func TestZfsDiffNaive(t *testing.T) {
+	graphtest.DriverTestDiffNaive(t, "zfs")
+}
+
+func TestZfsTeardown(t *testing.T) {
+	defer destroyTestPool(t)
+	graphtest.PutDriver(t)
+}
+
+func TestZfsParseOptions(t *testing.T) {
+	if fsName, err := parseOptions([]string{"zfs.fsname=tank/docker/"}); err != nil || fsName != "tank/docker" {
+		t.Fatalf("Expected tank/docker, got %q, %v", fsName, err)
+	}
+	for _, option := range []string{"zfs.fsname=", "zfs.fsname=tank@snap", "zfs.unknown=1", "zfs.fsname"} {
+		if _, err := parseOptions([]string{option}); err == nil {
+			t.Fatalf("Expected %s to be refused", option)
+		}
+	}
+}
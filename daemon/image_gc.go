@@ -0,0 +1,289 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+)
+
+// imageGCCheckInterval is how often the disk usage of the graph root is
+// compared to the image GC threshold.
+const imageGCCheckInterval = time.Minute
+
+// imageGCGracePeriod is how long images are kept after being registered or
+// used, so that the images of builds in progress, between the commit of a
+// step and the container of the next one, aren't removed.
+const imageGCGracePeriod = 10 * time.Minute
+
+// gcImage is an image the image garbage collector may remove.
+type gcImage struct {
+	img      *image.Image
+	refs     []string
+	lastUsed time.Time
+}
+
+// ImageGC removes the images which are neither used by a container nor
+// protected by the keep list of the daemon. With dryrun set, the images are
+// only reported.
+func (daemon *Daemon) ImageGC(job *engine.Job) error {
+	if len(job.Args) != 0 {
+		return fmt.Errorf("Usage: %s", job.Name)
+	}
+	list, err := daemon.collectImages(job.Eng, job.GetenvBool("dryrun"))
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(job.Stdout).Encode(list)
+}
+
+// startImageGC collects images periodically and whenever the disk usage of
+// the graph root exceeds the threshold, as configured.
+func (daemon *Daemon) startImageGC() {
+	var (
+		interval  = daemon.config.ImageGCInterval
+		threshold = daemon.config.ImageGCThreshold
+		tick      = imageGCCheckInterval
+	)
+	if interval <= 0 && threshold <= 0 {
+		return
+	}
+	if threshold <= 0 || (interval > 0 && interval < tick) {
+		tick = interval
+	}
+
+	go func() {
+		lastRun := time.Now()
+		for range time.Tick(tick) {
+			run := interval > 0 && time.Since(lastRun) >= interval
+			if !run && threshold > 0 {
+				above, err := daemon.graphUsageAbove(threshold)
+				if err != nil {
+					logrus.Errorf("Unable to get the disk usage of %s: %s", daemon.graph.Root, err)
+					continue
+				}
+				run = above
+			}
+			if !run {
+				continue
+			}
+			lastRun = time.Now()
+			list, err := daemon.collectImages(daemon.eng, false)
+			if err != nil {
+				logrus.Errorf("Image garbage collection failed: %s", err)
+				continue
+			}
+			logrus.Debugf("Image garbage collection removed %d references", len(list))
+		}
+	}()
+}
+
+// collectImages removes all untagged images which aren't used by containers
+// or kept. With --image-gc-evict-tagged, tagged images are removed as well,
+// least recently used first, as long as the disk usage of the graph root is
+// above the threshold.
+func (daemon *Daemon) collectImages(eng *engine.Engine, dryRun bool) ([]types.ImageDelete, error) {
+	daemon.imageGCLock.Lock()
+	defer daemon.imageGCLock.Unlock()
+
+	images, protected, err := daemon.imageGCCandidates()
+	if err != nil {
+		return nil, err
+	}
+	threshold := daemon.config.ImageGCThreshold
+	overLimit := func(freed int64) bool { return false }
+	if threshold > 0 && daemon.config.ImageGCEvictTagged {
+		used, total, err := diskUsage(daemon.graph.Root)
+		if err != nil {
+			return nil, err
+		}
+		overLimit = func(freed int64) bool {
+			return (int64(used)-freed)*100 > int64(threshold)*int64(total)
+		}
+	}
+
+	list := []types.ImageDelete{}
+	for _, i := range imageGCPlan(images, protected, overLimit) {
+		tagged := len(i.refs) > 0
+		if dryRun {
+			for _, ref := range i.refs {
+				list = append(list, types.ImageDelete{Untagged: ref})
+			}
+			list = append(list, types.ImageDelete{Deleted: i.img.ID})
+			continue
+		}
+		// The plan estimates the space freed by each image, stop removing
+		// tagged images as soon as enough space is actually freed.
+		if tagged {
+			if above, err := daemon.graphUsageAbove(threshold); err != nil {
+				return list, err
+			} else if !above {
+				continue
+			}
+		}
+		// Removing the last reference of a tagged image removes the image.
+		names := i.refs
+		if !tagged {
+			names = []string{i.img.ID}
+		}
+		for _, name := range names {
+			if err := daemon.DeleteImage(eng, name, &list, true, false, true); err != nil {
+				logrus.Debugf("Image garbage collection skipped %s: %s", name, err)
+				break
+			}
+		}
+	}
+	return list, nil
+}
+
+// imageGCCandidates returns all the images in the graph along with the IDs of
+// those which must not be removed: the images used by containers and their
+// parents, the images held by pulls in progress or registered or used
+// within the grace period, and the images matching the keep list.
+func (daemon *Daemon) imageGCCandidates() (map[string]*gcImage, map[string]bool, error) {
+	all, err := daemon.graph.Map()
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		byID      = daemon.Repositories().ByID()
+		images    = make(map[string]*gcImage, len(all))
+		protected = make(map[string]bool)
+	)
+	for id, img := range all {
+		lastUsed, err := daemon.graph.LastUsed(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		i := &gcImage{img: img, refs: byID[id], lastUsed: lastUsed}
+		images[id] = i
+		if daemon.keepImage(i) || time.Since(lastUsed) < imageGCGracePeriod {
+			protected[id] = true
+		}
+	}
+	for id := range daemon.Repositories().PullingImages() {
+		protected[id] = true
+	}
+	for _, container := range daemon.List() {
+		img, err := daemon.graph.Get(container.ImageID)
+		if err != nil {
+			continue
+		}
+		if err := img.WalkHistory(func(p *image.Image) error {
+			protected[p.ID] = true
+			return nil
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return images, protected, nil
+}
+
+// keepImage returns true if the image matches a rule of the keep list,
+// either label=KEY[=VALUE] or repo=PATTERN.
+func (daemon *Daemon) keepImage(i *gcImage) bool {
+	for _, rule := range daemon.config.ImageGCKeep {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "label":
+			if i.img.Config == nil {
+				continue
+			}
+			kv := strings.SplitN(parts[1], "=", 2)
+			if value, exists := i.img.Config.Labels[kv[0]]; exists && (len(kv) == 1 || value == kv[1]) {
+				return true
+			}
+		case "repo":
+			for _, ref := range i.refs {
+				repoName, _ := parsers.ParseRepositoryTag(ref)
+				if match, _ := path.Match(parts[1], repoName); match {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// imageGCPlan returns the images to remove in order, children before their
+// parents. Untagged images go first, tagged images are only removed while
+// overLimit returns true for the estimated number of bytes freed so far.
+// Among those, the least recently used images go first.
+func imageGCPlan(images map[string]*gcImage, protected map[string]bool, overLimit func(freed int64) bool) []*gcImage {
+	var (
+		children = make(map[string]int)
+		removed  = make(map[string]bool)
+		plan     []*gcImage
+		freed    int64
+	)
+	for _, i := range images {
+		if i.img.Parent != "" {
+			children[i.img.Parent]++
+		}
+	}
+	for {
+		var next *gcImage
+		for id, i := range images {
+			if removed[id] || protected[id] || children[id] > 0 {
+				continue
+			}
+			if len(i.refs) > 0 && !overLimit(freed) {
+				continue
+			}
+			if next == nil || gcBefore(i, next) {
+				next = i
+			}
+		}
+		if next == nil {
+			return plan
+		}
+		plan = append(plan, next)
+		removed[next.img.ID] = true
+		freed += next.img.Size
+		if next.img.Parent != "" {
+			children[next.img.Parent]--
+		}
+	}
+}
+
+// gcBefore returns true if the image garbage collector removes a before b.
+func gcBefore(a, b *gcImage) bool {
+	if aTagged, bTagged := len(a.refs) > 0, len(b.refs) > 0; aTagged != bTagged {
+		return bTagged
+	}
+	if !a.lastUsed.Equal(b.lastUsed) {
+		return a.lastUsed.Before(b.lastUsed)
+	}
+	return a.img.ID < b.img.ID
+}
+
+// graphUsageAbove returns true if the disk usage of the filesystem holding
+// the graph root is above the given percentage.
+func (daemon *Daemon) graphUsageAbove(threshold int) (bool, error) {
+	used, total, err := diskUsage(daemon.graph.Root)
+	if err != nil {
+		return false, err
+	}
+	return used*100 > uint64(threshold)*total, nil
+}
+
+func diskUsage(path string) (used, total uint64, err error) {
+	var buf syscall.Statfs_t
+	if err := syscall.Statfs(path, &buf); err != nil {
+		return 0, 0, err
+	}
+	total = buf.Blocks * uint64(buf.Bsize)
+	used = total - buf.Bfree*uint64(buf.Bsize)
+	return used, total, nil
+}
@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/image"
+)
+
+func testGCImages() map[string]*gcImage {
+	now := time.Now()
+	images := make(map[string]*gcImage)
+	for _, i := range []*gcImage{
+		{img: &image.Image{ID: "base", Size: 100}, refs: []string{"base:latest"}, lastUsed: now.Add(-3 * time.Hour)},
+		{img: &image.Image{ID: "dangling", Parent: "base", Size: 10}, lastUsed: now},
+		{img: &image.Image{ID: "app", Parent: "base", Size: 10}, refs: []string{"app:latest"}, lastUsed: now.Add(-time.Hour)},
+		{img: &image.Image{ID: "web", Parent: "base", Size: 10}, refs: []string{"web:latest"}, lastUsed: now.Add(-2 * time.Hour)},
+		{img: &image.Image{ID: "intermediate", Parent: "base", Size: 10}, lastUsed: now.Add(-time.Hour)},
+		{img: &image.Image{ID: "build", Parent: "intermediate", Size: 10}, lastUsed: now.Add(-time.Hour)},
+	} {
+		images[i.img.ID] = i
+	}
+	return images
+}
+
+func planIDs(plan []*gcImage) []string {
+	ids := make([]string, len(plan))
+	for i, img := range plan {
+		ids[i] = img.img.ID
+	}
+	return ids
+}
+
+func checkPlan(t *testing.T, plan []*gcImage, expected ...string) {
+	ids := planIDs(plan)
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected %v to be removed, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected %v to be removed, got %v", expected, ids)
+		}
+	}
+}
+
+func TestImageGCPlanUntagged(t *testing.T) {
+	never := func(int64) bool { return false }
+
+	// Children go before their parents and tagged images are kept.
+	checkPlan(t, imageGCPlan(testGCImages(), nil, never), "build", "intermediate", "dangling")
+
+	// Images used by containers, and their parents, are kept.
+	protected := map[string]bool{"build": true, "intermediate": true, "base": true}
+	checkPlan(t, imageGCPlan(testGCImages(), protected, never), "dangling")
+}
+
+func TestImageGCPlanOverLimit(t *testing.T) {
+	// Tagged images are removed least recently used first, until enough
+	// space is freed.
+	var calls int
+	overLimit := func(freed int64) bool {
+		calls++
+		return freed < 40
+	}
+	checkPlan(t, imageGCPlan(testGCImages(), nil, overLimit), "build", "intermediate", "dangling", "web")
+	if calls == 0 {
+		t.Fatal("Expected the limit to be checked")
+	}
+
+	always := func(int64) bool { return true }
+	checkPlan(t, imageGCPlan(testGCImages(), map[string]bool{"app": true}, always), "build", "intermediate", "dangling", "web")
+	checkPlan(t, imageGCPlan(testGCImages(), nil, always), "build", "intermediate", "dangling", "web", "app", "base")
+}
@@ -40,6 +40,7 @@ func (daemon *Daemon) ContainerInspect(job *engine.Job) error {
 	out.SetJson("Config", container.Config)
 	out.SetJson("State", container.State)
 	out.Set("Image", container.ImageID)
+	out.Set("ImageDigest", container.ImageDigest)
 	out.SetJson("NetworkSettings", container.NetworkSettings)
 	out.Set("ResolvConfPath", container.ResolvConfPath)
 	out.Set("HostnamePath", container.HostnamePath)
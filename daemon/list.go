@@ -145,6 +145,7 @@ func (daemon *Daemon) Containers(job *engine.Job) error {
 			img = utils.ImageReference(img, graph.DEFAULTTAG)
 		}
 		newC.Image = img
+		newC.ImageDigest = container.ImageDigest
 		if len(container.Args) > 0 {
 			args := []string{}
 			for _, arg := range container.Args {
@@ -17,6 +17,7 @@ import (
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/nat"
 	"github.com/docker/docker/pkg/iptables"
+	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/pkg/parsers/kernel"
 	"github.com/docker/docker/pkg/resolvconf"
 	"github.com/docker/libcontainer/netlink"
@@ -31,7 +32,8 @@ const (
 type networkInterface struct {
 	IP           net.IP
 	IPv6         net.IP
-	PortMappings []net.Addr // There are mappings to the host interfaces
+	PortMappings []net.Addr      // There are mappings to the host interfaces
+	EgressChain  *iptables.Chain // Restricts the outgoing traffic, if any
 }
 
 type ifaces struct {
@@ -79,6 +81,8 @@ var (
 	globalIPv6Network *net.IPNet
 	portMapper        *portmapper.PortMapper
 	once              sync.Once
+	hairpinMode       bool
+	iptablesEnabled   bool
 
 	defaultBindingIP  = net.ParseIP("0.0.0.0")
 	currentInterfaces = ifaces{c: make(map[string]*networkInterface)}
@@ -108,11 +112,26 @@ func InitDriver(job *engine.Job) error {
 		fixedCIDRv6    = job.Getenv("FixedCIDRv6")
 	)
 	initPortMapper()
+	iptablesEnabled = enableIPTables
+
+	// Without the userland proxy, connections to published ports from the
+	// host and from other containers are handled by hairpin NAT.
+	hairpinMode = !job.GetenvBool("EnableUserlandProxy")
 
 	if defaultIP := job.Getenv("DefaultBindingIP"); defaultIP != "" {
 		defaultBindingIP = net.ParseIP(defaultIP)
 	}
 
+	if portRange := job.Getenv("EphemeralPortRange"); portRange != "" {
+		start, end, err := parsers.ParsePortRange(portRange)
+		if err != nil {
+			return err
+		}
+		if err := portMapper.Allocator.SetPortRange(int(start), int(end)); err != nil {
+			return fmt.Errorf("Invalid ephemeral port range %s: %v", portRange, err)
+		}
+	}
+
 	bridgeIface = job.Getenv("BridgeIface")
 	usingDefaultBridge := false
 	if bridgeIface == "" {
@@ -207,7 +226,7 @@ func InitDriver(job *engine.Job) error {
 
 	// Configure iptables for link support
 	if enableIPTables {
-		if err := setupIPTables(addrv4, icc, ipMasq); err != nil {
+		if err := setupIPTables(addrv4, icc, ipMasq, hairpinMode); err != nil {
 			return err
 		}
 
@@ -236,11 +255,11 @@ func InitDriver(job *engine.Job) error {
 	}
 
 	if enableIPTables {
-		_, err := iptables.NewChain("DOCKER", bridgeIface, iptables.Nat)
+		_, err := iptables.NewChain("DOCKER", bridgeIface, iptables.Nat, hairpinMode)
 		if err != nil {
 			return err
 		}
-		chain, err := iptables.NewChain("DOCKER", bridgeIface, iptables.Filter)
+		chain, err := iptables.NewChain("DOCKER", bridgeIface, iptables.Filter, hairpinMode)
 		if err != nil {
 			return err
 		}
@@ -290,7 +309,7 @@ func InitDriver(job *engine.Job) error {
 	return nil
 }
 
-func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
+func setupIPTables(addr net.Addr, icc, ipmasq, hairpin bool) error {
 	// Enable NAT
 
 	if ipmasq {
@@ -306,6 +325,25 @@ func setupIPTables(addr net.Addr, icc, ipmasq bool) error {
 		}
 	}
 
+	// In hairpin mode, connections from the host's loopback addresses are
+	// DNATed to the containers and need a routable source address
+	hairpinArgs := []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}
+	if hairpin {
+		if !iptables.Exists(iptables.Nat, "POSTROUTING", hairpinArgs...) {
+			if output, err := iptables.Raw(append([]string{
+				"-t", string(iptables.Nat), "-I", "POSTROUTING"}, hairpinArgs...)...); err != nil {
+				return fmt.Errorf("Unable to enable hairpin NAT: %s", err)
+			} else if len(output) != 0 {
+				return &iptables.ChainError{Chain: "POSTROUTING", Output: output}
+			}
+		}
+		if err := ioutil.WriteFile("/proc/sys/net/ipv4/conf/"+bridgeIface+"/route_localnet", []byte{'1', '\n'}, 0644); err != nil {
+			return fmt.Errorf("Unable to enable local routing for hairpin NAT: %s", err)
+		}
+	} else {
+		iptables.Raw(append([]string{"-t", string(iptables.Nat), "-D", "POSTROUTING"}, hairpinArgs...)...)
+	}
+
 	var (
 		args       = []string{"-i", bridgeIface, "-o", bridgeIface, "-j"}
 		acceptArgs = append(args, "ACCEPT")
@@ -526,6 +564,9 @@ func Allocate(job *engine.Job) error {
 
 	ip, err = ipAllocator.RequestIP(bridgeIPv4Network, requestedIP)
 	if err != nil {
+		if requestedIP != nil {
+			return fmt.Errorf("Unable to allocate requested IP address %s: %v", requestedIP, err)
+		}
 		return err
 	}
 
@@ -534,6 +575,11 @@ func Allocate(job *engine.Job) error {
 		mac = generateMacAddr(ip)
 	}
 
+	if requestedIPv6 != nil && globalIPv6Network == nil {
+		ipAllocator.ReleaseIP(bridgeIPv4Network, ip)
+		return fmt.Errorf("Unable to allocate requested IPv6 address %s: no global IPv6 network configured", requestedIPv6)
+	}
+
 	if globalIPv6Network != nil {
 		// If globalIPv6Network Size is at least a /80 subnet generate IPv6 address from MAC address
 		netmaskOnes, _ := globalIPv6Network.Mask.Size()
@@ -548,11 +594,26 @@ func Allocate(job *engine.Job) error {
 		globalIPv6, err = ipAllocator.RequestIP(globalIPv6Network, requestedIPv6)
 		if err != nil {
 			logrus.Errorf("Allocator: RequestIP v6: %v", err)
+			ipAllocator.ReleaseIP(bridgeIPv4Network, ip)
+			if job.Getenv("RequestedIPv6") != "" {
+				return fmt.Errorf("Unable to allocate requested IPv6 address %s: %v", requestedIPv6, err)
+			}
 			return err
 		}
 		logrus.Infof("Allocated IPv6 %s", globalIPv6)
 	}
 
+	var egressChain *iptables.Chain
+	if rules := job.GetenvList("EgressRules"); len(rules) > 0 {
+		if egressChain, err = setupEgressChain(id, ip, rules); err != nil {
+			ipAllocator.ReleaseIP(bridgeIPv4Network, ip)
+			if globalIPv6 != nil {
+				ipAllocator.ReleaseIP(globalIPv6Network, globalIPv6)
+			}
+			return err
+		}
+	}
+
 	out := engine.Env{}
 	out.Set("IP", ip.String())
 	out.Set("Mask", bridgeIPv4Network.Mask.String())
@@ -580,8 +641,9 @@ func Allocate(job *engine.Job) error {
 	}
 
 	currentInterfaces.Set(id, &networkInterface{
-		IP:   ip,
-		IPv6: globalIPv6,
+		IP:          ip,
+		IPv6:        globalIPv6,
+		EgressChain: egressChain,
 	})
 
 	out.WriteTo(job.Stdout)
@@ -606,6 +668,12 @@ func Release(job *engine.Job) error {
 		}
 	}
 
+	if containerInterface.EgressChain != nil {
+		if err := iptables.RemoveEgressChain(containerInterface.EgressChain.Name); err != nil {
+			logrus.Infof("Unable to remove egress rules for %s: %s", id, err)
+		}
+	}
+
 	if err := ipAllocator.ReleaseIP(bridgeIPv4Network, containerInterface.IP); err != nil {
 		logrus.Infof("Unable to release IPv4 %s", err)
 	}
@@ -617,6 +685,60 @@ func Release(job *engine.Job) error {
 	return nil
 }
 
+// setupEgressChain creates a chain which only lets the traffic the container
+// sends out of the bridge or to the host through when it matches one of the
+// given rules. DNS queries to the bridge address are always allowed so that
+// the embedded DNS server can be used.
+func setupEgressChain(id string, ip net.IP, rawRules []string) (*iptables.Chain, error) {
+	if !iptablesEnabled {
+		return nil, fmt.Errorf("Unable to restrict outgoing traffic: iptables is disabled")
+	}
+	// Only IPv4 traffic is filtered
+	if bridgeIPv6Addr != nil {
+		return nil, fmt.Errorf("Unable to restrict outgoing traffic: IPv6 is enabled")
+	}
+	rules := make([]*nat.EgressRule, 0, len(rawRules))
+	for _, rawRule := range rawRules {
+		rule, err := nat.ParseEgressRule(rawRule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	chain, err := iptables.NewEgressChain(egressChainName(id), bridgeIface, ip)
+	if err != nil {
+		return nil, err
+	}
+	bridgeAddr := &net.IPNet{IP: bridgeIPv4Network.IP, Mask: net.CIDRMask(32, 32)}
+	for _, proto := range []string{"udp", "tcp"} {
+		if err := chain.Allow(iptables.Insert, bridgeAddr, proto, "53"); err != nil {
+			iptables.RemoveEgressChain(chain.Name)
+			return nil, err
+		}
+	}
+	for _, rule := range rules {
+		var port string
+		if rule.Proto != "" {
+			port = fmt.Sprintf("%d:%d", rule.StartPort, rule.EndPort)
+		}
+		if err := chain.Allow(iptables.Insert, rule.Network, rule.Proto, port); err != nil {
+			iptables.RemoveEgressChain(chain.Name)
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+// egressChainName returns the name of the container's egress chain, which has
+// to stay within the 28 characters iptables allows for chain names.
+func egressChainName(id string) string {
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "DOCKER-EGRESS-" + id
+}
+
 // Allocate an external port and map it to the interface
 func AllocatePort(job *engine.Job) error {
 	var (
@@ -627,10 +749,16 @@ func AllocatePort(job *engine.Job) error {
 		hostIP        = job.Getenv("HostIP")
 		hostPort      = job.GetenvInt("HostPort")
 		containerPort = job.GetenvInt("ContainerPort")
+		count         = job.GetenvInt("PortCount")
 		proto         = job.Getenv("Proto")
 		network       = currentInterfaces.Get(id)
 	)
 
+	// A range of container ports is published to as many contiguous host ports
+	if count < 1 {
+		count = 1
+	}
+
 	if hostIP != "" {
 		ip = net.ParseIP(hostIP)
 		if ip == nil {
@@ -656,9 +784,9 @@ func AllocatePort(job *engine.Job) error {
 	// yields.
 	//
 
-	var host net.Addr
+	var hosts []net.Addr
 	for i := 0; i < MaxAllocatedPortAttempts; i++ {
-		if host, err = portMapper.Map(container, ip, hostPort); err == nil {
+		if hosts, err = portMapper.MapRange(container, ip, hostPort, count, !hairpinMode); err == nil {
 			break
 		}
 		// There is no point in immediately retrying to map an explicitly
@@ -674,10 +802,11 @@ func AllocatePort(job *engine.Job) error {
 		return err
 	}
 
-	network.PortMappings = append(network.PortMappings, host)
+	network.PortMappings = append(network.PortMappings, hosts...)
 
+	// Only the first host port of a range is reported
 	out := engine.Env{}
-	switch netAddr := host.(type) {
+	switch netAddr := hosts[0].(type) {
 	case *net.TCPAddr:
 		out.Set("HostIP", netAddr.IP.String())
 		out.SetInt("HostPort", netAddr.Port)
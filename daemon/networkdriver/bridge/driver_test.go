@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/docker/docker/daemon/networkdriver/portallocator"
 	"github.com/docker/docker/daemon/networkdriver/portmapper"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/pkg/iptables"
@@ -105,6 +106,56 @@ func TestHostnameFormatChecking(t *testing.T) {
 	}
 }
 
+func TestAllocatePortRange(t *testing.T) {
+	eng := engine.New()
+	eng.Logging = false
+
+	// Init driver
+	job := eng.Job("initdriver")
+	job.Setenv("EphemeralPortRange", "40000-40009")
+	if res := InitDriver(job); res != nil {
+		t.Fatalf("Failed to initialize network driver: %s", res)
+	}
+	defer portMapper.Allocator.SetPortRange(portallocator.DefaultPortRangeStart, portallocator.DefaultPortRangeEnd)
+
+	// Allocate interface
+	job = eng.Job("allocate_interface", "range_container")
+	if res := Allocate(job); res != nil {
+		t.Fatal("Failed to allocate network interface")
+	}
+	defer Release(eng.Job("release_interface", "range_container"))
+
+	newRangeJob := func(count int) (*engine.Job, *engine.Env) {
+		job := eng.Job("allocate_port", "range_container")
+		job.Setenv("HostIP", "127.0.0.1")
+		job.Setenv("Proto", "tcp")
+		job.Setenv("ContainerPort", "7000")
+		job.SetenvInt("PortCount", count)
+		out, err := job.Stdout.AddEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job, out
+	}
+
+	job, out := newRangeJob(6)
+	if err := job.Run(); err != nil {
+		t.Fatalf("Failed to allocate port range: %s", err)
+	}
+	if port := out.GetInt("HostPort"); port != 40000 {
+		t.Fatalf("Expected the range to start at 40000, got %d", port)
+	}
+	if n := len(currentInterfaces.Get("range_container").PortMappings); n != 6 {
+		t.Fatalf("Expected 6 port mappings, got %d", n)
+	}
+
+	// Only 4 contiguous ports are left in the ephemeral range
+	job, _ = newRangeJob(5)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected the allocation of a range larger than the free ports to fail")
+	}
+}
+
 func newInterfaceAllocation(t *testing.T, input engine.Env) (output engine.Env) {
 	eng := engine.New()
 	eng.Logging = false
@@ -222,6 +273,35 @@ func TestIPv6InterfaceAllocationRequest(t *testing.T) {
 	output = newInterfaceAllocation(t, input)
 }
 
+func TestIPv4InterfaceAllocationRequest(t *testing.T) {
+	prevNetwork := bridgeIPv4Network
+	_, bridgeIPv4Network, _ = net.ParseCIDR("192.168.100.1/24")
+	defer func() { bridgeIPv4Network = prevNetwork }()
+
+	input := engine.Env{}
+	expectedIP := net.ParseIP("192.168.100.42")
+	input.Set("RequestedIP", expectedIP.String())
+
+	output := newInterfaceAllocation(t, input)
+	if ip := net.ParseIP(output.Get("IP")); !ip.Equal(expectedIP) {
+		t.Fatalf("Error ip %s should be %s", ip, expectedIP)
+	}
+
+	// retry -> fails for duplicated address
+	input.SetBool("expectFail", true)
+	newInterfaceAllocation(t, input)
+
+	// an IPv6 request without a global IPv6 network fails and releases the IPv4 address
+	input = engine.Env{}
+	input.Set("RequestedIP", "192.168.100.43")
+	input.Set("RequestedIPv6", "2001:db8::43")
+	input.SetBool("expectFail", true)
+	newInterfaceAllocation(t, input)
+	if _, err := ipAllocator.RequestIP(bridgeIPv4Network, net.ParseIP("192.168.100.43")); err != nil {
+		t.Fatalf("Expected 192.168.100.43 to be released, got %v", err)
+	}
+}
+
 func TestMacAddrGeneration(t *testing.T) {
 	ip := net.ParseIP("192.168.0.1")
 	mac := generateMacAddr(ip).String()
@@ -262,7 +342,7 @@ func TestLinkContainers(t *testing.T) {
 	job.SetenvList("Ports", []string{"1234"})
 
 	bridgeIface = "lo"
-	_, err := iptables.NewChain("DOCKER", bridgeIface, iptables.Filter)
+	_, err := iptables.NewChain("DOCKER", bridgeIface, iptables.Filter, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -21,6 +21,7 @@ type ipMapping map[string]protoMap
 var (
 	ErrAllPortsAllocated = errors.New("all ports are allocated")
 	ErrUnknownProtocol   = errors.New("unknown protocol")
+	ErrInvalidPortRange  = errors.New("invalid port range")
 	defaultIP            = net.ParseIP("0.0.0.0")
 )
 
@@ -97,16 +98,45 @@ func getDynamicPortRange() (start int, end int, err error) {
 	return start, end, nil
 }
 
+// SetPortRange changes the range from which ports are handed out when no
+// specific port is requested. Ports which are already allocated are kept.
+func (p *PortAllocator) SetPortRange(begin, end int) error {
+	if begin <= 0 || end > 65535 || begin > end {
+		return ErrInvalidPortRange
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Begin, p.End = begin, end
+	for _, protomap := range p.ipMap {
+		for _, pm := range protomap {
+			pm.begin, pm.end, pm.last = begin, end, end
+		}
+	}
+	return nil
+}
+
 // RequestPort requests new port from global ports pool for specified ip and proto.
 // If port is 0 it returns first free port. Otherwise it cheks port availability
 // in pool and return that port or error if port is already busy.
 func (p *PortAllocator) RequestPort(ip net.IP, proto string, port int) (int, error) {
+	return p.RequestPortRange(ip, proto, port, 1)
+}
+
+// RequestPortRange reserves count contiguous ports for the specified ip and
+// proto, starting at port. If port is 0 the first free block of ports within
+// the allocator's range is used. Either all the ports are reserved or none
+// of them. It returns the first port of the block.
+func (p *PortAllocator) RequestPortRange(ip net.IP, proto string, port, count int) (int, error) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
 	if proto != "tcp" && proto != "udp" {
 		return 0, ErrUnknownProtocol
 	}
+	if count < 1 || port < 0 || port+count-1 > 65535 {
+		return 0, ErrInvalidPortRange
+	}
 
 	if ip == nil {
 		ip = defaultIP
@@ -123,14 +153,16 @@ func (p *PortAllocator) RequestPort(ip net.IP, proto string, port int) (int, err
 	}
 	mapping := protomap[proto]
 	if port > 0 {
-		if _, ok := mapping.p[port]; !ok {
-			mapping.p[port] = struct{}{}
-			return port, nil
+		for i := port; i < port+count; i++ {
+			if _, ok := mapping.p[i]; ok {
+				return 0, NewErrPortAlreadyAllocated(ipstr, i)
+			}
 		}
-		return 0, NewErrPortAlreadyAllocated(ipstr, port)
+		mapping.reserve(port, count)
+		return port, nil
 	}
 
-	port, err := mapping.findPort()
+	port, err := mapping.findRange(count)
 	if err != nil {
 		return 0, err
 	}
@@ -170,19 +202,39 @@ func (p *PortAllocator) ReleaseAll() error {
 	return nil
 }
 
-func (pm *portMap) findPort() (int, error) {
+// findRange reserves the first block of count free ports following the
+// last allocated one, wrapping around at the end of the range.
+func (pm *portMap) findRange(count int) (int, error) {
+	if count > pm.end-pm.begin+1 {
+		return 0, ErrAllPortsAllocated
+	}
 	port := pm.last
 	for i := 0; i <= pm.end-pm.begin; i++ {
 		port++
-		if port > pm.end {
+		if port > pm.end-count+1 {
 			port = pm.begin
 		}
 
-		if _, ok := pm.p[port]; !ok {
-			pm.p[port] = struct{}{}
-			pm.last = port
+		if pm.isFree(port, count) {
+			pm.reserve(port, count)
+			pm.last = port + count - 1
 			return port, nil
 		}
 	}
 	return 0, ErrAllPortsAllocated
 }
+
+func (pm *portMap) isFree(port, count int) bool {
+	for i := port; i < port+count; i++ {
+		if _, ok := pm.p[i]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (pm *portMap) reserve(port, count int) {
+	for i := port; i < port+count; i++ {
+		pm.p[i] = struct{}{}
+	}
+}
@@ -237,3 +237,104 @@ func TestNoDuplicateBPR(t *testing.T) {
 		t.Fatalf("Acquire(0) allocated the same port twice: %d", port)
 	}
 }
+
+func TestRequestPortRange(t *testing.T) {
+	p := New()
+
+	port, err := p.RequestPortRange(defaultIP, "tcp", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != p.Begin {
+		t.Fatalf("Expected port %d got %d", p.Begin, port)
+	}
+
+	// The next block starts after the previous one
+	port, err = p.RequestPortRange(defaultIP, "tcp", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := p.Begin + 10; port != expected {
+		t.Fatalf("Expected port %d got %d", expected, port)
+	}
+
+	// Explicit ranges are reserved as a whole or not at all
+	if _, err := p.RequestPortRange(defaultIP, "tcp", 5000, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPortRange(defaultIP, "tcp", 4995, 10); err == nil {
+		t.Fatal("Expected an error requesting an overlapping range")
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 4995); err != nil {
+		t.Fatalf("Expected port 4995 to be left free: %s", err)
+	}
+
+	if _, err := p.RequestPortRange(defaultIP, "tcp", 65530, 10); err != ErrInvalidPortRange {
+		t.Fatalf("Expected error %s got %s", ErrInvalidPortRange, err)
+	}
+}
+
+func TestRequestPortRangeSkipsAllocatedPorts(t *testing.T) {
+	p := New()
+	if err := p.SetPortRange(10000, 10019); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave the first block fragmented
+	if _, err := p.RequestPort(defaultIP, "udp", 10005); err != nil {
+		t.Fatal(err)
+	}
+	port, err := p.RequestPortRange(defaultIP, "udp", 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 10006 {
+		t.Fatalf("Expected port 10006 got %d", port)
+	}
+
+	// Only 10000-10004 and 10014-10019 are left
+	if _, err := p.RequestPortRange(defaultIP, "udp", 0, 7); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected error %s got %s", ErrAllPortsAllocated, err)
+	}
+	if port, err = p.RequestPortRange(defaultIP, "udp", 0, 6); err != nil {
+		t.Fatal(err)
+	}
+	if port != 10014 {
+		t.Fatalf("Expected port 10014 got %d", port)
+	}
+	if port, err = p.RequestPortRange(defaultIP, "udp", 0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if port != 10000 {
+		t.Fatalf("Expected port 10000 got %d", port)
+	}
+}
+
+func TestSetPortRange(t *testing.T) {
+	p := New()
+
+	if _, err := p.RequestPort(defaultIP, "tcp", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPortRange(20000, 20001); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []int{20000, 20001} {
+		port, err := p.RequestPort(defaultIP, "tcp", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != expected {
+			t.Fatalf("Expected port %d got %d", expected, port)
+		}
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected error %s got %s", ErrAllPortsAllocated, err)
+	}
+
+	for _, r := range [][2]int{{0, 100}, {200, 100}, {60000, 70000}} {
+		if err := p.SetPortRange(r[0], r[1]); err != ErrInvalidPortRange {
+			t.Fatalf("Expected error %s for range %d-%d, got %v", ErrInvalidPortRange, r[0], r[1], err)
+		}
+	}
+}
@@ -51,80 +51,92 @@ func (pm *PortMapper) SetIptablesChain(c *iptables.Chain) {
 	pm.chain = c
 }
 
-func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int) (host net.Addr, err error) {
+// Map publishes hostPort on hostIP and forwards it to the container address.
+// If useProxy is false, no userland proxy is started and connections from the
+// host itself rely on the hairpin NAT rules only; the host port is still bound
+// so that it can't be taken by another process.
+func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (net.Addr, error) {
+	hosts, err := pm.MapRange(container, hostIP, hostPort, 1, useProxy)
+	if err != nil {
+		return nil, err
+	}
+	return hosts[0], nil
+}
+
+// MapRange publishes count contiguous host ports starting at hostPort, or at
+// the first free block of ports if hostPort is 0, and forwards them to as
+// many ports starting at the container address' port. Either all the ports
+// are mapped or none of them.
+func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPort, count int, useProxy bool) ([]net.Addr, error) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
-	var (
-		m                 *mapping
-		proto             string
-		allocatedHostPort int
-		proxy             UserlandProxy
-	)
-
+	var proto string
 	switch container.(type) {
 	case *net.TCPAddr:
 		proto = "tcp"
-		if allocatedHostPort, err = pm.Allocator.RequestPort(hostIP, proto, hostPort); err != nil {
-			return nil, err
-		}
-
-		m = &mapping{
-			proto:     proto,
-			host:      &net.TCPAddr{IP: hostIP, Port: allocatedHostPort},
-			container: container,
-		}
-
-		proxy = NewProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
 	case *net.UDPAddr:
 		proto = "udp"
-		if allocatedHostPort, err = pm.Allocator.RequestPort(hostIP, proto, hostPort); err != nil {
-			return nil, err
-		}
-
-		m = &mapping{
-			proto:     proto,
-			host:      &net.UDPAddr{IP: hostIP, Port: allocatedHostPort},
-			container: container,
-		}
-
-		proxy = NewProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
 	default:
 		return nil, ErrUnknownBackendAddressType
 	}
 
-	// release the allocated port on any further error during return.
-	defer func() {
+	allocatedHostPort, err := pm.Allocator.RequestPortRange(hostIP, proto, hostPort, count)
+	if err != nil {
+		return nil, err
+	}
+
+	containerIP, containerPort := getIPAndPort(container)
+	hosts := make([]net.Addr, 0, count)
+	for i := 0; i < count; i++ {
+		host, err := pm.mapPort(proto, hostIP, allocatedHostPort+i, containerIP, containerPort+i, useProxy)
 		if err != nil {
-			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
+			// Undo the mappings done so far and give back the rest of the block.
+			for _, h := range hosts {
+				pm.unmap(h)
+			}
+			for port := allocatedHostPort + i; port < allocatedHostPort+count; port++ {
+				pm.Allocator.ReleasePort(hostIP, proto, port)
+			}
+			return nil, err
 		}
-	}()
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// mapPort sets up the forwarding of the already allocated hostPort.
+func (pm *PortMapper) mapPort(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, useProxy bool) (net.Addr, error) {
+	m := &mapping{proto: proto}
+	switch proto {
+	case "tcp":
+		m.host = &net.TCPAddr{IP: hostIP, Port: hostPort}
+		m.container = &net.TCPAddr{IP: containerIP, Port: containerPort}
+	case "udp":
+		m.host = &net.UDPAddr{IP: hostIP, Port: hostPort}
+		m.container = &net.UDPAddr{IP: containerIP, Port: containerPort}
+	}
 
 	key := getKey(m.host)
 	if _, exists := pm.currentMappings[key]; exists {
 		return nil, ErrPortMappedForIP
 	}
 
-	containerIP, containerPort := getIPAndPort(m.container)
-	if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
-		return nil, err
+	var proxy UserlandProxy
+	if useProxy {
+		proxy = NewProxy(proto, hostIP, hostPort, containerIP, containerPort)
+	} else {
+		proxy = newDummyProxy(proto, hostIP, hostPort)
 	}
 
-	cleanup := func() error {
-		// need to undo the iptables rules before we return
-		proxy.Stop()
-		pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
-		if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
-			return err
-		}
-
-		return nil
+	if err := pm.forward(iptables.Append, proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+		return nil, err
 	}
 
 	if err := proxy.Start(); err != nil {
-		if err := cleanup(); err != nil {
-			return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
-		}
+		// need to undo the iptables rules before we return
+		proxy.Stop()
+		pm.forward(iptables.Delete, proto, hostIP, hostPort, containerIP.String(), containerPort)
 		return nil, err
 	}
 	m.userlandProxy = proxy
@@ -135,7 +147,10 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int) (host
 func (pm *PortMapper) Unmap(host net.Addr) error {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
+	return pm.unmap(host)
+}
 
+func (pm *PortMapper) unmap(host net.Addr) error {
 	key := getKey(host)
 	data, exists := pm.currentMappings[key]
 	if !exists {
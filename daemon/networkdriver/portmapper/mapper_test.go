@@ -44,22 +44,22 @@ func TestMapPorts(t *testing.T) {
 		return (addr1.Network() == addr2.Network()) && (addr1.String() == addr2.String())
 	}
 
-	if host, err := pm.Map(srcAddr1, dstIp1, 80); err != nil {
+	if host, err := pm.Map(srcAddr1, dstIp1, 80, true); err != nil {
 		t.Fatalf("Failed to allocate port: %s", err)
 	} else if !addrEqual(dstAddr1, host) {
 		t.Fatalf("Incorrect mapping result: expected %s:%s, got %s:%s",
 			dstAddr1.String(), dstAddr1.Network(), host.String(), host.Network())
 	}
 
-	if _, err := pm.Map(srcAddr1, dstIp1, 80); err == nil {
+	if _, err := pm.Map(srcAddr1, dstIp1, 80, true); err == nil {
 		t.Fatalf("Port is in use - mapping should have failed")
 	}
 
-	if _, err := pm.Map(srcAddr2, dstIp1, 80); err == nil {
+	if _, err := pm.Map(srcAddr2, dstIp1, 80, true); err == nil {
 		t.Fatalf("Port is in use - mapping should have failed")
 	}
 
-	if _, err := pm.Map(srcAddr2, dstIp2, 80); err != nil {
+	if _, err := pm.Map(srcAddr2, dstIp2, 80, true); err != nil {
 		t.Fatalf("Failed to allocate port: %s", err)
 	}
 
@@ -127,14 +127,14 @@ func TestMapAllPortsSingleInterface(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		start, end := pm.Allocator.Begin, pm.Allocator.End
 		for i := start; i < end; i++ {
-			if host, err = pm.Map(srcAddr1, dstIp1, 0); err != nil {
+			if host, err = pm.Map(srcAddr1, dstIp1, 0, true); err != nil {
 				t.Fatal(err)
 			}
 
 			hosts = append(hosts, host)
 		}
 
-		if _, err := pm.Map(srcAddr1, dstIp1, start); err == nil {
+		if _, err := pm.Map(srcAddr1, dstIp1, start, true); err == nil {
 			t.Fatalf("Port %d should be bound but is not", start)
 		}
 
@@ -147,3 +147,68 @@ func TestMapAllPortsSingleInterface(t *testing.T) {
 		hosts = []net.Addr{}
 	}
 }
+
+func TestMapWithoutUserlandProxy(t *testing.T) {
+	pm := New()
+	hostIP := net.ParseIP("127.0.0.1")
+	containerAddr := &net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.1")}
+
+	host, err := pm.Map(containerAddr, hostIP, 0, false)
+	if err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+
+	// the host port must stay bound even without a proxy process
+	if l, err := net.Listen("tcp", host.String()); err == nil {
+		l.Close()
+		t.Fatalf("Port %s should be bound but is not", host)
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatalf("Failed to release port: %s", err)
+	}
+
+	l, err := net.Listen("tcp", host.String())
+	if err != nil {
+		t.Fatalf("Port %s should be released: %s", host, err)
+	}
+	l.Close()
+}
+
+func TestMapRange(t *testing.T) {
+	pm := New()
+	hostIP := net.ParseIP("192.168.0.1")
+	containerAddr := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 7000}
+
+	hosts, err := pm.MapRange(containerAddr, hostIP, 0, 5, true)
+	if err != nil {
+		t.Fatalf("Failed to map port range: %s", err)
+	}
+	if len(hosts) != 5 {
+		t.Fatalf("Expected 5 mappings, got %d", len(hosts))
+	}
+	first := hosts[0].(*net.TCPAddr).Port
+	for i, host := range hosts {
+		if port := host.(*net.TCPAddr).Port; port != first+i {
+			t.Fatalf("Expected host port %d, got %d", first+i, port)
+		}
+		m := pm.currentMappings[getKey(host)]
+		if _, port := getIPAndPort(m.container); port != 7000+i {
+			t.Fatalf("Expected host port %d to be forwarded to 7000+%d, got %d", first+i, i, port)
+		}
+	}
+
+	// A range overlapping an existing mapping isn't mapped at all
+	if _, err := pm.MapRange(containerAddr, hostIP, first-2, 5, true); err == nil {
+		t.Fatal("Port range is in use - mapping should have failed")
+	}
+	if _, err := pm.Map(containerAddr, hostIP, first-2, true); err != nil {
+		t.Fatalf("Expected port %d to be left unmapped: %s", first-2, err)
+	}
+
+	for _, host := range hosts {
+		if err := pm.Unmap(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/networkdriver"
+	"github.com/docker/docker/daemon/networkdriver/bridge"
+	"github.com/docker/docker/pkg/dnsserver"
+	"github.com/docker/docker/pkg/resolvconf"
+)
+
+// initResolver starts the embedded DNS server on the bridge address so that
+// containers on the bridge network can resolve each other by name.
+func (daemon *Daemon) initResolver() error {
+	bridgeIface := daemon.config.BridgeIface
+	if bridgeIface == "" {
+		bridgeIface = bridge.DefaultNetworkBridge
+	}
+	addr, _, err := networkdriver.GetIfaceAddr(bridgeIface)
+	if err != nil {
+		return err
+	}
+	ip := addr.(*net.IPNet).IP
+
+	resolver := dnsserver.New(net.JoinHostPort(ip.String(), "53"), daemon.resolveName)
+	resolvConf, err := resolvconf.Get()
+	if err != nil {
+		return err
+	}
+	resolver.SetNameservers(daemon.upstreamNameservers(resolvConf))
+	if err := resolver.Start(); err != nil {
+		return fmt.Errorf("Unable to start the embedded DNS server on %s: %v", ip, err)
+	}
+	daemon.eng.OnShutdown(func() {
+		if err := resolver.Close(); err != nil {
+			logrus.Errorf("Error during embedded DNS server shutdown: %v", err)
+		}
+	})
+	logrus.Debugf("Embedded DNS server listening on %s", resolver.LocalAddr())
+
+	daemon.resolver = resolver
+	daemon.resolverIP = ip
+	return nil
+}
+
+// upstreamNameservers returns the nameservers the embedded DNS server forwards
+// queries for non-container names to. Unlike the nameservers written to the
+// containers' resolv.conf, localhost nameservers are kept since the server
+// runs in the host's network namespace.
+func (daemon *Daemon) upstreamNameservers(resolvConf []byte) []string {
+	if len(daemon.config.Dns) > 0 {
+		return daemon.config.Dns
+	}
+	if ns := resolvconf.GetNameservers(resolvConf); len(ns) > 0 {
+		return ns
+	}
+	resolvConf, _ = resolvconf.FilterResolvDns(resolvConf, daemon.config.EnableIPv6)
+	return resolvconf.GetNameservers(resolvConf)
+}
+
+// resolveName answers a query for name from the container with address src.
+// Link aliases of the requesting container take precedence over the names of
+// the other containers on the bridge network.
+func (daemon *Daemon) resolveName(name string, src net.IP) ([]net.IP, bool) {
+	containers := daemon.List()
+
+	for _, c := range containers {
+		if !isResolvable(c) || !src.Equal(net.ParseIP(c.NetworkSettings.IPAddress)) {
+			continue
+		}
+		children, err := daemon.Children(c.Name)
+		if err != nil {
+			logrus.Debugf("dns: unable to get links of %s: %v", c.Name, err)
+			break
+		}
+		for linkAlias, child := range children {
+			if _, alias := path.Split(linkAlias); strings.ToLower(alias) == name && isResolvable(child) {
+				return containerAddrs(child), true
+			}
+		}
+		break
+	}
+
+	for _, c := range containers {
+		if isResolvable(c) && strings.ToLower(strings.TrimPrefix(c.Name, "/")) == name {
+			return containerAddrs(c), true
+		}
+	}
+	return nil, false
+}
+
+// isResolvable returns true if the container is attached to the bridge network
+// and has an address allocated.
+func isResolvable(c *Container) bool {
+	return c.hostConfig != nil && c.hostConfig.NetworkMode.IsPrivate() && !c.Config.NetworkDisabled && c.isNetworkAllocated()
+}
+
+func containerAddrs(c *Container) []net.IP {
+	ips := []net.IP{net.ParseIP(c.NetworkSettings.IPAddress)}
+	if ip := net.ParseIP(c.NetworkSettings.GlobalIPv6Address); ip != nil {
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// usesEmbeddedDns returns true if the container's resolv.conf points to the
+// embedded DNS server rather than to the host's nameservers.
+func (container *Container) usesEmbeddedDns() bool {
+	mode := container.hostConfig.NetworkMode
+	// Containers without a network can't reach the server on the bridge
+	return container.daemon.resolver != nil && mode.IsPrivate() && !mode.IsNone() && len(container.hostConfig.Dns) == 0
+}
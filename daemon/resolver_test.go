@@ -0,0 +1,43 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/dnsserver"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestSetupContainerDnsEmbeddedServer(t *testing.T) {
+	daemon := &Daemon{
+		config:     &Config{},
+		resolver:   dnsserver.New("10.0.42.1:53", nil),
+		resolverIP: net.ParseIP("10.0.42.1"),
+	}
+
+	for mode, expected := range map[runconfig.NetworkMode]bool{"bridge": true, "none": false} {
+		root, err := ioutil.TempDir("", "docker-test-resolver")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		container := &Container{
+			root:       root,
+			daemon:     daemon,
+			hostConfig: &runconfig.HostConfig{NetworkMode: mode},
+		}
+		if err := container.setupContainerDns(); err != nil {
+			t.Fatal(err)
+		}
+		resolvConf, err := ioutil.ReadFile(container.ResolvConfPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if embedded := strings.Contains(string(resolvConf), "nameserver 10.0.42.1"); embedded != expected {
+			t.Fatalf("Expected the embedded DNS server in the resolv.conf of a %s container to be %v, got:\n%s", mode, expected, resolvConf)
+		}
+	}
+}
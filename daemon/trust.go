@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/registry"
+)
+
+// checkImageTrust refuses images of repositories the trust policy requires
+// to be signed, unless the manifest the image was pulled from the repository
+// with is signed by a trusted key. An image is checked against all the
+// repositories it is tagged in, and all those it was ever pulled from or
+// tagged in, so that retagging it doesn't bypass the policy. The tag the
+// image was pulled with isn't known here; it was checked by the pull.
+func (daemon *Daemon) checkImageTrust(img *image.Image) error {
+	repoNames, err := daemon.graph.Repositories(img)
+	if err != nil {
+		return err
+	}
+	for _, name := range daemon.repositories.ByID()[img.ID] {
+		repoName, _ := parsers.ParseRepositoryTag(name)
+		repoNames = append(repoNames, repoName)
+	}
+
+	checked := make(map[string]bool)
+	for _, repoName := range repoNames {
+		if checked[repoName] {
+			continue
+		}
+		checked[repoName] = true
+
+		manifest, err := daemon.graph.SignedManifest(img, repoName)
+		if err != nil {
+			return err
+		}
+		repoInfo, err := registry.ParseRepositoryInfo(repoName)
+		if err != nil {
+			return err
+		}
+		keyID, err := daemon.trustStore.CheckPolicy(repoName, repoInfo.RemoteName, "", manifest)
+		if err != nil {
+			return fmt.Errorf("Refusing image %s of %s: %s", stringid.TruncateID(img.ID), repoName, err)
+		}
+		if keyID != "" {
+			logrus.Debugf("Image %s of %s is signed by trusted key %s", img.ID, repoName, keyID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/progressreader"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/registry"
+)
+
+// partialBlobMaxAge is how long a partial download is kept to be resumed by
+// the next pull of the blob.
+const partialBlobMaxAge = 24 * time.Hour
+
+// partialBlobPath returns the path a blob is downloaded to. The partial blob
+// is kept there when a download fails so that the next attempt, or the next
+// pull, resumes where it stopped.
+func (graph *Graph) partialBlobPath(dgst digest.Digest) (string, error) {
+	dir := path.Join(graph.Root, "_tmp", "blobs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return path.Join(dir, dgst.Algorithm()+"-"+dgst.Hex()), nil
+}
+
+// downloadedBlobPaths returns the paths of the copies of the blob with the
+// given digest fully downloaded to the graph tmp dir and not consumed yet.
+func (graph *Graph) downloadedBlobPaths(dgst digest.Digest) ([]string, error) {
+	partial, err := graph.partialBlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(partial + "-*")
+}
+
+// cleanupPartialBlobs removes the blobs abandoned in the graph tmp dir: the
+// blobs fully downloaded by pulls which didn't complete, and the partial
+// downloads older than partialBlobMaxAge, except the blobs with the digests
+// in keep.
+func (graph *Graph) cleanupPartialBlobs(keep map[digest.Digest]bool) error {
+	dir := path.Join(graph.Root, "_tmp", "blobs")
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range files {
+		// Partial downloads are named ALGORITHM-HEX, the fully downloaded
+		// blobs ALGORITHM-HEX-SUFFIX.
+		parts := strings.SplitN(fi.Name(), "-", 3)
+		if len(parts) >= 2 && keep[digest.NewDigestFromHex(parts[0], parts[1])] {
+			continue
+		}
+		if len(parts) == 2 && time.Since(fi.ModTime()) < partialBlobMaxAge {
+			continue
+		}
+		logrus.Debugf("Removing abandoned download %s", fi.Name())
+		if err := os.RemoveAll(path.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadV2Blob downloads the blob with the given digest to the graph tmp
+// dir, resuming partial downloads with Range requests and retrying failed
+// requests with an exponential backoff. The digest is verified over the
+// whole blob. It returns the blob, positioned at its start, and its size.
+// The blob is moved to a file of its own once downloaded, which the caller
+// removes once done with it, so that concurrent downloads of the same blob
+// never share a file.
+func (s *TagStore) downloadV2Blob(r *registry.Session, endpoint *registry.Endpoint, remoteName string, dgst digest.Digest, auth *registry.RequestAuthorization, out io.Writer, sf *streamformatter.StreamFormatter, progressID string) (*os.File, int64, error) {
+	// Blobs of the same digest are downloaded one at a time, since they
+	// share the partial download.
+	for {
+		c, err := s.poolAdd("pull", "blob:"+dgst.String())
+		if err == nil {
+			break
+		}
+		if c == nil {
+			return nil, 0, err
+		}
+		out.Write(sf.FormatProgress(progressID, "Blob already being downloaded. Waiting.", nil))
+		<-c
+	}
+	defer s.poolRemove("pull", "blob:"+dgst.String())
+
+	partial, err := s.graph.partialBlobPath(dgst)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		size      int64
+		retrier   transferRetrier
+		restarted bool
+	)
+	for {
+		var grew bool
+		if size, grew, err = s.downloadV2BlobFrom(r, endpoint, remoteName, dgst, auth, f, out, sf, progressID); err != nil {
+			// Only the attempts which make no progress are counted
+			if grew {
+				retrier.reset()
+			}
+			if !retrier.retry(err, "Download", out, sf, progressID) {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		out.Write(sf.FormatProgress(progressID, "Verifying Checksum", nil))
+		verified, err := verifyBlob(f, dgst)
+		if err != nil {
+			return nil, 0, err
+		}
+		if verified {
+			break
+		}
+		if restarted {
+			os.Remove(partial)
+			return nil, 0, fmt.Errorf("filesystem layer verification failed for digest %s", dgst)
+		}
+		// The part downloaded before resuming is likely corrupt, download
+		// the whole blob again.
+		logrus.Debugf("Verification of %s failed, restarting the download", dgst)
+		if err := f.Truncate(0); err != nil {
+			return nil, 0, err
+		}
+		restarted = true
+	}
+
+	downloaded := partial + "-" + stringid.GenerateRandomID()[:12]
+	if err := os.Rename(partial, downloaded); err != nil {
+		return nil, 0, err
+	}
+	blob, err := os.Open(downloaded)
+	if err != nil {
+		os.Remove(downloaded)
+		return nil, 0, err
+	}
+	return blob, size, nil
+}
+
+// verifyBlob returns whether the content of f matches the digest.
+func verifyBlob(f *os.File, dgst digest.Digest) (bool, error) {
+	verifier, err := digest.NewDigestVerifier(dgst)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(verifier, f); err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
+
+// downloadV2BlobFrom appends the part of the blob missing from f to f,
+// restarting from scratch if the registry doesn't support Range requests.
+// It also returns whether f grew, even if the download failed.
+func (s *TagStore) downloadV2BlobFrom(r *registry.Session, endpoint *registry.Endpoint, remoteName string, dgst digest.Digest, auth *registry.RequestAuthorization, f *os.File, out io.Writer, sf *streamformatter.StreamFormatter, progressID string) (int64, bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	rc, offset, size, err := r.GetV2ImageBlobReaderFrom(endpoint, remoteName, dgst.Algorithm(), dgst.Hex(), fi.Size(), auth)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rc.Close()
+
+	if offset != fi.Size() {
+		logrus.Debugf("Restarting download of %s from byte %d", dgst, offset)
+		if err := f.Truncate(offset); err != nil {
+			return 0, false, err
+		}
+	}
+	if _, err := f.Seek(offset, 0); err != nil {
+		return 0, false, err
+	}
+	action := "Downloading"
+	if offset > 0 {
+		action = "Resuming download"
+	}
+	n, err := io.Copy(f, progressreader.New(progressreader.Config{
+		In:         s.limitBandwidth(rc),
+		Out:        out,
+		Formatter:  sf,
+		Size:       int(size),
+		Current:    int(offset),
+		LastUpdate: int(offset),
+		NewLines:   false,
+		ID:         progressID,
+		Action:     action,
+	}))
+	grew := offset+n > fi.Size()
+	if err != nil {
+		return 0, grew, fmt.Errorf("unable to copy v2 image blob data: %s", err)
+	}
+	if offset+n != size {
+		return 0, grew, fmt.Errorf("unable to copy v2 image blob data: got %d bytes, expected %d", offset+n, size)
+	}
+	return size, grew, nil
+}
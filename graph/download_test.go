@@ -0,0 +1,364 @@
+package graph
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/requestdecorator"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// flakyBlobServer serves a blob, dropping the connection halfway through the
+// first dropCount responses, then failing the next errorCount requests.
+type flakyBlobServer struct {
+	sync.Mutex
+	blob          []byte
+	dropCount     int
+	errorCount    int
+	supportsRange bool
+	ranges        []string
+}
+
+func (s *flakyBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	drop := s.dropCount > 0
+	s.dropCount--
+	fail := !drop && s.errorCount > 0
+	if fail {
+		s.errorCount--
+	}
+	s.Unlock()
+
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.supportsRange {
+		r.Header.Del("Range")
+	}
+	if !drop {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.blob))
+		return
+	}
+
+	var (
+		start   int
+		status  = "200 OK"
+		headers = fmt.Sprintf("Content-Length: %d\r\n", len(s.blob))
+	)
+	if rng := r.Header.Get("Range"); rng != "" {
+		fmt.Sscanf(rng, "bytes=%d-", &start)
+		status = "206 Partial Content"
+		headers = fmt.Sprintf("Content-Length: %d\r\nContent-Range: bytes %d-%d/%d\r\n", len(s.blob)-start, start, len(s.blob)-1, len(s.blob))
+	}
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(buf, "HTTP/1.1 %s\r\n%s\r\n", status, headers)
+	buf.Write(s.blob[start : start+(len(s.blob)-start)/2])
+	buf.Flush()
+}
+
+// downloadTest downloads the blob of a flakyBlobServer into a test graph.
+type downloadTest struct {
+	store    *TagStore
+	server   *flakyBlobServer
+	ts       *httptest.Server
+	endpoint *registry.Endpoint
+	session  *registry.Session
+	auth     *registry.RequestAuthorization
+	dgst     digest.Digest
+	tmp      string
+}
+
+// newTestRegistrySession returns a session with the v2 registry served by
+// handler, authorized for the repository foo/bar.
+func newTestRegistrySession(t *testing.T, handler http.Handler) (*httptest.Server, *registry.Endpoint, *registry.Session, *registry.RequestAuthorization) {
+	ts := httptest.NewServer(handler)
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := &registry.Endpoint{URL: u, Version: registry.APIVersion2}
+	session, err := registry.NewSession(&registry.AuthConfig{}, requestdecorator.NewRequestFactory(), endpoint, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := registry.NewRequestAuthorization(&registry.AuthConfig{}, endpoint, "repository", "foo/bar", []string{"pull", "push"})
+	return ts, endpoint, session, auth
+}
+
+func newDownloadTest(t *testing.T, server *flakyBlobServer) *downloadTest {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &downloadTest{store: mkTestTagStore(tmp, t), server: server, tmp: tmp}
+	d.ts, d.endpoint, d.session, d.auth = newTestRegistrySession(t, server)
+	if d.dgst, err = digest.FromBytes(server.blob); err != nil {
+		t.Fatal(err)
+	}
+	transferRetryDelay = time.Millisecond
+	return d
+}
+
+func (d *downloadTest) Close() {
+	transferRetryDelay = 500 * time.Millisecond
+	d.ts.Close()
+	d.store.graph.driver.Cleanup()
+	os.RemoveAll(d.tmp)
+}
+
+func (d *downloadTest) partialPath(t *testing.T) string {
+	partialPath, err := d.store.graph.partialBlobPath(d.dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return partialPath
+}
+
+func (d *downloadTest) download(t *testing.T) ([]byte, error) {
+	out := &bytes.Buffer{}
+	f, size, err := d.store.downloadV2Blob(d.session, d.endpoint, "foo/bar", d.dgst, d.auth, out, streamformatter.NewStreamFormatter(false), "blob")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if size != int64(len(d.server.blob)) {
+		t.Fatalf("Expected size %d, got %d", len(d.server.blob), size)
+	}
+	return ioutil.ReadAll(f)
+}
+
+func testBlob(t *testing.T) []byte {
+	blob := make([]byte, 1<<20)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func TestDownloadV2BlobResumes(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), dropCount: 2, supportsRange: true})
+	defer d.Close()
+	blob, err := d.download(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob, d.server.blob) {
+		t.Fatal("Downloaded blob differs from the served blob")
+	}
+	if len(d.server.ranges) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(d.server.ranges))
+	}
+	if d.server.ranges[0] != "" {
+		t.Fatalf("Expected the first request to start at 0, got %q", d.server.ranges[0])
+	}
+	for _, rng := range d.server.ranges[1:] {
+		if !strings.HasPrefix(rng, "bytes=") {
+			t.Fatalf("Expected retries to resume with a Range request, got %q", rng)
+		}
+	}
+}
+
+func TestDownloadV2BlobWithoutRangeSupport(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), dropCount: 1})
+	defer d.Close()
+	blob, err := d.download(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob, d.server.blob) {
+		t.Fatal("Downloaded blob differs from the served blob")
+	}
+}
+
+func TestDownloadV2BlobResetsRetriesOnProgress(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), dropCount: 2 * maxTransferAttempts, supportsRange: true})
+	defer d.Close()
+	blob, err := d.download(t)
+	if err != nil {
+		t.Fatalf("Expected a download making progress to keep resuming, got %v", err)
+	}
+	if !bytes.Equal(blob, d.server.blob) {
+		t.Fatal("Downloaded blob differs from the served blob")
+	}
+}
+
+func TestDownloadV2BlobResumesNextPull(t *testing.T) {
+	// The attempts after the first one make no progress
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), dropCount: 1, errorCount: maxTransferAttempts - 1, supportsRange: true})
+	defer d.Close()
+	if _, err := d.download(t); err == nil {
+		t.Fatal("Expected the download to fail")
+	}
+	if len(d.server.ranges) != maxTransferAttempts {
+		t.Fatalf("Expected %d attempts, got %d", maxTransferAttempts, len(d.server.ranges))
+	}
+	fi, err := os.Stat(d.partialPath(t))
+	if err != nil {
+		t.Fatalf("Expected the partial blob to be kept: %s", err)
+	}
+	if fi.Size() == 0 || fi.Size() >= int64(len(d.server.blob)) {
+		t.Fatalf("Expected a partial blob, got %d of %d bytes", fi.Size(), len(d.server.blob))
+	}
+
+	d.server.ranges = nil
+	blob, err := d.download(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob, d.server.blob) {
+		t.Fatal("Downloaded blob differs from the served blob")
+	}
+	if expected := fmt.Sprintf("bytes=%d-", fi.Size()); len(d.server.ranges) != 1 || d.server.ranges[0] != expected {
+		t.Fatalf("Expected a single request with Range %q, got %q", expected, d.server.ranges)
+	}
+}
+
+func TestDownloadV2BlobCorruptPartial(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), supportsRange: true})
+	defer d.Close()
+	if err := ioutil.WriteFile(d.partialPath(t), []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := d.download(t)
+	if err != nil {
+		t.Fatalf("Expected the download to restart from scratch, got %v", err)
+	}
+	if !bytes.Equal(blob, d.server.blob) {
+		t.Fatal("Downloaded blob differs from the served blob")
+	}
+	if len(d.server.ranges) != 2 || d.server.ranges[0] != "bytes=7-" || d.server.ranges[1] != "" {
+		t.Fatalf("Expected a resumed download then a full one, got %q", d.server.ranges)
+	}
+}
+
+func TestDownloadV2BlobCorrupt(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), supportsRange: true})
+	defer d.Close()
+	// The registry serves a blob which doesn't match the digest
+	d.server.blob = testBlob(t)
+	if _, err := d.download(t); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Fatalf("Expected the verification to fail, got %v", err)
+	}
+	if len(d.server.ranges) != 2 {
+		t.Fatalf("Expected the download to be restarted once, got %d requests", len(d.server.ranges))
+	}
+	if _, err := os.Stat(d.partialPath(t)); !os.IsNotExist(err) {
+		t.Fatal("Expected the corrupt partial blob to be removed")
+	}
+}
+
+func TestDownloadV2BlobRangeNotSatisfiable(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), supportsRange: true})
+	defer d.Close()
+	var requests int
+	d.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	})
+	if err := ioutil.WriteFile(d.partialPath(t), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The download restarts from scratch once per attempt, instead of looping
+	if _, err := d.download(t); err == nil {
+		t.Fatal("Expected the download to fail")
+	}
+	if requests != 2*maxTransferAttempts {
+		t.Fatalf("Expected %d requests, got %d", 2*maxTransferAttempts, requests)
+	}
+}
+
+func TestDownloadV2BlobConcurrently(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t), supportsRange: true})
+	defer d.Close()
+
+	files := make(chan *os.File, 2)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			f, _, err := d.store.downloadV2Blob(d.session, d.endpoint, "foo/bar", d.dgst, d.auth, ioutil.Discard, streamformatter.NewStreamFormatter(false), "blob")
+			files <- f
+			errs <- err
+		}()
+	}
+	var names []string
+	for i := 0; i < 2; i++ {
+		f := <-files
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		blob, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(blob, d.server.blob) {
+			t.Fatal("Downloaded blob differs from the served blob")
+		}
+		names = append(names, f.Name())
+	}
+	// Each download gets a file of its own, removing one leaves the other
+	if names[0] == names[1] {
+		t.Fatalf("Expected the downloads to get distinct files, got %s twice", names[0])
+	}
+	if err := os.Remove(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(names[1]); err != nil {
+		t.Fatalf("Expected the other download to be kept: %s", err)
+	}
+}
+
+func TestCleanupPartialBlobs(t *testing.T) {
+	d := newDownloadTest(t, &flakyBlobServer{blob: testBlob(t)})
+	defer d.Close()
+	partial := d.partialPath(t)
+	var (
+		kept      = digest.NewDigestFromHex("sha256", strings.Repeat("a", 64))
+		recent    = partial
+		old       = partial[:len(partial)-1] + "0"
+		abandoned = partial + "-0123456789ab"
+	)
+	keptPath, err := d.store.graph.partialBlobPath(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keptPath += "-0123456789ab"
+	for _, p := range []string{recent, old, abandoned, keptPath} {
+		if err := ioutil.WriteFile(p, []byte("blob"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	longAgo := time.Now().Add(-2 * partialBlobMaxAge)
+	if err := os.Chtimes(old, longAgo, longAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.store.graph.cleanupPartialBlobs(map[digest.Digest]bool{kept: true}); err != nil {
+		t.Fatal(err)
+	}
+	for p, expected := range map[string]bool{recent: true, old: false, abandoned: false, keptPath: true} {
+		if _, err := os.Stat(p); (err == nil) != expected {
+			t.Fatalf("Expected %s to be kept: %v, got %v", p, expected, err)
+		}
+	}
+}
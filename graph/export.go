@@ -10,20 +10,40 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/registry"
 )
 
 // CmdImageExport exports all images with the given tag. All versions
-// containing the same tag are exported. The resulting output is an
-// uncompressed tar ball.
+// containing the same tag are exported. The resulting output is a tar ball,
+// compressed with the compression named by the "compress" env, if any. The
+// images are laid out in the format named by the "format" env, legacy by
+// default or oci. The layers of the image named by the "excludelayersfrom"
+// env and of its parents, which the receiver already has, aren't exported.
 // name is the set of tags to export.
 // out is the writer where the images are written to.
 func (s *TagStore) CmdImageExport(job *engine.Job) error {
 	if len(job.Args) < 1 {
 		return fmt.Errorf("Usage: %s IMAGE [IMAGE...]\n", job.Name)
 	}
+	compression, err := archive.ParseCompression(job.Getenv("compress"))
+	if err != nil {
+		return err
+	}
+	var oci bool
+	switch format := job.Getenv("format"); format {
+	case "", "legacy":
+	case "oci":
+		oci = true
+	default:
+		return fmt.Errorf("Unknown save format %q", format)
+	}
+	excluded, err := s.excludedLayers(job.Getenv("excludelayersfrom"))
+	if err != nil {
+		return err
+	}
 	// get image json
 	tempdir, err := ioutil.TempDir("", "docker-export-")
 	if err != nil {
@@ -40,6 +60,14 @@ func (s *TagStore) CmdImageExport(job *engine.Job) error {
 			repo[tag] = id
 		}
 	}
+	exported := make(map[string]bool)
+	exportImage := func(id string) error {
+		exported[id] = true
+		if oci {
+			return nil
+		}
+		return s.exportImage(job.Eng, id, tempdir, excluded)
+	}
 	for _, name := range job.Args {
 		name = registry.NormalizeLocalName(name)
 		logrus.Debugf("Serializing %s", name)
@@ -48,7 +76,7 @@ func (s *TagStore) CmdImageExport(job *engine.Job) error {
 			// this is a base repo name, like 'busybox'
 			for tag, id := range rootRepo {
 				addKey(name, tag, id)
-				if err := s.exportImage(job.Eng, id, tempdir); err != nil {
+				if err := exportImage(id); err != nil {
 					return err
 				}
 			}
@@ -67,21 +95,32 @@ func (s *TagStore) CmdImageExport(job *engine.Job) error {
 				if len(repoTag) > 0 {
 					addKey(repoName, repoTag, img.ID)
 				}
-				if err := s.exportImage(job.Eng, img.ID, tempdir); err != nil {
+				if err := exportImage(img.ID); err != nil {
 					return err
 				}
 
 			} else {
 				// this must be an ID that didn't get looked up just right?
-				if err := s.exportImage(job.Eng, name, tempdir); err != nil {
+				if err := exportImage(name); err != nil {
 					return err
 				}
 			}
 		}
 		logrus.Debugf("End Serializing %s", name)
 	}
-	// write repositories, if there is something to write
-	if len(rootRepoMap) > 0 {
+	if oci {
+		exporter, err := newOCIExporter(s.graph, tempdir, excluded)
+		if err != nil {
+			return err
+		}
+		if err := exporter.exportImages(rootRepoMap, exported); err != nil {
+			return err
+		}
+		if err := exporter.Close(); err != nil {
+			return err
+		}
+	} else if len(rootRepoMap) > 0 {
+		// write repositories, if there is something to write
 		rootRepoJson, _ := json.Marshal(rootRepoMap)
 		if err := ioutil.WriteFile(path.Join(tempdir, "repositories"), rootRepoJson, os.FileMode(0644)); err != nil {
 			return err
@@ -90,7 +129,7 @@ func (s *TagStore) CmdImageExport(job *engine.Job) error {
 		logrus.Debugf("There were no repositories to write")
 	}
 
-	fs, err := archive.Tar(tempdir, archive.Uncompressed)
+	fs, err := archive.Tar(tempdir, compression)
 	if err != nil {
 		return err
 	}
@@ -103,9 +142,32 @@ func (s *TagStore) CmdImageExport(job *engine.Job) error {
 	return nil
 }
 
+// excludedLayers returns the IDs of the image with the given name and of its
+// parents, whose layers aren't exported, or nil if name is empty.
+func (s *TagStore) excludedLayers(name string) (map[string]bool, error) {
+	if name == "" {
+		return nil, nil
+	}
+	img, err := s.LookupImage(name)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, fmt.Errorf("No such image: %s", name)
+	}
+	excluded := make(map[string]bool)
+	if err := img.WalkHistory(func(img *image.Image) error {
+		excluded[img.ID] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return excluded, nil
+}
+
 // FIXME: this should be a top-level function, not a class method
-func (s *TagStore) exportImage(eng *engine.Engine, name, tempdir string) error {
-	for n := name; n != ""; {
+func (s *TagStore) exportImage(eng *engine.Engine, name, tempdir string, excluded map[string]bool) error {
+	for n := name; n != "" && !excluded[n]; {
 		// temporary directory
 		tmpImageDir := path.Join(tempdir, n)
 		if err := os.Mkdir(tmpImageDir, os.FileMode(0755)); err != nil {
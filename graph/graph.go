@@ -1,16 +1,18 @@
 package graph
 
 import (
-	"compress/gzip"
 	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -23,6 +25,7 @@ import (
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/tarsum"
 	"github.com/docker/docker/pkg/truncindex"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/docker/utils"
@@ -33,6 +36,29 @@ type Graph struct {
 	Root    string
 	idIndex *truncindex.TruncIndex
 	driver  graphdriver.Driver
+
+	// legacyIDs maps the IDs images were registered under before image IDs
+	// were derived from their content to their content-addressable IDs.
+	legacyIDs   map[string]string
+	legacyIndex *truncindex.TruncIndex
+	// blobSums maps the digests of layers as stored in a v2 registry to
+	// the digests of their uncompressed content.
+	blobSums map[digest.Digest]digest.Digest
+	// lazyLayers are the layers of the images registered before their
+	// layer was fully extracted, while it is being extracted.
+	lazyLayers map[string]*lazyLayer
+	// lazyFailureHandler is called with the ID of an image when the
+	// extraction of its layer fails in the background.
+	lazyFailureHandler func(id string)
+	// imageLocks serialize the commits of images with the same ID, which
+	// concurrent pulls or loads of the same layer compute.
+	imageLocks map[string]*imageLock
+	sync.Mutex
+}
+
+type imageLock struct {
+	sync.Mutex
+	refs int
 }
 
 // NewGraph instantiates a new graph at the given root path in the filesystem.
@@ -48,9 +74,13 @@ func NewGraph(root string, driver graphdriver.Driver) (*Graph, error) {
 	}
 
 	graph := &Graph{
-		Root:    abspath,
-		idIndex: truncindex.NewTruncIndex([]string{}),
-		driver:  driver,
+		Root:       abspath,
+		idIndex:    truncindex.NewTruncIndex([]string{}),
+		driver:     driver,
+		legacyIDs:  make(map[string]string),
+		blobSums:   make(map[digest.Digest]digest.Digest),
+		lazyLayers: make(map[string]*lazyLayer),
+		imageLocks: make(map[string]*imageLock),
 	}
 	if err := graph.restore(); err != nil {
 		return nil, err
@@ -63,16 +93,44 @@ func (graph *Graph) restore() error {
 	if err != nil {
 		return err
 	}
-	var ids = []string{}
+	if err := graph.loadLegacyIDs(); err != nil {
+		return err
+	}
+	var (
+		ids        = []string{}
+		legacy     = []*image.Image{}
+		incomplete = []*image.Image{}
+		blobs      = make(map[digest.Digest]bool)
+	)
 	for _, v := range dir {
 		id := v.Name()
-		if graph.driver.Exists(id) {
-			ids = append(ids, id)
+		img, err := image.LoadImage(graph.ImageRoot(id))
+		if err != nil || img.ID != id || !graph.driver.Exists(img.LayerID()) {
+			continue
+		}
+		ids = append(ids, id)
+		checksum, _ := img.GetCheckSum(graph.ImageRoot(id))
+		if img.IsIncomplete(graph.ImageRoot(id)) {
+			incomplete = append(incomplete, img)
+			blobs[digest.Digest(checksum)] = true
+		}
+		if img.LayerDigest == "" {
+			legacy = append(legacy, img)
+			continue
+		}
+		if checksum != "" {
+			graph.blobSums[digest.Digest(checksum)] = img.LayerDigest
 		}
 	}
 	graph.idIndex = truncindex.NewTruncIndex(ids)
 	logrus.Debugf("Restored %d elements", len(dir))
-	return nil
+	// The blobs of the layers registered lazily are kept to resume their
+	// extraction.
+	if err := graph.cleanupPartialBlobs(blobs); err != nil {
+		logrus.Errorf("Unable to remove abandoned downloads: %s", err)
+	}
+	graph.resumeLazyLayers(incomplete)
+	return graph.migrateLegacyImages(legacy)
 }
 
 // FIXME: Implement error subclass instead of looking at the error text
@@ -94,7 +152,10 @@ func (graph *Graph) Exists(id string) bool {
 func (graph *Graph) Get(name string) (*image.Image, error) {
 	id, err := graph.idIndex.Get(name)
 	if err != nil {
-		return nil, fmt.Errorf("could not find image: %v", err)
+		var exists bool
+		if id, exists = graph.resolveLegacyID(name); !exists {
+			return nil, fmt.Errorf("could not find image: %v", err)
+		}
 	}
 	img, err := image.LoadImage(graph.ImageRoot(id))
 	if err != nil {
@@ -106,7 +167,11 @@ func (graph *Graph) Get(name string) (*image.Image, error) {
 	img.SetGraph(graph)
 
 	if img.Size < 0 {
-		size, err := graph.driver.DiffSize(img.ID, img.Parent)
+		parentLayerID, err := img.ParentLayerID()
+		if err != nil {
+			return nil, err
+		}
+		size, err := graph.driver.DiffSize(img.LayerID(), parentLayerID)
 		if err != nil {
 			return nil, fmt.Errorf("unable to calculate size of image id %q: %s", img.ID, err)
 		}
@@ -122,7 +187,6 @@ func (graph *Graph) Get(name string) (*image.Image, error) {
 // Create creates a new image and registers it in the graph.
 func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, containerImage, comment, author string, containerConfig, config *runconfig.Config) (*image.Image, error) {
 	img := &image.Image{
-		ID:            stringid.GenerateRandomID(),
 		Comment:       comment,
 		Created:       time.Now().UTC(),
 		DockerVersion: dockerversion.VERSION,
@@ -144,58 +208,124 @@ func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, contain
 	return img, nil
 }
 
-// Register imports a pre-existing image into the graph.
-func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader) (err error) {
+// Register imports a pre-existing image into the graph under its
+// content-addressable ID, which img.ID is set to on success. A non-empty
+// img.ID, like the ID of an image pulled from a v1 registry or loaded from
+// a tarball, remains a valid reference to the image. Layers whose content
+// and config are already in the graph are not stored twice.
+func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader) error {
+	_, err := graph.register(img, func(root string) error {
+		return image.StoreImage(img, layerData, root)
+	})
+	return err
+}
+
+// register registers img with the layer stored by store, which also stores
+// the metadata of the image in root. It returns whether the image was added
+// to the graph, as opposed to already being in it.
+func (graph *Graph) register(img *image.Image, store func(root string) error) (added bool, err error) {
+	legacyID := img.ID
+	if legacyID != "" {
+		if err := utils.ValidateID(legacyID); err != nil {
+			return false, err
+		}
+		// (This is a convenience to save time. Race conditions are taken care of by os.Rename)
+		if graph.Exists(legacyID) {
+			return false, fmt.Errorf("Image %s already exists", legacyID)
+		}
+	}
+	if img.Parent != "" {
+		parent, err := graph.Get(img.Parent)
+		if err != nil {
+			return false, err
+		}
+		img.Parent = parent.ID
+	}
+
+	// The ID of the layer in the driver is not known to anybody else, so
+	// there is no need to clean up after an earlier attempt.
+	layerID := stringid.GenerateRandomID()
+	img.SetLayerID(layerID)
 	defer func() {
 		// If any error occurs, remove the new dir from the driver.
 		// Don't check for errors since the dir might not have been created.
-		// FIXME: this leaves a possible race condition.
 		if err != nil {
-			graph.driver.Remove(img.ID)
+			graph.driver.Remove(layerID)
+			img.ID = legacyID
 		}
 	}()
-	if err := utils.ValidateID(img.ID); err != nil {
-		return err
-	}
-	// (This is a convenience to save time. Race conditions are taken care of by os.Rename)
-	if graph.Exists(img.ID) {
-		return fmt.Errorf("Image %s already exists", img.ID)
-	}
-
-	// Ensure that the image root does not exist on the filesystem
-	// when it is not registered in the graph.
-	// This is common when you switch from one graph driver to another
-	if err := os.RemoveAll(graph.ImageRoot(img.ID)); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	// If the driver has this ID but the graph doesn't, remove it from the driver to start fresh.
-	// (the graph is the source of truth).
-	// Ignore errors, since we don't know if the driver correctly returns ErrNotExist.
-	// (FIXME: make that mandatory for drivers).
-	graph.driver.Remove(img.ID)
 
 	tmp, err := graph.Mktemp("")
 	defer os.RemoveAll(tmp)
 	if err != nil {
-		return fmt.Errorf("Mktemp failed: %s", err)
+		return false, fmt.Errorf("Mktemp failed: %s", err)
 	}
 
+	img.SetGraph(graph)
+	parentLayerID, err := img.ParentLayerID()
+	if err != nil {
+		return false, err
+	}
 	// Create root filesystem in the driver
-	if err := graph.driver.Create(img.ID, img.Parent); err != nil {
-		return fmt.Errorf("Driver %s failed to create image rootfs %s: %s", graph.driver, img.ID, err)
+	if err := graph.driver.Create(layerID, parentLayerID); err != nil {
+		return false, fmt.Errorf("Driver %s failed to create image rootfs %s: %s", graph.driver, layerID, err)
 	}
 	// Apply the diff/layer
-	img.SetGraph(graph)
-	if err := image.StoreImage(img, layerData, tmp); err != nil {
-		return err
+	if err := store(tmp); err != nil {
+		return false, err
+	}
+
+	unlock := graph.lockImage(img.ID)
+	defer unlock()
+	if existing, err := graph.Get(img.ID); err == nil {
+		logrus.Debugf("Image %s is already in the graph", img.ID)
+		graph.driver.Remove(layerID)
+		*img = *existing
+	} else if _, indexErr := graph.idIndex.Get(img.ID); indexErr == nil {
+		// Never replace the root of a registered image
+		return false, err
+	} else {
+		// Ensure that the image root does not exist on the filesystem
+		// when it is not registered in the graph.
+		// This is common when you switch from one graph driver to another
+		if err := os.RemoveAll(graph.ImageRoot(img.ID)); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		// Commit
+		if err := os.Rename(tmp, graph.ImageRoot(img.ID)); err != nil {
+			return false, err
+		}
+		graph.idIndex.Add(img.ID)
+		added = true
 	}
-	// Commit
-	if err := os.Rename(tmp, graph.ImageRoot(img.ID)); err != nil {
-		return err
+
+	if legacyID != "" && legacyID != img.ID {
+		return added, graph.addLegacyID(legacyID, img.ID)
+	}
+	return added, nil
+}
+
+// lockImage locks the image with the given ID until the returned function is
+// called, for its root to be checked and committed atomically.
+func (graph *Graph) lockImage(id string) func() {
+	graph.Lock()
+	l, exists := graph.imageLocks[id]
+	if !exists {
+		l = &imageLock{}
+		graph.imageLocks[id] = l
+	}
+	l.refs++
+	graph.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		graph.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(graph.imageLocks, id)
+		}
+		graph.Unlock()
 	}
-	graph.idIndex.Add(img.ID)
-	return nil
 }
 
 // TempLayerArchive creates a temporary archive of the given image's filesystem layer.
@@ -207,6 +337,9 @@ func (graph *Graph) TempLayerArchive(id string, sf *streamformatter.StreamFormat
 	if err != nil {
 		return nil, err
 	}
+	if err := graph.WaitLayers(image.ID); err != nil {
+		return nil, err
+	}
 	tmp, err := graph.Mktemp("")
 	if err != nil {
 		return nil, err
@@ -248,14 +381,49 @@ func (graph *Graph) newTempFile() (*os.File, error) {
 func bufferToFile(f *os.File, src io.Reader) (int64, digest.Digest, error) {
 	var (
 		h = sha256.New()
-		w = gzip.NewWriter(io.MultiWriter(f, h))
+		w = archive.NewParallelGzipWriter(io.MultiWriter(f, h))
 	)
-	_, err := io.Copy(w, src)
-	w.Close()
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return 0, "", err
+	}
+	// The blocks are compressed and written until the writer is closed
+	if err := w.Close(); err != nil {
+		return 0, "", err
+	}
+	return rewindBuffer(f, h)
+}
+
+// bufferIndexedToFile buffers the layer archive src to f as an indexed
+// archive, which the layer can be registered lazily from, recording the
+// digest of the layer in the index.
+func bufferIndexedToFile(f *os.File, src io.Reader) (int64, digest.Digest, error) {
+	var (
+		h = sha256.New()
+		w = archive.NewIndexedWriter(io.MultiWriter(f, h))
+	)
+	layer, err := tarsum.NewTarSum(src, true, tarsum.Version1)
 	if err != nil {
 		return 0, "", err
 	}
-	if err = f.Sync(); err != nil {
+	if err := w.WriteArchive(layer); err != nil {
+		return 0, "", err
+	}
+	// The digest is computed once the whole archive is read
+	if _, err := io.Copy(ioutil.Discard, layer); err != nil {
+		return 0, "", err
+	}
+	w.Index.Digest = layer.Sum(nil)
+	if err := w.Close(); err != nil {
+		return 0, "", err
+	}
+	return rewindBuffer(f, h)
+}
+
+// rewindBuffer syncs the buffer f, whose content was hashed with h, and
+// returns its size and digest, with f positioned at its start.
+func rewindBuffer(f *os.File, h hash.Hash) (int64, digest.Digest, error) {
+	if err := f.Sync(); err != nil {
 		return 0, "", err
 	}
 	n, err := f.Seek(0, os.SEEK_CUR)
@@ -342,10 +510,16 @@ func isNotEmpty(err error) bool {
 
 // Delete atomically removes an image from the graph.
 func (graph *Graph) Delete(name string) error {
-	id, err := graph.idIndex.Get(name)
+	img, err := graph.Get(name)
 	if err != nil {
 		return err
 	}
+	id := img.ID
+	// The layer can't be removed while it is being extracted
+	graph.cancelLayer(id)
+	if err := graph.removeLegacyIDs(id); err != nil {
+		return err
+	}
 	tmp, err := graph.Mktemp("")
 	graph.idIndex.Delete(id)
 	if err == nil {
@@ -360,7 +534,7 @@ func (graph *Graph) Delete(name string) error {
 		tmp = graph.ImageRoot(id)
 	}
 	// Remove rootfs data from the driver
-	graph.driver.Remove(id)
+	graph.driver.Remove(img.LayerID())
 	// Remove the trashed image directory
 	return os.RemoveAll(tmp)
 }
@@ -433,6 +607,130 @@ func (graph *Graph) Heads() (map[string]*image.Image, error) {
 	return heads, err
 }
 
+// SetBlobSum records the digest of the image's layer as stored in a v2
+// registry.
+func (graph *Graph) SetBlobSum(img *image.Image, blobSum digest.Digest) error {
+	if err := img.SaveCheckSum(graph.ImageRoot(img.ID), blobSum.String()); err != nil {
+		return err
+	}
+	graph.Lock()
+	graph.blobSums[blobSum] = img.LayerDigest
+	graph.Unlock()
+	return nil
+}
+
+// AddBlobSource records that the image's layer is stored as a blob in the
+// given repository of a v2 registry, so that it can be mounted from there
+// when it is pushed to another repository of the same registry.
+func (graph *Graph) AddBlobSource(img *image.Image, repoName string) error {
+	graph.Lock()
+	defer graph.Unlock()
+	root := graph.ImageRoot(img.ID)
+	sources, err := img.GetBlobSources(root)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if source == repoName {
+			return nil
+		}
+	}
+	return img.SaveBlobSources(root, append(sources, repoName))
+}
+
+// BlobSources returns the repositories the image's layer is known to be
+// stored in as a blob.
+func (graph *Graph) BlobSources(img *image.Image) ([]string, error) {
+	graph.Lock()
+	defer graph.Unlock()
+	return img.GetBlobSources(graph.ImageRoot(img.ID))
+}
+
+// SetSignedManifest records the signed manifest the image was pulled from
+// the repository with, replacing the one of an earlier pull.
+func (graph *Graph) SetSignedManifest(img *image.Image, repoName string, manifest []byte) error {
+	graph.Lock()
+	defer graph.Unlock()
+	root := graph.ImageRoot(img.ID)
+	manifests, err := img.GetSignedManifests(root)
+	if err != nil {
+		return err
+	}
+	manifests[repoName] = manifest
+	return img.SaveSignedManifests(root, manifests)
+}
+
+// SignedManifest returns the signed manifest the image was pulled from the
+// repository with, or nil if it wasn't.
+func (graph *Graph) SignedManifest(img *image.Image, repoName string) ([]byte, error) {
+	graph.Lock()
+	defer graph.Unlock()
+	manifests, err := img.GetSignedManifests(graph.ImageRoot(img.ID))
+	if err != nil {
+		return nil, err
+	}
+	return manifests[repoName], nil
+}
+
+// AddRepository records that the image was tagged in the repository, so that
+// the trust policy of the repository keeps applying to the image once it is
+// untagged from it.
+func (graph *Graph) AddRepository(img *image.Image, repoName string) error {
+	graph.Lock()
+	defer graph.Unlock()
+	root := graph.ImageRoot(img.ID)
+	manifests, err := img.GetSignedManifests(root)
+	if err != nil {
+		return err
+	}
+	if _, exists := manifests[repoName]; exists {
+		return nil
+	}
+	manifests[repoName] = nil
+	return img.SaveSignedManifests(root, manifests)
+}
+
+// Repositories returns the repositories the image was pulled from or tagged
+// in, with or without a signed manifest.
+func (graph *Graph) Repositories(img *image.Image) ([]string, error) {
+	graph.Lock()
+	defer graph.Unlock()
+	manifests, err := img.GetSignedManifests(graph.ImageRoot(img.ID))
+	if err != nil {
+		return nil, err
+	}
+	repoNames := make([]string, 0, len(manifests))
+	for repoName := range manifests {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+	return repoNames, nil
+}
+
+// LayerDigest returns the digest of the uncompressed content of the layer
+// stored in a v2 registry as blobSum, if such a layer is in the graph.
+func (graph *Graph) LayerDigest(blobSum digest.Digest) (digest.Digest, bool) {
+	graph.Lock()
+	defer graph.Unlock()
+	dgst, exists := graph.blobSums[blobSum]
+	return dgst, exists
+}
+
+// Touch records that the image is being used, e.g. by a new container.
+func (graph *Graph) Touch(id string) error {
+	now := time.Now()
+	return os.Chtimes(graph.ImageRoot(id), now, now)
+}
+
+// LastUsed returns when the image was last registered or used.
+func (graph *Graph) LastUsed(id string) (time.Time, error) {
+	fi, err := os.Stat(graph.ImageRoot(id))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
 func (graph *Graph) ImageRoot(id string) string {
 	return path.Join(graph.Root, id)
 }
@@ -3,7 +3,6 @@ package graph
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/engine"
@@ -35,13 +34,19 @@ func (s *TagStore) CmdHistory(job *engine.Job) error {
 	history := []types.ImageHistory{}
 
 	err = foundImage.WalkHistory(func(img *image.Image) error {
-		history = append(history, types.ImageHistory{
-			ID:        img.ID,
-			Created:   img.Created.Unix(),
-			CreatedBy: strings.Join(img.ContainerConfig.Cmd, " "),
-			Tags:      lookupMap[img.ID],
-			Size:      img.Size,
-		})
+		// The steps of a squashed layer but the last have no image of their own
+		steps := img.CreationSteps()
+		for i := len(steps) - 1; i >= 0; i-- {
+			entry := types.ImageHistory{
+				ID:        "<missing>",
+				Created:   steps[i].Created.Unix(),
+				CreatedBy: steps[i].CreatedBy,
+			}
+			if i == len(steps)-1 {
+				entry.ID, entry.Tags, entry.Size = img.ID, lookupMap[img.ID], img.Size
+			}
+			history = append(history, entry)
+		}
 		return nil
 	})
 
@@ -0,0 +1,573 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/tarsum"
+	"github.com/docker/docker/registry"
+)
+
+// Layers pulled from indexed archives can be registered lazily, i.e. before
+// being fully extracted: only the files needed to run the entrypoint of the
+// image are extracted before registering them, see prefetch.go, the rest of
+// their content being extracted in the background. Reading the content of a
+// layer, to push or save it, waits for its extraction. If the extraction
+// fails, the image and the images on top of it are untagged and containers
+// can't be created from them anymore.
+//
+// The prefetched files are verified against the digests recorded in the
+// index before the image is registered, so containers never run files which
+// don't match the index. The index itself is only verified once the whole
+// layer is downloaded and its digest matches the manifest: until then, a
+// registry can serve a forged index along with files matching it.
+
+// lazyDrivers are the storage drivers which layers can be registered lazily
+// with, because they use the content of the layers below a layer in place
+// rather than copying it when the layer is created, and tolerate writes to
+// the layers below the layer of a mounted container. overlay2 doesn't: the
+// behaviour of overlayfs is undefined when its lower layers are modified.
+var lazyDrivers = map[string]bool{
+	"aufs": true,
+}
+
+var (
+	errRangeNotSupported = errors.New("The registry doesn't support Range requests")
+	errLayerCancelled    = errors.New("The extraction of the layer was cancelled")
+	errIndexNoDigests    = errors.New("The archive index doesn't record the digests of the files of the layer")
+)
+
+// lazyLayer is the extraction in the background of the layer of an image
+// registered before being fully extracted.
+type lazyLayer struct {
+	done chan struct{}
+	err  error
+	// cancel is closed to cancel the extraction, which holds the lock
+	// while writing to the layer.
+	cancel chan struct{}
+	sync.Mutex
+}
+
+func newLazyLayer() *lazyLayer {
+	return &lazyLayer{
+		done:   make(chan struct{}),
+		cancel: make(chan struct{}),
+	}
+}
+
+func (l *lazyLayer) cancelled() bool {
+	select {
+	case <-l.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// failed returns the error of the extraction of the layer if it failed, or
+// nil if the extraction succeeded or is still running.
+func (l *lazyLayer) failed() error {
+	select {
+	case <-l.done:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// cancellableReader fails reading from the archive of a layer once its
+// extraction is cancelled.
+type cancellableReader struct {
+	io.Reader
+	l *lazyLayer
+}
+
+func (r *cancellableReader) Read(p []byte) (int, error) {
+	if r.l.cancelled() {
+		return 0, errLayerCancelled
+	}
+	return r.Reader.Read(p)
+}
+
+// supportsLazyLayers returns whether the storage driver of the graph allows
+// to register layers lazily.
+func (graph *Graph) supportsLazyLayers() bool {
+	return lazyDrivers[graph.driver.String()]
+}
+
+// RegisterLazy registers img like Register, with the layer of the indexed
+// archive r before it is fully extracted: only the regular files for which
+// prefetch returns true are extracted, after verifying them against the
+// digests recorded in the index. The whole archive, as returned by fetch, is
+// then extracted in the background and verified against the digest recorded
+// in its index.
+func (graph *Graph) RegisterLazy(img *image.Image, r io.ReaderAt, index *archive.ArchiveIndex, prefetch func(*archive.IndexEntry) bool, fetch func() (io.ReadCloser, error)) error {
+	layerDigest, err := digest.ParseDigest(index.Digest)
+	if err != nil {
+		return fmt.Errorf("Invalid digest %q in the archive index: %s", index.Digest, err)
+	}
+	added, err := graph.register(img, func(root string) error {
+		parentLayerID, err := img.ParentLayerID()
+		if err != nil {
+			return err
+		}
+		layer := index.Tar(r, prefetch)
+		defer layer.Close()
+		if _, err := graph.driver.ApplyDiff(img.LayerID(), parentLayerID, layer); err != nil {
+			return err
+		}
+		img.LayerDigest = layerDigest
+		img.Size = 0
+		for _, e := range index.Entries {
+			img.Size += e.Size
+		}
+		if img.ID, err = image.ComputeID(img); err != nil {
+			return err
+		}
+		if err := img.SaveIncomplete(root, true); err != nil {
+			return err
+		}
+		return img.SaveMetadata(root)
+	})
+	if err != nil || !added {
+		return err
+	}
+
+	l := newLazyLayer()
+	graph.Lock()
+	graph.lazyLayers[img.ID] = l
+	graph.Unlock()
+	go graph.completeLazyLayer(img, l, fetch)
+	return nil
+}
+
+// completeLazyLayer extracts the whole layer of img in the background.
+func (graph *Graph) completeLazyLayer(img *image.Image, l *lazyLayer, fetch func() (io.ReadCloser, error)) {
+	l.err = graph.extractLazyLayer(img, l, fetch)
+	close(l.done)
+	switch l.err {
+	case nil:
+		graph.Lock()
+		if graph.lazyLayers[img.ID] == l {
+			delete(graph.lazyLayers, img.ID)
+		}
+		graph.Unlock()
+		logrus.Debugf("Extracted the layer of %s", img.ID)
+	case errLayerCancelled:
+		logrus.Debugf("Cancelled the extraction of the layer of %s", img.ID)
+	default:
+		logrus.Errorf("Error extracting the layer of %s: %s", img.ID, l.err)
+		graph.Lock()
+		handler := graph.lazyFailureHandler
+		graph.Unlock()
+		if handler != nil {
+			handler(img.ID)
+		}
+	}
+}
+
+func (graph *Graph) extractLazyLayer(img *image.Image, l *lazyLayer, fetch func() (io.ReadCloser, error)) error {
+	layerData, err := fetch()
+	if err != nil {
+		return err
+	}
+	defer layerData.Close()
+
+	// Whiteouts only hide the files of the layers below once they are
+	// extracted.
+	if img.Parent != "" {
+		if err := graph.WaitLayers(img.Parent); err != nil {
+			return err
+		}
+	}
+
+	// The layer is only removed once its extraction stops writing to it.
+	l.Lock()
+	defer l.Unlock()
+	if l.cancelled() {
+		return errLayerCancelled
+	}
+	decompressed, err := archive.DecompressStream(&cancellableReader{layerData, l})
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+	layer, err := tarsum.NewTarSum(decompressed, true, tarsum.Version1)
+	if err != nil {
+		return err
+	}
+	parentLayerID, err := img.ParentLayerID()
+	if err != nil {
+		return err
+	}
+	size, err := graph.driver.ApplyDiff(img.LayerID(), parentLayerID, layer)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, layer); err != nil {
+		return err
+	}
+	if l.cancelled() {
+		return errLayerCancelled
+	}
+	if dgst := digest.Digest(layer.Sum(nil)); dgst != img.LayerDigest {
+		return fmt.Errorf("Layer verification failed: expected digest %s, got %s", img.LayerDigest, dgst)
+	}
+
+	root := graph.ImageRoot(img.ID)
+	img.Size = size
+	if err := img.SaveSize(root); err != nil {
+		return err
+	}
+	return img.SaveIncomplete(root, false)
+}
+
+// waitLayer waits for the layer of the image with the given ID to be
+// extracted if it was registered lazily, returning the error of the
+// extraction.
+func (graph *Graph) waitLayer(id string) error {
+	graph.Lock()
+	l := graph.lazyLayers[id]
+	graph.Unlock()
+	if l == nil {
+		return nil
+	}
+	<-l.done
+	return l.err
+}
+
+// cancelLayer cancels the extraction of the layer of the image with the
+// given ID if it was registered lazily, waiting only for the extraction to
+// stop writing to the layer, so that the layer can be removed.
+func (graph *Graph) cancelLayer(id string) {
+	graph.Lock()
+	l := graph.lazyLayers[id]
+	delete(graph.lazyLayers, id)
+	graph.Unlock()
+	if l == nil {
+		return
+	}
+	if !l.cancelled() {
+		close(l.cancel)
+	}
+	l.Lock()
+	l.Unlock()
+}
+
+// LayerError returns an error if the layer of the image with the given ID,
+// or of one of its parents, was registered lazily and failed to be
+// extracted. Containers can't be created from such an image.
+func (graph *Graph) LayerError(id string) error {
+	img, err := graph.Get(id)
+	if err != nil {
+		return err
+	}
+	return img.WalkHistory(func(img *image.Image) error {
+		graph.Lock()
+		l := graph.lazyLayers[img.ID]
+		graph.Unlock()
+		if l == nil {
+			return nil
+		}
+		if err := l.failed(); err != nil {
+			return fmt.Errorf("The layer of %s failed to be extracted, remove the image and pull it again: %s", img.ID, err)
+		}
+		return nil
+	})
+}
+
+// setLazyFailureHandler sets the function called with the ID of an image
+// when the extraction of its layer fails in the background, and returns the
+// IDs of the images which layers already failed to be extracted.
+func (graph *Graph) setLazyFailureHandler(handler func(id string)) []string {
+	graph.Lock()
+	defer graph.Unlock()
+	graph.lazyFailureHandler = handler
+	var failed []string
+	for id, l := range graph.lazyLayers {
+		if l.failed() != nil {
+			failed = append(failed, id)
+		}
+	}
+	return failed
+}
+
+// WaitLayers waits for the layers of the image with the given ID and of its
+// parents to be extracted if they were registered lazily.
+func (graph *Graph) WaitLayers(id string) error {
+	img, err := graph.Get(id)
+	if err != nil {
+		return err
+	}
+	return img.WalkHistory(func(img *image.Image) error {
+		if err := graph.waitLayer(img.ID); err != nil {
+			return fmt.Errorf("The layer of %s was not extracted: %s", img.ID, err)
+		}
+		return nil
+	})
+}
+
+// resumeLazyLayers resumes the extraction of the layers registered lazily
+// which weren't fully extracted when the daemon stopped, from their blobs if
+// they were fully downloaded.
+func (graph *Graph) resumeLazyLayers(images []*image.Image) {
+	lazyLayers := make(map[*image.Image]*lazyLayer)
+	graph.Lock()
+	for _, img := range images {
+		l := newLazyLayer()
+		graph.lazyLayers[img.ID] = l
+		lazyLayers[img] = l
+	}
+	graph.Unlock()
+
+	for img, l := range lazyLayers {
+		img.SetGraph(graph)
+		go graph.completeLazyLayer(img, l, func() (io.ReadCloser, error) {
+			checksum, err := img.GetCheckSum(graph.ImageRoot(img.ID))
+			if err != nil {
+				return nil, err
+			}
+			blob, err := graph.openDownloadedBlob(digest.Digest(checksum))
+			if err != nil {
+				return nil, fmt.Errorf("the layer was not fully downloaded when the daemon stopped, remove the image and pull it again: %s", err)
+			}
+			return blob, nil
+		})
+	}
+}
+
+// downloadedBlob is a blob downloaded to the graph tmp dir, removed when it
+// is closed.
+type downloadedBlob struct {
+	*os.File
+}
+
+func (b *downloadedBlob) Close() error {
+	err := b.File.Close()
+	os.Remove(b.Name())
+	return err
+}
+
+// openDownloadedBlob opens a copy of the blob with the given digest fully
+// downloaded to the graph tmp dir, verifying it. The copy is moved first so
+// that it is only opened once.
+func (graph *Graph) openDownloadedBlob(dgst digest.Digest) (io.ReadCloser, error) {
+	paths, err := graph.downloadedBlobPaths(dgst)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		claimed := p + "-" + stringid.GenerateRandomID()[:12]
+		if err := os.Rename(p, claimed); err != nil {
+			continue
+		}
+		f, err := os.Open(claimed)
+		if err != nil {
+			return nil, err
+		}
+		blob := &downloadedBlob{f}
+		verifier, err := digest.NewDigestVerifier(dgst)
+		if err != nil {
+			blob.Close()
+			return nil, err
+		}
+		if _, err := io.Copy(verifier, f); err != nil {
+			blob.Close()
+			return nil, err
+		}
+		if !verifier.Verified() {
+			blob.Close()
+			return nil, fmt.Errorf("verification failed for digest %s", dgst)
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			blob.Close()
+			return nil, err
+		}
+		return blob, nil
+	}
+	return nil, fmt.Errorf("no downloaded blob with digest %s", dgst)
+}
+
+// remoteBlob reads a blob of a v2 registry at any offset with Range
+// requests, consecutive reads being served by the same request. The part of
+// the blob already downloaded to the graph tmp dir, if any, is read from
+// there instead.
+type remoteBlob struct {
+	sync.Mutex
+	open func(offset int64) (io.ReadCloser, int64, int64, error)
+	size int64
+	body io.ReadCloser
+	pos  int64
+	// cache is the partial download of the blob
+	cache     *os.File
+	cacheSize int64
+}
+
+func newRemoteBlob(r *registry.Session, src *v2Source, remoteName string, dgst digest.Digest, cache string) (*remoteBlob, error) {
+	b := &remoteBlob{
+		open: func(offset int64) (io.ReadCloser, int64, int64, error) {
+			return r.GetV2ImageBlobReaderFrom(src.endpoint, remoteName, dgst.Algorithm(), dgst.Hex(), offset, src.auth)
+		},
+	}
+	var err error
+	if b.body, _, b.size, err = b.open(0); err != nil {
+		return nil, err
+	}
+	if f, err := os.Open(cache); err == nil {
+		if fi, err := f.Stat(); err == nil {
+			b.cache, b.cacheSize = f, fi.Size()
+		} else {
+			f.Close()
+		}
+	}
+	return b, nil
+}
+
+func (b *remoteBlob) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	if max := b.size - off; int64(len(p)) > max {
+		n, err := b.ReadAt(p[:max], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	if b.cache != nil && off+int64(len(p)) <= b.cacheSize {
+		return b.cache.ReadAt(p, off)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.body == nil || b.pos != off {
+		if b.body != nil {
+			b.body.Close()
+			b.body = nil
+		}
+		rc, start, _, err := b.open(off)
+		if err != nil {
+			return 0, err
+		}
+		if start != off {
+			rc.Close()
+			return 0, errRangeNotSupported
+		}
+		b.body, b.pos = rc, off
+	}
+	n, err := io.ReadFull(b.body, p)
+	b.pos += int64(n)
+	if err != nil {
+		b.body.Close()
+		b.body = nil
+	}
+	return n, err
+}
+
+func (b *remoteBlob) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.cache != nil {
+		b.cache.Close()
+	}
+	if b.body != nil {
+		return b.body.Close()
+	}
+	return nil
+}
+
+// lazyDownload is the blob of a layer in a registry, an indexed archive,
+// which the layer is registered lazily from.
+type lazyDownload struct {
+	blob  *remoteBlob
+	index *archive.ArchiveIndex
+}
+
+// SetLazyLayers sets whether layers are pushed as indexed archives, and
+// pulled lazily from indexed archives when the storage driver allows it.
+func (store *TagStore) SetLazyLayers(enabled bool) {
+	store.lazyLayers = enabled
+}
+
+// openLazyDownload reads the index of the blob with the given digest from
+// the first source supporting Range requests, returning an error if the
+// blob isn't an indexed archive recording the digests of its files.
+func (s *TagStore) openLazyDownload(r *registry.Session, sources []*v2Source, remoteName string, dgst digest.Digest) (*lazyDownload, error) {
+	partial, err := s.graph.partialBlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	l := &lazyDownload{}
+	if _, err := tryV2Sources(sources, func(src *v2Source) error {
+		blob, err := newRemoteBlob(r, src, remoteName, dgst, partial)
+		if err != nil {
+			return err
+		}
+		index, err := archive.ReadArchiveIndex(blob, blob.size)
+		if err == nil && !index.HasDigests() {
+			err = errIndexNoDigests
+		}
+		if err != nil {
+			blob.Close()
+			return err
+		}
+		l.blob, l.index = blob, index
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// lazyPrefetch returns the entries of the layers downloaded lazily to
+// extract before registering them, once all the layers are downloaded.
+func (s *TagStore) lazyPrefetch(downloads []downloadInfo) (map[*archive.IndexEntry]bool, error) {
+	view := make(lazyView)
+	for i := len(downloads) - 1; i >= 0; i-- {
+		d := &downloads[i]
+		if d.err != nil {
+			err := <-d.err
+			d.err = nil
+			if err != nil {
+				return nil, err
+			}
+		}
+		if d.lazy != nil {
+			view.add(d.lazy.index, d.lazy.blob)
+		}
+	}
+	tmp, err := s.graph.Mktemp("")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	return view.prefetchFiles(downloads[0].img.Config, tmp), nil
+}
+
+// lazyFetch returns a function downloading the blob with the given digest,
+// to extract the layer registered lazily from it in the background.
+func (s *TagStore) lazyFetch(r *registry.Session, sources []*v2Source, remoteName string, dgst digest.Digest, sf *streamformatter.StreamFormatter) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		var f *os.File
+		s.downloadSlots.acquire()
+		_, err := tryV2Sources(sources, func(src *v2Source) (err error) {
+			f, _, err = s.downloadV2Blob(r, src.endpoint, remoteName, dgst, src.auth, ioutil.Discard, sf, dgst.String())
+			return err
+		})
+		s.downloadSlots.release()
+		if err != nil {
+			return nil, err
+		}
+		return &downloadedBlob{f}, nil
+	}
+}
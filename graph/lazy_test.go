@@ -0,0 +1,367 @@
+package graph
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/utils"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+const testLazyImageID = "f0e1d2c3b4a5968778695a4b3c2d1e0ff0e1d2c3b4a5968778695a4b3c2d1e0f"
+
+// lazyTestLayer returns a layer with a script as entrypoint, run by an
+// interpreter reached through a symlink, and a file it doesn't need.
+func lazyTestLayer(t *testing.T, data string) []byte {
+	uid, gid := os.Getuid(), os.Getgid()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range []struct {
+		hdr     *tar.Header
+		content string
+	}{
+		{&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{&tar.Header{Name: "bin/busybox", Typeflag: tar.TypeReg, Mode: 0755}, "busybox"},
+		{&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"}, ""},
+		{&tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{&tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{&tar.Header{Name: "usr/bin/entrypoint", Typeflag: tar.TypeReg, Mode: 0755}, "#!/bin/sh -e\n"},
+		{&tar.Header{Name: "data", Typeflag: tar.TypeReg, Mode: 0644}, data},
+	} {
+		e.hdr.Uid, e.hdr.Gid, e.hdr.Size = uid, gid, int64(len(e.content))
+		if err := tw.WriteHeader(e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// lazyTestBlob returns layer as the indexed archive pushed for it.
+func lazyTestBlob(t *testing.T, layer []byte) []byte {
+	f, err := ioutil.TempFile("", "docker-test-blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, _, err := bufferIndexedToFile(f, bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func readTestIndex(t *testing.T, blob []byte) *archive.ArchiveIndex {
+	index, err := archive.ReadArchiveIndex(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func TestLazyViewPrefetchFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-test-prefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	blob := lazyTestBlob(t, lazyTestLayer(t, "data"))
+	view := make(lazyView)
+	view.add(readTestIndex(t, blob), bytes.NewReader(blob))
+
+	for _, config := range []*runconfig.Config{
+		{Entrypoint: []string{"entrypoint"}, Cmd: []string{"data"}},
+		{Cmd: []string{"/usr/bin/entrypoint"}},
+		{Cmd: []string{"./entrypoint"}, WorkingDir: "/usr/bin"},
+		{Cmd: []string{"entrypoint"}, Env: []string{"PATH=/bin:/usr/bin"}},
+	} {
+		files := view.prefetchFiles(config, tmp)
+		var names []string
+		for e := range files {
+			names = append(names, e.Name)
+		}
+		if len(names) != 2 || !files[view["/usr/bin/entrypoint"].entry] || !files[view["/bin/busybox"].entry] {
+			t.Fatalf("Expected the entrypoint and its interpreter to be prefetched for %v, got %v", config, names)
+		}
+	}
+	if files := view.prefetchFiles(&runconfig.Config{Cmd: []string{"missing"}}, tmp); len(files) != 0 {
+		t.Fatalf("Expected no file to be prefetched for a missing command, got %d", len(files))
+	}
+}
+
+func TestLazyViewWhiteouts(t *testing.T) {
+	blob := lazyTestBlob(t, lazyTestLayer(t, "data"))
+	view := make(lazyView)
+	view.add(readTestIndex(t, blob), bytes.NewReader(blob))
+	view.add(&archive.ArchiveIndex{Entries: []*archive.IndexEntry{
+		{Name: ".wh.data", Typeflag: tar.TypeReg},
+		{Name: "bin/.wh..wh..opq", Typeflag: tar.TypeReg},
+	}}, nil)
+
+	for _, name := range []string{"/data", "/bin/busybox", "/bin/sh"} {
+		if _, exists := view[name]; exists {
+			t.Fatalf("Expected %s to be removed by the whiteouts", name)
+		}
+	}
+	for _, name := range []string{"/bin", "/usr/bin/entrypoint"} {
+		if _, exists := view[name]; !exists {
+			t.Fatalf("Expected %s to be kept", name)
+		}
+	}
+}
+
+func TestRegisterLazy(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	// A single layer doesn't depend on the driver referencing its parent
+	lazyDrivers[store.graph.driver.String()] = true
+	defer delete(lazyDrivers, store.graph.driver.String())
+
+	layer := lazyTestLayer(t, "data")
+	blob := lazyTestBlob(t, layer)
+	index := readTestIndex(t, blob)
+	fetched := make(chan struct{})
+	img := &image.Image{ID: testLazyImageID}
+	if err := store.graph.RegisterLazy(img, bytes.NewReader(blob), index, func(e *archive.IndexEntry) bool {
+		return e.Name == "usr/bin/entrypoint"
+	}, func() (io.ReadCloser, error) {
+		<-fetched
+		return ioutil.NopCloser(bytes.NewReader(blob)), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The image is registered with the ID of its whole layer
+	dgst, err := image.LayerDigest(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.LayerDigest != dgst {
+		t.Fatalf("Expected layer digest %s, got %s", dgst, img.LayerDigest)
+	}
+	if !img.IsIncomplete(store.graph.ImageRoot(img.ID)) {
+		t.Fatal("Expected the image to be incomplete until its layer is extracted")
+	}
+	dir, err := store.graph.driver.Get(img.LayerID(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.graph.driver.Put(img.LayerID())
+	if _, err := os.Stat(filepath.Join(dir, "usr/bin/entrypoint")); err != nil {
+		t.Fatalf("Expected the prefetched file to be extracted: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the other files not to be extracted yet, got %v", err)
+	}
+
+	close(fetched)
+	if err := store.graph.WaitLayers(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "data")); err != nil || string(content) != "data" {
+		t.Fatalf("Expected the whole layer to be extracted, got %q, %v", content, err)
+	}
+	if img.IsIncomplete(store.graph.ImageRoot(img.ID)) {
+		t.Fatal("Expected the image to be complete once its layer is extracted")
+	}
+}
+
+func TestRegisterLazyVerifiesLayer(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	lazyDrivers[store.graph.driver.String()] = true
+	defer delete(lazyDrivers, store.graph.driver.String())
+
+	blob := lazyTestBlob(t, lazyTestLayer(t, "data"))
+	tampered := lazyTestBlob(t, lazyTestLayer(t, "tampered"))
+	fetched := make(chan struct{})
+	img := &image.Image{ID: testLazyImageID}
+	if err := store.graph.RegisterLazy(img, bytes.NewReader(blob), readTestIndex(t, blob), func(*archive.IndexEntry) bool {
+		return false
+	}, func() (io.ReadCloser, error) {
+		<-fetched
+		return ioutil.NopCloser(bytes.NewReader(tampered)), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("foo", "latest", img.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	close(fetched)
+	if err := store.graph.WaitLayers(img.ID); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Fatalf("Expected the verification of the layer to fail, got %v", err)
+	}
+	if !img.IsIncomplete(store.graph.ImageRoot(img.ID)) {
+		t.Fatal("Expected the image to remain incomplete")
+	}
+
+	// The image is untagged and can't be tagged or used anymore
+	for i := 0; ; i++ {
+		if found, _ := store.LookupImage("foo:latest"); found == nil {
+			break
+		} else if i == 100 {
+			t.Fatal("Expected foo:latest to be untagged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := store.graph.LayerError(img.ID); err == nil {
+		t.Fatal("Expected the failed extraction to be reported")
+	}
+	if err := store.Set("foo", "latest", img.ID, false); err == nil {
+		t.Fatal("Expected tagging the image to fail")
+	}
+	if err := store.graph.Delete(img.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterLazyVerifiesPrefetchedFiles(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	lazyDrivers[store.graph.driver.String()] = true
+	defer delete(lazyDrivers, store.graph.driver.String())
+
+	blob := lazyTestBlob(t, lazyTestLayer(t, "data"))
+	index := readTestIndex(t, blob)
+	// The registry serves an entrypoint which doesn't match the index
+	for _, e := range index.Entries {
+		if e.Name == "usr/bin/entrypoint" {
+			e.Digest = "sha256:" + strings.Repeat("0", 64)
+		}
+	}
+	img := &image.Image{ID: testLazyImageID}
+	if err := store.graph.RegisterLazy(img, bytes.NewReader(blob), index, func(e *archive.IndexEntry) bool {
+		return e.Name == "usr/bin/entrypoint"
+	}, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(blob)), nil
+	}); err == nil || !strings.Contains(err.Error(), "doesn't match the archive index") {
+		t.Fatalf("Expected the prefetched file to fail verification, got %v", err)
+	}
+	if store.graph.Exists(img.ID) {
+		t.Fatal("Expected the image not to be registered")
+	}
+}
+
+func TestDeleteCancelsLazyLayer(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	lazyDrivers[store.graph.driver.String()] = true
+	defer delete(lazyDrivers, store.graph.driver.String())
+
+	blob := lazyTestBlob(t, lazyTestLayer(t, "data"))
+	fetched := make(chan struct{})
+	defer close(fetched)
+	img := &image.Image{ID: testLazyImageID}
+	if err := store.graph.RegisterLazy(img, bytes.NewReader(blob), readTestIndex(t, blob), func(*archive.IndexEntry) bool {
+		return false
+	}, func() (io.ReadCloser, error) {
+		// The fetch hangs until the end of the test
+		<-fetched
+		return ioutil.NopCloser(bytes.NewReader(blob)), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := make(chan error)
+	go func() {
+		deleted <- store.graph.Delete(img.ID)
+	}()
+	select {
+	case err := <-deleted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected removing the image not to wait for its layer")
+	}
+	if store.graph.Exists(img.ID) {
+		t.Fatal("Expected the image to be removed")
+	}
+}
+
+func TestOpenLazyDownload(t *testing.T) {
+	blob := lazyTestBlob(t, lazyTestLayer(t, "data"))
+	d := newDownloadTest(t, &flakyBlobServer{blob: blob, supportsRange: true})
+	defer d.Close()
+	sources := []*v2Source{{endpoint: d.endpoint, auth: d.auth}}
+	l, err := d.store.openLazyDownload(d.session, sources, "foo/bar", d.dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.blob.Close()
+
+	expected := readTestIndex(t, blob)
+	if l.index.Digest != expected.Digest || len(l.index.Entries) != len(expected.Entries) {
+		t.Fatalf("Expected the index of the blob, got %d entries with digest %s", len(l.index.Entries), l.index.Digest)
+	}
+	for _, e := range l.index.Entries {
+		if e.Name != "data" {
+			continue
+		}
+		content, err := e.Open(l.blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf, err := ioutil.ReadAll(content); err != nil || string(buf) != "data" {
+			t.Fatalf("Expected to read data remotely, got %q, %v", buf, err)
+		}
+	}
+	// The whole blob is never requested
+	for _, rng := range d.server.ranges[1:] {
+		if !strings.HasPrefix(rng, "bytes=") {
+			t.Fatalf("Expected Range requests, got %q", rng)
+		}
+	}
+}
+
+func TestOpenLazyDownloadFallsBack(t *testing.T) {
+	for _, server := range []*flakyBlobServer{
+		{blob: lazyTestBlob(t, lazyTestLayer(t, "data"))},
+		{blob: testBlob(t), supportsRange: true},
+	} {
+		d := newDownloadTest(t, server)
+		sources := []*v2Source{{endpoint: d.endpoint, auth: d.auth}}
+		if _, err := d.store.openLazyDownload(d.session, sources, "foo/bar", d.dgst); err == nil {
+			t.Fatal("Expected the blob not to be downloaded lazily")
+		}
+		d.Close()
+	}
+}
@@ -4,6 +4,7 @@ package graph
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -13,11 +14,13 @@ import (
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/utils"
 )
 
 // Loads a set of images into the repository. This is the complementary of ImageExport.
-// The input stream is an uncompressed tar ball containing images and metadata.
+// The input stream is an uncompressed tar ball containing images and metadata,
+// in the legacy layout or in an OCI-style image layout.
 func (s *TagStore) CmdLoad(job *engine.Job) error {
 	tmpImageDir, err := ioutil.TempDir("", "docker-import-")
 	if err != nil {
@@ -46,11 +49,19 @@ func (s *TagStore) CmdLoad(job *engine.Job) error {
 		return err
 	}
 
+	if isOCILayout(repoDir) {
+		return s.loadOCI(repoDir, job.Stdout)
+	}
+
 	dirs, err := ioutil.ReadDir(repoDir)
 	if err != nil {
 		return err
 	}
 
+	if err := s.checkParents(repoDir, dirs); err != nil {
+		return err
+	}
+
 	for _, d := range dirs {
 		if d.IsDir() {
 			if err := s.recursiveLoad(job.Eng, d.Name(), tmpImageDir); err != nil {
@@ -67,8 +78,14 @@ func (s *TagStore) CmdLoad(job *engine.Job) error {
 		}
 
 		for imageName, tagMap := range repositories {
-			for tag, address := range tagMap {
-				if err := s.SetLoad(imageName, tag, address, true, job.Stdout); err != nil {
+			for ref, address := range tagMap {
+				if utils.DigestReference(ref) {
+					if err := s.SetDigest(imageName, ref, address); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := s.SetLoad(imageName, ref, address, true, job.Stdout); err != nil {
 					return err
 				}
 			}
@@ -80,6 +97,35 @@ func (s *TagStore) CmdLoad(job *engine.Job) error {
 	return nil
 }
 
+// checkParents checks that the parents of the images in the directories
+// dirs of repoDir are in repoDir or loaded already, as the parents whose
+// layers were excluded from the archive must be loaded first.
+func (s *TagStore) checkParents(repoDir string, dirs []os.FileInfo) error {
+	inArchive := make(map[string]bool)
+	for _, d := range dirs {
+		if d.IsDir() {
+			inArchive[d.Name()] = true
+		}
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		imageJson, err := ioutil.ReadFile(path.Join(repoDir, d.Name(), "json"))
+		if err != nil {
+			return err
+		}
+		img, err := image.NewImgJSON(imageJson)
+		if err != nil {
+			return err
+		}
+		if img.Parent != "" && !inArchive[img.Parent] && !s.graph.Exists(img.Parent) {
+			return fmt.Errorf("The parent %s of image %s is missing, load the image its layers were excluded from first", stringid.TruncateID(img.Parent), stringid.TruncateID(img.ID))
+		}
+	}
+	return nil
+}
+
 func (s *TagStore) recursiveLoad(eng *engine.Engine, address, tmpImageDir string) error {
 	if err := eng.Job("image_get", address).Run(); err != nil {
 		logrus.Debugf("Loading %s", address)
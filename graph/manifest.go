@@ -98,6 +98,34 @@ func (s *TagStore) loadManifest(eng *engine.Engine, manifestBytes []byte, dgst,
 	return &manifest, verified, nil
 }
 
+// untrustedImageError is returned for images refused by the trust policy.
+type untrustedImageError struct {
+	error
+}
+
+// checkTrustPolicy checks the signed manifest of an image of the repository
+// against the trust policy of the daemon. A nil manifest stands for an image
+// without signatures, e.g. one from a v1 registry. The manifest must be
+// signed for the pulled tag, unless it is pulled by digest.
+func (s *TagStore) checkTrustPolicy(eng *engine.Engine, repoInfo *registry.RepositoryInfo, tag string, manifest []byte) error {
+	repoName := repoInfo.LocalName
+	job := eng.Job("trust_policy_check", repoName)
+	job.Setenv("RemoteName", repoInfo.RemoteName)
+	if !utils.DigestReference(tag) {
+		job.Setenv("Tag", tag)
+	}
+	job.Setenv("Manifest", string(manifest))
+	stdoutBuffer := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuffer)
+	if err := job.Run(); err != nil {
+		return untrustedImageError{fmt.Errorf("Refusing image of %s: %s", repoName, err)}
+	}
+	if keyID := engine.Tail(stdoutBuffer, 1); keyID != "" {
+		logrus.Infof("Image manifest for %s is signed by trusted key %s", repoName, keyID)
+	}
+	return nil
+}
+
 func checkValidManifest(manifest *registry.ManifestData) error {
 	if len(manifest.FSLayers) != len(manifest.History) {
 		return fmt.Errorf("length of history not equal to number of layers")
@@ -0,0 +1,216 @@
+package graph
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/truncindex"
+)
+
+// legacyIDsFile is the file in the graph root which maps the IDs images were
+// registered under before image IDs were derived from their content to their
+// content-addressable IDs.
+const legacyIDsFile = "legacy-ids.json"
+
+func (graph *Graph) loadLegacyIDs() error {
+	buf, err := ioutil.ReadFile(path.Join(graph.Root, legacyIDsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(buf, &graph.legacyIDs); err != nil {
+			return err
+		}
+	}
+	ids := make([]string, 0, len(graph.legacyIDs))
+	for id := range graph.legacyIDs {
+		ids = append(ids, id)
+	}
+	graph.legacyIndex = truncindex.NewTruncIndex(ids)
+	return nil
+}
+
+// saveLegacyIDs must be called with the graph locked.
+func (graph *Graph) saveLegacyIDs() error {
+	buf, err := json.Marshal(graph.legacyIDs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(graph.Root, legacyIDsFile), buf, 0600)
+}
+
+// addLegacyID makes legacyID a reference to the image with the given
+// content-addressable ID.
+func (graph *Graph) addLegacyID(legacyID, id string) error {
+	graph.Lock()
+	defer graph.Unlock()
+	graph.legacyIDs[legacyID] = id
+	graph.legacyIndex.Add(legacyID)
+	return graph.saveLegacyIDs()
+}
+
+// removeLegacyIDs removes all the legacy references to the image with the
+// given content-addressable ID.
+func (graph *Graph) removeLegacyIDs(id string) error {
+	graph.Lock()
+	defer graph.Unlock()
+	var removed bool
+	for legacyID, contentID := range graph.legacyIDs {
+		if contentID == id {
+			delete(graph.legacyIDs, legacyID)
+			graph.legacyIndex.Delete(legacyID)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return graph.saveLegacyIDs()
+}
+
+// resolveLegacyID returns the content-addressable ID of the image registered
+// under the given legacy ID or ID prefix.
+func (graph *Graph) resolveLegacyID(name string) (string, bool) {
+	legacyID, err := graph.legacyIndex.Get(name)
+	if err != nil {
+		return "", false
+	}
+	graph.Lock()
+	defer graph.Unlock()
+	id, exists := graph.legacyIDs[legacyID]
+	return id, exists
+}
+
+// migrateLegacyImages converts images registered before image IDs were
+// derived from their content to content-addressable IDs. Their filesystem
+// layers stay where they are in the storage driver and their old IDs remain
+// valid references to the migrated images.
+func (graph *Graph) migrateLegacyImages(images []*image.Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+	logrus.Infof("Migrating %d images to content-addressable IDs", len(images))
+
+	var (
+		legacy   = make(map[string]*image.Image)
+		migrated = make(map[string]*image.Image)
+		migrate  func(legacyID string) (*image.Image, error)
+	)
+	for _, img := range images {
+		legacy[img.ID] = img
+	}
+	migrate = func(legacyID string) (*image.Image, error) {
+		if img, exists := migrated[legacyID]; exists {
+			return img, nil
+		}
+		var (
+			img    = legacy[legacyID]
+			parent *image.Image
+			err    error
+		)
+		if _, exists := legacy[img.Parent]; exists {
+			parent, err = migrate(img.Parent)
+		} else if img.Parent != "" {
+			parent, err = graph.Get(img.Parent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := graph.migrateImage(img, parent); err != nil {
+			return nil, err
+		}
+		migrated[legacyID] = img
+		return img, nil
+	}
+
+	for legacyID := range legacy {
+		if _, err := migrate(legacyID); err != nil {
+			logrus.Errorf("Failed to migrate image %s: %s", legacyID, err)
+		}
+	}
+	return nil
+}
+
+// migrateImage moves the legacy image img, whose parent has already been
+// migrated, to its content-addressable ID.
+func (graph *Graph) migrateImage(img, parent *image.Image) error {
+	var (
+		legacyID      = img.ID
+		legacyRoot    = graph.ImageRoot(legacyID)
+		parentLayerID string
+	)
+	img.SetLayerID(img.LayerID())
+	if parent != nil {
+		img.Parent = parent.ID
+		parentLayerID = parent.LayerID()
+	}
+
+	layer, err := graph.driver.Diff(img.LayerID(), parentLayerID)
+	if err != nil {
+		return err
+	}
+	img.LayerDigest, err = image.LayerDigest(layer)
+	layer.Close()
+	if err != nil {
+		return err
+	}
+	if img.ID, err = image.ComputeID(img); err != nil {
+		return err
+	}
+	logrus.Debugf("Migrating image %s to %s", legacyID, img.ID)
+
+	if graph.Exists(img.ID) {
+		// The same content was registered twice under different IDs. The
+		// duplicate layer is left in the driver since containers and other
+		// layers may be based on it.
+		logrus.Debugf("Image %s is already in the graph, keeping layer %s", img.ID, img.LayerID())
+	} else {
+		tmp, err := graph.Mktemp("")
+		defer os.RemoveAll(tmp)
+		if err != nil {
+			return err
+		}
+		if err := img.SaveMetadata(tmp); err != nil {
+			return err
+		}
+		if checksum, err := img.GetCheckSum(legacyRoot); err != nil {
+			return err
+		} else if checksum != "" {
+			if err := img.SaveCheckSum(tmp, checksum); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(tmp, graph.ImageRoot(img.ID)); err != nil {
+			return err
+		}
+		graph.idIndex.Add(img.ID)
+	}
+
+	if err := graph.addLegacyID(legacyID, img.ID); err != nil {
+		return err
+	}
+	graph.idIndex.Delete(legacyID)
+	return os.RemoveAll(legacyRoot)
+}
+
+// resolveLegacyIDs updates the references to images which have been migrated
+// to content-addressable IDs.
+func (store *TagStore) resolveLegacyIDs() error {
+	var updated bool
+	for _, repo := range store.Repositories {
+		for ref, id := range repo {
+			if img, err := store.graph.Get(id); err == nil && img.ID != id {
+				repo[ref] = img.ID
+				updated = true
+			}
+		}
+	}
+	if !updated {
+		return nil
+	}
+	return store.save()
+}
@@ -0,0 +1,208 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/daemon/events"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/utils"
+)
+
+const testLegacyImageID = "7e0ac1e8cfbc2ad1c2d8e7b2d55b32aa5ab39d46ddd5a0a5e5fda0d5eb3e5fc1"
+
+func TestRegisterContentAddressable(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	img, err := store.graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.LayerDigest == "" {
+		t.Fatal("Expected the layer digest to be recorded")
+	}
+	if id, err := image.ComputeID(img); err != nil {
+		t.Fatal(err)
+	} else if img.ID != id {
+		t.Fatalf("Expected image ID %s, got %s", id, img.ID)
+	}
+
+	// The same content and config registered under another ID is stored once
+	archive, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dup := &image.Image{ID: testLegacyImageID, Comment: testOfficialImageName}
+	if err := store.graph.Register(dup, archive); err != nil {
+		t.Fatal(err)
+	}
+	if dup.ID != img.ID {
+		t.Fatalf("Expected image ID %s, got %s", img.ID, dup.ID)
+	}
+	if dup.LayerID() != img.LayerID() {
+		t.Fatalf("Expected layer %s to be reused, got %s", img.LayerID(), dup.LayerID())
+	}
+	if images, err := store.graph.Map(); err != nil {
+		t.Fatal(err)
+	} else if len(images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(images))
+	}
+	if resolved, err := store.graph.Get(testLegacyImageID); err != nil {
+		t.Fatal(err)
+	} else if resolved.ID != img.ID {
+		t.Fatalf("Expected %s to resolve to %s, got %s", testLegacyImageID, img.ID, resolved.ID)
+	}
+
+	if err := store.graph.Delete(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if store.graph.Exists(testLegacyImageID) || store.graph.Exists(testOfficialImageID) {
+		t.Fatal("Expected the legacy IDs to be removed with the image")
+	}
+}
+
+func TestRegisterConcurrently(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	// Images with the same content and config get the same ID
+	const count = 8
+	var (
+		wg     sync.WaitGroup
+		images [count]*image.Image
+		errs   [count]error
+	)
+	for i := range images {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			archive, err := fakeTar()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			images[i] = &image.Image{Comment: "concurrent"}
+			errs[i] = store.graph.Register(images[i], archive)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, img := range images {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if img.ID != images[0].ID {
+			t.Fatalf("Expected image ID %s, got %s", images[0].ID, img.ID)
+		}
+	}
+	img, err := store.graph.Get(images[0].ID)
+	if err != nil {
+		t.Fatalf("Expected the image to be registered: %s", err)
+	}
+	if _, err := os.Stat(path.Join(store.graph.ImageRoot(img.ID), "json")); err != nil {
+		t.Fatalf("Expected the image root to be kept: %s", err)
+	}
+}
+
+func TestMigrateLegacyImages(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	driver, err := graphdriver.New(tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Cleanup()
+
+	// Store an image the way it was stored before image IDs were derived
+	// from their content: under a random ID, in a layer of the same ID.
+	root := path.Join(tmp, "graph")
+	legacyRoot := path.Join(root, testLegacyImageID)
+	if err := os.MkdirAll(legacyRoot, 0700); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Create(testLegacyImageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.ApplyDiff(testLegacyImageID, "", archive); err != nil {
+		t.Fatal(err)
+	}
+	legacy := &image.Image{ID: testLegacyImageID, Comment: "legacy"}
+	if err := legacy.SaveMetadata(legacyRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "tags"), []byte(`{"Repositories":{"legacy":{"latest":"`+testLegacyImageID+`"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := NewGraph(root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := graph.Get(testLegacyImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := image.ComputeID(img); err != nil {
+		t.Fatal(err)
+	} else if img.ID != id {
+		t.Fatalf("Expected image to be migrated to %s, got %s", id, img.ID)
+	}
+	// Exporting the layer again, e.g. with docker save, yields the same digest
+	layer, err := img.TarLayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layer.Close()
+	if dgst, err := image.LayerDigest(layer); err != nil {
+		t.Fatal(err)
+	} else if img.LayerDigest != dgst {
+		t.Fatalf("Expected layer digest %s, got %s", dgst, img.LayerDigest)
+	}
+	if img.LayerID() != testLegacyImageID {
+		t.Fatalf("Expected the layer to stay at %s, got %s", testLegacyImageID, img.LayerID())
+	}
+	if _, err := os.Stat(legacyRoot); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed", legacyRoot)
+	}
+
+	store, err := NewTagStore(path.Join(tmp, "tags"), graph, nil, nil, events.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := store.Repositories["legacy"]["latest"]; id != img.ID {
+		t.Fatalf("Expected tag to refer to %s, got %s", img.ID, id)
+	}
+
+	// Migrating is done once
+	graph, err = NewGraph(root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated, err := graph.Get(testLegacyImageID); err != nil {
+		t.Fatal(err)
+	} else if migrated.ID != img.ID {
+		t.Fatalf("Expected %s, got %s", img.ID, migrated.ID)
+	}
+}
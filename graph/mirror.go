@@ -0,0 +1,80 @@
+package graph
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/registry"
+)
+
+// v2Source is a v2 registry endpoint a repository can be pulled from, either
+// a mirror of the registry of the repository or the registry itself.
+type v2Source struct {
+	endpoint *registry.Endpoint
+	auth     *registry.RequestAuthorization
+	mirror   bool
+}
+
+func (src *v2Source) String() string {
+	return src.endpoint.URL.Host
+}
+
+// v2Sources returns the mirrors of the registry of the repository which
+// support the v2 API, in the configured order, followed by the registry
+// itself if it is reachable.
+func (s *TagStore) v2Sources(r *registry.Session, repoInfo *registry.RepositoryInfo) ([]*v2Source, error) {
+	var sources []*v2Source
+	for _, mirror := range repoInfo.Index.Mirrors {
+		endpoint, err := registry.NewMirrorEndpoint(mirror)
+		if err != nil {
+			logrus.Debugf("Skipping mirror %s of %s: %s", mirror, repoInfo.Index.Name, err)
+			continue
+		}
+		// Mirrors are pulled from anonymously, the credentials are those
+		// of the registry.
+		auth := r.GetV2MirrorAuthorization(endpoint, repoInfo.RemoteName)
+		sources = append(sources, &v2Source{endpoint: endpoint, auth: auth, mirror: true})
+	}
+	if len(repoInfo.Index.Mirrors) > 0 && len(sources) == 0 {
+		logrus.Debugf("No mirror of %s supports the V2 registry API, falling back to v1", repoInfo.Index.Name)
+		return nil, ErrV2RegistryUnavailable
+	}
+
+	endpoint, err := r.V2RegistryEndpoint(repoInfo.Index)
+	if err != nil {
+		if len(sources) > 0 {
+			logrus.Debugf("Unable to reach V2 registry %s, pulling from its mirrors only: %s", repoInfo.Index.Name, err)
+			return sources, nil
+		}
+		if repoInfo.Index.Official {
+			logrus.Debugf("Unable to pull from V2 registry, falling back to v1: %s", err)
+			return nil, ErrV2RegistryUnavailable
+		}
+		return nil, fmt.Errorf("error getting registry endpoint: %s", err)
+	}
+	auth, err := r.GetV2Authorization(endpoint, repoInfo.RemoteName, true)
+	if err != nil {
+		if len(sources) > 0 {
+			logrus.Debugf("Unable to authorize with V2 registry %s, pulling from its mirrors only: %s", repoInfo.Index.Name, err)
+			return sources, nil
+		}
+		return nil, fmt.Errorf("error getting authorization: %s", err)
+	}
+	return append(sources, &v2Source{endpoint: endpoint, auth: auth}), nil
+}
+
+// tryV2Sources calls f with each source in order until it succeeds. It
+// returns the source f succeeded with, or the error of the last source.
+func tryV2Sources(sources []*v2Source, f func(*v2Source) error) (*v2Source, error) {
+	var err error
+	for _, src := range sources {
+		if err = f(src); err == nil {
+			return src, nil
+		}
+		if src.mirror {
+			// Don't report errors when pulling from mirrors.
+			logrus.Debugf("Error pulling from mirror %s, falling back to the next endpoint: %s", src, err)
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,152 @@
+package graph
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/requestdecorator"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// blobRegistry is a v2 registry serving the given blobs, or a v1 only
+// registry if v1 is set.
+type blobRegistry struct {
+	v1       bool
+	blobs    map[string][]byte
+	requests int
+	// authorized is the number of requests sent with credentials
+	authorized int
+}
+
+func (s *blobRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.v1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.requests++
+	if r.Header.Get("Authorization") != "" {
+		s.authorized++
+	}
+	i := strings.LastIndex(r.URL.Path, "/blobs/")
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	blob, ok := s.blobs[r.URL.Path[i+len("/blobs/"):]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+}
+
+func TestPullV2BlobFromMirrors(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	defer func(delay time.Duration) { transferRetryDelay = delay }(transferRetryDelay)
+	transferRetryDelay = time.Millisecond
+
+	mirrored, missing := testBlob(t), testBlob(t)
+	mirroredDigest, err := digest.FromBytes(mirrored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingDigest, err := digest.FromBytes(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1Mirror := httptest.NewServer(&blobRegistry{v1: true})
+	defer v1Mirror.Close()
+	mirror := &blobRegistry{blobs: map[string][]byte{mirroredDigest.String(): mirrored}}
+	mirrorServer := httptest.NewServer(mirror)
+	defer mirrorServer.Close()
+	upstream := &blobRegistry{blobs: map[string][]byte{mirroredDigest.String(): mirrored, missingDigest.String(): missing}}
+	upstreamServer := httptest.NewServer(upstream)
+	defer upstreamServer.Close()
+
+	u, err := url.Parse(upstreamServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoInfo := &registry.RepositoryInfo{
+		Index: &registry.IndexInfo{
+			Name:    u.Host,
+			Mirrors: []string{v1Mirror.URL + "/v1/", mirrorServer.URL + "/v1/"},
+		},
+		RemoteName: "foo/bar",
+	}
+	endpoint, err := repoInfo.GetEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := &registry.AuthConfig{Username: "user", Password: "secret"}
+	session, err := registry.NewSession(authConfig, requestdecorator.NewRequestFactory(), endpoint, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := store.v2Sources(session, repoInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 || !sources[0].mirror || sources[0].endpoint.URL.Host != strings.TrimPrefix(mirrorServer.URL, "http://") || sources[1].mirror {
+		t.Fatalf("Expected the v2 mirror followed by upstream, got %v", sources)
+	}
+
+	for _, test := range []struct {
+		dgst     digest.Digest
+		blob     []byte
+		expected *v2Source
+	}{
+		{mirroredDigest, mirrored, sources[0]},
+		{missingDigest, missing, sources[1]},
+	} {
+		var f *os.File
+		src, err := tryV2Sources(sources, func(src *v2Source) (err error) {
+			f, _, err = store.downloadV2Blob(session, src.endpoint, repoInfo.RemoteName, test.dgst, src.auth, ioutil.Discard, streamformatter.NewStreamFormatter(false), "blob")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(blob, test.blob) {
+			t.Fatal("Downloaded blob differs from the served blob")
+		}
+		if src != test.expected {
+			t.Fatalf("Expected %s to be pulled from %s, got %s", test.dgst, test.expected, src)
+		}
+	}
+	if mirror.requests != 2 || upstream.requests != 1 {
+		t.Fatalf("Expected 2 requests to the mirror and 1 upstream, got %d and %d", mirror.requests, upstream.requests)
+	}
+	// The credentials of the registry are never sent to its mirrors
+	if mirror.authorized != 0 || upstream.authorized != 1 {
+		t.Fatalf("Expected credentials to be sent upstream only, got %d requests with credentials to the mirror and %d upstream", mirror.authorized, upstream.authorized)
+	}
+}
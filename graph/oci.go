@@ -0,0 +1,486 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/utils"
+)
+
+// Images are saved either in the legacy layout, with a directory per image,
+// or in an OCI-style image layout, in which every file but the index is a
+// blob named by its digest, so that it can be verified offline and pushed
+// as is to a v2 registry:
+//
+//	oci-layout            the version of the layout
+//	index.json            the manifests of the saved images and their tags
+//	blobs/sha256/<hex>    the manifests, the configs and the layers
+//
+// The manifest of an image lists the layers of the image and of its
+// parents, base first, as gzip compressed tar archives, and references the
+// config of the image, its JSON. Each layer references the config of the
+// image it belongs to in an annotation, so that its parents can be
+// registered too. The layers excluded from the layout, which the receiver
+// already has, are left out of the manifests, whose first layer then
+// references the config of an image whose parent isn't in the layout.
+
+const (
+	ociLayoutFile         = "oci-layout"
+	ociLayoutVersion      = "1.0.0"
+	ociIndexFile          = "index.json"
+	ociManifestMediaType  = "application/vnd.oci.image.manifest.v1+json"
+	ociLayerMediaType     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	imageConfigMediaType  = "application/vnd.docker.container.image.v1+json"
+	refNameAnnotation     = "org.opencontainers.image.ref.name"
+	imageConfigAnnotation = "com.docker.image.v1.config"
+)
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// ociDescriptor describes a blob of an OCI-style image layout.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+func ociBlobPath(dir string, dgst digest.Digest) string {
+	return filepath.Join(dir, "blobs", dgst.Algorithm(), dgst.Hex())
+}
+
+// ociExporter saves images in an OCI-style image layout.
+type ociExporter struct {
+	graph *Graph
+	dir   string
+	index ociIndex
+	// layers are the descriptors of the layers already written, by image ID
+	layers map[string]ociDescriptor
+	// excluded are the IDs of the images whose layers aren't written
+	excluded map[string]bool
+}
+
+func newOCIExporter(graph *Graph, dir string, excluded map[string]bool) (*ociExporter, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return nil, err
+	}
+	return &ociExporter{
+		graph:    graph,
+		dir:      dir,
+		index:    ociIndex{SchemaVersion: 2},
+		layers:   make(map[string]ociDescriptor),
+		excluded: excluded,
+	}, nil
+}
+
+// exportImages adds the manifests of the tagged images of repos, and of
+// the images with the given IDs which aren't tagged, to the index.
+func (e *ociExporter) exportImages(repos map[string]Repository, ids map[string]bool) error {
+	tagged := make(map[string]bool)
+	names := make([]string, 0, len(repos))
+	for name := range repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		refs := make([]string, 0, len(repos[name]))
+		for ref := range repos[name] {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			id := repos[name][ref]
+			if err := e.exportImage(id, utils.ImageReference(name, ref)); err != nil {
+				return err
+			}
+			tagged[id] = true
+		}
+	}
+
+	untagged := make([]string, 0, len(ids))
+	for id := range ids {
+		untagged = append(untagged, id)
+	}
+	sort.Strings(untagged)
+	for _, id := range untagged {
+		img, err := e.graph.Get(id)
+		if err != nil {
+			return err
+		}
+		if !tagged[img.ID] {
+			if err := e.exportImage(img.ID, ""); err != nil {
+				return err
+			}
+			tagged[img.ID] = true
+		}
+	}
+	return nil
+}
+
+// exportImage adds the manifest of the image with the given ID to the
+// index, with the reference ref if not empty.
+func (e *ociExporter) exportImage(id, ref string) error {
+	img, err := e.graph.Get(id)
+	if err != nil {
+		return err
+	}
+	images, err := img.History()
+	if err != nil {
+		return err
+	}
+	manifest := ociManifest{SchemaVersion: 2, MediaType: ociManifestMediaType, Layers: []ociDescriptor{}}
+	for i := len(images) - 1; i >= 0; i-- {
+		if e.excluded[images[i].ID] {
+			continue
+		}
+		layer, err := e.exportLayer(images[i])
+		if err != nil {
+			return err
+		}
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+	if manifest.Config, err = e.exportConfig(img); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(&manifest)
+	if err != nil {
+		return err
+	}
+	desc, err := e.writeBlob(ociManifestMediaType, buf)
+	if err != nil {
+		return err
+	}
+	if ref != "" {
+		desc.Annotations = map[string]string{refNameAnnotation: ref}
+	}
+	e.index.Manifests = append(e.index.Manifests, desc)
+	return nil
+}
+
+// exportLayer writes the config and the layer of img, unless they are
+// already written, and returns the descriptor of the layer.
+func (e *ociExporter) exportLayer(img *image.Image) (ociDescriptor, error) {
+	if layer, exists := e.layers[img.ID]; exists {
+		return layer, nil
+	}
+	configDesc, err := e.exportConfig(img)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	if err := e.graph.waitLayer(img.ID); err != nil {
+		return ociDescriptor{}, err
+	}
+	arch, err := img.TarLayer()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer arch.Close()
+	f, err := ioutil.TempFile(e.dir, "layer-")
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, dgst, err := bufferToFile(f, arch)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	if err := os.Rename(f.Name(), ociBlobPath(e.dir, dgst)); err != nil {
+		return ociDescriptor{}, err
+	}
+
+	layer := ociDescriptor{
+		MediaType:   ociLayerMediaType,
+		Digest:      dgst,
+		Size:        size,
+		Annotations: map[string]string{imageConfigAnnotation: configDesc.Digest.String()},
+	}
+	e.layers[img.ID] = layer
+	return layer, nil
+}
+
+// exportConfig writes the config of img and returns its descriptor.
+func (e *ociExporter) exportConfig(img *image.Image) (ociDescriptor, error) {
+	config, err := img.RawJson()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return e.writeBlob(imageConfigMediaType, config)
+}
+
+func (e *ociExporter) writeBlob(mediaType string, content []byte) (ociDescriptor, error) {
+	dgst, err := digest.FromBytes(content)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	if err := ioutil.WriteFile(ociBlobPath(e.dir, dgst), content, 0644); err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}, nil
+}
+
+// Close writes the index and the version of the layout.
+func (e *ociExporter) Close() error {
+	buf, err := json.Marshal(&e.index)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(e.dir, ociIndexFile), buf, 0644); err != nil {
+		return err
+	}
+	buf, err = json.Marshal(&ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(e.dir, ociLayoutFile), buf, 0644)
+}
+
+// isOCILayout returns whether dir is an OCI-style image layout.
+func isOCILayout(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ociLayoutFile))
+	return err == nil
+}
+
+// openOCIBlob opens the blob with the given digest of the image layout in
+// dir, verifying its digest, and its size unless size is negative.
+func openOCIBlob(dir string, dgst digest.Digest, size int64) (*os.File, error) {
+	if err := dgst.Validate(); err != nil || dgst.Algorithm() != "sha256" {
+		return nil, fmt.Errorf("Invalid blob digest %q", dgst)
+	}
+	f, err := os.Open(ociBlobPath(dir, dgst))
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := digest.NewDigestVerifier(dgst)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	n, err := io.Copy(verifier, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !verifier.Verified() || (size >= 0 && n != size) {
+		f.Close()
+		return nil, fmt.Errorf("Blob %s failed verification", dgst)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// readOCIBlobJSON decodes the JSON blob with the given digest of the image
+// layout in dir into v, verifying it.
+func readOCIBlobJSON(dir string, dgst digest.Digest, size int64, v interface{}) error {
+	f, err := openOCIBlob(dir, dgst, size)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("Error decoding blob %s: %s", dgst, err)
+	}
+	return nil
+}
+
+// loadOCI loads the images of the OCI-style image layout in dir, and tags
+// them with the references of their manifests.
+func (s *TagStore) loadOCI(dir string, out io.Writer) error {
+	var layout ociLayout
+	buf, err := ioutil.ReadFile(filepath.Join(dir, ociLayoutFile))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, &layout); err != nil {
+		return err
+	}
+	if layout.ImageLayoutVersion != ociLayoutVersion {
+		return fmt.Errorf("Unsupported image layout version %q", layout.ImageLayoutVersion)
+	}
+	var index ociIndex
+	if buf, err = ioutil.ReadFile(filepath.Join(dir, ociIndexFile)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, &index); err != nil {
+		return err
+	}
+
+	// Verify the manifests and read the configs of their layers before
+	// loading anything, so that no image is loaded if a parent is missing
+	manifests := make([]*ociManifest, len(index.Manifests))
+	images := make([][]*image.Image, len(index.Manifests))
+	inLayout := make(map[string]bool)
+	for i, desc := range index.Manifests {
+		if desc.MediaType != ociManifestMediaType {
+			return fmt.Errorf("Unsupported manifest media type %s", desc.MediaType)
+		}
+		manifests[i] = &ociManifest{}
+		if err := readOCIBlobJSON(dir, desc.Digest, desc.Size, manifests[i]); err != nil {
+			return err
+		}
+		if images[i], err = readOCIManifestImages(dir, manifests[i]); err != nil {
+			return err
+		}
+		for _, img := range images[i][:len(images[i])-1] {
+			inLayout[img.ID] = true
+		}
+	}
+	for i := range manifests {
+		// The images whose layers were excluded from the layout must have
+		// been loaded already
+		first := images[i][0]
+		if len(manifests[i].Layers) == 0 && !s.graph.Exists(first.ID) {
+			return fmt.Errorf("Image %s is missing, load the image its layers were excluded from first", stringid.TruncateID(first.ID))
+		}
+		if len(manifests[i].Layers) > 0 && first.Parent != "" && !inLayout[first.Parent] && !s.graph.Exists(first.Parent) {
+			return fmt.Errorf("The parent %s of image %s is missing, load the image its layers were excluded from first", stringid.TruncateID(first.Parent), stringid.TruncateID(first.ID))
+		}
+	}
+
+	for i, desc := range index.Manifests {
+		id, err := s.loadOCIManifest(dir, manifests[i], images[i])
+		if err != nil {
+			return err
+		}
+
+		ref := desc.Annotations[refNameAnnotation]
+		if ref == "" {
+			continue
+		}
+		repoName, tag := parsers.ParseRepositoryTag(ref)
+		if utils.DigestReference(tag) {
+			if err := s.SetDigest(repoName, tag, id); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.SetLoad(repoName, tag, id, true, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOCIManifestImages returns the images whose configs the layers of
+// manifest reference, base first, followed by the image of its config.
+func readOCIManifestImages(dir string, manifest *ociManifest) ([]*image.Image, error) {
+	if len(manifest.Layers) > 0 {
+		if last := manifest.Layers[len(manifest.Layers)-1]; last.Annotations[imageConfigAnnotation] != manifest.Config.Digest.String() {
+			return nil, fmt.Errorf("The config %s of the manifest is not the config of its last layer", manifest.Config.Digest)
+		}
+	}
+	var images []*image.Image
+	for _, layer := range manifest.Layers {
+		configDigest := layer.Annotations[imageConfigAnnotation]
+		if configDigest == "" {
+			return nil, fmt.Errorf("Layer %s has no image config", layer.Digest)
+		}
+		img, err := readOCIConfig(dir, digest.Digest(configDigest), -1)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(images); n > 0 && img.Parent != images[n-1].ID {
+			return nil, fmt.Errorf("The layers of the manifest of %s are not the layers of its parents", stringid.TruncateID(img.ID))
+		}
+		images = append(images, img)
+	}
+	img, err := readOCIConfig(dir, manifest.Config.Digest, manifest.Config.Size)
+	if err != nil {
+		return nil, err
+	}
+	return append(images, img), nil
+}
+
+func readOCIConfig(dir string, dgst digest.Digest, size int64) (*image.Image, error) {
+	img := &image.Image{}
+	if err := readOCIBlobJSON(dir, dgst, size, img); err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateID(img.ID); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// loadOCIManifest registers the layers of manifest with the images their
+// configs describe, as read by readOCIManifestImages, returning the ID of
+// the image of the manifest.
+func (s *TagStore) loadOCIManifest(dir string, manifest *ociManifest, images []*image.Image) (string, error) {
+	for i, layer := range manifest.Layers {
+		if err := s.loadOCILayer(dir, images[i], layer); err != nil {
+			return "", err
+		}
+	}
+	// The image of the manifest is registered with its last layer, or was
+	// loaded already if all its layers were excluded
+	if len(manifest.Layers) > 0 {
+		return images[len(manifest.Layers)-1].ID, nil
+	}
+	img, err := s.graph.Get(images[0].ID)
+	if err != nil {
+		return "", err
+	}
+	return img.ID, nil
+}
+
+// loadOCILayer registers img with the given layer unless it is already in
+// the graph, setting img.ID to its ID in the graph.
+func (s *TagStore) loadOCILayer(dir string, img *image.Image, layer ociDescriptor) error {
+	if existing, err := s.graph.Get(img.ID); err == nil {
+		img.ID = existing.ID
+		return nil
+	}
+
+	// ensure no two downloads of the same layer happen at the same time
+	if c, err := s.poolAdd("pull", "layer:"+img.ID); err != nil {
+		if c == nil {
+			return err
+		}
+		logrus.Debugf("Image (id: %s) load is already running, waiting: %v", img.ID, err)
+		<-c
+		existing, err := s.graph.Get(img.ID)
+		if err != nil {
+			return err
+		}
+		img.ID = existing.ID
+		return nil
+	}
+	defer s.poolRemove("pull", "layer:"+img.ID)
+
+	logrus.Debugf("Loading %s", img.ID)
+	f, err := openOCIBlob(dir, layer.Digest, layer.Size)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.graph.Register(img, f)
+}
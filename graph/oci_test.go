@@ -0,0 +1,190 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/utils"
+)
+
+// mkTestOCIImages registers the image app:latest, with two layers, in a
+// new store and returns the store and the images, base first.
+func mkTestOCIImages(root string, t *testing.T) (*TagStore, []*image.Image) {
+	store := mkTestTagStore(root, t)
+	base := registerSquashTestImage(t, store.graph, "", "base", map[string]string{"base": "base"})
+	app := registerSquashTestImage(t, store.graph, base.ID, "app", map[string]string{"app": "app"})
+	if err := store.Set("app", "latest", app.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	return store, []*image.Image{base, app}
+}
+
+// exportTestOCILayout saves the images of repos and the images with the
+// given IDs in an OCI-style image layout, except the layers of the excluded
+// images, and returns the directory of the layout.
+func exportTestOCILayout(t *testing.T, store *TagStore, repos map[string]Repository, ids, excluded map[string]bool) string {
+	dir, err := ioutil.TempDir("", "docker-test-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter, err := newOCIExporter(store.graph, dir, excluded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.exportImages(repos, ids); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadOCILayout(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	source, images := mkTestOCIImages(tmp, t)
+	defer source.graph.driver.Cleanup()
+	id := images[1].ID
+	dir := exportTestOCILayout(t, source, map[string]Repository{"app": {"latest": id}}, map[string]bool{id: true, images[0].ID: true}, nil)
+	defer os.RemoveAll(dir)
+	if !isOCILayout(dir) {
+		t.Fatal("Expected an OCI-style image layout")
+	}
+
+	tmp, err = utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	if err := store.loadOCI(dir, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// The IDs the images were saved with remain valid references
+	img, err := store.LookupImage("app:latest")
+	if err != nil || img == nil {
+		t.Fatalf("Expected app:latest to be loaded, got %v, %v", img, err)
+	}
+	if saved, err := store.graph.Get(id); err != nil || saved.ID != img.ID {
+		t.Fatalf("Expected app:latest to be the image %s, got %v, %v", id, saved, err)
+	}
+	if !store.graph.Exists(img.Parent) {
+		t.Fatalf("Expected the parent %s to be loaded", img.Parent)
+	}
+
+	// Loading the images again only tags them
+	if err := store.loadOCI(dir, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOCILayoutVerifiesBlobs(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	source, images := mkTestOCIImages(tmp, t)
+	defer source.graph.driver.Cleanup()
+	dir := exportTestOCILayout(t, source, map[string]Repository{"app": {"latest": images[1].ID}}, nil, nil)
+	defer os.RemoveAll(dir)
+
+	// Tamper with every layer of the layout
+	blobs, err := filepath.Glob(filepath.Join(dir, "blobs", "sha256", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range blobs {
+		buf, err := ioutil.ReadFile(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the layers are gzip compressed, the other blobs are JSON
+		if len(buf) > 2 && buf[0] == 0x1f && buf[1] == 0x8b {
+			if err := ioutil.WriteFile(blob, append(buf, 0), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tmp, err = utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	if err := store.loadOCI(dir, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "failed verification") {
+		t.Fatalf("Expected the verification of the layers to fail, got %v", err)
+	}
+	if img, _ := store.LookupImage("app:latest"); img != nil {
+		t.Fatal("Expected app:latest not to be loaded")
+	}
+}
+
+func TestLoadOCILayoutExcludedLayers(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	source, images := mkTestOCIImages(tmp, t)
+	defer source.graph.driver.Cleanup()
+	base, app := images[0], images[1]
+	if err := source.Set("base", "latest", base.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	excluded, err := source.excludedLayers("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(excluded) != 1 || !excluded[base.ID] {
+		t.Fatalf("Expected the layer of %s to be excluded, got %v", base.ID, excluded)
+	}
+	baseDir := exportTestOCILayout(t, source, map[string]Repository{"base": {"latest": base.ID}}, nil, nil)
+	defer os.RemoveAll(baseDir)
+	appDir := exportTestOCILayout(t, source, map[string]Repository{"app": {"latest": app.ID}}, nil, excluded)
+	defer os.RemoveAll(appDir)
+	blobs, err := filepath.Glob(filepath.Join(appDir, "blobs", "sha256", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the manifest, the config and the layer of app
+	if len(blobs) != 3 {
+		t.Fatalf("Expected the layer of base to be excluded, got %d blobs", len(blobs))
+	}
+
+	tmp, err = utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	if err := store.loadOCI(appDir, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "is missing") {
+		t.Fatalf("Expected the missing parent to fail the load, got %v", err)
+	}
+	if img, _ := store.LookupImage("app:latest"); img != nil {
+		t.Fatal("Expected app:latest not to be loaded")
+	}
+
+	if err := store.loadOCI(baseDir, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.loadOCI(appDir, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := store.LookupImage("app:latest"); err != nil || img == nil {
+		t.Fatalf("Expected app:latest to be loaded, got %v, %v", img, err)
+	}
+}
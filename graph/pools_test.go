@@ -1,9 +1,11 @@
 package graph
 
 import (
+	"os"
 	"testing"
 
 	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/docker/utils"
 )
 
 func init() {
@@ -47,3 +49,36 @@ func TestPools(t *testing.T) {
 		t.Fatalf("Expected `Unknown pool type`")
 	}
 }
+
+func TestPullingImages(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	img, err := store.LookupImage(testOfficialImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.poolAdd("pull", "layer:"+img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.poolAdd("pull", "img:0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.poolAdd("pull", testOfficialImageName); err != nil {
+		t.Fatal(err)
+	}
+	if ids := store.PullingImages(); len(ids) != 1 || !ids[img.ID] {
+		t.Fatalf("Expected only %s to be held, got %v", img.ID, ids)
+	}
+	if err := store.poolRemove("pull", "layer:"+img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := store.PullingImages(); len(ids) != 0 {
+		t.Fatalf("Expected no image to be held, got %v", ids)
+	}
+}
@@ -0,0 +1,272 @@
+package graph
+
+import (
+	"bufio"
+	"debug/elf"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+// Before registering layers lazily, the files needed to run the entrypoint
+// of the image are extracted: its executable, the interpreter of scripts,
+// and the ELF interpreter and shared libraries of binaries, which are found
+// from the indexes of the layers without extracting them.
+
+const (
+	// defaultPathEnv is the PATH of the containers of images without one
+	defaultPathEnv = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	maxSymlinks    = 40
+)
+
+var (
+	libDirs = []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64", "/usr/local/lib"}
+	// libArchDirs are the subdirectories of libDirs libraries are installed
+	// in for each architecture by multiarch distributions
+	libArchDirs = map[elf.Machine]string{
+		elf.EM_386:     "i386-linux-gnu",
+		elf.EM_X86_64:  "x86_64-linux-gnu",
+		elf.EM_ARM:     "arm-linux-gnueabihf",
+		elf.EM_AARCH64: "aarch64-linux-gnu",
+		elf.EM_PPC64:   "powerpc64le-linux-gnu",
+		elf.EM_S390:    "s390x-linux-gnu",
+	}
+)
+
+// lazyFile is an entry of the indexed archive of a layer.
+type lazyFile struct {
+	entry *archive.IndexEntry
+	blob  io.ReaderAt
+}
+
+// lazyView is the filesystem of an image, by absolute path, as described by
+// the indexes of its layers.
+type lazyView map[string]lazyFile
+
+// add adds the entries of the index of a layer to the view, on top of the
+// layers already added.
+func (v lazyView) add(index *archive.ArchiveIndex, blob io.ReaderAt) {
+	for _, e := range index.Entries {
+		name := path.Clean("/" + e.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == ".wh..wh..opq":
+			v.remove(path.Clean(dir), false)
+		case strings.HasPrefix(base, ".wh..wh."):
+			// AUFS metadata
+		case strings.HasPrefix(base, ".wh."):
+			v.remove(path.Join(dir, base[len(".wh."):]), true)
+		default:
+			v[name] = lazyFile{entry: e, blob: blob}
+		}
+	}
+}
+
+// remove removes the children of dir from the view, and dir itself if self
+// is true.
+func (v lazyView) remove(dir string, self bool) {
+	if self {
+		delete(v, dir)
+	}
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name := range v {
+		if strings.HasPrefix(name, prefix) {
+			delete(v, name)
+		}
+	}
+}
+
+// resolve returns the absolute path of name, relative to dir, with the
+// symlinks of the view resolved.
+func (v lazyView) resolve(dir, name string) (string, bool) {
+	var (
+		resolved = "/"
+		rest     = strings.Split(path.Join(dir, name), "/")
+		links    = 0
+	)
+	for len(rest) > 0 {
+		component := rest[0]
+		rest = rest[1:]
+		if component == "" || component == "." {
+			continue
+		}
+		next := path.Join(resolved, component)
+		if f, exists := v[next]; exists && f.entry.Typeflag == tar.TypeSymlink {
+			if links++; links > maxSymlinks {
+				return "", false
+			}
+			if path.IsAbs(f.entry.Linkname) {
+				resolved = "/"
+			}
+			rest = append(strings.Split(f.entry.Linkname, "/"), rest...)
+			continue
+		}
+		resolved = next
+	}
+	return resolved, true
+}
+
+// file returns the regular file at name, relative to dir, if any.
+func (v lazyView) file(dir, name string) (string, lazyFile, bool) {
+	resolved, ok := v.resolve(dir, name)
+	if !ok {
+		return "", lazyFile{}, false
+	}
+	f, exists := v[resolved]
+	if !exists || !f.entry.HasContent() {
+		return "", lazyFile{}, false
+	}
+	return resolved, f, true
+}
+
+// executable returns the executable of the command of config, if it is in
+// the view.
+func (v lazyView) executable(config *runconfig.Config) (string, bool) {
+	if config == nil {
+		return "", false
+	}
+	cmd := config.Entrypoint
+	if len(cmd) == 0 {
+		cmd = config.Cmd
+	}
+	if len(cmd) == 0 || cmd[0] == "" {
+		return "", false
+	}
+	if strings.Contains(cmd[0], "/") {
+		workdir := config.WorkingDir
+		if workdir == "" {
+			workdir = "/"
+		}
+		name, _, exists := v.file(workdir, cmd[0])
+		return name, exists
+	}
+	pathEnv := defaultPathEnv
+	for _, env := range config.Env {
+		if strings.HasPrefix(env, "PATH=") {
+			pathEnv = strings.TrimPrefix(env, "PATH=")
+		}
+	}
+	for _, dir := range strings.Split(pathEnv, ":") {
+		if name, _, exists := v.file("/", path.Join(dir, cmd[0])); exists {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// prefetchFiles returns the entries of the files needed to run the command
+// of config, reading the content of the executables to tmpDir.
+func (v lazyView) prefetchFiles(config *runconfig.Config, tmpDir string) map[*archive.IndexEntry]bool {
+	files := make(map[*archive.IndexEntry]bool)
+	exe, exists := v.executable(config)
+	if !exists {
+		return files
+	}
+	queue := []string{exe}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		f := v[name]
+		if files[f.entry] {
+			continue
+		}
+		files[f.entry] = true
+		deps, err := v.dependencies(f, tmpDir)
+		if err != nil {
+			logrus.Debugf("Unable to find the dependencies of %s: %s", name, err)
+			continue
+		}
+		queue = append(queue, deps...)
+	}
+	return files
+}
+
+// dependencies returns the files the executable f depends on which are in
+// the view.
+func (v lazyView) dependencies(f lazyFile, tmpDir string) ([]string, error) {
+	content, err := f.entry.Open(f.blob)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(tmpDir, "")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, content); err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	var deps []string
+	line, _ := bufio.NewReader(tmp).ReadString('\n')
+	if strings.HasPrefix(line, "#!") {
+		// The interpreter of a script
+		if fields := strings.Fields(line[len("#!"):]); len(fields) > 0 {
+			if name, _, exists := v.file("/", fields[0]); exists {
+				deps = append(deps, name)
+			}
+		}
+		return deps, nil
+	}
+
+	binary, err := elf.NewFile(tmp)
+	if err != nil {
+		// Neither a script nor an ELF binary
+		return nil, nil
+	}
+	defer binary.Close()
+	for _, prog := range binary.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		if name, _, exists := v.file("/", strings.TrimRight(string(interp), "\x00")); exists {
+			deps = append(deps, name)
+		}
+	}
+	libs, err := binary.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range libs {
+		if name, exists := v.library(lib, binary.Machine); exists {
+			deps = append(deps, name)
+		}
+	}
+	return deps, nil
+}
+
+// library returns the shared library with the given name for the given
+// architecture, if it is in the view.
+func (v lazyView) library(lib string, machine elf.Machine) (string, bool) {
+	if strings.Contains(lib, "/") {
+		name, _, exists := v.file("/", lib)
+		return name, exists
+	}
+	for _, dir := range libDirs {
+		dirs := []string{dir}
+		if archDir, exists := libArchDirs[machine]; exists {
+			dirs = append(dirs, path.Join(dir, archDir))
+		}
+		for _, dir := range dirs {
+			if name, _, exists := v.file(dir, lib); exists {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
@@ -3,7 +3,6 @@ package graph
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
@@ -14,6 +13,7 @@ import (
 	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
@@ -62,7 +62,17 @@ func (s *TagStore) CmdPull(job *engine.Job) error {
 	logrus.Debugf("pulling image from host %q with remote name %q", repoInfo.Index.Name, repoInfo.RemoteName)
 	endpoint, err := repoInfo.GetEndpoint()
 	if err != nil {
-		return err
+		// Pull through a mirror if the registry itself is unreachable.
+		var mirrorErr error
+		for _, mirror := range repoInfo.Index.Mirrors {
+			if endpoint, mirrorErr = registry.NewMirrorEndpoint(mirror); mirrorErr == nil {
+				logrus.Debugf("Unable to reach %s, pulling from mirror %s: %s", repoInfo.Index.Name, mirror, err)
+				break
+			}
+		}
+		if len(repoInfo.Index.Mirrors) == 0 || mirrorErr != nil {
+			return err
+		}
 	}
 
 	r, err := registry.NewSession(authConfig, registry.HTTPRequestFactory(metaHeaders), endpoint, true)
@@ -75,7 +85,7 @@ func (s *TagStore) CmdPull(job *engine.Job) error {
 		logName = utils.ImageReference(logName, tag)
 	}
 
-	if len(repoInfo.Index.Mirrors) == 0 && (repoInfo.Index.Official || endpoint.Version == registry.APIVersion2) {
+	if repoInfo.Index.Official || endpoint.Version == registry.APIVersion2 || len(repoInfo.Index.Mirrors) > 0 {
 		if repoInfo.Official {
 			j := job.Eng.Job("trust_update_base")
 			if err = j.Run(); err != nil {
@@ -87,6 +97,8 @@ func (s *TagStore) CmdPull(job *engine.Job) error {
 		if err := s.pullV2Repository(job.Eng, r, job.Stdout, repoInfo, tag, sf, job.GetenvBool("parallel")); err == nil {
 			s.eventsService.Log("pull", logName, "")
 			return nil
+		} else if _, untrusted := err.(untrustedImageError); untrusted {
+			return err
 		} else if err != registry.ErrDoesNotExist && err != ErrV2RegistryUnavailable {
 			logrus.Errorf("Error from V2 registry: %s", err)
 		}
@@ -94,6 +106,11 @@ func (s *TagStore) CmdPull(job *engine.Job) error {
 		logrus.Debug("image does not exist on v2 registry, falling back to v1")
 	}
 
+	// Images pulled from v1 registries aren't signed.
+	if err := s.checkTrustPolicy(job.Eng, repoInfo, tag, nil); err != nil {
+		return err
+	}
+
 	logrus.Debugf("pulling v1 repository with local name %q", repoInfo.LocalName)
 	if err = s.pullRepository(r, job.Stdout, repoInfo, tag, sf, job.GetenvBool("parallel")); err != nil {
 		return err
@@ -313,46 +330,56 @@ func (s *TagStore) pullImage(r *registry.Session, out io.Writer, imgID, endpoint
 				}
 			}
 
-			for j := 1; j <= retries; j++ {
-				// Get the layer
-				status := "Pulling fs layer"
-				if j > 1 {
-					status = fmt.Sprintf("Pulling fs layer [retries: %d]", j)
-				}
-				out.Write(sf.FormatProgress(stringid.TruncateID(id), status, nil))
-				layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token, int64(imgSize))
-				if uerr, ok := err.(*url.Error); ok {
-					err = uerr.Err
-				}
-				if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else if err != nil {
-					out.Write(sf.FormatProgress(stringid.TruncateID(id), "Error pulling dependent layers", nil))
-					return layersDownloaded, err
-				}
-				layersDownloaded = true
-				defer layer.Close()
-
-				err = s.graph.Register(img,
-					progressreader.New(progressreader.Config{
-						In:        layer,
-						Out:       out,
-						Formatter: sf,
-						Size:      imgSize,
-						NewLines:  false,
-						ID:        stringid.TruncateID(id),
-						Action:    "Downloading",
-					}))
-				if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else if err != nil {
-					out.Write(sf.FormatProgress(stringid.TruncateID(id), "Error downloading dependent layers", nil))
-					return layersDownloaded, err
-				} else {
-					break
+			// Only the layer download takes a download slot, not waiting for
+			// it to be pulled by another client.
+			s.downloadSlots.acquire()
+			err = func() error {
+				for j := 1; j <= retries; j++ {
+					// Get the layer
+					status := "Pulling fs layer"
+					if j > 1 {
+						status = fmt.Sprintf("Pulling fs layer [retries: %d]", j)
+					}
+					out.Write(sf.FormatProgress(stringid.TruncateID(id), status, nil))
+					layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token, int64(imgSize))
+					if uerr, ok := err.(*url.Error); ok {
+						err = uerr.Err
+					}
+					if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
+						time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+						continue
+					} else if err != nil {
+						out.Write(sf.FormatProgress(stringid.TruncateID(id), "Error pulling dependent layers", nil))
+						return err
+					}
+					layersDownloaded = true
+					defer layer.Close()
+
+					err = s.graph.Register(img,
+						progressreader.New(progressreader.Config{
+							In:        s.limitBandwidth(layer),
+							Out:       out,
+							Formatter: sf,
+							Size:      imgSize,
+							NewLines:  false,
+							ID:        stringid.TruncateID(id),
+							Action:    "Downloading",
+						}))
+					if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
+						time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+						continue
+					} else if err != nil {
+						out.Write(sf.FormatProgress(stringid.TruncateID(id), "Error downloading dependent layers", nil))
+						return err
+					} else {
+						break
+					}
 				}
+				return nil
+			}()
+			s.downloadSlots.release()
+			if err != nil {
+				return layersDownloaded, err
 			}
 		}
 		out.Write(sf.FormatProgress(stringid.TruncateID(id), "Download complete", nil))
@@ -376,41 +403,37 @@ type downloadInfo struct {
 	tmpFile    *os.File
 	length     int64
 	downloaded bool
+	lazy       *lazyDownload
 	err        chan error
 }
 
 func (s *TagStore) pullV2Repository(eng *engine.Engine, r *registry.Session, out io.Writer, repoInfo *registry.RepositoryInfo, tag string, sf *streamformatter.StreamFormatter, parallel bool) error {
-	endpoint, err := r.V2RegistryEndpoint(repoInfo.Index)
-	if err != nil {
-		if repoInfo.Index.Official {
-			logrus.Debugf("Unable to pull from V2 registry, falling back to v1: %s", err)
-			return ErrV2RegistryUnavailable
-		}
-		return fmt.Errorf("error getting registry endpoint: %s", err)
-	}
-	auth, err := r.GetV2Authorization(endpoint, repoInfo.RemoteName, true)
+	sources, err := s.v2Sources(r, repoInfo)
 	if err != nil {
-		return fmt.Errorf("error getting authorization: %s", err)
+		return err
 	}
 	var layersDownloaded bool
 	if tag == "" {
 		logrus.Debugf("Pulling tag list from V2 registry for %s", repoInfo.CanonicalName)
-		tags, err := r.GetV2RemoteTags(endpoint, repoInfo.RemoteName, auth)
-		if err != nil {
+		var tags []string
+		if _, err := tryV2Sources(sources, func(src *v2Source) (err error) {
+			tags, err = r.GetV2RemoteTags(src.endpoint, repoInfo.RemoteName, src.auth)
+			return err
+		}); err != nil {
 			return err
 		}
 		if len(tags) == 0 {
 			return registry.ErrDoesNotExist
 		}
 		for _, t := range tags {
-			if downloaded, err := s.pullV2Tag(eng, r, out, endpoint, repoInfo, t, sf, parallel, auth); err != nil {
+			if downloaded, err := s.pullV2Tag(eng, r, out, sources, repoInfo, t, sf, parallel); err != nil {
 				return err
 			} else if downloaded {
 				layersDownloaded = true
 			}
 		}
 	} else {
-		if downloaded, err := s.pullV2Tag(eng, r, out, endpoint, repoInfo, tag, sf, parallel, auth); err != nil {
+		if downloaded, err := s.pullV2Tag(eng, r, out, sources, repoInfo, tag, sf, parallel); err != nil {
 			return err
 		} else if downloaded {
 			layersDownloaded = true
@@ -425,10 +448,19 @@ func (s *TagStore) pullV2Repository(eng *engine.Engine, r *registry.Session, out
 	return nil
 }
 
-func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Writer, endpoint *registry.Endpoint, repoInfo *registry.RepositoryInfo, tag string, sf *streamformatter.StreamFormatter, parallel bool, auth *registry.RequestAuthorization) (bool, error) {
+// pullV2Tag pulls the manifest of the tag and its layers from the first of
+// the sources which has them.
+func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Writer, sources []*v2Source, repoInfo *registry.RepositoryInfo, tag string, sf *streamformatter.StreamFormatter, parallel bool) (bool, error) {
 	logrus.Debugf("Pulling tag from V2 registry: %q", tag)
 
-	manifestBytes, manifestDigest, err := r.GetV2ImageManifest(endpoint, repoInfo.RemoteName, tag, auth)
+	var (
+		manifestBytes  []byte
+		manifestDigest string
+	)
+	manifestSource, err := tryV2Sources(sources, func(src *v2Source) (err error) {
+		manifestBytes, manifestDigest, err = r.GetV2ImageManifest(src.endpoint, repoInfo.RemoteName, tag, src.auth)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
@@ -444,12 +476,26 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		return false, err
 	}
 
+	if err := s.checkTrustPolicy(eng, repoInfo, tag, manifestBytes); err != nil {
+		return false, err
+	}
+
 	if verified {
 		logrus.Printf("Image manifest for %s has been verified", utils.ImageReference(repoInfo.CanonicalName, tag))
 	}
-	out.Write(sf.FormatStatus(tag, "Pulling from %s", repoInfo.CanonicalName))
+	if manifestSource.mirror {
+		out.Write(sf.FormatStatus(tag, "Pulling from %s, mirror: %s", repoInfo.CanonicalName, manifestSource))
+	} else {
+		out.Write(sf.FormatStatus(tag, "Pulling from %s", repoInfo.CanonicalName))
+	}
 
-	downloads := make([]downloadInfo, len(manifest.FSLayers))
+	var (
+		downloads = make([]downloadInfo, len(manifest.FSLayers))
+		// The content-addressable ID of a layer is known before pulling it
+		// if the layer and all of its parents are already in the graph.
+		parentID     string
+		parentExists = true
+	)
 
 	for i := len(manifest.FSLayers) - 1; i >= 0; i-- {
 		var (
@@ -463,18 +509,20 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		}
 		downloads[i].img = img
 
-		// Check if exists
-		if s.graph.Exists(img.ID) {
-			logrus.Debugf("Image already exists: %s", img.ID)
-			continue
-		}
-
 		dgst, err := digest.ParseDigest(sumStr)
 		if err != nil {
 			return false, err
 		}
 		downloads[i].digest = dgst
 
+		// Check if exists
+		if parentExists {
+			if parentID, parentExists = s.lookupV2Layer(img, parentID, dgst); parentExists {
+				logrus.Debugf("Image already exists: %s", parentID)
+				continue
+			}
+		}
+
 		out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Pulling fs layer", nil))
 
 		downloadFunc := func(di *downloadInfo) error {
@@ -490,43 +538,36 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 				}
 			} else {
 				defer s.poolRemove("pull", "img:"+img.ID)
-				tmpFile, err := ioutil.TempFile("", "GetV2ImageBlob")
-				if err != nil {
-					return err
+				if s.lazyLayers && s.graph.supportsLazyLayers() {
+					lazy, err := s.openLazyDownload(r, sources, repoInfo.RemoteName, di.digest)
+					if err == nil {
+						out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Index downloaded", nil))
+						di.lazy = lazy
+						di.imgJSON = imgJSON
+						return nil
+					}
+					logrus.Debugf("Unable to pull %s lazily, downloading it: %s", di.digest, err)
 				}
-
-				r, l, err := r.GetV2ImageBlobReader(endpoint, repoInfo.RemoteName, di.digest.Algorithm(), di.digest.Hex(), auth)
-				if err != nil {
+				var (
+					tmpFile *os.File
+					l       int64
+				)
+				s.downloadSlots.acquire()
+				src, err := tryV2Sources(sources, func(src *v2Source) (err error) {
+					tmpFile, l, err = s.downloadV2Blob(r, src.endpoint, repoInfo.RemoteName, di.digest, src.auth, out, sf, stringid.TruncateID(img.ID))
 					return err
-				}
-				defer r.Close()
-
-				verifier, err := digest.NewDigestVerifier(di.digest)
+				})
+				s.downloadSlots.release()
 				if err != nil {
 					return err
 				}
 
-				if _, err := io.Copy(tmpFile, progressreader.New(progressreader.Config{
-					In:        ioutil.NopCloser(io.TeeReader(r, verifier)),
-					Out:       out,
-					Formatter: sf,
-					Size:      int(l),
-					NewLines:  false,
-					ID:        stringid.TruncateID(img.ID),
-					Action:    "Downloading",
-				})); err != nil {
-					return fmt.Errorf("unable to copy v2 image blob data: %s", err)
-				}
-
-				out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Verifying Checksum", nil))
-
-				if !verifier.Verified() {
-					logrus.Infof("Image verification failed: checksum mismatch for %q", di.digest.String())
-					verified = false
+				if len(sources) > 1 {
+					out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), fmt.Sprintf("Download complete from %s", src), nil))
+				} else {
+					out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Download complete", nil))
 				}
 
-				out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Download complete", nil))
-
 				logrus.Debugf("Downloaded %s to tempfile %s", img.ID, tmpFile.Name())
 				di.tmpFile = tmpFile
 				di.length = l
@@ -550,42 +591,100 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		}
 	}
 
-	var tagUpdated bool
+	var (
+		tagUpdated bool
+		prefetch   map[*archive.IndexEntry]bool
+	)
+	parentID = ""
 	for i := len(downloads) - 1; i >= 0; i-- {
 		d := &downloads[i]
 		if d.err != nil {
 			err := <-d.err
+			d.err = nil
 			if err != nil {
 				return false, err
 			}
 		}
-		if d.downloaded {
+		// Progress is reported under the v1 ID of the layer in the manifest.
+		progressID := stringid.TruncateID(d.img.ID)
+		if d.lazy != nil {
+			defer d.lazy.blob.Close()
+			// The files to extract first are looked for in all the layers
+			if prefetch == nil {
+				if prefetch, err = s.lazyPrefetch(downloads); err != nil {
+					return false, err
+				}
+			}
+			out.Write(sf.FormatProgress(progressID, "Extracting the files of the entrypoint", nil))
+			d.img.ID = ""
+			d.img.Parent = parentID
+			err = s.graph.RegisterLazy(d.img, d.lazy.blob, d.lazy.index, func(e *archive.IndexEntry) bool {
+				return prefetch[e]
+			}, s.lazyFetch(r, sources, repoInfo.RemoteName, d.digest, sf))
+			if err != nil {
+				return false, err
+			}
+			if err := s.graph.SetBlobSum(d.img, d.digest); err != nil {
+				return false, err
+			}
+			if err := s.graph.AddBlobSource(d.img, blobSource(repoInfo)); err != nil {
+				logrus.Debugf("Unable to record blob source of %s: %s", d.img.ID, err)
+			}
+			parentID = d.img.ID
+			out.Write(sf.FormatProgress(progressID, "Pull complete, extracting in the background", nil))
+			tagUpdated = true
+		} else if d.downloaded {
 			// if tmpFile is empty assume download and extracted elsewhere
 			defer os.Remove(d.tmpFile.Name())
 			defer d.tmpFile.Close()
 			d.tmpFile.Seek(0, 0)
 			if d.tmpFile != nil {
+				// The layer is registered under its content-addressable ID,
+				// on top of the layer below it in the manifest.
+				d.img.ID = ""
+				d.img.Parent = parentID
 				err = s.graph.Register(d.img,
 					progressreader.New(progressreader.Config{
 						In:        d.tmpFile,
 						Out:       out,
 						Formatter: sf,
 						Size:      int(d.length),
-						ID:        stringid.TruncateID(d.img.ID),
+						ID:        progressID,
 						Action:    "Extracting",
 					}))
 				if err != nil {
 					return false, err
 				}
+				if err := s.graph.SetBlobSum(d.img, d.digest); err != nil {
+					return false, err
+				}
+				if err := s.graph.AddBlobSource(d.img, blobSource(repoInfo)); err != nil {
+					logrus.Debugf("Unable to record blob source of %s: %s", d.img.ID, err)
+				}
 
 				// FIXME: Pool release here for parallel tag pull (ensures any downloads block until fully extracted)
 			}
-			out.Write(sf.FormatProgress(stringid.TruncateID(d.img.ID), "Pull complete", nil))
+			parentID = d.img.ID
+			out.Write(sf.FormatProgress(progressID, "Pull complete", nil))
 			tagUpdated = true
 		} else {
-			out.Write(sf.FormatProgress(stringid.TruncateID(d.img.ID), "Already exists", nil))
+			var exists bool
+			if parentID, exists = s.lookupV2Layer(d.img, parentID, d.digest); !exists {
+				return false, fmt.Errorf("layer %s of %s was not pulled", d.digest, utils.ImageReference(repoInfo.CanonicalName, tag))
+			}
+			if img, err := s.graph.Get(parentID); err == nil {
+				if err := s.graph.AddBlobSource(img, blobSource(repoInfo)); err != nil {
+					logrus.Debugf("Unable to record blob source of %s: %s", img.ID, err)
+				}
+			}
+			out.Write(sf.FormatProgress(progressID, "Already exists", nil))
 		}
 
+		// The layers are held until the image is tagged, so that they
+		// aren't removed by the image garbage collector in the meantime.
+		if _, err := s.poolAdd("pull", "layer:"+parentID); err == nil {
+			defer s.poolRemove("pull", "layer:"+parentID)
+		}
 	}
 
 	// Check for new tag if no layers downloaded
@@ -611,16 +710,50 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		out.Write(sf.FormatStatus("", "Digest: %s", manifestDigest))
 	}
 
+	// The manifest is kept to check the image against the trust policy
+	// when it is used.
+	img, err := s.graph.Get(parentID)
+	if err != nil {
+		return false, err
+	}
+	if err := s.graph.SetSignedManifest(img, repoInfo.LocalName, manifestBytes); err != nil {
+		return false, err
+	}
+
 	if utils.DigestReference(tag) {
-		if err = s.SetDigest(repoInfo.LocalName, tag, downloads[0].img.ID); err != nil {
+		if err = s.SetDigest(repoInfo.LocalName, tag, parentID); err != nil {
 			return false, err
 		}
 	} else {
 		// only set the repository/tag -> image ID mapping when pulling by tag (i.e. not by digest)
-		if err = s.Set(repoInfo.LocalName, tag, downloads[0].img.ID, true); err != nil {
+		if err = s.Set(repoInfo.LocalName, tag, parentID, true); err != nil {
 			return false, err
 		}
+		// the image pulled by tag can be referenced by the manifest digest as well
+		if manifestDigest != "" {
+			if err = s.SetDigest(repoInfo.LocalName, manifestDigest, parentID); err != nil {
+				logrus.Warnf("Unable to set digest %s for %s: %s", manifestDigest, utils.ImageReference(repoInfo.LocalName, tag), err)
+			}
+		}
 	}
 
 	return tagUpdated, nil
 }
+
+// lookupV2Layer returns the content-addressable ID of the layer described by
+// img, stored in the registry as blobSum, on top of the layer with the
+// given ID, and whether the layer is in the graph.
+func (s *TagStore) lookupV2Layer(img *image.Image, parentID string, blobSum digest.Digest) (string, bool) {
+	layerDigest, exists := s.graph.LayerDigest(blobSum)
+	if !exists {
+		return "", false
+	}
+	config := *img
+	config.Parent = parentID
+	config.LayerDigest = layerDigest
+	id, err := image.ComputeID(&config)
+	if err != nil || !s.graph.Exists(id) {
+		return "", false
+	}
+	return id, true
+}
@@ -12,6 +12,7 @@ import (
 	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/progressreader"
@@ -258,6 +259,9 @@ func (s *TagStore) pushImage(r *registry.Session, out io.Writer, imgID, ep strin
 		return "", err
 	}
 
+	s.uploadSlots.acquire()
+	defer s.uploadSlots.release()
+
 	layerData, err := s.graph.TempLayerArchive(imgID, sf, out)
 	if err != nil {
 		return "", fmt.Errorf("Failed to generate layer archive: %s", err)
@@ -269,7 +273,7 @@ func (s *TagStore) pushImage(r *registry.Session, out io.Writer, imgID, ep strin
 
 	checksum, checksumPayload, err := r.PushImageLayerRegistry(imgData.ID,
 		progressreader.New(progressreader.Config{
-			In:        layerData,
+			In:        s.limitBandwidth(layerData),
 			Out:       out,
 			Formatter: sf,
 			Size:      int(layerData.Size),
@@ -354,7 +358,15 @@ func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, o
 		m.FSLayers = make([]*registry.FSLayer, len(layers))
 		m.History = make([]*registry.ManifestHistory, len(layers))
 
-		// Schema version 1 requires layer ordering from top to root
+		// Schema version 1 requires layer ordering from top to root. The
+		// blobs of the layers are pushed concurrently, each waiting for an
+		// upload slot.
+		var (
+			wg   sync.WaitGroup
+			errs = make([]error, len(layers))
+		)
+		// Don't leave pushes running behind when returning early.
+		defer wg.Wait()
 		for i, layer := range layers {
 			logrus.Debugf("Pushing layer: %s", layer.ID)
 
@@ -373,36 +385,20 @@ func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, o
 			if err != nil {
 				return fmt.Errorf("error getting image checksum: %s", err)
 			}
+			m.History[i] = &registry.ManifestHistory{V1Compatibility: string(jsonData)}
 
-			var exists bool
-			if len(checksum) > 0 {
-				sumParts := strings.SplitN(checksum, ":", 2)
-				if len(sumParts) < 2 {
-					return fmt.Errorf("Invalid checksum: %s", checksum)
-				}
-
-				// Call mount blob
-				exists, err = r.HeadV2ImageBlob(endpoint, repoInfo.RemoteName, sumParts[0], sumParts[1], auth)
-				if err != nil {
-					out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Image push failed", nil))
-					return err
-				}
-			}
-			if !exists {
-				if cs, err := s.pushV2Image(r, layer, endpoint, repoInfo.RemoteName, sf, out, auth); err != nil {
-					return err
-				} else if cs != checksum {
-					// Cache new checksum
-					if err := layer.SaveCheckSum(s.graph.ImageRoot(layer.ID), cs); err != nil {
-						return err
-					}
-					checksum = cs
-				}
-			} else {
-				out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Image already exists", nil))
+			wg.Add(1)
+			go func(i int, layer *image.Image, checksum string) {
+				defer wg.Done()
+				checksum, errs[i] = s.pushV2Layer(r, layer, endpoint, repoInfo, checksum, auth, out, sf)
+				m.FSLayers[i] = &registry.FSLayer{BlobSum: checksum}
+			}(i, layer, checksum)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
 			}
-			m.FSLayers[i] = &registry.FSLayer{BlobSum: checksum}
-			m.History[i] = &registry.ManifestHistory{V1Compatibility: string(jsonData)}
 		}
 
 		if err := checkValidManifest(m); err != nil {
@@ -422,12 +418,25 @@ func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, o
 		if err = js.Sign(s.trustKey); err != nil {
 			return err
 		}
+		if s.signingKey != nil {
+			if len(s.signingChain) > 0 {
+				err = js.SignWithChain(s.signingKey, s.signingChain)
+			} else {
+				err = js.Sign(s.signingKey)
+			}
+			if err != nil {
+				return err
+			}
+		}
 
 		signedBody, err := js.PrettySignature("signatures")
 		if err != nil {
 			return err
 		}
 		logrus.Infof("Signed manifest for %s:%s using daemon's key: %s", repoInfo.LocalName, tag, s.trustKey.KeyID())
+		if s.signingKey != nil {
+			logrus.Infof("Signed manifest for %s:%s using signing key: %s", repoInfo.LocalName, tag, s.signingKey.KeyID())
+		}
 
 		// push the manifest
 		digest, err := r.PutV2ImageManifest(endpoint, repoInfo.RemoteName, tag, signedBody, mBytes, auth)
@@ -436,10 +445,68 @@ func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, o
 		}
 
 		out.Write(sf.FormatStatus("", "Digest: %s", digest))
+		if digest != "" {
+			if err := s.SetDigest(repoInfo.LocalName, digest.String(), layers[0].ID); err != nil {
+				logrus.Warnf("Unable to set digest %s for %s: %s", digest, utils.ImageReference(repoInfo.LocalName, tag), err)
+			}
+		}
 	}
 	return nil
 }
 
+// pushV2Layer pushes the blob of the layer to the v2 registry unless the
+// registry already has it, or it can be mounted from another repository. It
+// returns the checksum of the blob.
+func (s *TagStore) pushV2Layer(r *registry.Session, layer *image.Image, endpoint *registry.Endpoint, repoInfo *registry.RepositoryInfo, checksum string, auth *registry.RequestAuthorization, out io.Writer, sf *streamformatter.StreamFormatter) (string, error) {
+	var (
+		exists bool
+		err    error
+	)
+	if len(checksum) > 0 {
+		sumParts := strings.SplitN(checksum, ":", 2)
+		if len(sumParts) < 2 {
+			return "", fmt.Errorf("Invalid checksum: %s", checksum)
+		}
+
+		// Call mount blob
+		exists, err = r.HeadV2ImageBlob(endpoint, repoInfo.RemoteName, sumParts[0], sumParts[1], auth)
+		if err != nil {
+			out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Image push failed", nil))
+			return "", err
+		}
+	}
+	if !exists && len(checksum) > 0 {
+		if exists, err = s.mountV2Blob(r, layer, endpoint, repoInfo, digest.Digest(checksum)); err != nil {
+			out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Image push failed", nil))
+			return "", err
+		}
+		if exists {
+			out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Mounted from another repository", nil))
+		}
+	} else if exists {
+		out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Image already exists", nil))
+	}
+	if !exists {
+		out.Write(sf.FormatProgress(stringid.TruncateID(layer.ID), "Waiting", nil))
+		s.uploadSlots.acquire()
+		cs, err := s.pushV2Image(r, layer, endpoint, repoInfo.RemoteName, sf, out, auth)
+		s.uploadSlots.release()
+		if err != nil {
+			return "", err
+		} else if cs != checksum {
+			// Cache new checksum
+			if err := s.graph.SetBlobSum(layer, digest.Digest(cs)); err != nil {
+				return "", err
+			}
+			checksum = cs
+		}
+	}
+	if err := s.graph.AddBlobSource(layer, blobSource(repoInfo)); err != nil {
+		logrus.Debugf("Unable to record blob source of %s: %s", layer.ID, err)
+	}
+	return checksum, nil
+}
+
 // PushV2Image pushes the image content to the v2 registry, first buffering the contents to disk
 func (s *TagStore) pushV2Image(r *registry.Session, img *image.Image, endpoint *registry.Endpoint, imageName string, sf *streamformatter.StreamFormatter, out io.Writer, auth *registry.RequestAuthorization) (string, error) {
 	out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Buffering to Disk", nil))
@@ -448,6 +515,9 @@ func (s *TagStore) pushV2Image(r *registry.Session, img *image.Image, endpoint *
 	if err != nil {
 		return "", err
 	}
+	if err := s.graph.WaitLayers(image.ID); err != nil {
+		return "", err
+	}
 	arch, err := image.TarLayer()
 	if err != nil {
 		return "", err
@@ -463,21 +533,19 @@ func (s *TagStore) pushV2Image(r *registry.Session, img *image.Image, endpoint *
 		os.Remove(tf.Name())
 	}()
 
-	size, dgst, err := bufferToFile(tf, arch)
+	buffer := bufferToFile
+	if s.lazyLayers {
+		buffer = bufferIndexedToFile
+	}
+	size, dgst, err := buffer(tf, arch)
+	if err != nil {
+		return "", err
+	}
 
 	// Send the layer
 	logrus.Debugf("rendered layer for %s of [%d] size", img.ID, size)
 
-	if err := r.PutV2ImageBlob(endpoint, imageName, dgst.Algorithm(), dgst.Hex(),
-		progressreader.New(progressreader.Config{
-			In:        tf,
-			Out:       out,
-			Formatter: sf,
-			Size:      int(size),
-			NewLines:  false,
-			ID:        stringid.TruncateID(img.ID),
-			Action:    "Pushing",
-		}), auth); err != nil {
+	if err := s.uploadV2Blob(r, endpoint, imageName, dgst, tf, size, auth, out, sf, stringid.TruncateID(img.ID)); err != nil {
 		out.Write(sf.FormatProgress(stringid.TruncateID(img.ID), "Image push failed", nil))
 		return "", err
 	}
@@ -485,6 +553,41 @@ func (s *TagStore) pushV2Image(r *registry.Session, img *image.Image, endpoint *
 	return dgst.String(), nil
 }
 
+// mountV2Blob mounts the blob of the layer into the repository from another
+// repository of the same registry it is known to be stored in, if any.
+// Mounting is only an optimization, sources which can't be mounted from,
+// e.g. because the user can't pull from them, are skipped.
+func (s *TagStore) mountV2Blob(r *registry.Session, layer *image.Image, endpoint *registry.Endpoint, repoInfo *registry.RepositoryInfo, dgst digest.Digest) (bool, error) {
+	sources, err := s.graph.BlobSources(layer)
+	if err != nil {
+		return false, err
+	}
+	prefix := repoInfo.Index.Name + "/"
+	for _, source := range sources {
+		if !strings.HasPrefix(source, prefix) || source == blobSource(repoInfo) {
+			continue
+		}
+		fromName := strings.TrimPrefix(source, prefix)
+		auth := r.GetV2MountAuthorization(endpoint, repoInfo.RemoteName, fromName)
+		mounted, err := r.MountV2ImageBlob(endpoint, repoInfo.RemoteName, fromName, dgst.Algorithm(), dgst.Hex(), auth)
+		if err != nil {
+			logrus.Debugf("Unable to mount blob %s of %s from %s: %s", dgst, layer.ID, source, err)
+			continue
+		}
+		if mounted {
+			logrus.Debugf("Mounted blob %s of %s from %s", dgst, layer.ID, source)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// blobSource returns the name blobs stored in the repository are recorded
+// under, made of the registry and the remote name of the repository.
+func blobSource(repoInfo *registry.RepositoryInfo) string {
+	return repoInfo.Index.Name + "/" + repoInfo.RemoteName
+}
+
 // FIXME: Allow to interrupt current push when new push of same image is done.
 func (s *TagStore) CmdPush(job *engine.Job) error {
 	if n := len(job.Args); n != 1 {
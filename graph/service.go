@@ -164,6 +164,9 @@ func (s *TagStore) CmdTarLayer(job *engine.Job) error {
 	}
 	name := job.Args[0]
 	if image, err := s.LookupImage(name); err == nil && image != nil {
+		if err := s.graph.WaitLayers(image.ID); err != nil {
+			return err
+		}
 		fs, err := image.TarLayer()
 		if err != nil {
 			return err
@@ -0,0 +1,91 @@
+package graph
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// Squash registers an image with the config of the image with the given ID
+// and a single layer with the changes of its layers since its parent with
+// the ID parent, or with its whole filesystem if parent is empty. The steps
+// which created the squashed layers are kept in the Steps of the new
+// image. The image itself is returned if it has a single layer to squash.
+func (graph *Graph) Squash(id, parent string) (*image.Image, error) {
+	img, err := graph.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The images whose layers are squashed, from the image down to parent
+	var squashed []*image.Image
+	base := img
+	for base != nil && base.ID != parent {
+		squashed = append(squashed, base)
+		if base, err = base.GetParent(); err != nil {
+			return nil, err
+		}
+	}
+	if parent != "" && base == nil {
+		return nil, fmt.Errorf("%s is not a parent of %s", stringid.TruncateID(parent), stringid.TruncateID(img.ID))
+	}
+	if len(squashed) <= 1 {
+		return img, nil
+	}
+
+	if err := graph.WaitLayers(img.ID); err != nil {
+		return nil, err
+	}
+	layerFs, err := graph.driver.Get(img.LayerID(), "")
+	if err != nil {
+		return nil, err
+	}
+	defer graph.driver.Put(img.LayerID())
+
+	var layer archive.Archive
+	if base == nil {
+		if layer, err = archive.Tar(layerFs, archive.Uncompressed); err != nil {
+			return nil, err
+		}
+	} else {
+		baseFs, err := graph.driver.Get(base.LayerID(), "")
+		if err != nil {
+			return nil, err
+		}
+		defer graph.driver.Put(base.LayerID())
+		// Files added and deleted again since the base aren't part of the
+		// changes, while the files of the base deleted since are whiteouts.
+		changes, err := archive.ChangesDirs(layerFs, baseFs)
+		if err != nil {
+			return nil, err
+		}
+		if layer, err = archive.ExportChanges(layerFs, changes); err != nil {
+			return nil, err
+		}
+	}
+	defer layer.Close()
+
+	squashedImg := &image.Image{
+		Comment:         img.Comment,
+		Created:         img.Created,
+		Container:       img.Container,
+		ContainerConfig: img.ContainerConfig,
+		DockerVersion:   img.DockerVersion,
+		Author:          img.Author,
+		Config:          img.Config,
+		Architecture:    img.Architecture,
+		OS:              img.OS,
+	}
+	if base != nil {
+		squashedImg.Parent = base.ID
+	}
+	for i := len(squashed) - 1; i >= 0; i-- {
+		squashedImg.Steps = append(squashedImg.Steps, squashed[i].CreationSteps()...)
+	}
+	if err := graph.Register(squashedImg, layer); err != nil {
+		return nil, err
+	}
+	return squashedImg, nil
+}
@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/utils"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+// squashTestLayer returns a layer adding the given files, and deleting the
+// files whose content is empty.
+func squashTestLayer(t *testing.T, files map[string]string) io.Reader {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Uid: os.Getuid(), Gid: os.Getgid(), Size: int64(len(content))}
+		if content == "" {
+			hdr.Name = filepath.Join(filepath.Dir(name), ".wh."+filepath.Base(name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func registerSquashTestImage(t *testing.T, graph *Graph, parent string, cmd string, files map[string]string) *image.Image {
+	img := &image.Image{
+		Parent:          parent,
+		Created:         time.Now().UTC(),
+		ContainerConfig: runconfig.Config{Cmd: []string{"/bin/sh", "-c", cmd}},
+		Config:          &runconfig.Config{Cmd: []string{cmd}},
+	}
+	if err := graph.Register(img, squashTestLayer(t, files)); err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestSquash(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	base := registerSquashTestImage(t, graph, "", "base", map[string]string{"kept": "kept", "deleted": "deleted"})
+	first := registerSquashTestImage(t, graph, base.ID, "first", map[string]string{"added": "added", "transient": "transient"})
+	last := registerSquashTestImage(t, graph, first.ID, "last", map[string]string{"deleted": "", "transient": ""})
+
+	img, err := graph.Squash(last.ID, base.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Parent != base.ID {
+		t.Fatalf("Expected the squashed image to have the parent %s, got %s", base.ID, img.Parent)
+	}
+	if !reflect.DeepEqual(img.Config, last.Config) {
+		t.Fatalf("Expected the squashed image to have the config %v, got %v", last.Config, img.Config)
+	}
+	if len(img.Steps) != 2 || img.Steps[0].CreatedBy != "/bin/sh -c first" || img.Steps[1].CreatedBy != "/bin/sh -c last" {
+		t.Fatalf("Expected the steps of the squashed layers in the history, got %v", img.Steps)
+	}
+
+	layer, err := img.TarLayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layer.Close()
+	entries := make(map[string]string)
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			entries[filepath.Clean(hdr.Name)] = string(content)
+		}
+	}
+	if expected := map[string]string{"added": "added", ".wh.deleted": ""}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected the squashed layer to contain %v, got %v", expected, entries)
+	}
+
+	// Squashing the squashed image again changes nothing
+	if again, err := graph.Squash(img.ID, base.ID); err != nil || again.ID != img.ID {
+		t.Fatalf("Expected %s, got %v, %v", img.ID, again, err)
+	}
+}
+
+func TestSquashWholeFilesystem(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	base := registerSquashTestImage(t, graph, "", "base", map[string]string{"kept": "kept", "deleted": "deleted"})
+	squashed := registerSquashTestImage(t, graph, base.ID, "squashed", map[string]string{"deleted": ""})
+	if squashed, err = graph.Squash(squashed.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	// The steps of the squashed layers are kept when squashing them again
+	last := registerSquashTestImage(t, graph, squashed.ID, "last", map[string]string{"added": "added"})
+	if last, err = graph.Squash(last.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if last.Parent != "" {
+		t.Fatalf("Expected the squashed image to have no parent, got %s", last.Parent)
+	}
+	var steps []string
+	for _, h := range last.Steps {
+		steps = append(steps, h.CreatedBy)
+	}
+	if expected := []string{"/bin/sh -c base", "/bin/sh -c squashed", "/bin/sh -c last"}; !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("Expected the steps %v, got %v", expected, steps)
+	}
+
+	dir, err := graph.driver.Get(last.LayerID(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.driver.Put(last.LayerID())
+	for name, exists := range map[string]bool{"kept": true, "added": true, "deleted": false} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Fatalf("Expected %s to exist: %v, got %v", name, exists, err)
+		}
+	}
+
+	if _, err := graph.Squash(last.ID, base.ID); err == nil {
+		t.Fatal("Expected squashing since an image which isn't a parent to fail")
+	}
+}
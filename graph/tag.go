@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/docker/docker/engine"
+	"github.com/docker/docker/utils"
 )
 
 func (s *TagStore) CmdTag(job *engine.Job) error {
@@ -14,5 +15,8 @@ func (s *TagStore) CmdTag(job *engine.Job) error {
 	if len(job.Args) == 3 {
 		tag = job.Args[2]
 	}
+	if utils.DigestReference(tag) {
+		return fmt.Errorf("refusing to create a tag with a digest reference: digests are set when images are pulled or pushed")
+	}
 	return s.Set(job.Args[1], tag, job.Args[0], job.GetenvBool("force"))
 }
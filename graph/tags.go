@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/events"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/parsers"
@@ -35,11 +37,19 @@ type TagStore struct {
 	graph        *Graph
 	Repositories map[string]Repository
 	trustKey     libtrust.PrivateKey
+	// signingKey additionally signs pushed manifests, with the
+	// certificates of signingChain if any.
+	signingKey   libtrust.PrivateKey
+	signingChain []*x509.Certificate
 	sync.Mutex
 	// FIXME: move push/pull-related fields
 	// to a helper type
 	pullingPool     map[string]chan struct{}
 	pushingPool     map[string]chan struct{}
+	downloadSlots   transferSlots
+	uploadSlots     transferSlots
+	bandwidth       *bandwidthLimiter
+	lazyLayers      bool
 	registryService *registry.Service
 	eventsService   *events.Events
 }
@@ -87,10 +97,48 @@ func NewTagStore(path string, graph *Graph, key libtrust.PrivateKey, registrySer
 		}
 	} else if err != nil {
 		return nil, err
+	} else if err := store.resolveLegacyIDs(); err != nil {
+		return nil, err
+	}
+	for _, id := range graph.setLazyFailureHandler(store.untagFailedImage) {
+		store.untagFailedImage(id)
 	}
 	return store, nil
 }
 
+// untagFailedImage removes the references to the image with the given ID,
+// which layer failed to be extracted, and to the images on top of it.
+func (store *TagStore) untagFailedImage(id string) {
+	store.Lock()
+	defer store.Unlock()
+	if err := store.reload(); err != nil {
+		logrus.Errorf("Unable to untag the images on top of %s: %s", id, err)
+		return
+	}
+	for repoName, repoRefs := range store.Repositories {
+		for ref, imgID := range repoRefs {
+			if !store.graph.Exists(imgID) || store.graph.LayerError(imgID) == nil {
+				continue
+			}
+			logrus.Errorf("Untagging %s: the layer of %s failed to be extracted", utils.ImageReference(repoName, ref), id)
+			delete(repoRefs, ref)
+		}
+		if len(repoRefs) == 0 {
+			delete(store.Repositories, repoName)
+		}
+	}
+	if err := store.save(); err != nil {
+		logrus.Errorf("Unable to untag the images on top of %s: %s", id, err)
+	}
+}
+
+// SetSigningKey sets a key to sign pushed manifests with in addition to the
+// key of the daemon, and the certificate chain certifying it, if any.
+func (store *TagStore) SetSigningKey(key libtrust.PrivateKey, chain []*x509.Certificate) {
+	store.signingKey = key
+	store.signingChain = chain
+}
+
 func (store *TagStore) save() error {
 	// Store the json ball
 	jsonData, err := json.Marshal(store)
@@ -178,20 +226,59 @@ func (store *TagStore) DeleteAll(id string) error {
 		return nil
 	}
 	for _, name := range names {
-		if strings.Contains(name, ":") {
-			nameParts := strings.Split(name, ":")
-			if _, err := store.Delete(nameParts[0], nameParts[1]); err != nil {
-				return err
-			}
-		} else {
-			if _, err := store.Delete(name, ""); err != nil {
-				return err
-			}
+		repoName, ref := parsers.ParseRepositoryTag(name)
+		if _, err := store.Delete(repoName, ref); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// ImageDigest returns the digest of the manifest the image referenced by name
+// was pulled or pushed with, or an empty string if the image has no digest.
+// Digests of the repository given in name are preferred to those of other
+// repositories the image is in.
+func (store *TagStore) ImageDigest(name, imgID string) string {
+	repoName, ref := parsers.ParseRepositoryTag(name)
+	if utils.DigestReference(ref) {
+		return ref
+	}
+
+	store.Lock()
+	defer store.Unlock()
+	repoName = registry.NormalizeLocalName(repoName)
+	if dgst := imageDigest(store.Repositories[repoName], imgID); dgst != "" {
+		return dgst
+	}
+	repoNames := make([]string, 0, len(store.Repositories))
+	for repoName := range store.Repositories {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+	for _, repoName := range repoNames {
+		if dgst := imageDigest(store.Repositories[repoName], imgID); dgst != "" {
+			return dgst
+		}
+	}
+	return ""
+}
+
+// imageDigest returns the first digest of the repository referencing the
+// image.
+func imageDigest(repo Repository, imgID string) string {
+	var digests []string
+	for ref, id := range repo {
+		if id == imgID && utils.DigestReference(ref) {
+			digests = append(digests, ref)
+		}
+	}
+	if len(digests) == 0 {
+		return ""
+	}
+	sort.Strings(digests)
+	return digests[0]
+}
+
 func (store *TagStore) Delete(repoName, ref string) (bool, error) {
 	store.Lock()
 	defer store.Unlock()
@@ -247,6 +334,10 @@ func (store *TagStore) SetLoad(repoName, tag, imageName string, force bool, out
 	if err := store.reload(); err != nil {
 		return err
 	}
+	// Images which layers failed to be extracted are untagged
+	if err := store.graph.LayerError(img.ID); err != nil {
+		return err
+	}
 	var repo Repository
 	repoName = registry.NormalizeLocalName(repoName)
 	if r, exists := store.Repositories[repoName]; exists {
@@ -267,6 +358,9 @@ func (store *TagStore) SetLoad(repoName, tag, imageName string, force bool, out
 		repo = make(map[string]string)
 		store.Repositories[repoName] = repo
 	}
+	if err := store.graph.AddRepository(img, repoName); err != nil {
+		return err
+	}
 	repo[tag] = img.ID
 	return store.save()
 }
@@ -292,6 +386,10 @@ func (store *TagStore) SetDigest(repoName, digest, imageName string) error {
 		return err
 	}
 
+	if err := store.graph.LayerError(img.ID); err != nil {
+		return err
+	}
+
 	repoName = registry.NormalizeLocalName(repoName)
 	repoRefs, exists := store.Repositories[repoName]
 	if !exists {
@@ -300,6 +398,9 @@ func (store *TagStore) SetDigest(repoName, digest, imageName string) error {
 	} else if oldID, exists := repoRefs[digest]; exists && oldID != img.ID {
 		return fmt.Errorf("Conflict: Digest %s is already set to image %s", digest, oldID)
 	}
+	if err := store.graph.AddRepository(img, repoName); err != nil {
+		return err
+	}
 
 	repoRefs[digest] = img.ID
 	return store.save()
@@ -341,6 +442,16 @@ func (store *TagStore) GetImage(repoName, refOrID string) (*image.Image, error)
 		}
 	}
 
+	// The image may be referenced by the ID it had before it was migrated
+	// to its content-addressable ID.
+	if img, err := store.graph.Get(refOrID); err == nil {
+		for _, revision := range repo {
+			if revision == img.ID {
+				return img, nil
+			}
+		}
+	}
+
 	return nil, nil
 }
 
@@ -413,6 +524,35 @@ func (store *TagStore) poolAdd(kind, key string) (chan struct{}, error) {
 	return c, nil
 }
 
+// PullingImages returns the IDs of the images held by the pulls in
+// progress, which may not be tagged yet.
+func (store *TagStore) PullingImages() map[string]bool {
+	store.Lock()
+	keys := make([]string, 0, len(store.pullingPool))
+	for key := range store.pullingPool {
+		keys = append(keys, key)
+	}
+	store.Unlock()
+
+	ids := make(map[string]bool)
+	for _, key := range keys {
+		var id string
+		switch {
+		case strings.HasPrefix(key, "img:"):
+			id = strings.TrimPrefix(key, "img:")
+		case strings.HasPrefix(key, "layer:"):
+			id = strings.TrimPrefix(key, "layer:")
+		default:
+			continue
+		}
+		// The pools of v1 pulls hold legacy IDs
+		if img, err := store.graph.Get(id); err == nil {
+			ids[img.ID] = true
+		}
+	}
+	return ids
+}
+
 func (store *TagStore) poolRemove(kind, key string) error {
 	store.Lock()
 	defer store.Unlock()
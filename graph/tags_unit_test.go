@@ -68,7 +68,7 @@ func mkTestTagStore(root string, t *testing.T) *TagStore {
 	if err != nil {
 		t.Fatal(err)
 	}
-	img := &image.Image{ID: testOfficialImageID}
+	img := &image.Image{ID: testOfficialImageID, Comment: testOfficialImageName}
 	if err := graph.Register(img, officialArchive); err != nil {
 		t.Fatal(err)
 	}
@@ -79,7 +79,7 @@ func mkTestTagStore(root string, t *testing.T) *TagStore {
 	if err != nil {
 		t.Fatal(err)
 	}
-	img = &image.Image{ID: testPrivateImageID}
+	img = &image.Image{ID: testPrivateImageID, Comment: testPrivateImageName}
 	if err := graph.Register(img, privateArchive); err != nil {
 		t.Fatal(err)
 	}
@@ -101,6 +101,17 @@ func TestLookupImage(t *testing.T) {
 	store := mkTestTagStore(tmp, t)
 	defer store.graph.driver.Cleanup()
 
+	// The images are registered under their content-addressable IDs, the
+	// IDs they were registered with remain valid references.
+	officialImg, err := store.graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateImg, err := store.graph.Get(testPrivateImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	officialLookups := []string{
 		testOfficialImageID,
 		testOfficialImageIDShort,
@@ -143,8 +154,8 @@ func TestLookupImage(t *testing.T) {
 			t.Errorf("Error looking up %s: %s", name, err)
 		} else if img == nil {
 			t.Errorf("Expected 1 image, none found: %s", name)
-		} else if img.ID != testOfficialImageID {
-			t.Errorf("Expected ID '%s' found '%s'", testOfficialImageID, img.ID)
+		} else if img.ID != officialImg.ID {
+			t.Errorf("Expected ID '%s' found '%s'", officialImg.ID, img.ID)
 		}
 	}
 
@@ -153,8 +164,8 @@ func TestLookupImage(t *testing.T) {
 			t.Errorf("Error looking up %s: %s", name, err)
 		} else if img == nil {
 			t.Errorf("Expected 1 image, none found: %s", name)
-		} else if img.ID != testPrivateImageID {
-			t.Errorf("Expected ID '%s' found '%s'", testPrivateImageID, img.ID)
+		} else if img.ID != privateImg.ID {
+			t.Errorf("Expected ID '%s' found '%s'", privateImg.ID, img.ID)
 		}
 	}
 
@@ -171,12 +182,93 @@ func TestLookupImage(t *testing.T) {
 			t.Errorf("Error looking up %s: %s", name, err)
 		} else if img == nil {
 			t.Errorf("Expected 1 image, none found: %s", name)
-		} else if img.ID != testPrivateImageID {
-			t.Errorf("Expected ID '%s' found '%s'", testPrivateImageID, img.ID)
+		} else if img.ID != privateImg.ID {
+			t.Errorf("Expected ID '%s' found '%s'", privateImg.ID, img.ID)
 		}
 	}
 }
 
+func TestImageDigest(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	privateImg, err := store.graph.Get(testPrivateImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	officialImg, err := store.graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name, id, digest string
+	}{
+		{testPrivateImageName + "@" + testPrivateImageDigest, privateImg.ID, testPrivateImageDigest},
+		{testPrivateImageName, privateImg.ID, testPrivateImageDigest},
+		{testPrivateImageName + ":" + DEFAULTTAG, privateImg.ID, testPrivateImageDigest},
+		{testPrivateImageIDShort, privateImg.ID, testPrivateImageDigest},
+		{testOfficialImageName, officialImg.ID, ""},
+	} {
+		if dgst := store.ImageDigest(test.name, test.id); dgst != test.digest {
+			t.Errorf("Expected the digest of %s to be %q, got %q", test.name, test.digest, dgst)
+		}
+	}
+
+	// The digest remains the one of the image after the tag moves.
+	if err := store.Set(testPrivateImageName, DEFAULTTAG, testOfficialImageID, true); err != nil {
+		t.Fatal(err)
+	}
+	if dgst := store.ImageDigest(testPrivateImageName, officialImg.ID); dgst != "" {
+		t.Errorf("Expected no digest for the retagged image, got %q", dgst)
+	}
+	if img, err := store.LookupImage(testPrivateImageName + "@" + testPrivateImageDigest); err != nil || img.ID != privateImg.ID {
+		t.Errorf("Expected the digest to reference %s, got %v, %v", privateImg.ID, img, err)
+	}
+
+	if err := store.DeleteAll(privateImg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if refs := store.ByID()[privateImg.ID]; len(refs) != 0 {
+		t.Errorf("Expected all the references of %s to be deleted, got %v", privateImg.ID, refs)
+	}
+}
+
+func TestImageRepositories(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	img, err := store.graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("foo", "", img.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteAll(img.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The repositories remain recorded once the image is untagged
+	repoNames, err := store.graph.Repositories(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repoNames) != 2 || repoNames[0] != "foo" || repoNames[1] != testOfficialImageName {
+		t.Fatalf("Expected the image to be recorded in foo and %s, got %v", testOfficialImageName, repoNames)
+	}
+}
+
 func TestValidTagName(t *testing.T) {
 	validTags := []string{"9", "foo", "foo-test", "bar.baz.boo"}
 	for _, tag := range validTags {
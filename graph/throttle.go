@@ -0,0 +1,99 @@
+package graph
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthChunkSize is the most bytes read at once from a transfer limited
+// by a bandwidthLimiter, so that the rate stays smooth.
+const bandwidthChunkSize = 32 * 1024
+
+// transferSlots bounds the number of transfers running at the same time. A
+// nil transferSlots doesn't bound them.
+type transferSlots chan struct{}
+
+func newTransferSlots(max int) transferSlots {
+	if max <= 0 {
+		return nil
+	}
+	return make(transferSlots, max)
+}
+
+// acquire blocks until a transfer can start.
+func (ts transferSlots) acquire() {
+	if ts != nil {
+		ts <- struct{}{}
+	}
+}
+
+// release is called once a transfer is over.
+func (ts transferSlots) release() {
+	if ts != nil {
+		<-ts
+	}
+}
+
+// bandwidthLimiter caps the rate of all the transfers sharing it.
+type bandwidthLimiter struct {
+	sync.Mutex
+	rate int64 // bytes per second
+	// next is when the bytes allowed so far will have been transferred at
+	// the rate.
+	next time.Time
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: rate}
+}
+
+// wait blocks until n more bytes may be transferred.
+func (l *bandwidthLimiter) wait(n int) {
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.Unlock()
+	time.Sleep(delay)
+}
+
+// bandwidthReader reads from a transfer at the rate of its limiter.
+type bandwidthReader struct {
+	io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (br *bandwidthReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthChunkSize {
+		p = p[:bandwidthChunkSize]
+	}
+	n, err := br.ReadCloser.Read(p)
+	if n > 0 {
+		br.limiter.wait(n)
+	}
+	return n, err
+}
+
+// SetTransferLimits bounds the number of layers downloaded and uploaded at
+// the same time by all the pulls and pushes of the daemon, and the bandwidth
+// they share, in bytes per second. Zero doesn't bound them.
+func (store *TagStore) SetTransferLimits(maxDownloads, maxUploads int, bandwidth int64) {
+	store.downloadSlots = newTransferSlots(maxDownloads)
+	store.uploadSlots = newTransferSlots(maxUploads)
+	store.bandwidth = newBandwidthLimiter(bandwidth)
+}
+
+// limitBandwidth returns rc reading at the bandwidth of the daemon.
+func (store *TagStore) limitBandwidth(rc io.ReadCloser) io.ReadCloser {
+	if store.bandwidth == nil {
+		return rc
+	}
+	return &bandwidthReader{ReadCloser: rc, limiter: store.bandwidth}
+}
@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransferSlots(t *testing.T) {
+	var (
+		slots   = newTransferSlots(2)
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running int
+		max     int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots.acquire()
+			defer slots.release()
+			mu.Lock()
+			if running++; running > max {
+				max = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if max != 2 {
+		t.Fatalf("Expected 2 transfers at most at the same time, got %d", max)
+	}
+
+	// Unbounded slots never block.
+	unbounded := newTransferSlots(0)
+	for i := 0; i < 10; i++ {
+		unbounded.acquire()
+	}
+}
+
+func TestLimitBandwidth(t *testing.T) {
+	store := &TagStore{}
+	data := bytes.Repeat([]byte("a"), 200*1024)
+
+	rc := ioutil.NopCloser(bytes.NewReader(data))
+	if store.limitBandwidth(rc) != rc {
+		t.Fatal("Expected the bandwidth not to be limited by default")
+	}
+
+	store.SetTransferLimits(0, 0, 1024*1024)
+	start := time.Now()
+	var wg sync.WaitGroup
+	// Two transfers share the bandwidth, taking 400ms at 1MB/s.
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := ioutil.ReadAll(store.limitBandwidth(ioutil.NopCloser(bytes.NewReader(data))))
+			if err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(b, data) {
+				t.Error("Expected the data to be read unchanged")
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("Expected the transfers to take about 400ms, took %s", elapsed)
+	}
+}
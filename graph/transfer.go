@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/registry"
+)
+
+// maxTransferAttempts is how many times a blob download or upload is
+// attempted in a row before giving up.
+const maxTransferAttempts = 5
+
+var (
+	// transferRetryDelay is the delay before the first retry of a blob
+	// transfer, doubled for every further retry up to maxTransferRetryDelay.
+	transferRetryDelay    = 500 * time.Millisecond
+	maxTransferRetryDelay = 30 * time.Second
+)
+
+// transferRetrier keeps track of the failed attempts of a blob transfer.
+type transferRetrier struct {
+	failures int
+	delay    time.Duration
+}
+
+// retry reports a failed attempt of the transfer, and waits before the next
+// one. It returns false if the transfer must be given up.
+func (rt *transferRetrier) retry(err error, action string, out io.Writer, sf *streamformatter.StreamFormatter, progressID string) bool {
+	rt.failures++
+	if rt.failures == maxTransferAttempts || !isRetryableTransferError(err) {
+		return false
+	}
+	if rt.delay == 0 {
+		rt.delay = transferRetryDelay
+	}
+	logrus.Infof("%s of %s failed, retrying in %s: %s", action, progressID, rt.delay, err)
+	out.Write(sf.FormatProgress(progressID, fmt.Sprintf("%s failed, retrying in %s", action, rt.delay), nil))
+	time.Sleep(rt.delay)
+	if rt.delay *= 2; rt.delay > maxTransferRetryDelay {
+		rt.delay = maxTransferRetryDelay
+	}
+	return true
+}
+
+// reset is called once the transfer makes progress again.
+func (rt *transferRetrier) reset() {
+	rt.failures = 0
+	rt.delay = 0
+}
+
+// isRetryableTransferError returns false for the errors which transferring
+// the blob again won't fix, such as a missing blob or a failed login.
+func isRetryableTransferError(err error) bool {
+	if jerr, ok := err.(*jsonmessage.JSONError); ok {
+		// 416 is returned for a chunk which doesn't start where the
+		// registry expects it to, the upload resumes from the right offset.
+		return jerr.Code >= 500 || jerr.Code == 416
+	}
+	return err != registry.ErrLoginRequired
+}
@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/progressreader"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/registry"
+)
+
+// uploadChunkSize is the size of the chunks blobs are uploaded in. A failed
+// upload resumes from the last chunk the registry received.
+var uploadChunkSize int64 = 10 << 20
+
+// uploadV2Blob uploads the blob of the given size and digest in chunks,
+// resuming the upload where the registry says it stopped when a chunk
+// fails.
+func (s *TagStore) uploadV2Blob(r *registry.Session, endpoint *registry.Endpoint, imageName string, dgst digest.Digest, blob io.ReadSeeker, size int64, auth *registry.RequestAuthorization, out io.Writer, sf *streamformatter.StreamFormatter, progressID string) error {
+	location, err := r.InitiateV2ImageBlobUpload(endpoint, imageName, auth)
+	if err != nil {
+		return err
+	}
+
+	var (
+		offset  int64
+		resync  bool
+		retrier transferRetrier
+	)
+	for {
+		if resync {
+			// The registry may have received part of the failed chunk, or
+			// even all of it if only its response was lost.
+			next, received, err := r.GetV2ImageBlobUploadStatus(location, auth)
+			if err != nil {
+				if !retrier.retry(err, "Upload", out, sf, progressID) {
+					return err
+				}
+				continue
+			}
+			logrus.Debugf("Resuming upload of %s at byte %d", dgst, received)
+			location, offset, resync = next, received, false
+		}
+		if offset >= size {
+			break
+		}
+
+		n := size - offset
+		if n > uploadChunkSize {
+			n = uploadChunkSize
+		}
+		if _, err := blob.Seek(offset, 0); err != nil {
+			return err
+		}
+		next, err := r.PatchV2ImageBlob(location, offset, n, progressreader.New(progressreader.Config{
+			In:         s.limitBandwidth(ioutil.NopCloser(io.LimitReader(blob, n))),
+			Out:        out,
+			Formatter:  sf,
+			Size:       int(size),
+			Current:    int(offset),
+			LastUpdate: int(offset),
+			NewLines:   false,
+			ID:         progressID,
+			Action:     "Pushing",
+		}), auth)
+		if err != nil {
+			if !retrier.retry(err, "Upload", out, sf, progressID) {
+				return err
+			}
+			resync = true
+			continue
+		}
+		location, offset = next, offset+n
+		retrier.reset()
+	}
+	return r.CompleteV2ImageBlobUpload(location, dgst.Algorithm(), dgst.Hex(), auth)
+}
@@ -0,0 +1,266 @@
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// flakyUploadServer accepts chunked blob uploads to foo/bar, dropping the
+// connection while receiving the chunks listed in drop, or right after
+// receiving those listed in dropResponse. Blobs of foo/source can be mounted,
+// unless mountStatus is set, which mount requests are answered with.
+type flakyUploadServer struct {
+	sync.Mutex
+	received     []byte
+	blob         []byte
+	patches      int
+	drop         map[int]bool
+	dropResponse map[int]bool
+	sourceBlobs  map[string]bool
+	mountStatus  int
+	mounted      []string
+}
+
+func (s *flakyUploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	const location = "/v2/foo/bar/blobs/uploads/upload-1"
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v2/foo/bar/blobs/uploads/":
+		if mount := r.URL.Query().Get("mount"); mount != "" {
+			if s.mountStatus != 0 {
+				w.WriteHeader(s.mountStatus)
+				return
+			}
+			if r.URL.Query().Get("from") == "foo/source" && s.sourceBlobs[mount] {
+				s.mounted = append(s.mounted, mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		s.received = nil
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == "PATCH" && r.URL.Path == location:
+		s.patches++
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Content-Range"), "%d-%d", &start, &end)
+		if start != len(s.received) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if s.drop[s.patches] {
+			// Receive half of the chunk and drop the connection.
+			chunk := make([]byte, (end-start+1)/2)
+			n, _ := io.ReadFull(r.Body, chunk)
+			s.received = append(s.received, chunk[:n]...)
+			s.hangUp(w)
+			return
+		}
+		chunk, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		s.received = append(s.received, chunk...)
+		if s.dropResponse[s.patches] {
+			s.hangUp(w)
+			return
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == "GET" && r.URL.Path == location:
+		w.Header().Set("Location", location)
+		if len(s.received) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(s.received)-1))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT" && r.URL.Path == location:
+		dgst, err := digest.FromBytes(s.received)
+		if err != nil || r.URL.Query().Get("digest") != dgst.String() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blob = s.received
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *flakyUploadServer) hangUp(w http.ResponseWriter) {
+	if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+func testUploadV2Blob(t *testing.T, server *flakyUploadServer, blob []byte) error {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	ts, endpoint, session, auth := newTestRegistrySession(t, server)
+	defer ts.Close()
+
+	defer func(chunkSize int64, delay time.Duration) {
+		uploadChunkSize, transferRetryDelay = chunkSize, delay
+	}(uploadChunkSize, transferRetryDelay)
+	uploadChunkSize, transferRetryDelay = 256<<10, time.Millisecond
+
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	return store.uploadV2Blob(session, endpoint, "foo/bar", dgst, bytes.NewReader(blob), int64(len(blob)), auth, out, streamformatter.NewStreamFormatter(false), "blob")
+}
+
+func TestUploadV2BlobInChunks(t *testing.T) {
+	server := &flakyUploadServer{}
+	blob := testBlob(t)
+	if err := testUploadV2Blob(t, server, blob); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(server.blob, blob) {
+		t.Fatal("Uploaded blob differs from the pushed blob")
+	}
+	if server.patches != 4 {
+		t.Fatalf("Expected 4 chunks, got %d", server.patches)
+	}
+}
+
+func TestUploadV2BlobResumes(t *testing.T) {
+	server := &flakyUploadServer{
+		drop:         map[int]bool{2: true, 3: true},
+		dropResponse: map[int]bool{5: true},
+	}
+	blob := testBlob(t)
+	if err := testUploadV2Blob(t, server, blob); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(server.blob, blob) {
+		t.Fatal("Uploaded blob differs from the pushed blob")
+	}
+	// The chunks dropped halfway are resumed from where they were dropped,
+	// the chunk whose response was lost isn't sent again.
+	if server.patches != 5 {
+		t.Fatalf("Expected 5 chunks, got %d", server.patches)
+	}
+}
+
+func TestUploadV2BlobGivesUp(t *testing.T) {
+	drop := make(map[int]bool)
+	for i := 1; i <= maxTransferAttempts; i++ {
+		drop[i] = true
+	}
+	server := &flakyUploadServer{drop: drop}
+	if err := testUploadV2Blob(t, server, testBlob(t)); err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+	if server.patches != maxTransferAttempts {
+		t.Fatalf("Expected %d attempts, got %d", maxTransferAttempts, server.patches)
+	}
+}
+
+func TestMountV2Blob(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	dgst := digest.Digest("sha256:" + strings.Repeat("a", 64))
+	server := &flakyUploadServer{sourceBlobs: map[string]bool{dgst.String(): true}}
+	ts, endpoint, session, _ := newTestRegistrySession(t, server)
+	defer ts.Close()
+
+	img, err := store.graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoInfo := &registry.RepositoryInfo{
+		Index:      &registry.IndexInfo{Name: endpoint.URL.Host},
+		RemoteName: "foo/bar",
+	}
+
+	// The blob isn't known to be in another repository of the registry.
+	if err := store.graph.AddBlobSource(img, "registry.example.com/foo/source"); err != nil {
+		t.Fatal(err)
+	}
+	if mounted, err := store.mountV2Blob(session, img, endpoint, repoInfo, dgst); err != nil {
+		t.Fatal(err)
+	} else if mounted {
+		t.Fatal("Expected the blob not to be mounted")
+	}
+
+	for _, source := range []string{endpoint.URL.Host + "/foo/other", endpoint.URL.Host + "/foo/source"} {
+		if err := store.graph.AddBlobSource(img, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mounted, err := store.mountV2Blob(session, img, endpoint, repoInfo, dgst); err != nil {
+		t.Fatal(err)
+	} else if !mounted {
+		t.Fatal("Expected the blob to be mounted")
+	}
+	if len(server.mounted) != 1 || server.mounted[0] != dgst.String() {
+		t.Fatalf("Expected %s to be mounted, got %v", dgst, server.mounted)
+	}
+}
+
+func TestPushV2LayerMountRefused(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		tmp, err := utils.TestDirectory("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		store := mkTestTagStore(tmp, t)
+		defer store.graph.driver.Cleanup()
+
+		dgst := digest.Digest("sha256:" + strings.Repeat("a", 64))
+		server := &flakyUploadServer{sourceBlobs: map[string]bool{dgst.String(): true}, mountStatus: status}
+		ts, endpoint, session, auth := newTestRegistrySession(t, server)
+		defer ts.Close()
+
+		img, err := store.graph.Get(testOfficialImageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repoInfo := &registry.RepositoryInfo{
+			Index:      &registry.IndexInfo{Name: endpoint.URL.Host},
+			RemoteName: "foo/bar",
+		}
+		if err := store.graph.AddBlobSource(img, endpoint.URL.Host+"/foo/source"); err != nil {
+			t.Fatal(err)
+		}
+
+		// The layer is uploaded when it can't be mounted
+		out := &bytes.Buffer{}
+		if _, err := store.pushV2Layer(session, img, endpoint, repoInfo, dgst.String(), auth, out, streamformatter.NewStreamFormatter(false)); err != nil {
+			t.Fatalf("Expected the push to succeed when the mount gets a %d, got %v", status, err)
+		}
+		if len(server.mounted) != 0 || server.blob == nil {
+			t.Fatalf("Expected the blob to be uploaded when the mount gets a %d, mounted %v", status, server.mounted)
+		}
+	}
+}
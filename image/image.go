@@ -1,15 +1,20 @@
 package image
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/tarsum"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/docker/utils"
 )
@@ -31,9 +36,29 @@ type Image struct {
 	Config          *runconfig.Config `json:"config,omitempty"`
 	Architecture    string            `json:"architecture,omitempty"`
 	OS              string            `json:"os,omitempty"`
-	Size            int64
+	// LayerDigest is the tarsum of the filesystem layer. Unlike a digest of
+	// the archive itself, it doesn't change when the storage driver exports
+	// the layer again. It is part of the image's content-addressable ID.
+	LayerDigest digest.Digest `json:"layer_digest,omitempty"`
+	// Steps are the steps which created the image when its layer is the
+	// squashed layers of several steps, the last step being the image's own.
+	Steps []Step `json:"history,omitempty"`
+	Size  int64
 
 	graph Graph
+	// layerID is the ID of the image's layer in the storage driver, which
+	// is the image ID for images registered before IDs were derived from
+	// their content.
+	layerID string
+}
+
+// Step is a step of the creation of an image, like the commit of a
+// Dockerfile instruction.
+type Step struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
 }
 
 func LoadImage(root string) (*Image, error) {
@@ -73,23 +98,96 @@ func LoadImage(root string) (*Image, error) {
 		img.Size = int64(size)
 	}
 
+	if buf, err := ioutil.ReadFile(path.Join(root, "layerid")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		img.layerID = string(buf)
+	}
+
 	return img, nil
 }
 
+// LayerDigest returns the digest of the uncompressed filesystem layer read
+// from layer, as recorded in the LayerDigest field of an image.
+func LayerDigest(layer io.Reader) (digest.Digest, error) {
+	ts, err := tarsum.NewTarSum(layer, true, tarsum.Version1)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return "", err
+	}
+	return digest.Digest(ts.Sum(nil)), nil
+}
+
+// ComputeID returns the content-addressable ID of the image: the sha256 of
+// its config, which references the parent by its own content-addressable ID
+// and the filesystem layer by its digest.
+func ComputeID(img *Image) (string, error) {
+	config := *img
+	config.ID = ""
+	config.Size = 0
+	buf, err := json.Marshal(&config)
+	if err != nil {
+		return "", err
+	}
+	dgst, err := digest.FromBytes(buf)
+	if err != nil {
+		return "", err
+	}
+	return dgst.Hex(), nil
+}
+
 // StoreImage stores file system layer data for the given image to the
-// image's registered storage driver. Image metadata is stored in a file
-// at the specified root directory.
+// image's registered storage driver and sets the image's ID to its
+// content-addressable ID. Image metadata is stored in a file at the
+// specified root directory.
 func StoreImage(img *Image, layerData archive.ArchiveReader, root string) (err error) {
+	parentLayerID, err := img.ParentLayerID()
+	if err != nil {
+		return err
+	}
 	// Store the layer. If layerData is not nil, unpack it into the new layer
-	if layerData != nil {
-		if img.Size, err = img.graph.Driver().ApplyDiff(img.ID, img.Parent, layerData); err != nil {
+	// while computing its digest.
+	if layerData == nil {
+		if img.LayerDigest, err = LayerDigest(bytes.NewReader(nil)); err != nil {
+			return err
+		}
+	} else {
+		decompressed, err := archive.DecompressStream(layerData)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		layer, err := tarsum.NewTarSum(decompressed, true, tarsum.Version1)
+		if err != nil {
+			return err
+		}
+		if img.Size, err = img.graph.Driver().ApplyDiff(img.LayerID(), parentLayerID, layer); err != nil {
+			return err
+		}
+		// The driver may stop reading at the end-of-archive marker.
+		if _, err := io.Copy(ioutil.Discard, layer); err != nil {
 			return err
 		}
+		img.LayerDigest = digest.Digest(layer.Sum(nil))
 	}
+	if img.ID, err = ComputeID(img); err != nil {
+		return err
+	}
+	return img.SaveMetadata(root)
+}
 
+// SaveMetadata stores the image's metadata in the directory root.
+func (img *Image) SaveMetadata(root string) error {
 	if err := img.SaveSize(root); err != nil {
 		return err
 	}
+	if err := ioutil.WriteFile(path.Join(root, "layerid"), []byte(img.LayerID()), 0600); err != nil {
+		return fmt.Errorf("Error storing layer id in %s/layerid: %s", root, err)
+	}
 
 	f, err := os.OpenFile(jsonPath(root), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
 	if err != nil {
@@ -105,6 +203,31 @@ func (img *Image) SetGraph(graph Graph) {
 	img.graph = graph
 }
 
+// LayerID returns the ID of the image's filesystem layer in the storage
+// driver.
+func (img *Image) LayerID() string {
+	if img.layerID == "" {
+		return img.ID
+	}
+	return img.layerID
+}
+
+// SetLayerID sets the ID of the image's filesystem layer in the storage
+// driver.
+func (img *Image) SetLayerID(id string) {
+	img.layerID = id
+}
+
+// ParentLayerID returns the storage driver ID of the parent's filesystem
+// layer, or an empty string if the image has no parent.
+func (img *Image) ParentLayerID() (string, error) {
+	parent, err := img.GetParent()
+	if err != nil || parent == nil {
+		return "", err
+	}
+	return parent.LayerID(), nil
+}
+
 // SaveSize stores the current `size` value of `img` in the directory `root`.
 func (img *Image) SaveSize(root string) error {
 	if err := ioutil.WriteFile(path.Join(root, "layersize"), []byte(strconv.Itoa(int(img.Size))), 0600); err != nil {
@@ -131,6 +254,84 @@ func (img *Image) GetCheckSum(root string) (string, error) {
 	return string(cs), err
 }
 
+// SaveIncomplete records whether the layer of the image was registered
+// before being fully extracted.
+func (img *Image) SaveIncomplete(root string, incomplete bool) error {
+	if !incomplete {
+		if err := os.Remove(path.Join(root, "incomplete")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := ioutil.WriteFile(path.Join(root, "incomplete"), nil, 0600); err != nil {
+		return fmt.Errorf("Error storing incomplete in %s/incomplete: %s", root, err)
+	}
+	return nil
+}
+
+// IsIncomplete returns whether the layer of the image was registered before
+// being fully extracted, and still is.
+func (img *Image) IsIncomplete(root string) bool {
+	_, err := os.Stat(path.Join(root, "incomplete"))
+	return err == nil
+}
+
+// SaveBlobSources records the repositories, e.g. registry.example.com/foo/bar,
+// in which the layer is stored as a blob.
+func (img *Image) SaveBlobSources(root string, sources []string) error {
+	buf, err := json.Marshal(sources)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(root, "blobsources"), buf, 0600); err != nil {
+		return fmt.Errorf("Error storing blob sources in %s/blobsources: %s", root, err)
+	}
+	return nil
+}
+
+func (img *Image) GetBlobSources(root string) ([]string, error) {
+	buf, err := ioutil.ReadFile(path.Join(root, "blobsources"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sources []string
+	if err := json.Unmarshal(buf, &sources); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// SaveSignedManifests records the signed manifests the image was pulled
+// with, by repository.
+func (img *Image) SaveSignedManifests(root string, manifests map[string][]byte) error {
+	buf, err := json.Marshal(manifests)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(root, "signedmanifests"), buf, 0600); err != nil {
+		return fmt.Errorf("Error storing signed manifests in %s/signedmanifests: %s", root, err)
+	}
+	return nil
+}
+
+func (img *Image) GetSignedManifests(root string) (map[string][]byte, error) {
+	buf, err := ioutil.ReadFile(path.Join(root, "signedmanifests"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	manifests := make(map[string][]byte)
+	if err := json.Unmarshal(buf, &manifests); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
 func jsonPath(root string) string {
 	return path.Join(root, "json")
 }
@@ -157,7 +358,11 @@ func (img *Image) TarLayer() (arch archive.Archive, err error) {
 
 	driver := img.graph.Driver()
 
-	return driver.Diff(img.ID, img.Parent)
+	parentLayerID, err := img.ParentLayerID()
+	if err != nil {
+		return nil, err
+	}
+	return driver.Diff(img.LayerID(), parentLayerID)
 }
 
 // Image includes convenience proxy functions to its graph
@@ -192,6 +397,20 @@ func (img *Image) WalkHistory(handler func(*Image) error) (err error) {
 	return nil
 }
 
+// CreationSteps returns the steps which created the image, oldest first:
+// its Steps, or its own step if its layer wasn't squashed.
+func (img *Image) CreationSteps() []Step {
+	if len(img.Steps) > 0 {
+		return img.Steps
+	}
+	return []Step{{
+		Created:   img.Created,
+		CreatedBy: strings.Join(img.ContainerConfig.Cmd, " "),
+		Author:    img.Author,
+		Comment:   img.Comment,
+	}}
+}
+
 func (img *Image) GetParent() (*Image, error) {
 	if img.Parent == "" {
 		return nil, nil
@@ -5627,3 +5627,44 @@ func TestBuildEmptyStringVolume(t *testing.T) {
 
 	logDone("build - empty string volume")
 }
+
+func TestBuildSquash(t *testing.T) {
+	name := "testbuildsquash"
+	defer deleteImages(name)
+	buildCmd := exec.Command(dockerBinary, "build", "--squash", "-t", name, "-")
+	buildCmd.Stdin = strings.NewReader(`FROM busybox
+RUN echo added > /added && echo transient > /transient
+RUN rm /transient /etc/passwd
+CMD ["cat", "/added"]`)
+	if out, _, err := runCommandWithOutput(buildCmd); err != nil {
+		t.Fatalf("failed to build the image: %s, %v", out, err)
+	}
+
+	busyboxID, err := getIDByName("busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := inspectField(name, "Parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent != busyboxID {
+		t.Fatalf("Expected the layers to be squashed on top of busybox %s, got %s", busyboxID, parent)
+	}
+
+	out, _, _ := dockerCmd(t, "run", "--rm", name)
+	if strings.TrimSpace(out) != "added" {
+		t.Fatalf("Expected the added file in the squashed layer, got %q", out)
+	}
+	out, _, _ = dockerCmd(t, "run", "--rm", name, "sh", "-c", "ls /transient /etc/passwd || echo deleted")
+	if !strings.HasSuffix(strings.TrimSpace(out), "deleted") {
+		t.Fatalf("Expected the deleted files not to exist, got %q", out)
+	}
+
+	out, _, _ = dockerCmd(t, "history", "--no-trunc", name)
+	if !strings.Contains(out, "<missing>") || !strings.Contains(out, "echo added > /added") || !strings.Contains(out, "rm /transient /etc/passwd") {
+		t.Fatalf("Expected the instructions of the squashed layers in the history, got %s", out)
+	}
+
+	logDone("build - squash the layers since the base image")
+}
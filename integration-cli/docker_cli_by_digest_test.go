@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -533,3 +536,148 @@ func TestDeleteImageByIDOnlyPulledByDigest(t *testing.T) {
 
 	logDone("by_digest - delete image by id only pulled by digest")
 }
+
+func TestHistoryByDigest(t *testing.T) {
+	defer setupRegistry(t)()
+
+	digest, err := setupImage()
+	if err != nil {
+		t.Fatalf("error setting up image: %v", err)
+	}
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, digest)
+	c := exec.Command(dockerBinary, "pull", imageReference)
+	out, _, err := runCommandWithOutput(c)
+	if err != nil {
+		t.Fatalf("error pulling by digest: %s, %v", out, err)
+	}
+	defer deleteImages(imageReference)
+
+	c = exec.Command(dockerBinary, "history", "-q", "--no-trunc", imageReference)
+	out, _, err = runCommandWithOutput(c)
+	if err != nil {
+		t.Fatalf("error getting the history by digest: %s, %v", out, err)
+	}
+	imageID, err := inspectField(imageReference, "Id")
+	if err != nil {
+		t.Fatalf("error getting image id: %v", err)
+	}
+	if !strings.HasPrefix(out, imageID) {
+		t.Fatalf("expected the history to start with %s, got %s", imageID, out)
+	}
+
+	logDone("by_digest - history by digest")
+}
+
+func TestTagToDigestFails(t *testing.T) {
+	c := exec.Command(dockerBinary, "tag", "busybox", "busybox@sha256:16a1e1bfa7a5c0d7d3bab7f1fc3d2af8a2d6b43d3e0ab9bbbea2fd27c1bf9cc8")
+	out, _, err := runCommandWithOutput(c)
+	if err == nil {
+		t.Fatalf("expected tagging with a digest to fail: %s", out)
+	}
+	if !strings.Contains(out, "refusing to create a tag with a digest reference") {
+		t.Fatalf("unexpected error tagging with a digest: %s", out)
+	}
+
+	logDone("by_digest - tag to digest fails")
+}
+
+func TestSaveLoadByDigest(t *testing.T) {
+	defer setupRegistry(t)()
+
+	digest, err := setupImage()
+	if err != nil {
+		t.Fatalf("error setting up image: %v", err)
+	}
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, digest)
+	c := exec.Command(dockerBinary, "pull", imageReference)
+	out, _, err := runCommandWithOutput(c)
+	if err != nil {
+		t.Fatalf("error pulling by digest: %s, %v", out, err)
+	}
+	defer deleteImages(imageReference)
+
+	imageID, err := inspectField(imageReference, "Id")
+	if err != nil {
+		t.Fatalf("error getting image id: %v", err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "save-load-by-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tarFile := filepath.Join(tmpDir, "image.tar")
+
+	c = exec.Command(dockerBinary, "save", "--output", tarFile, imageReference)
+	if out, _, err := runCommandWithOutput(c); err != nil {
+		t.Fatalf("error saving by digest: %s, %v", out, err)
+	}
+	if err := deleteImages(imageReference); err != nil {
+		t.Fatalf("error deleting image by digest: %v", err)
+	}
+	c = exec.Command(dockerBinary, "load", "--input", tarFile)
+	if out, _, err := runCommandWithOutput(c); err != nil {
+		t.Fatalf("error loading by digest: %s, %v", out, err)
+	}
+
+	loadedID, err := inspectField(imageReference, "Id")
+	if err != nil {
+		t.Fatalf("error getting the id of the loaded image: %v", err)
+	}
+	if loadedID != imageID {
+		t.Fatalf("expected the loaded image id %s, got %s", imageID, loadedID)
+	}
+
+	logDone("by_digest - save and load by digest")
+}
+
+func TestPsShowsImageDigest(t *testing.T) {
+	defer setupRegistry(t)()
+
+	digest, err := setupImage()
+	if err != nil {
+		t.Fatalf("error setting up image: %v", err)
+	}
+
+	// pull by tag, the container records the digest of the pulled image
+	c := exec.Command(dockerBinary, "pull", repoName)
+	out, _, err := runCommandWithOutput(c)
+	if err != nil {
+		t.Fatalf("error pulling by tag: %s, %v", out, err)
+	}
+	defer deleteImages(repoName)
+
+	containerName := "psbydigest"
+	c = exec.Command(dockerBinary, "create", "--name", containerName, repoName)
+	if out, _, err := runCommandWithOutput(c); err != nil {
+		t.Fatalf("error creating container: %s, %v", out, err)
+	}
+	defer deleteContainer(containerName)
+
+	// move the tag, the container keeps the digest it was created from
+	c = exec.Command(dockerBinary, "tag", "-f", "busybox", repoName)
+	if out, _, err := runCommandWithOutput(c); err != nil {
+		t.Fatalf("error moving the tag: %s, %v", out, err)
+	}
+
+	res, err := inspectField(containerName, "ImageDigest")
+	if err != nil {
+		t.Fatalf("failed to get ImageDigest: %v", err)
+	}
+	if res != digest {
+		t.Fatalf("unexpected ImageDigest: %s (expected %s)", res, digest)
+	}
+
+	c = exec.Command(dockerBinary, "ps", "-a", "--digests")
+	out, _, err = runCommandWithOutput(c)
+	if err != nil {
+		t.Fatalf("error listing containers: %s, %v", out, err)
+	}
+	if !strings.Contains(out, "DIGEST") || !strings.Contains(out, digest) {
+		t.Fatalf("expected the digest %s to be listed: %s", digest, out)
+	}
+
+	logDone("by_digest - ps shows image digest")
+}
@@ -279,3 +279,32 @@ func TestCommitChange(t *testing.T) {
 
 	logDone("commit - commit --change")
 }
+
+func TestCommitSquash(t *testing.T) {
+	out, _, _ := dockerCmd(t, "run", "-d", "busybox", "sh", "-c", "echo foo > /foo && rm /etc/passwd")
+	containerID := strings.TrimSpace(out)
+	defer deleteContainer(containerID)
+	dockerCmd(t, "wait", containerID)
+
+	dockerCmd(t, "commit", "--squash", containerID, "testcommitsquash")
+	defer deleteImages("testcommitsquash")
+	if parent, err := inspectField("testcommitsquash", "Parent"); err != nil || parent != "" {
+		t.Fatalf("Expected the squashed image to have no parent, got %q, %v", parent, err)
+	}
+	out, _, _ = dockerCmd(t, "run", "--rm", "testcommitsquash", "sh", "-c", "cat /foo; ls /etc/passwd || echo deleted")
+	if !strings.HasPrefix(out, "foo") || !strings.HasSuffix(strings.TrimSpace(out), "deleted") {
+		t.Fatalf("Expected the changes of the container in the squashed image, got %q", out)
+	}
+
+	dockerCmd(t, "commit", "--squash-parent", "busybox", containerID, "testcommitsquashparent")
+	defer deleteImages("testcommitsquashparent")
+	busyboxID, err := getIDByName("busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent, err := inspectField("testcommitsquashparent", "Parent"); err != nil || parent != busyboxID {
+		t.Fatalf("Expected the squashed image to have the parent %s, got %q, %v", busyboxID, parent, err)
+	}
+
+	logDone("commit - squash the layers of the container")
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -896,3 +897,79 @@ func TestDaemonwithwrongkey(t *testing.T) {
 	os.Remove("/etc/docker/key.json")
 	logDone("daemon - it should be failed to start daemon with wrong key")
 }
+
+func TestDaemonUserlandProxyModes(t *testing.T) {
+	for _, userlandProxy := range []bool{true, false} {
+		d := NewDaemon(t)
+		if err := d.StartWithBusybox(fmt.Sprintf("--userland-proxy=%v", userlandProxy)); err != nil {
+			t.Fatalf("Could not start daemon with --userland-proxy=%v: %v", userlandProxy, err)
+		}
+
+		if out, err := d.Cmd("run", "-d", "--name", "server", "-p", "127.0.0.1:8765:80", "busybox", "sh", "-c", "while true; do echo hello | nc -l -p 80; done"); err != nil {
+			d.Stop()
+			t.Fatalf("Could not run server: err=%v\n%s", err, out)
+		}
+
+		// connections from the host to the loopback address
+		var (
+			out string
+			err error
+		)
+		for i := 0; i < 10; i++ {
+			var conn net.Conn
+			if conn, err = net.Dial("tcp", "127.0.0.1:8765"); err == nil {
+				var b []byte
+				b, err = ioutil.ReadAll(conn)
+				conn.Close()
+				if out = string(b); strings.Contains(out, "hello") {
+					break
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !strings.Contains(out, "hello") {
+			d.Stop()
+			t.Fatalf("Could not reach published port with --userland-proxy=%v: err=%v, got %q", userlandProxy, err, out)
+		}
+
+		psOut, _, err := runCommandWithOutput(exec.Command("ps", "aux"))
+		if err != nil {
+			d.Stop()
+			t.Fatal(err, psOut)
+		}
+		if hasProxy := strings.Contains(psOut, "docker-proxy -proto tcp -host-ip 127.0.0.1 -host-port 8765"); hasProxy != userlandProxy {
+			d.Stop()
+			t.Fatalf("Expected docker-proxy running to be %v with --userland-proxy=%v, got %v", userlandProxy, userlandProxy, hasProxy)
+		}
+
+		if err := d.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logDone("daemon - published ports reachable from localhost with and without userland proxy")
+}
+
+func TestDaemonUserlandProxyFalseHairpin(t *testing.T) {
+	d := NewDaemon(t)
+	if err := d.StartWithBusybox("--userland-proxy=false"); err != nil {
+		t.Fatalf("Could not start daemon: %v", err)
+	}
+	defer d.Stop()
+
+	if out, err := d.Cmd("run", "-d", "--name", "server", "-p", "8766:80", "busybox", "sh", "-c", "while true; do echo hello | nc -l -p 80; done"); err != nil {
+		t.Fatalf("Could not run server: err=%v\n%s", err, out)
+	}
+
+	// a container connecting to the published port through the bridge
+	// address is hairpinned back to the server container
+	out, err := d.Cmd("run", "busybox", "sh", "-c", "nc $(ip route | awk '/default/ { print $3 }') 8766 </dev/null")
+	if err != nil {
+		t.Fatalf("Could not reach published port from a container: err=%v\n%s", err, out)
+	}
+	if !strings.Contains(out, "hello") {
+		t.Fatalf("Expected hello from the server container, got %q", out)
+	}
+
+	logDone("daemon - published ports reachable from containers without userland proxy")
+}
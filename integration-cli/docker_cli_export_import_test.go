@@ -95,3 +95,43 @@ func TestExportContainerWithOutputAndImportImage(t *testing.T) {
 
 	logDone("export - export/import a container/image with output flag")
 }
+
+// export a container compressed with gzip and import it into a new image
+func TestExportContainerWithCompressAndImportImage(t *testing.T) {
+	runCmd := exec.Command(dockerBinary, "run", "-d", "busybox", "true")
+	out, _, err := runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal("failed to create a container", out, err)
+	}
+
+	cleanedContainerID := strings.TrimSpace(out)
+	defer deleteContainer(cleanedContainerID)
+
+	exportCmd := exec.Command(dockerBinary, "export", "--compress=gzip", cleanedContainerID)
+	if out, _, err = runCommandWithOutput(exportCmd); err != nil {
+		t.Fatalf("failed to export container: %s, %v", out, err)
+	}
+	if !strings.HasPrefix(out, "\x1f\x8b\x08") {
+		t.Fatalf("expected a gzip stream, got %q", out[:10])
+	}
+
+	importCmd := exec.Command(dockerBinary, "import", "-", "repo/testexp:v1")
+	importCmd.Stdin = strings.NewReader(out)
+	out, _, err = runCommandWithOutput(importCmd)
+	if err != nil {
+		t.Fatalf("failed to import image: %s, %v", out, err)
+	}
+	defer deleteImages("repo/testexp:v1")
+
+	runCmd = exec.Command(dockerBinary, "run", "--rm", "repo/testexp:v1", "true")
+	if out, _, err = runCommandWithOutput(runCmd); err != nil {
+		t.Fatalf("failed to run the imported image: %s, %v", out, err)
+	}
+
+	exportCmd = exec.Command(dockerBinary, "export", "--compress=xz", cleanedContainerID)
+	if out, _, err = runCommandWithOutput(exportCmd); err == nil || !strings.Contains(out, "Unsupported compression") {
+		t.Fatalf("expected xz to be refused: %s, %v", out, err)
+	}
+
+	logDone("export - export a container with --compress and import it")
+}
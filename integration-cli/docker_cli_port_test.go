@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os/exec"
 	"sort"
 	"strings"
@@ -71,9 +72,7 @@ func TestPortList(t *testing.T) {
 	}
 
 	if !assertPortList(t, out, []string{
-		"80/tcp -> 0.0.0.0:9876",
-		"81/tcp -> 0.0.0.0:9877",
-		"82/tcp -> 0.0.0.0:9878"}) {
+		"80-82/tcp -> 0.0.0.0:9876-9878"}) {
 		t.Error("Port list is not correct")
 	}
 	runCmd = exec.Command(dockerBinary, "rm", "-f", ID)
@@ -112,10 +111,8 @@ func TestPortList(t *testing.T) {
 	}
 
 	if !assertPortList(t, out, []string{
-		"80/tcp -> 0.0.0.0:9876",
-		"80/tcp -> 0.0.0.0:9999",
-		"81/tcp -> 0.0.0.0:9877",
-		"82/tcp -> 0.0.0.0:9878"}) {
+		"80-82/tcp -> 0.0.0.0:9876-9878",
+		"80/tcp -> 0.0.0.0:9999"}) {
 		t.Error("Port list is not correct\n", out)
 	}
 	runCmd = exec.Command(dockerBinary, "rm", "-f", ID)
@@ -126,6 +123,55 @@ func TestPortList(t *testing.T) {
 	logDone("port - test port list")
 }
 
+func TestPortListEphemeralRange(t *testing.T) {
+	defer deleteAllContainers()
+
+	runCmd := exec.Command(dockerBinary, "run", "-d", "-p", "7000-7009", "-p", "7020/udp", "busybox", "top")
+	out, _, err := runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	ID := strings.TrimSpace(out)
+
+	hostPort := publicPort(t, ID, "7000/tcp")
+	udpPort := publicPort(t, ID, "7020/udp")
+
+	// The host ports of a published range are contiguous
+	runCmd = exec.Command(dockerBinary, "port", ID)
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{
+		fmt.Sprintf("7000-7009/tcp -> 0.0.0.0:%d-%d", hostPort, hostPort+9),
+		fmt.Sprintf("7020/udp -> 0.0.0.0:%d", udpPort)}) {
+		t.Error("Port list is not correct")
+	}
+
+	runCmd = exec.Command(dockerBinary, "port", ID, "7002-7004")
+	out, _, err = runCommandWithOutput(runCmd)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if !assertPortList(t, out, []string{fmt.Sprintf("0.0.0.0:%d-%d", hostPort+2, hostPort+4)}) {
+		t.Error("Port list is not correct")
+	}
+
+	logDone("port - ephemeral port ranges are contiguous")
+}
+
+func publicPort(t *testing.T, id, port string) int {
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "port", id, port))
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	var hostPort int
+	if _, err := fmt.Sscanf(strings.TrimSpace(out), "0.0.0.0:%d", &hostPort); err != nil {
+		t.Fatalf("Unexpected public port for %s: %q", port, out)
+	}
+	return hostPort
+}
+
 func assertPortList(t *testing.T, out string, expected []string) bool {
 	//lines := strings.Split(out, "\n")
 	lines := strings.Split(strings.Trim(out, "\n "), "\n")
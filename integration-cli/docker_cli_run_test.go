@@ -2546,6 +2546,41 @@ func TestRunDeallocatePortOnMissingIptablesRule(t *testing.T) {
 	logDone("run - port should be deallocated even on iptables error")
 }
 
+func TestRunEgressRules(t *testing.T) {
+	defer deleteAllContainers()
+	testRequires(t, SameHostDaemon)
+
+	cmd := exec.Command(dockerBinary, "run", "-d", "--egress", "192.0.2.0/24:80", "busybox", "top")
+	out, _, err := runCommandWithOutput(cmd)
+	if err != nil {
+		t.Fatal(err, out)
+	}
+	id := strings.TrimSpace(out)
+	chain := "DOCKER-EGRESS-" + id[:12]
+
+	out, _, err = runCommandWithOutput(exec.Command("iptables", "-S", chain))
+	if err != nil {
+		t.Fatal(err, out)
+	}
+	if !strings.Contains(out, "-d 192.0.2.0/24 -p tcp -m tcp --dport 80 -j ACCEPT") || !strings.Contains(out, "-j DROP") {
+		t.Fatalf("Egress rules missing from %s: %s", chain, out)
+	}
+
+	if out, _, err = runCommandWithOutput(exec.Command(dockerBinary, "stop", id)); err != nil {
+		t.Fatal(err, out)
+	}
+	if out, _, err = runCommandWithOutput(exec.Command("iptables", "-S", chain)); err == nil {
+		t.Fatalf("Expected %s to be removed when the container stopped: %s", chain, out)
+	}
+
+	cmd = exec.Command(dockerBinary, "run", "--net=host", "--egress", "192.0.2.0/24", "busybox", "true")
+	if out, _, err = runCommandWithOutput(cmd); err == nil || !strings.Contains(out, "--egress can only be used with --net=bridge") {
+		t.Fatalf("Expected --egress to conflict with --net=host, got: %s", out)
+	}
+
+	logDone("run - egress rules are installed and removed")
+}
+
 func TestRunPortInUse(t *testing.T) {
 	defer deleteAllContainers()
 	testRequires(t, SameHostDaemon)
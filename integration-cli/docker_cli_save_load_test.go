@@ -261,6 +261,142 @@ func TestSaveAndLoadRepoFlags(t *testing.T) {
 	logDone("save - save a repo using -o && load a repo using -i")
 }
 
+func TestSaveWithCompressAndLoadRepo(t *testing.T) {
+	repoName := "foobar-save-compress-test"
+	tagCmd := exec.Command(dockerBinary, "tag", "busybox:latest", repoName)
+	if out, _, err := runCommandWithOutput(tagCmd); err != nil {
+		t.Fatalf("failed to tag repo: %s, %v", out, err)
+	}
+	defer deleteImages(repoName)
+
+	inspectCmd := exec.Command(dockerBinary, "inspect", repoName)
+	before, _, err := runCommandWithOutput(inspectCmd)
+	if err != nil {
+		t.Fatalf("the repo should exist before saving it: %s, %v", before, err)
+	}
+
+	saveCmd := exec.Command(dockerBinary, "save", "--compress=gzip", repoName)
+	out, _, err := runCommandWithOutput(saveCmd)
+	if err != nil {
+		t.Fatalf("failed to save repo: %s, %v", out, err)
+	}
+	if !strings.HasPrefix(out, "\x1f\x8b\x08") {
+		t.Fatalf("expected a gzip stream, got %q", out[:10])
+	}
+
+	deleteImages(repoName)
+
+	loadCmd := exec.Command(dockerBinary, "load")
+	loadCmd.Stdin = strings.NewReader(out)
+	if out, _, err = runCommandWithOutput(loadCmd); err != nil {
+		t.Fatalf("failed to load repo: %s, %v", out, err)
+	}
+
+	inspectCmd = exec.Command(dockerBinary, "inspect", repoName)
+	after, _, err := runCommandWithOutput(inspectCmd)
+	if err != nil {
+		t.Fatalf("the repo should exist after loading it: %s, %v", after, err)
+	}
+
+	if before != after {
+		t.Fatalf("inspect is not the same after a save / load")
+	}
+
+	logDone("save - save a repo using --compress && load it")
+}
+
+func TestSaveOCILayoutAndLoadRepo(t *testing.T) {
+	repoName := "foobar-save-oci-test"
+	tagCmd := exec.Command(dockerBinary, "tag", "busybox:latest", repoName)
+	if out, _, err := runCommandWithOutput(tagCmd); err != nil {
+		t.Fatalf("failed to tag repo: %s, %v", out, err)
+	}
+	defer deleteImages(repoName)
+
+	id, err := inspectField(repoName, "Id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saveCmd := exec.Command(dockerBinary, "save", "--format=oci", repoName)
+	out, _, err := runCommandWithOutput(saveCmd)
+	if err != nil {
+		t.Fatalf("failed to save repo: %s, %v", out, err)
+	}
+
+	listCmd := exec.Command("tar", "t")
+	listCmd.Stdin = strings.NewReader(out)
+	files, _, err := runCommandWithOutput(listCmd)
+	if err != nil {
+		t.Fatalf("failed to list the archive: %s, %v", files, err)
+	}
+	for _, name := range []string{"oci-layout", "index.json", "blobs/sha256/"} {
+		if !strings.Contains(files, name) {
+			t.Fatalf("expected %s in the OCI image layout, got %s", name, files)
+		}
+	}
+
+	deleteImages(repoName)
+
+	loadCmd := exec.Command(dockerBinary, "load")
+	loadCmd.Stdin = strings.NewReader(out)
+	if out, _, err = runCommandWithOutput(loadCmd); err != nil {
+		t.Fatalf("failed to load repo: %s, %v", out, err)
+	}
+
+	// The ID the image was saved with remains a valid reference
+	if out, _, err := dockerCmd(t, "inspect", id); err != nil {
+		t.Fatalf("the image should exist after loading it: %s, %v", out, err)
+	}
+	if out, _, err := dockerCmd(t, "inspect", repoName); err != nil {
+		t.Fatalf("the repo should exist after loading it: %s, %v", out, err)
+	}
+
+	logDone("save - save a repo using --format=oci && load it")
+}
+
+func TestSaveExcludeLayersAndLoadRepo(t *testing.T) {
+	repoName := "foobar-save-exclude-test"
+	if _, err := buildImage(repoName, "FROM busybox\nRUN touch /foo", true); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteImages(repoName)
+
+	baseID, err := inspectField("busybox", "Id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saveCmd := exec.Command(dockerBinary, "save", "--exclude-layers-from=busybox", repoName)
+	out, _, err := runCommandWithOutput(saveCmd)
+	if err != nil {
+		t.Fatalf("failed to save repo: %s, %v", out, err)
+	}
+
+	listCmd := exec.Command("tar", "t")
+	listCmd.Stdin = strings.NewReader(out)
+	files, _, err := runCommandWithOutput(listCmd)
+	if err != nil {
+		t.Fatalf("failed to list the archive: %s, %v", files, err)
+	}
+	if strings.Contains(files, baseID) {
+		t.Fatalf("expected the layers of busybox to be excluded, got %s", files)
+	}
+
+	deleteImages(repoName)
+
+	loadCmd := exec.Command(dockerBinary, "load")
+	loadCmd.Stdin = strings.NewReader(out)
+	if out, _, err = runCommandWithOutput(loadCmd); err != nil {
+		t.Fatalf("failed to load repo: %s, %v", out, err)
+	}
+	if out, _, err := dockerCmd(t, "inspect", repoName); err != nil {
+		t.Fatalf("the repo should exist after loading it: %s, %v", out, err)
+	}
+
+	logDone("save - save a repo using --exclude-layers-from && load it")
+}
+
 func TestSaveMultipleNames(t *testing.T) {
 	repoName := "foobar-save-multi-name-test"
 
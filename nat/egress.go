@@ -0,0 +1,73 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/pkg/parsers"
+)
+
+const EgressRuleFormat = "ip[/mask][:port[-port][/proto]]"
+
+// EgressRule describes a destination a container is allowed to send
+// traffic to when its outgoing traffic is restricted.
+type EgressRule struct {
+	Network *net.IPNet
+	// Proto is empty when the rule applies to any protocol, in which case
+	// there is no port range either.
+	Proto     string
+	StartPort int
+	EndPort   int
+}
+
+// ParseEgressRule parses a rule in the format ip[/mask][:port[-port][/proto]],
+// e.g. 10.0.0.0/8, 192.168.1.10:443 or 0.0.0.0/0:53/udp. A bare address
+// covers a single host and the protocol defaults to tcp when a port is given.
+func ParseEgressRule(rawRule string) (*EgressRule, error) {
+	var (
+		rawNetwork = rawRule
+		rawPorts   string
+		hasPorts   bool
+		rule       = &EgressRule{}
+	)
+	if i := strings.Index(rawRule, ":"); i != -1 {
+		rawNetwork, rawPorts, hasPorts = rawRule[:i], rawRule[i+1:], true
+	}
+
+	if !strings.Contains(rawNetwork, "/") {
+		rawNetwork += "/32"
+	}
+	ip, network, err := net.ParseCIDR(rawNetwork)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("Invalid egress rule %q: destination must be an IPv4 address or network (%s)", rawRule, EgressRuleFormat)
+	}
+	rule.Network = network
+
+	if !hasPorts {
+		return rule, nil
+	}
+	proto, ports := SplitProtoPort(rawPorts)
+	if !validateProto(proto) {
+		return nil, fmt.Errorf("Invalid egress rule %q: invalid proto %s", rawRule, proto)
+	}
+	start, end, err := parsers.ParsePortRange(ports)
+	if err != nil || start == 0 {
+		return nil, fmt.Errorf("Invalid egress rule %q: invalid port range %s", rawRule, ports)
+	}
+	rule.Proto = proto
+	rule.StartPort = int(start)
+	rule.EndPort = int(end)
+	return rule, nil
+}
+
+// String returns the rule in the format accepted by ParseEgressRule.
+func (r *EgressRule) String() string {
+	if r.Proto == "" {
+		return r.Network.String()
+	}
+	if r.StartPort == r.EndPort {
+		return fmt.Sprintf("%s:%d/%s", r.Network, r.StartPort, r.Proto)
+	}
+	return fmt.Sprintf("%s:%d-%d/%s", r.Network, r.StartPort, r.EndPort, r.Proto)
+}
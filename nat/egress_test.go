@@ -0,0 +1,50 @@
+package nat
+
+import (
+	"testing"
+)
+
+func TestParseEgressRule(t *testing.T) {
+	valid := map[string]string{
+		"10.0.0.0/8":              "10.0.0.0/8",
+		"10.1.2.3/8":              "10.0.0.0/8",
+		"192.168.1.10":            "192.168.1.10/32",
+		"192.168.1.10:443":        "192.168.1.10/32:443/tcp",
+		"0.0.0.0/0:53/udp":        "0.0.0.0/0:53/udp",
+		"172.16.0.0/12:8000-8100": "172.16.0.0/12:8000-8100/tcp",
+	}
+	for raw, expected := range valid {
+		rule, err := ParseEgressRule(raw)
+		if err != nil {
+			t.Fatalf("Parsing %q failed: %v", raw, err)
+		}
+		if rule.String() != expected {
+			t.Fatalf("Expected %q to parse as %s, got %s", raw, expected, rule)
+		}
+	}
+
+	rule, err := ParseEgressRule("192.168.1.10:8000-8100/udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Proto != "udp" || rule.StartPort != 8000 || rule.EndPort != 8100 {
+		t.Fatalf("Unexpected rule %+v", rule)
+	}
+
+	for _, raw := range []string{
+		"",
+		"example.com",
+		"10.0.0.0/33",
+		"2001:db8::/32",
+		"10.0.0.0/8:",
+		"10.0.0.0/8:0",
+		"10.0.0.0/8:http",
+		"10.0.0.0/8:70000",
+		"10.0.0.0/8:100-10",
+		"10.0.0.0/8:53/icmp",
+	} {
+		if _, err := ParseEgressRule(raw); err == nil {
+			t.Fatalf("Expected an error parsing %q", raw)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/docker/docker/api"
+	"github.com/docker/docker/nat"
 	flag "github.com/docker/docker/pkg/mflag"
 	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/pkg/ulimit"
@@ -44,6 +45,10 @@ func LabelListVar(values *[]string, names []string, usage string) {
 	flag.Var(newListOptsRef(values, ValidateLabel), names, usage)
 }
 
+func ImageGCKeepListVar(values *[]string, names []string, usage string) {
+	flag.Var(newListOptsRef(values, ValidateImageGCKeep), names, usage)
+}
+
 func UlimitMapVar(values map[string]*ulimit.Ulimit, names []string, usage string) {
 	flag.Var(NewUlimitOpt(values), names, usage)
 }
@@ -228,6 +233,46 @@ func ValidateExtraHost(val string) (string, error) {
 	return val, nil
 }
 
+func ValidateEgressRule(val string) (string, error) {
+	rule, err := nat.ParseEgressRule(strings.TrimSpace(val))
+	if err != nil {
+		return "", err
+	}
+	return rule.String(), nil
+}
+
+// ValidateStorageOpt validates a storage driver option of a container,
+// KEY=VALUE.
+func ValidateStorageOpt(val string) (string, error) {
+	arr := strings.SplitN(val, "=", 2)
+	if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
+		return "", fmt.Errorf("bad storage option format: %s", val)
+	}
+	return val, nil
+}
+
+// ValidateImageGCKeep validates a rule of the image garbage collector keep
+// list, either label=KEY[=VALUE] or repo=PATTERN.
+func ValidateImageGCKeep(val string) (string, error) {
+	arr := strings.SplitN(val, "=", 2)
+	if len(arr) != 2 || arr[1] == "" {
+		return "", fmt.Errorf("bad image GC keep rule format: %s", val)
+	}
+	switch arr[0] {
+	case "label":
+		if strings.HasPrefix(arr[1], "=") {
+			return "", fmt.Errorf("bad image GC keep rule format: %s", val)
+		}
+	case "repo":
+		if _, err := path.Match(arr[1], ""); err != nil {
+			return "", fmt.Errorf("invalid repository pattern in image GC keep rule: %s", val)
+		}
+	default:
+		return "", fmt.Errorf("unknown image GC keep rule: %s, must be label or repo", arr[0])
+	}
+	return val, nil
+}
+
 func ValidateLabel(val string) (string, error) {
 	if strings.Count(val, "=") != 1 {
 		return "", fmt.Errorf("bad attribute format: %s", val)
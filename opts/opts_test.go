@@ -152,3 +152,50 @@ func TestValidateExtraHosts(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateImageGCKeep(t *testing.T) {
+	valid := []string{
+		`label=keep`,
+		`label=com.example.tier=base`,
+		`repo=ubuntu`,
+		`repo=registry.example.com/*`,
+	}
+
+	invalid := map[string]string{
+		`keep`:          `bad image GC keep rule format`,
+		`label=`:        `bad image GC keep rule format`,
+		`label==value`:  `bad image GC keep rule format`,
+		`repo=[invalid`: `invalid repository pattern`,
+		`tag=latest`:    `unknown image GC keep rule`,
+	}
+
+	for _, rule := range valid {
+		if _, err := ValidateImageGCKeep(rule); err != nil {
+			t.Fatalf("ValidateImageGCKeep(`"+rule+"`) should succeed: error %v", err)
+		}
+	}
+
+	for rule, expectedError := range invalid {
+		if _, err := ValidateImageGCKeep(rule); err == nil {
+			t.Fatalf("ValidateImageGCKeep(`%q`) should have failed validation", rule)
+		} else {
+			if !strings.Contains(err.Error(), expectedError) {
+				t.Fatalf("ValidateImageGCKeep(`%q`) error should contain %q", rule, expectedError)
+			}
+		}
+	}
+}
+
+func TestValidateStorageOpt(t *testing.T) {
+	for _, opt := range []string{`size=20G`, `size=1024`} {
+		if _, err := ValidateStorageOpt(opt); err != nil {
+			t.Fatalf("ValidateStorageOpt(`"+opt+"`) should succeed: error %v", err)
+		}
+	}
+
+	for _, opt := range []string{`size`, `size=`, `=20G`} {
+		if _, err := ValidateStorageOpt(opt); err == nil {
+			t.Fatalf("ValidateStorageOpt(`%q`) should have failed validation", opt)
+		}
+	}
+}
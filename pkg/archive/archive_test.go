@@ -160,10 +160,14 @@ func TestTarUntar(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	for _, c := range []Compression{
+	compressions := []Compression{
 		Uncompressed,
 		Gzip,
-	} {
+	}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		compressions = append(compressions, Zstd)
+	}
+	for _, c := range compressions {
 		changes, err := tarUntar(t, origin, &TarOptions{
 			Compression:     c,
 			ExcludePatterns: []string{"3"},
@@ -179,6 +183,60 @@ func TestTarUntar(t *testing.T) {
 	}
 }
 
+func TestParseCompression(t *testing.T) {
+	for name, expected := range map[string]Compression{
+		"":     Uncompressed,
+		"none": Uncompressed,
+		"gzip": Gzip,
+		"GZIP": Gzip,
+		"zstd": Zstd,
+	} {
+		if c, err := ParseCompression(name); err != nil || c != expected {
+			t.Fatalf("Expected %q to be %s, got %s, %v", name, expected.Extension(), c.Extension(), err)
+		}
+	}
+	for _, name := range []string{"xz", "bzip2", "lz4"} {
+		if _, err := ParseCompression(name); err == nil {
+			t.Fatalf("Expected %q to be refused", name)
+		}
+	}
+}
+
+func TestDetectCompressionZstd(t *testing.T) {
+	// Header of a zstd frame
+	if c := DetectCompression([]byte{0x28, 0xB5, 0x2F, 0xFD, 0x04, 0x58, 0x00, 0x00, 0x00, 0x00}); c != Zstd {
+		t.Fatalf("Expected zstd, got %s", c.Extension())
+	}
+}
+
+func TestCmdWriteStream(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := CmdWriteStream(exec.Command("tr", "a-z", "A-Z"), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "HELLO" {
+		t.Fatalf("Expected HELLO, got %q", buf.String())
+	}
+}
+
+func TestCmdWriteStreamBad(t *testing.T) {
+	w, err := CmdWriteStream(exec.Command("/bin/sh", "-c", "cat > /dev/null; echo error >&2; exit 1"), ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	if err := w.Close(); err == nil || !strings.Contains(err.Error(), "error") {
+		t.Fatalf("Expected the error of the command, got %v", err)
+	}
+}
+
 func TestTarWithOptions(t *testing.T) {
 	origin, err := ioutil.TempDir("", "docker-test-untar-origin")
 	if err != nil {
@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// gzipBlockSize is the size of the blocks of data compressed concurrently
+// by a parallel gzip writer.
+const gzipBlockSize = 1 << 20
+
+var errGzipWriterClosed = errors.New("Write to a closed gzip writer")
+
+type gzipBlock struct {
+	data       []byte
+	compressed bytes.Buffer
+	err        error
+	done       chan struct{}
+}
+
+// parallelGzipWriter compresses blocks of data on several cores. Every block
+// is written as a gzip member of its own, the concatenation of the members
+// being a valid gzip stream which readers decompress as a whole.
+type parallelGzipWriter struct {
+	dest       io.Writer
+	block      []byte
+	blocks     chan *gzipBlock // Blocks being compressed, in order
+	started    bool            // Whether a block has been compressed
+	closed     bool
+	wg         sync.WaitGroup
+	sync.Mutex // Protects err
+	err        error
+}
+
+// NewParallelGzipWriter returns a writer compressing the data written to it
+// with gzip on all the available cores, in blocks of 1MB.
+func NewParallelGzipWriter(dest io.Writer) io.WriteCloser {
+	w := &parallelGzipWriter{
+		dest:   dest,
+		block:  make([]byte, 0, gzipBlockSize),
+		blocks: make(chan *gzipBlock, runtime.GOMAXPROCS(0)),
+	}
+	w.wg.Add(1)
+	go w.writeBlocks()
+	return w
+}
+
+func (w *parallelGzipWriter) getErr() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.err
+}
+
+func (w *parallelGzipWriter) setErr(err error) {
+	w.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.Unlock()
+}
+
+// writeBlocks writes the compressed blocks in order.
+func (w *parallelGzipWriter) writeBlocks() {
+	defer w.wg.Done()
+	for b := range w.blocks {
+		<-b.done
+		if b.err != nil {
+			w.setErr(b.err)
+		}
+		if w.getErr() != nil {
+			continue
+		}
+		if _, err := b.compressed.WriteTo(w.dest); err != nil {
+			w.setErr(err)
+		}
+	}
+}
+
+// compress queues the current block to be compressed, the queue being
+// bounded by the number of cores.
+func (w *parallelGzipWriter) compress() {
+	b := &gzipBlock{data: w.block, done: make(chan struct{})}
+	w.block = make([]byte, 0, gzipBlockSize)
+	w.started = true
+	w.blocks <- b
+	go func() {
+		defer close(b.done)
+		gz := gzip.NewWriter(&b.compressed)
+		if _, err := gz.Write(b.data); err != nil {
+			b.err = err
+			return
+		}
+		b.err = gz.Close()
+	}()
+}
+
+func (w *parallelGzipWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errGzipWriterClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		if err := w.getErr(); err != nil {
+			return n, err
+		}
+		free := gzipBlockSize - len(w.block)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.block = append(w.block, p[:free]...)
+		p = p[free:]
+		n += free
+		if len(w.block) == gzipBlockSize {
+			w.compress()
+		}
+	}
+	return n, nil
+}
+
+// Close compresses the last block and waits for all the blocks to be
+// written.
+func (w *parallelGzipWriter) Close() error {
+	if w.closed {
+		return w.getErr()
+	}
+	w.closed = true
+	// Even an empty stream is written as a gzip member
+	if len(w.block) > 0 || !w.started {
+		w.compress()
+	}
+	close(w.blocks)
+	w.wg.Wait()
+	return w.getErr()
+}
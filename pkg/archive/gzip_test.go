@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func parallelGzip(t *testing.T, data []byte, chunkSize int) []byte {
+	buf := new(bytes.Buffer)
+	w := NewParallelGzipWriter(buf)
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gunzip(t *testing.T, compressed []byte) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParallelGzipWriter(t *testing.T) {
+	data := make([]byte, 3*gzipBlockSize+12345)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range data {
+		// Compressible but not constant
+		data[i] = byte(rnd.Intn(16))
+	}
+
+	for _, chunkSize := range []int{1000, gzipBlockSize, 5 * gzipBlockSize} {
+		compressed := parallelGzip(t, data, chunkSize)
+		if len(compressed) >= len(data) {
+			t.Fatalf("Expected the data to be compressed, got %d bytes from %d", len(compressed), len(data))
+		}
+		if !bytes.Equal(gunzip(t, compressed), data) {
+			t.Fatalf("Data written with chunks of %d bytes changed by compression", chunkSize)
+		}
+		if DetectCompression(compressed) != Gzip {
+			t.Fatal("Expected the compressed data to be detected as gzip")
+		}
+	}
+
+	// The output only depends on the data
+	if !bytes.Equal(parallelGzip(t, data, 1000), parallelGzip(t, data, 7777)) {
+		t.Fatal("Expected the same output for the same data")
+	}
+}
+
+func TestParallelGzipWriterEmpty(t *testing.T) {
+	if data := gunzip(t, parallelGzip(t, nil, 1)); len(data) != 0 {
+		t.Fatalf("Expected no data, got %d bytes", len(data))
+	}
+}
+
+func TestParallelGzipWriterWriteAfterClose(t *testing.T) {
+	w := NewParallelGzipWriter(ioutil.Discard)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, gzipBlockSize)); err != errGzipWriterClosed {
+		t.Fatalf("Expected %q writing after close, got %v", errGzipWriterClosed, err)
+	}
+}
@@ -0,0 +1,360 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+// An indexed archive is a tar archive compressed with gzip in which every
+// entry is compressed as a gzip member of its own. The end-of-archive marker
+// of the tar archive is followed by a member with the index of the entries,
+// which locates their members in the archive, and by a footer locating the
+// index. Indexed archives are decompressed like any other gzip archive, but
+// single entries can also be read without reading the whole archive, e.g.
+// with Range requests to a registry.
+
+const (
+	indexMagic = "DOCKERINDEX"
+	// Members are read in chunks of this size, which are requested at once
+	// when the archive is read remotely
+	memberBufferSize = 1 << 20
+)
+
+// ErrNotIndexed is returned when reading the index of an archive which
+// isn't indexed.
+var ErrNotIndexed = errors.New("Archive is not indexed")
+
+// IndexEntry describes an entry of an indexed archive and locates its member.
+type IndexEntry struct {
+	Name           string
+	Typeflag       byte
+	Linkname       string `json:",omitempty"`
+	Mode           int64
+	Uid            int
+	Gid            int
+	Uname          string `json:",omitempty"`
+	Gname          string `json:",omitempty"`
+	Size           int64
+	ModTime        time.Time
+	Devmajor       int64             `json:",omitempty"`
+	Devminor       int64             `json:",omitempty"`
+	Xattrs         map[string]string `json:",omitempty"`
+	Offset         int64
+	CompressedSize int64
+	// Digest is the sha256 digest of the content of regular files, which
+	// the content read from the member of the entry is verified against.
+	Digest string `json:",omitempty"`
+}
+
+// ArchiveIndex is the index of an indexed archive.
+type ArchiveIndex struct {
+	// Digest is the digest of the uncompressed archive recorded by the
+	// writer of the archive, which readers have to verify.
+	Digest  string `json:",omitempty"`
+	Entries []*IndexEntry
+}
+
+func newIndexEntry(hdr *tar.Header) *IndexEntry {
+	return &IndexEntry{
+		Name:     hdr.Name,
+		Typeflag: hdr.Typeflag,
+		Linkname: hdr.Linkname,
+		Mode:     hdr.Mode,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+		Size:     hdr.Size,
+		ModTime:  hdr.ModTime,
+		Devmajor: hdr.Devmajor,
+		Devminor: hdr.Devminor,
+		Xattrs:   hdr.Xattrs,
+	}
+}
+
+// Header returns the tar header of the entry.
+func (e *IndexEntry) Header() *tar.Header {
+	return &tar.Header{
+		Name:     e.Name,
+		Typeflag: e.Typeflag,
+		Linkname: e.Linkname,
+		Mode:     e.Mode,
+		Uid:      e.Uid,
+		Gid:      e.Gid,
+		Uname:    e.Uname,
+		Gname:    e.Gname,
+		Size:     e.Size,
+		ModTime:  e.ModTime,
+		Devmajor: e.Devmajor,
+		Devminor: e.Devminor,
+		Xattrs:   e.Xattrs,
+	}
+}
+
+// HasContent returns whether the entry is a regular file with content,
+// which is only read from the member of the entry.
+func (e *IndexEntry) HasContent() bool {
+	return (e.Typeflag == tar.TypeReg || e.Typeflag == tar.TypeRegA) && e.Size > 0
+}
+
+// HasDigests returns whether the index records the digests of the content of
+// all its regular files, which indexes written before they were recorded
+// don't.
+func (index *ArchiveIndex) HasDigests() bool {
+	for _, e := range index.Entries {
+		if e.HasContent() && e.Digest == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// contentDigest returns the digest of the content of an entry hashed with h.
+func contentDigest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// verifiedReader fails at the end of the content of an entry if it doesn't
+// match the digest of the entry.
+type verifiedReader struct {
+	io.Reader
+	e *IndexEntry
+	h hash.Hash
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF {
+		if dgst := contentDigest(r.h); dgst != r.e.Digest {
+			return n, fmt.Errorf("Content of %s doesn't match the archive index: expected digest %s, got %s", r.e.Name, r.e.Digest, dgst)
+		}
+	}
+	return n, err
+}
+
+// Open returns a reader of the content of the entry, read from the indexed
+// archive r. If the entry has a digest, reading the content fails at its end
+// if it doesn't match.
+func (e *IndexEntry) Open(r io.ReaderAt) (io.Reader, error) {
+	gz, err := gzip.NewReader(bufio.NewReaderSize(io.NewSectionReader(r, e.Offset, e.CompressedSize), memberBufferSize))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != e.Name {
+		return nil, fmt.Errorf("Expected entry %s at offset %d, found %s", e.Name, e.Offset, hdr.Name)
+	}
+	if e.Digest != "" {
+		return &verifiedReader{Reader: tr, e: e, h: sha256.New()}, nil
+	}
+	return tr, nil
+}
+
+// IndexedWriter compresses a tar archive into an indexed archive.
+type IndexedWriter struct {
+	// Index is written when the writer is closed
+	Index  ArchiveIndex
+	dest   io.Writer
+	offset int64
+	closed bool
+}
+
+// NewIndexedWriter returns a writer of an indexed archive to dest.
+func NewIndexedWriter(dest io.Writer) *IndexedWriter {
+	return &IndexedWriter{dest: dest}
+}
+
+func (w *IndexedWriter) Write(p []byte) (int, error) {
+	n, err := w.dest.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+// writeMember compresses what write writes as a gzip member of its own,
+// returning the offset and size of the member.
+func (w *IndexedWriter) writeMember(write func(io.Writer) error) (int64, int64, error) {
+	offset := w.offset
+	gz := gzip.NewWriter(w)
+	if err := write(gz); err != nil {
+		gz.Close()
+		return 0, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, 0, err
+	}
+	return offset, w.offset - offset, nil
+}
+
+// WriteArchive compresses the entries of the tar archive read from src, up
+// to its end-of-archive marker, and adds them to the index along with the
+// digests of the content of regular files.
+func (w *IndexedWriter) WriteArchive(src io.Reader) error {
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var (
+			entry = newIndexEntry(hdr)
+			h     = sha256.New()
+		)
+		entry.Offset, entry.CompressedSize, err = w.writeMember(func(dest io.Writer) error {
+			tw := tar.NewWriter(dest)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.MultiWriter(tw, h), tr); err != nil {
+				return err
+			}
+			// Pad the entry without writing the end-of-archive marker
+			return tw.Flush()
+		})
+		if err != nil {
+			return err
+		}
+		if entry.HasContent() {
+			entry.Digest = contentDigest(h)
+		}
+		w.Index.Entries = append(w.Index.Entries, entry)
+	}
+}
+
+// Close writes the end-of-archive marker, the index and the footer.
+func (w *IndexedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if _, _, err := w.writeMember(func(dest io.Writer) error {
+		return tar.NewWriter(dest).Close()
+	}); err != nil {
+		return err
+	}
+	indexOffset, _, err := w.writeMember(func(dest io.Writer) error {
+		return json.NewEncoder(dest).Encode(&w.Index)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(indexFooter(indexOffset))
+	return err
+}
+
+// indexFooter returns the footer of an indexed archive, an empty gzip member
+// of a fixed size with the offset of the index in its extra field.
+func indexFooter(indexOffset int64) []byte {
+	buf := bytes.NewBuffer(nil)
+	gz, _ := gzip.NewWriterLevel(buf, gzip.NoCompression)
+	gz.Header.Extra = []byte(fmt.Sprintf("%016x%s", indexOffset, indexMagic))
+	gz.Close()
+	return buf.Bytes()
+}
+
+var indexFooterSize = int64(len(indexFooter(0)))
+
+// ReadArchiveIndex reads the index of the archive of the given size from r,
+// returning ErrNotIndexed if the archive isn't indexed.
+func ReadArchiveIndex(r io.ReaderAt, size int64) (*ArchiveIndex, error) {
+	if size < indexFooterSize {
+		return nil, ErrNotIndexed
+	}
+	footer := make([]byte, indexFooterSize)
+	if _, err := r.ReadAt(footer, size-indexFooterSize); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(footer))
+	if err != nil {
+		return nil, ErrNotIndexed
+	}
+	extra := string(gz.Header.Extra)
+	if len(extra) != 16+len(indexMagic) || !strings.HasSuffix(extra, indexMagic) {
+		return nil, ErrNotIndexed
+	}
+	indexOffset, err := strconv.ParseInt(extra[:16], 16, 64)
+	if err != nil || indexOffset < 0 || indexOffset >= size-indexFooterSize {
+		return nil, ErrNotIndexed
+	}
+
+	gz, err = gzip.NewReader(bufio.NewReaderSize(io.NewSectionReader(r, indexOffset, size-indexFooterSize-indexOffset), memberBufferSize))
+	if err != nil {
+		return nil, err
+	}
+	var index ArchiveIndex
+	if err := json.NewDecoder(gz).Decode(&index); err != nil {
+		return nil, fmt.Errorf("Error reading the archive index: %s", err)
+	}
+	return &index, nil
+}
+
+// Tar returns an uncompressed tar archive of the entries of the index, in
+// which the regular files are included, with their content read from the
+// indexed archive r and verified against their digests, only if include
+// returns true for them. Hard links to the files which are left out are left
+// out too.
+func (index *ArchiveIndex) Tar(r io.ReaderAt, include func(*IndexEntry) bool) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(index.writeTar(pw, r, include))
+	}()
+	return pr
+}
+
+func (index *ArchiveIndex) writeTar(dest io.Writer, r io.ReaderAt, include func(*IndexEntry) bool) error {
+	var (
+		tw      = tar.NewWriter(dest)
+		leftOut = make(map[string]bool)
+	)
+	for _, e := range index.Entries {
+		switch {
+		case e.Typeflag == tar.TypeLink && leftOut[filepath.Clean(e.Linkname)]:
+			leftOut[filepath.Clean(e.Name)] = true
+			continue
+		case e.HasContent():
+			if !include(e) {
+				leftOut[filepath.Clean(e.Name)] = true
+				continue
+			}
+			if e.Digest == "" {
+				return fmt.Errorf("No digest of %s in the archive index", e.Name)
+			}
+			content, err := e.Open(r)
+			if err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(e.Header()); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, content); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tw.WriteHeader(e.Header()); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,207 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+type testEntry struct {
+	hdr     *tar.Header
+	content string
+}
+
+var indexedTestEntries = []testEntry{
+	{&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+	{&tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Size: 4}, "tool"},
+	{&tar.Header{Name: "bin/link", Typeflag: tar.TypeLink, Linkname: "bin/tool"}, ""},
+	{&tar.Header{Name: "data", Typeflag: tar.TypeReg, Mode: 0644, Size: 10}, "0123456789"},
+	{&tar.Header{Name: "data-link", Typeflag: tar.TypeLink, Linkname: "data"}, ""},
+	{&tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0644}, ""},
+	{&tar.Header{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "bin/tool"}, ""},
+	{&tar.Header{Name: ".wh.removed", Typeflag: tar.TypeReg, Mode: 0600}, ""},
+}
+
+func testTar(t *testing.T, entries []testEntry) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testIndexedArchive(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := NewIndexedWriter(buf)
+	if err := w.WriteArchive(bytes.NewReader(testTar(t, indexedTestEntries))); err != nil {
+		t.Fatal(err)
+	}
+	w.Index.Digest = "tarsum+sha256:0123"
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTestTar returns the contents of the entries of a tar archive by name.
+func readTestTar(t *testing.T, r io.Reader) map[string]string {
+	entries := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(content)
+	}
+}
+
+func TestIndexedArchiveDecompresses(t *testing.T) {
+	archive := testIndexedArchive(t)
+	if c := DetectCompression(archive); c != Gzip {
+		t.Fatalf("Expected an indexed archive to be compressed with gzip, got %s", c.Extension())
+	}
+	decompressed, err := DecompressStream(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decompressed.Close()
+
+	entries := readTestTar(t, decompressed)
+	if len(entries) != len(indexedTestEntries) {
+		t.Fatalf("Expected %d entries, got %d", len(indexedTestEntries), len(entries))
+	}
+	for _, e := range indexedTestEntries {
+		if content, exists := entries[e.hdr.Name]; !exists || content != e.content {
+			t.Fatalf("Expected %s to contain %q, got %q", e.hdr.Name, e.content, content)
+		}
+	}
+}
+
+func TestReadArchiveIndex(t *testing.T) {
+	archive := testIndexedArchive(t)
+	index, err := ReadArchiveIndex(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Digest != "tarsum+sha256:0123" {
+		t.Fatalf("Expected the digest of the archive, got %q", index.Digest)
+	}
+	if len(index.Entries) != len(indexedTestEntries) {
+		t.Fatalf("Expected %d entries, got %d", len(indexedTestEntries), len(index.Entries))
+	}
+	for i, e := range index.Entries {
+		if expected := indexedTestEntries[i]; e.Name != expected.hdr.Name || e.Typeflag != expected.hdr.Typeflag || e.Size != expected.hdr.Size {
+			t.Fatalf("Expected entry %d to be %s, got %s", i, expected.hdr.Name, e.Name)
+		}
+		content, err := e.Open(bytes.NewReader(archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != indexedTestEntries[i].content {
+			t.Fatalf("Expected %s to contain %q, got %q", e.Name, indexedTestEntries[i].content, buf)
+		}
+	}
+}
+
+func TestReadArchiveIndexNotIndexed(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(testTar(t, indexedTestEntries)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	for _, archive := range [][]byte{buf.Bytes(), testTar(t, indexedTestEntries), nil} {
+		if _, err := ReadArchiveIndex(bytes.NewReader(archive), int64(len(archive))); err != ErrNotIndexed {
+			t.Fatalf("Expected %v, got %v", ErrNotIndexed, err)
+		}
+	}
+}
+
+func TestArchiveIndexTar(t *testing.T) {
+	archive := testIndexedArchive(t)
+	index, err := ReadArchiveIndex(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := index.Tar(bytes.NewReader(archive), func(e *IndexEntry) bool {
+		return e.Name == "bin/tool"
+	})
+	defer rc.Close()
+
+	expected := map[string]string{
+		"bin/":        "",
+		"bin/tool":    "tool",
+		"bin/link":    "",
+		"empty":       "",
+		"symlink":     "",
+		".wh.removed": "",
+	}
+	if entries := readTestTar(t, rc); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected %v, got %v", expected, entries)
+	}
+}
+
+func TestArchiveIndexVerifiesContent(t *testing.T) {
+	archive := testIndexedArchive(t)
+	index, err := ReadArchiveIndex(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.HasDigests() {
+		t.Fatal("Expected the index to record the digests of the files")
+	}
+	var tool *IndexEntry
+	for _, e := range index.Entries {
+		if e.Name == "bin/tool" {
+			tool = e
+		}
+	}
+	// The content served for the entry doesn't match the index
+	tool.Digest = index.Entries[3].Digest
+	content, err := tool.Open(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(content); err == nil || !strings.Contains(err.Error(), "doesn't match the archive index") {
+		t.Fatalf("Expected the content of bin/tool to fail verification, got %v", err)
+	}
+	rc := index.Tar(bytes.NewReader(archive), func(e *IndexEntry) bool {
+		return e == tool
+	})
+	defer rc.Close()
+	if _, err := ioutil.ReadAll(rc); err == nil {
+		t.Fatal("Expected the tar archive of a file failing verification to fail")
+	}
+
+	tool.Digest = ""
+	if index.HasDigests() {
+		t.Fatal("Expected an index without the digest of bin/tool not to have digests")
+	}
+}
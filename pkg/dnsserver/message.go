@@ -0,0 +1,140 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	headerLen = 12
+
+	typeA    uint16 = 1
+	typeAAAA uint16 = 28
+	classIN  uint16 = 1
+
+	flagQR = 1 << 15
+	flagAA = 1 << 10
+	flagRD = 1 << 8
+	flagRA = 1 << 7
+
+	rcodeServFail = 2
+)
+
+var (
+	ErrShortMessage  = errors.New("dns message too short")
+	ErrBadName       = errors.New("malformed name in dns question")
+	ErrNoNameservers = errors.New("no upstream nameservers configured")
+)
+
+// question is the first (and in practice only) entry of the question
+// section of a DNS query.
+type question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	// raw holds the wire encoding of the question so it can be echoed
+	// back verbatim in the response.
+	raw []byte
+}
+
+// parseQuery extracts the header fields and the first question from msg.
+func parseQuery(msg []byte) (id, flags uint16, q *question, err error) {
+	if len(msg) < headerLen {
+		return 0, 0, nil, ErrShortMessage
+	}
+	id = binary.BigEndian.Uint16(msg[0:2])
+	flags = binary.BigEndian.Uint16(msg[2:4])
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		// Nobody sends more than one question in practice and the
+		// semantics for doing so are not well defined, so let the
+		// upstream servers deal with it.
+		return id, flags, nil, nil
+	}
+
+	var (
+		labels []string
+		off    = headerLen
+	)
+	for {
+		if off >= len(msg) {
+			return id, flags, nil, ErrBadName
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		// Compression pointers are not expected in the question of a query.
+		if l&0xC0 != 0 || off+l > len(msg) {
+			return id, flags, nil, ErrBadName
+		}
+		labels = append(labels, string(msg[off:off+l]))
+		off += l
+	}
+	if off+4 > len(msg) {
+		return id, flags, nil, ErrShortMessage
+	}
+	q = &question{
+		Name:  strings.ToLower(strings.Join(labels, ".")),
+		Type:  binary.BigEndian.Uint16(msg[off : off+2]),
+		Class: binary.BigEndian.Uint16(msg[off+2 : off+4]),
+		raw:   msg[headerLen : off+4],
+	}
+	return id, flags, q, nil
+}
+
+// buildResponse returns an authoritative answer to q containing an A or
+// AAAA record for each of the given addresses matching the queried type.
+// An empty set of addresses produces a NODATA response.
+func buildResponse(id, reqFlags uint16, q *question, ips []net.IP, ttl uint32) []byte {
+	var answers [][]byte
+	for _, ip := range ips {
+		var rdata []byte
+		switch q.Type {
+		case typeA:
+			rdata = ip.To4()
+		case typeAAAA:
+			if ip.To4() == nil {
+				rdata = ip.To16()
+			}
+		}
+		if rdata == nil {
+			continue
+		}
+		rr := make([]byte, 12+len(rdata))
+		// The name is a compression pointer to the question at offset 12.
+		binary.BigEndian.PutUint16(rr[0:2], 0xC000|headerLen)
+		binary.BigEndian.PutUint16(rr[2:4], q.Type)
+		binary.BigEndian.PutUint16(rr[4:6], classIN)
+		binary.BigEndian.PutUint32(rr[6:10], ttl)
+		binary.BigEndian.PutUint16(rr[10:12], uint16(len(rdata)))
+		copy(rr[12:], rdata)
+		answers = append(answers, rr)
+	}
+
+	flags := uint16(flagQR|flagAA|flagRA) | reqFlags&(0x7800|flagRD)
+	return buildMessage(id, flags, q, answers)
+}
+
+// buildError returns a response to q carrying the given rcode and no answers.
+func buildError(id, reqFlags uint16, q *question, rcode uint16) []byte {
+	flags := uint16(flagQR|flagRA) | reqFlags&(0x7800|flagRD) | rcode&0xF
+	return buildMessage(id, flags, q, nil)
+}
+
+func buildMessage(id, flags uint16, q *question, answers [][]byte) []byte {
+	msg := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], flags)
+	if q != nil {
+		binary.BigEndian.PutUint16(msg[4:6], 1)
+		msg = append(msg, q.raw...)
+	}
+	binary.BigEndian.PutUint16(msg[6:8], uint16(len(answers)))
+	for _, rr := range answers {
+		msg = append(msg, rr...)
+	}
+	return msg
+}
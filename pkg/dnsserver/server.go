@@ -0,0 +1,273 @@
+// Package dnsserver implements a small DNS server which answers A and AAAA
+// queries for locally known names and forwards everything else to a set of
+// upstream nameservers.
+package dnsserver
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultTTL is the TTL of the records served for local names. It is
+	// kept short since the addresses change whenever a container restarts.
+	DefaultTTL = 10
+
+	maxMessageSize   = 65535
+	upstreamTimeout  = 4 * time.Second
+	tcpClientTimeout = 10 * time.Second
+)
+
+// LookupFunc resolves name on behalf of the client with the address src.
+// It returns the addresses for name and whether name is known locally at
+// all; unknown names are forwarded to the upstream nameservers.
+type LookupFunc func(name string, src net.IP) ([]net.IP, bool)
+
+// Server serves DNS over UDP and TCP on a single address.
+type Server struct {
+	addr   string
+	lookup LookupFunc
+
+	sync.Mutex
+	nameservers []string
+
+	udpConn     *net.UDPConn
+	tcpListener *net.TCPListener
+}
+
+// New returns a server that listens on addr (host:port) and resolves
+// local names using lookup.
+func New(addr string, lookup LookupFunc) *Server {
+	return &Server{
+		addr:   addr,
+		lookup: lookup,
+	}
+}
+
+// SetNameservers replaces the list of upstream nameservers. Entries are IP
+// addresses, optionally with a port; port 53 is assumed when it is omitted.
+func (s *Server) SetNameservers(nameservers []string) {
+	upstream := make([]string, 0, len(nameservers))
+	for _, ns := range nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, "53")
+		}
+		upstream = append(upstream, ns)
+	}
+	s.Lock()
+	s.nameservers = upstream
+	s.Unlock()
+}
+
+func (s *Server) getNameservers() []string {
+	s.Lock()
+	defer s.Unlock()
+	return s.nameservers
+}
+
+// Start binds the UDP and TCP listeners and starts serving requests in the
+// background.
+func (s *Server) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	// Use the same port for TCP when an ephemeral one was requested.
+	tcpAddr := &net.TCPAddr{IP: udpAddr.IP, Port: udpConn.LocalAddr().(*net.UDPAddr).Port}
+	tcpListener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+	s.udpConn = udpConn
+	s.tcpListener = tcpListener
+
+	go s.serveUDP()
+	go s.serveTCP()
+	return nil
+}
+
+// LocalAddr returns the UDP address the server is bound to.
+func (s *Server) LocalAddr() net.Addr {
+	if s.udpConn == nil {
+		return nil
+	}
+	return s.udpConn.LocalAddr()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	if s.udpConn == nil {
+		return nil
+	}
+	s.tcpListener.Close()
+	return s.udpConn.Close()
+}
+
+func (s *Server) serveUDP() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := s.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if !isClosedError(err) {
+				logrus.Errorf("dns: stopping UDP server: %v", err)
+			}
+			return
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			resp := s.handle(query, from.IP, "udp")
+			if resp == nil {
+				return
+			}
+			if _, err := s.udpConn.WriteToUDP(resp, from); err != nil {
+				logrus.Debugf("dns: failed to write response to %s: %v", from, err)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.tcpListener.AcceptTCP()
+		if err != nil {
+			if !isClosedError(err) {
+				logrus.Errorf("dns: stopping TCP server: %v", err)
+			}
+			return
+		}
+		go s.handleTCPConn(conn)
+	}
+}
+
+func (s *Server) handleTCPConn(conn *net.TCPConn) {
+	defer conn.Close()
+	src := conn.RemoteAddr().(*net.TCPAddr).IP
+	for {
+		conn.SetDeadline(time.Now().Add(tcpClientTimeout))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			if err != io.EOF {
+				logrus.Debugf("dns: failed to read TCP query from %s: %v", src, err)
+			}
+			return
+		}
+		resp := s.handle(query, src, "tcp")
+		if resp == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, resp); err != nil {
+			logrus.Debugf("dns: failed to write TCP response to %s: %v", src, err)
+			return
+		}
+	}
+}
+
+// handle returns the response to query, either answered locally or obtained
+// from an upstream nameserver over the given network.
+func (s *Server) handle(query []byte, src net.IP, network string) []byte {
+	id, flags, q, err := parseQuery(query)
+	if err != nil {
+		logrus.Debugf("dns: dropping malformed query from %s: %v", src, err)
+		return nil
+	}
+	// Only standard queries for A and AAAA records are answered locally.
+	if q != nil && flags&flagQR == 0 && flags&0x7800 == 0 && q.Class == classIN &&
+		(q.Type == typeA || q.Type == typeAAAA) {
+		if ips, found := s.lookup(q.Name, src); found {
+			return buildResponse(id, flags, q, ips, DefaultTTL)
+		}
+	}
+
+	resp, err := s.forward(query, network)
+	if err != nil {
+		logrus.Debugf("dns: failed to forward query from %s: %v", src, err)
+		return buildError(id, flags, q, rcodeServFail)
+	}
+	return resp
+}
+
+// forward relays query to the upstream nameservers in order and returns the
+// first response received.
+func (s *Server) forward(query []byte, network string) ([]byte, error) {
+	nameservers := s.getNameservers()
+	if len(nameservers) == 0 {
+		return nil, ErrNoNameservers
+	}
+	var lastErr error
+	for _, ns := range nameservers {
+		resp, err := exchange(query, network, ns)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func exchange(query []byte, network, nameserver string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, nameserver, upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray datagrams which don't belong to this query.
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var l uint16
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func isClosedError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Err.Error() == "use of closed network connection"
+}
@@ -0,0 +1,156 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], flagRD)
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, byte(classIN))
+	return msg
+}
+
+// answers returns the rcode and the addresses in the answer section of resp.
+func answers(t *testing.T, resp []byte, query []byte) (int, []net.IP) {
+	if len(resp) < len(query) {
+		t.Fatalf("Response too short: %v", resp)
+	}
+	if resp[0] != query[0] || resp[1] != query[1] {
+		t.Fatalf("Response id mismatch")
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	if flags&flagQR == 0 {
+		t.Fatalf("Expected QR flag to be set in response")
+	}
+	var (
+		ips []net.IP
+		off = len(query)
+	)
+	for i := 0; i < int(binary.BigEndian.Uint16(resp[6:8])); i++ {
+		l := int(binary.BigEndian.Uint16(resp[off+10 : off+12]))
+		ips = append(ips, net.IP(resp[off+12:off+12+l]))
+		off += 12 + l
+	}
+	return int(flags & 0xF), ips
+}
+
+func startServer(t *testing.T, names map[string][]net.IP) *Server {
+	s := New("127.0.0.1:0", func(name string, src net.IP) ([]net.IP, bool) {
+		ips, found := names[name]
+		return ips, found
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func exchangeUDP(t *testing.T, s *Server, query []byte) []byte {
+	resp, err := exchange(query, "udp", s.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestLocalLookup(t *testing.T) {
+	s := startServer(t, map[string][]net.IP{
+		"web": {net.ParseIP("172.17.0.5"), net.ParseIP("2001:db8::5")},
+	})
+	defer s.Close()
+
+	query := newQuery(1, "Web", typeA)
+	rcode, ips := answers(t, exchangeUDP(t, s, query), query)
+	if rcode != 0 {
+		t.Fatalf("Expected rcode 0, got %d", rcode)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("172.17.0.5")) {
+		t.Fatalf("Expected 172.17.0.5, got %v", ips)
+	}
+
+	query = newQuery(2, "web", typeAAAA)
+	_, ips = answers(t, exchangeUDP(t, s, query), query)
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("2001:db8::5")) {
+		t.Fatalf("Expected 2001:db8::5, got %v", ips)
+	}
+}
+
+func TestLocalLookupOverTCP(t *testing.T) {
+	s := startServer(t, map[string][]net.IP{"db": {net.ParseIP("172.17.0.6")}})
+	defer s.Close()
+
+	query := newQuery(3, "db", typeA)
+	resp, err := exchange(query, "tcp", s.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ips := answers(t, resp, query); len(ips) != 1 || !ips[0].Equal(net.ParseIP("172.17.0.6")) {
+		t.Fatalf("Expected 172.17.0.6, got %v", ips)
+	}
+}
+
+func TestForwardToUpstream(t *testing.T) {
+	upstream := startServer(t, map[string][]net.IP{"example.com": {net.ParseIP("192.0.2.1")}})
+	defer upstream.Close()
+
+	s := startServer(t, map[string][]net.IP{"web": {net.ParseIP("172.17.0.5")}})
+	defer s.Close()
+	// The first nameserver doesn't answer at all, the second one does.
+	s.SetNameservers([]string{"127.0.0.1:1", upstream.LocalAddr().String()})
+
+	query := newQuery(4, "example.com", typeA)
+	_, ips := answers(t, exchangeUDP(t, s, query), query)
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("Expected 192.0.2.1, got %v", ips)
+	}
+}
+
+func TestForwardWithoutNameservers(t *testing.T) {
+	s := startServer(t, nil)
+	defer s.Close()
+
+	query := newQuery(5, "example.com", typeA)
+	if rcode, _ := answers(t, exchangeUDP(t, s, query), query); rcode != rcodeServFail {
+		t.Fatalf("Expected SERVFAIL, got rcode %d", rcode)
+	}
+}
+
+func TestSetNameserversDefaultPort(t *testing.T) {
+	s := New("127.0.0.1:0", nil)
+	s.SetNameservers([]string{"8.8.8.8", "2001:4860:4860::8888", "10.0.0.1:5353"})
+	expected := []string{"8.8.8.8:53", "[2001:4860:4860::8888]:53", "10.0.0.1:5353"}
+	for i, ns := range s.getNameservers() {
+		if ns != expected[i] {
+			t.Fatalf("Expected %s, got %s", expected[i], ns)
+		}
+	}
+}
+
+func TestMalformedQuery(t *testing.T) {
+	s := startServer(t, nil)
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 512)); err == nil {
+		t.Fatal("Expected no response to a malformed query")
+	}
+}
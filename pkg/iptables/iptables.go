@@ -31,9 +31,10 @@ var (
 )
 
 type Chain struct {
-	Name   string
-	Bridge string
-	Table  Table
+	Name        string
+	Bridge      string
+	Table       Table
+	HairpinMode bool
 }
 
 type ChainError struct {
@@ -58,11 +59,16 @@ func initCheck() error {
 	return nil
 }
 
-func NewChain(name, bridge string, table Table) (*Chain, error) {
+// NewChain creates the chain in the given table and hooks it into the
+// built-in chains. In hairpin mode, traffic from the bridge and from the
+// host's loopback addresses to published ports is handled by the chain too,
+// instead of being left to the userland proxy.
+func NewChain(name, bridge string, table Table, hairpinMode bool) (*Chain, error) {
 	c := &Chain{
-		Name:   name,
-		Bridge: bridge,
-		Table:  table,
+		Name:        name,
+		Bridge:      bridge,
+		Table:       table,
+		HairpinMode: hairpinMode,
 	}
 
 	if string(c.Table) == "" {
@@ -90,8 +96,10 @@ func NewChain(name, bridge string, table Table) (*Chain, error) {
 		}
 		output := []string{
 			"-m", "addrtype",
-			"--dst-type", "LOCAL",
-			"!", "--dst", "127.0.0.0/8"}
+			"--dst-type", "LOCAL"}
+		if !hairpinMode {
+			output = append(output, "!", "--dst", "127.0.0.0/8")
+		}
 		if !Exists(Nat, "OUTPUT", output...) {
 			if err := c.Output(Append, output...); err != nil {
 				return nil, fmt.Errorf("Failed to inject docker in OUTPUT chain: %s", err)
@@ -133,13 +141,15 @@ func (c *Chain) Forward(action Action, ip net.IP, port int, proto, destAddr stri
 		// value" by both iptables and ip6tables.
 		daddr = "0/0"
 	}
-	if output, err := Raw("-t", string(Nat), string(action), c.Name,
+	args := []string{"-t", string(Nat), string(action), c.Name,
 		"-p", proto,
 		"-d", daddr,
-		"--dport", strconv.Itoa(port),
-		"!", "-i", c.Bridge,
-		"-j", "DNAT",
-		"--to-destination", net.JoinHostPort(destAddr, strconv.Itoa(destPort))); err != nil {
+		"--dport", strconv.Itoa(port)}
+	if !c.HairpinMode {
+		args = append(args, "!", "-i", c.Bridge)
+	}
+	args = append(args, "-j", "DNAT", "--to-destination", net.JoinHostPort(destAddr, strconv.Itoa(destPort)))
+	if output, err := Raw(args...); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return &ChainError{Chain: "FORWARD", Output: output}
@@ -199,6 +209,98 @@ func (c *Chain) Link(action Action, ip1, ip2 net.IP, port int, proto string) err
 	return nil
 }
 
+// NewEgressChain creates a filter chain restricting the traffic that the
+// container with the given address sends out of the bridge or to the host
+// itself. Replies on established connections are let through and everything
+// else is dropped, unless it is accepted by a rule added with Allow. Traffic
+// between containers on the bridge is not affected.
+func NewEgressChain(name, bridge string, ip net.IP) (*Chain, error) {
+	// Clean up a chain left behind by a previous daemon.
+	if err := RemoveEgressChain(name); err != nil {
+		return nil, err
+	}
+	c := &Chain{
+		Name:   name,
+		Bridge: bridge,
+		Table:  Filter,
+	}
+
+	if output, err := Raw("-t", string(c.Table), "-N", c.Name); err != nil {
+		return nil, err
+	} else if len(output) != 0 {
+		return nil, fmt.Errorf("Could not create %s/%s chain: %s", c.Table, c.Name, output)
+	}
+	for _, rule := range [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		{"-j", "DROP"},
+	} {
+		if output, err := Raw(append([]string{string(Append), c.Name}, rule...)...); err != nil {
+			RemoveEgressChain(c.Name)
+			return nil, err
+		} else if len(output) != 0 {
+			RemoveEgressChain(c.Name)
+			return nil, &ChainError{Chain: c.Name, Output: output}
+		}
+	}
+
+	for _, hook := range [][]string{
+		{"FORWARD", "-s", ip.String(), "-i", c.Bridge, "!", "-o", c.Bridge},
+		// Traffic to the host's own addresses doesn't go through FORWARD
+		{"INPUT", "-s", ip.String(), "-i", c.Bridge},
+	} {
+		if output, err := Raw(append(append([]string{string(Insert)}, hook...), "-j", c.Name)...); err != nil {
+			RemoveEgressChain(c.Name)
+			return nil, err
+		} else if len(output) != 0 {
+			RemoveEgressChain(c.Name)
+			return nil, fmt.Errorf("Could not create linking rule to %s/%s: %s", c.Table, c.Name, output)
+		}
+	}
+	return c, nil
+}
+
+// RemoveEgressChain unhooks the egress chain from the FORWARD and INPUT
+// chains and deletes it. It is not an error if the chain doesn't exist.
+func RemoveEgressChain(name string) error {
+	for _, builtin := range []string{"FORWARD", "INPUT"} {
+		rules, err := Raw("-S", builtin)
+		if err != nil {
+			return err
+		}
+		for _, rule := range strings.Split(string(rules), "\n") {
+			// Rules are listed as "-A <chain> <match> -j <target>"
+			fields := strings.Fields(rule)
+			if len(fields) < 4 || fields[0] != "-A" || fields[len(fields)-2] != "-j" || fields[len(fields)-1] != name {
+				continue
+			}
+			if _, err := Raw(append([]string{string(Delete)}, fields[1:]...)...); err != nil {
+				return err
+			}
+		}
+	}
+	// Ignore errors - the chain may not exist
+	Raw("-t", string(Filter), "-F", name)
+	Raw("-t", string(Filter), "-X", name)
+	return nil
+}
+
+// Allow adds or removes a rule to the egress chain accepting the traffic to
+// the network dest. An empty proto matches any protocol, otherwise port is
+// a single port or a range in the start:end notation. Use Insert to add a
+// rule, so that it comes before the chain's final DROP rule.
+func (c *Chain) Allow(action Action, dest *net.IPNet, proto, port string) error {
+	args := []string{"-t", string(Filter), string(action), c.Name, "-d", dest.String()}
+	if proto != "" {
+		args = append(args, "-p", proto, "--dport", port)
+	}
+	if output, err := Raw(append(args, "-j", "ACCEPT")...); err != nil {
+		return err
+	} else if len(output) != 0 {
+		return &ChainError{Chain: c.Name, Output: output}
+	}
+	return nil
+}
+
 // Add linking rule to nat/PREROUTING chain.
 func (c *Chain) Prerouting(action Action, args ...string) error {
 	a := []string{"-t", string(Nat), string(action), "PREROUTING"}
@@ -232,7 +334,7 @@ func (c *Chain) Remove() error {
 	if c.Table == Nat {
 		c.Prerouting(Delete, "-m", "addrtype", "--dst-type", "LOCAL")
 		c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", "127.0.0.0/8")
-		c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL") // Created in versions <= 0.1.6
+		c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL") // Created in versions <= 0.1.6 and in hairpin mode
 
 		c.Prerouting(Delete)
 		c.Output(Delete)
@@ -32,6 +32,7 @@ type AuthConfig struct {
 	Auth          string `json:"auth"`
 	Email         string `json:"email"`
 	ServerAddress string `json:"serveraddress,omitempty"`
+	CredsHelper   string `json:"credsHelper,omitempty"`
 }
 
 type ConfigFile struct {
@@ -45,6 +46,8 @@ type RequestAuthorization struct {
 	resource         string
 	scope            string
 	actions          []string
+	// Scopes of other resources the token is requested for
+	extraScopes []string
 
 	tokenLock       sync.Mutex
 	tokenCache      string
@@ -83,7 +86,8 @@ func (auth *RequestAuthorization) getToken() (string, error) {
 			for k, v := range challenge.Parameters {
 				params[k] = v
 			}
-			params["scope"] = fmt.Sprintf("%s:%s:%s", auth.resource, auth.scope, strings.Join(auth.actions, ","))
+			scopes := append([]string{fmt.Sprintf("%s:%s:%s", auth.resource, auth.scope, strings.Join(auth.actions, ","))}, auth.extraScopes...)
+			params["scope"] = strings.Join(scopes, " ")
 			token, err := getToken(auth.authConfig.Username, auth.authConfig.Password, params, auth.registryEndpoint, client, factory)
 			if err != nil {
 				return "", err
@@ -182,9 +186,13 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		configFile.Configs[IndexServerAddress()] = authConfig
 	} else {
 		for k, authConfig := range configFile.Configs {
-			authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
-			if err != nil {
-				return &configFile, err
+			// The credentials of registries using a credential helper
+			// are only got from the helper when needed.
+			if authConfig.CredsHelper == "" {
+				authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
+				if err != nil {
+					return &configFile, err
+				}
 			}
 			authConfig.Auth = ""
 			authConfig.ServerAddress = k
@@ -206,7 +214,9 @@ func SaveConfig(configFile *ConfigFile) error {
 	for k, authConfig := range configFile.Configs {
 		authCopy := authConfig
 
-		authCopy.Auth = encodeAuth(&authCopy)
+		if authCopy.CredsHelper == "" {
+			authCopy.Auth = encodeAuth(&authCopy)
+		}
 		authCopy.Username = ""
 		authCopy.Password = ""
 		authCopy.ServerAddress = ""
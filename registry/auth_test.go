@@ -2,7 +2,11 @@ package registry
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -170,3 +174,39 @@ func TestResolveAuthConfigFullURL(t *testing.T) {
 		}
 	}
 }
+
+func TestMountAuthorizationScopes(t *testing.T) {
+	var scopes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes = r.URL.Query()["scope"]
+		w.Write([]byte(`{"token": "t"}`))
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoint{
+		URL:     u,
+		Version: APIVersion2,
+		AuthChallenges: []*AuthorizationChallenge{
+			{Scheme: "bearer", Parameters: map[string]string{"realm": ts.URL + "/token"}},
+		},
+	}
+
+	r := &Session{authConfig: &AuthConfig{Username: "foo", Password: "bar"}}
+	req, err := http.NewRequest("POST", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.GetV2MountAuthorization(ep, "foo/bar", "foo/source").Authorize(req); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"repository:foo/bar:pull,push", "repository:foo/source:pull"}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("Expected the token to be requested for %v, got %v", expected, scopes)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer t" {
+		t.Fatalf("Expected the request to be authorized with the token, got %q", auth)
+	}
+}
@@ -48,7 +48,7 @@ func IndexServerName() string {
 // the current process.
 func (options *Options) InstallFlags() {
 	options.Mirrors = opts.NewListOpts(ValidateMirror)
-	flag.Var(&options.Mirrors, []string{"-registry-mirror"}, "Preferred Docker registry mirror")
+	flag.Var(&options.Mirrors, []string{"-registry-mirror"}, "Preferred Docker registry mirror, REGISTRY=URL for other registries")
 	options.InsecureRegistries = opts.NewListOpts(ValidateIndexName)
 	flag.Var(&options.InsecureRegistries, []string{"-insecure-registry"}, "Enable insecure registry communication")
 }
@@ -114,10 +114,32 @@ func NewServiceConfig(options *Options) *ServiceConfig {
 		}
 	}
 
+	// Split --registry-mirror into mirrors of the public registry and
+	// mirrors of other registries, given as REGISTRY=URL.
+	officialMirrors := make([]string, 0)
+	for _, m := range options.Mirrors.GetAll() {
+		indexName, mirror := splitMirror(m)
+		if indexName == "" || indexName == IndexServerName() {
+			officialMirrors = append(officialMirrors, mirror)
+			continue
+		}
+		index, ok := config.IndexConfigs[indexName]
+		if !ok {
+			index = &IndexInfo{
+				Name:     indexName,
+				Mirrors:  make([]string, 0),
+				Secure:   config.isSecureIndex(indexName),
+				Official: false,
+			}
+			config.IndexConfigs[indexName] = index
+		}
+		index.Mirrors = append(index.Mirrors, mirror)
+	}
+
 	// Configure public registry.
 	config.IndexConfigs[IndexServerName()] = &IndexInfo{
 		Name:     IndexServerName(),
-		Mirrors:  options.Mirrors.GetAll(),
+		Mirrors:  officialMirrors,
 		Secure:   true,
 		Official: true,
 	}
@@ -174,8 +196,37 @@ func (config *ServiceConfig) isSecureIndex(indexName string) bool {
 	return true
 }
 
-// ValidateMirror validates an HTTP(S) registry mirror
+// splitMirror splits a validated mirror into the name of the registry it
+// mirrors, empty for the public registry, and its URL.
+func splitMirror(val string) (string, string) {
+	if i := strings.Index(val, "="); i > 0 && i < strings.Index(val, "://") {
+		return val[:i], val[i+1:]
+	}
+	return "", val
+}
+
+// ValidateMirror validates an HTTP(S) registry mirror, given as URL for the
+// public registry or as REGISTRY=URL for another registry.
 func ValidateMirror(val string) (string, error) {
+	indexName, val := splitMirror(val)
+	if indexName != "" {
+		if strings.Contains(indexName, "/") {
+			return "", fmt.Errorf("Invalid registry %s for mirror %s", indexName, val)
+		}
+		var err error
+		if indexName, err = ValidateIndexName(indexName); err != nil {
+			return "", err
+		}
+		mirror, err := ValidateMirror(val)
+		if err != nil {
+			return "", err
+		}
+		if indexName == IndexServerName() {
+			return mirror, nil
+		}
+		return indexName + "=" + mirror, nil
+	}
+
 	uri, err := url.Parse(val)
 	if err != nil {
 		return "", fmt.Errorf("%s is not a valid URI", val)
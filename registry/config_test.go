@@ -16,6 +16,8 @@ func TestValidateMirror(t *testing.T) {
 		"https://127.0.0.1",
 		"http://127.0.0.1:5000",
 		"https://127.0.0.1:5000",
+		"example.com=http://mirror-1.com",
+		"localhost:5000=https://mirror-1.com:5000",
 	}
 
 	invalid := []string{
@@ -33,6 +35,10 @@ func TestValidateMirror(t *testing.T) {
 		"https://mirror-1.com/v1/",
 		"https://mirror-1.com/v1/#",
 		"https://mirror-1.com?q",
+		"example.com=ftp://mirror-1.com",
+		"example.com=https://mirror-1.com/v1/",
+		"example.com/foo=http://mirror-1.com",
+		"=http://mirror-1.com",
 	}
 
 	for _, address := range valid {
@@ -47,3 +53,18 @@ func TestValidateMirror(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateMirrorOfRegistry(t *testing.T) {
+	expected := map[string]string{
+		"http://mirror-1.com":                      "http://mirror-1.com/v1/",
+		"example.com=http://mirror-1.com":          "example.com=http://mirror-1.com/v1/",
+		"localhost:5000=https://mirror-1.com:5000": "localhost:5000=https://mirror-1.com:5000/v1/",
+		IndexServerName() + "=http://mirror-1.com": "http://mirror-1.com/v1/",
+		"index.docker.io=https://mirror-1.com":     "https://mirror-1.com/v1/",
+	}
+	for address, want := range expected {
+		if ret, err := ValidateMirror(address); err != nil || ret != want {
+			t.Errorf("ValidateMirror(`%s`) got %s %v, expected %s", address, ret, err, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// credentialHelperPrefix prefixes the name of a credential helper to get the
+// program implementing it.
+const credentialHelperPrefix = "docker-credential-"
+
+var (
+	// ErrCredentialsNotFound is returned when a credential helper has no
+	// credentials for a registry.
+	ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+)
+
+// helperCredentials are the credentials exchanged with credential helpers.
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// runCredentialHelper runs the action of the credential helper with the
+// given input and returns its output. Helpers print the reason they failed on
+// their output.
+func runCredentialHelper(helper, action string, input io.Reader) ([]byte, error) {
+	cmd := exec.Command(credentialHelperPrefix+helper, action)
+	cmd.Stdin = input
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == ErrCredentialsNotFound.Error() {
+			return nil, ErrCredentialsNotFound
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("Error running credential helper %s %s: %s", helper, action, msg)
+	}
+	return out, nil
+}
+
+// GetHelperCredentials fills in the username and password of the auth config
+// from its credential helper. Auth configs without a credential helper, or
+// whose credentials are already known, are left untouched.
+func GetHelperCredentials(authConfig *AuthConfig) error {
+	if authConfig.CredsHelper == "" || authConfig.Username != "" {
+		return nil
+	}
+	out, err := runCredentialHelper(authConfig.CredsHelper, "get", strings.NewReader(authConfig.ServerAddress))
+	if err != nil {
+		return err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return fmt.Errorf("Invalid credentials from credential helper %s: %s", authConfig.CredsHelper, err)
+	}
+	authConfig.Username = creds.Username
+	authConfig.Password = creds.Secret
+	return nil
+}
+
+// StoreHelperCredentials stores the username and password of the auth config
+// with its credential helper.
+func StoreHelperCredentials(authConfig *AuthConfig) error {
+	b, err := json.Marshal(helperCredentials{
+		ServerURL: authConfig.ServerAddress,
+		Username:  authConfig.Username,
+		Secret:    authConfig.Password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = runCredentialHelper(authConfig.CredsHelper, "store", bytes.NewReader(b))
+	return err
+}
+
+// EraseHelperCredentials erases the credentials of the auth config from its
+// credential helper.
+func EraseHelperCredentials(authConfig *AuthConfig) error {
+	_, err := runCredentialHelper(authConfig.CredsHelper, "erase", strings.NewReader(authConfig.ServerAddress))
+	return err
+}
+
+// LoadHelperCredentials fills in the credentials of all the registries of
+// the config file which use a credential helper, skipping those the helper
+// has no credentials for or fails to return the credentials of.
+func (config *ConfigFile) LoadHelperCredentials() {
+	for k, authConfig := range config.Configs {
+		if err := GetHelperCredentials(&authConfig); err != nil {
+			if err == ErrCredentialsNotFound {
+				logrus.Debugf("No credentials for %s in credential helper %s", k, authConfig.CredsHelper)
+			} else {
+				logrus.Warnf("Unable to get the credentials for %s from credential helper %s: %s", k, authConfig.CredsHelper, err)
+			}
+			continue
+		}
+		config.Configs[k] = authConfig
+	}
+}
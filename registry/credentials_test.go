@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupCredentialHelper puts the test credential helper in the PATH, storing
+// credentials in a temporary directory.
+func setupCredentialHelper(t *testing.T) func() {
+	store, err := ioutil.TempDir("", "docker-test-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", testdata+string(os.PathListSeparator)+path)
+	os.Setenv("DOCKER_CREDENTIAL_TEST_STORE", store)
+	return func() {
+		os.Setenv("PATH", path)
+		os.Unsetenv("DOCKER_CREDENTIAL_TEST_STORE")
+		os.RemoveAll(store)
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	defer setupCredentialHelper(t)()
+
+	authConfig := &AuthConfig{
+		Username:      "docker-user",
+		Password:      "docker-pass",
+		ServerAddress: "https://registry.example.com/v1/",
+		CredsHelper:   "test",
+	}
+	if err := StoreHelperCredentials(authConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &AuthConfig{ServerAddress: authConfig.ServerAddress, CredsHelper: "test"}
+	if err := GetHelperCredentials(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "docker-user" || got.Password != "docker-pass" {
+		t.Fatalf("Expected docker-user:docker-pass, got %s:%s", got.Username, got.Password)
+	}
+
+	other := &AuthConfig{ServerAddress: "other.example.com", CredsHelper: "test"}
+	if err := GetHelperCredentials(other); err != ErrCredentialsNotFound {
+		t.Fatalf("Expected %q, got %v", ErrCredentialsNotFound, err)
+	}
+
+	if err := EraseHelperCredentials(authConfig); err != nil {
+		t.Fatal(err)
+	}
+	got = &AuthConfig{ServerAddress: authConfig.ServerAddress, CredsHelper: "test"}
+	if err := GetHelperCredentials(got); err != ErrCredentialsNotFound {
+		t.Fatalf("Expected %q after erasing the credentials, got %v", ErrCredentialsNotFound, err)
+	}
+	if err := EraseHelperCredentials(authConfig); err != ErrCredentialsNotFound {
+		t.Fatalf("Expected %q erasing missing credentials, got %v", ErrCredentialsNotFound, err)
+	}
+}
+
+func TestCredentialHelperMissing(t *testing.T) {
+	authConfig := &AuthConfig{ServerAddress: "registry.example.com", CredsHelper: "missing"}
+	err := GetHelperCredentials(authConfig)
+	if err == nil || !strings.Contains(err.Error(), "credential helper missing") {
+		t.Fatalf("Expected an error running the missing helper, got %v", err)
+	}
+}
+
+func TestConfigFileWithCredentialHelper(t *testing.T) {
+	defer setupCredentialHelper(t)()
+
+	configFile, err := setupTempConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configFile.rootPath)
+
+	helperAuth := AuthConfig{
+		Username:      "helper-user",
+		Password:      "helper-pass",
+		Email:         "helper@example.com",
+		ServerAddress: "helperIndex",
+		CredsHelper:   "test",
+	}
+	if err := StoreHelperCredentials(&helperAuth); err != nil {
+		t.Fatal(err)
+	}
+	configFile.Configs["helperIndex"] = helperAuth
+	configFile.Configs["emptyIndex"] = AuthConfig{CredsHelper: "test"}
+	configFile.Configs["brokenIndex"] = AuthConfig{CredsHelper: "missing"}
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(configFile.rootPath, CONFIGFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "helper-") {
+		t.Fatalf("Expected the credentials not to be saved in the config file:\n%s", b)
+	}
+
+	configFile, err = LoadConfig(configFile.rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authConfig := configFile.Configs["helperIndex"]; authConfig.Username != "" || authConfig.CredsHelper != "test" || authConfig.Email != "helper@example.com" {
+		t.Fatalf("Expected the credential helper to be loaded without credentials, got %+v", authConfig)
+	}
+	// A failing helper doesn't prevent loading the other credentials
+	configFile.LoadHelperCredentials()
+	if authConfig := configFile.Configs["helperIndex"]; authConfig.Username != "helper-user" || authConfig.Password != "helper-pass" {
+		t.Fatalf("Expected the credentials of the helper, got %+v", authConfig)
+	}
+	for _, k := range []string{"emptyIndex", "brokenIndex"} {
+		if authConfig := configFile.Configs[k]; authConfig.Username != "" {
+			t.Fatalf("Expected no credentials for %s, got %+v", k, authConfig)
+		}
+	}
+	if authConfig := configFile.Configs["testIndex"]; authConfig.Username != "docker-user" || authConfig.Password != "docker-pass" {
+		t.Fatalf("Expected the credentials of the config file, got %+v", authConfig)
+	}
+}
@@ -56,6 +56,25 @@ func NewEndpoint(index *IndexInfo) (*Endpoint, error) {
 	return endpoint, nil
 }
 
+// NewMirrorEndpoint returns the v2 endpoint of a registry mirror, as given
+// to --registry-mirror, or an error if the mirror doesn't support the v2
+// API.
+func NewMirrorEndpoint(mirror string) (*Endpoint, error) {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := newEndpoint(fmt.Sprintf("%s://%s/v2/", u.Scheme, u.Host), u.Scheme == "https")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := endpoint.Ping(); err != nil {
+		return nil, err
+	}
+	endpoint.URLBuilder = v2.NewURLBuilder(endpoint.URL)
+	return endpoint, nil
+}
+
 func validateEndpoint(endpoint *Endpoint) error {
 	logrus.Debugf("pinging registry endpoint %s", endpoint)
 
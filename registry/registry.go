@@ -20,7 +20,7 @@ import (
 var (
 	ErrAlreadyExists = errors.New("Image already exists")
 	ErrDoesNotExist  = errors.New("Image does not exist")
-	errLoginRequired = errors.New("Authentication is required.")
+	ErrLoginRequired = errors.New("Authentication is required.")
 )
 
 type TimeoutType uint32
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/docker/docker/pkg/parsers/filters"
 	"github.com/docker/docker/pkg/requestdecorator"
 )
 
@@ -652,6 +653,29 @@ func TestNewIndexInfo(t *testing.T) {
 	}
 	testIndexInfo(config, expectedIndexInfos)
 
+	config = makeServiceConfig([]string{"http://mirror1.local", "example.com=http://mirror2.local", "example.com=http://mirror3.local"}, []string{"example.com"})
+	expectedIndexInfos = map[string]*IndexInfo{
+		IndexServerName(): {
+			Name:     IndexServerName(),
+			Official: true,
+			Secure:   true,
+			Mirrors:  []string{"http://mirror1.local"},
+		},
+		"example.com": {
+			Name:     "example.com",
+			Official: false,
+			Secure:   false,
+			Mirrors:  []string{"http://mirror2.local", "http://mirror3.local"},
+		},
+		"other.com": {
+			Name:     "other.com",
+			Official: false,
+			Secure:   true,
+			Mirrors:  noMirrors,
+		},
+	}
+	testIndexInfo(config, expectedIndexInfos)
+
 	config = makeServiceConfig(nil, []string{"42.42.0.0/16"})
 	expectedIndexInfos = map[string]*IndexInfo{
 		"example.com": {
@@ -726,7 +750,7 @@ func TestPushImageJSONIndex(t *testing.T) {
 
 func TestSearchRepositories(t *testing.T) {
 	r := spawnTestRegistrySession(t)
-	results, err := r.SearchRepositories("fakequery")
+	results, err := r.SearchRepositories("fakequery", DefaultSearchLimit, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -736,6 +760,16 @@ func TestSearchRepositories(t *testing.T) {
 	assertEqual(t, results.NumResults, 1, "Expected 1 search results")
 	assertEqual(t, results.Query, "fakequery", "Expected 'fakequery' as query")
 	assertEqual(t, results.Results[0].StarCount, 42, "Expected 'fakeimage' a ot hae 42 stars")
+
+	results, err = r.SearchRepositories("fakequery", DefaultSearchLimit, filters.Args{"stars": {"43"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, results.NumResults, 0, "Expected no results with more than 42 stars")
+
+	if _, err := r.SearchRepositories("fakequery", DefaultSearchLimit, filters.Args{"unknown": {"true"}}); err == nil {
+		t.Fatal("Expected an error searching with an unknown filter")
+	}
 }
 
 func TestValidRemoteName(t *testing.T) {
@@ -901,3 +935,28 @@ func TestIsSecureIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		start, size  int64
+		valid        bool
+	}{
+		{"bytes 0-99/100", 0, 100, true},
+		{"bytes 42-99/100", 42, 100, true},
+		{"bytes 42-99/*", 42, -1, true},
+		{"bytes */100", 0, 0, false},
+		{"42-99/100", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, size, err := parseContentRange(tt.contentRange)
+		if tt.valid != (err == nil) {
+			t.Errorf("parseContentRange(%q): expected valid=%v, got %v", tt.contentRange, tt.valid, err)
+			continue
+		}
+		if tt.valid && (start != tt.start || size != tt.size) {
+			t.Errorf("parseContentRange(%q): expected %d, %d, got %d, %d", tt.contentRange, tt.start, tt.size, start, size)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/parsers/filters"
+)
+
+const (
+	// DefaultSearchLimit is the number of search results returned when no
+	// limit is given.
+	DefaultSearchLimit = 25
+	// MaxSearchLimit is the maximum number of search results returned.
+	MaxSearchLimit = 100
+
+	// catalogPageSize is the number of repositories fetched per request to
+	// the catalog of v2 registries.
+	catalogPageSize = 100
+)
+
+var acceptedSearchFilterTags = map[string]struct{}{
+	"stars":        {},
+	"is-official":  {},
+	"is-automated": {},
+}
+
+// searchFilters selects search results by their stars and whether they are
+// official or automated.
+type searchFilters struct {
+	stars     int
+	official  string
+	automated string
+}
+
+func newSearchFilters(args filters.Args) (*searchFilters, error) {
+	f := &searchFilters{}
+	for name, values := range args {
+		if _, ok := acceptedSearchFilterTags[name]; !ok {
+			return nil, fmt.Errorf("Invalid filter '%s'", name)
+		}
+		for _, value := range values {
+			switch name {
+			case "stars":
+				stars, err := strconv.Atoi(value)
+				if err != nil || stars < 0 {
+					return nil, fmt.Errorf("Invalid filter 'stars=%s'", value)
+				}
+				if stars > f.stars {
+					f.stars = stars
+				}
+			case "is-official", "is-automated":
+				value = strings.ToLower(value)
+				if value != "true" && value != "false" {
+					return nil, fmt.Errorf("Invalid filter '%s=%s'", name, value)
+				}
+				if name == "is-official" {
+					f.official = value
+				} else {
+					f.automated = value
+				}
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *searchFilters) match(result SearchResult) bool {
+	if result.StarCount < f.stars {
+		return false
+	}
+	if f.official != "" && strconv.FormatBool(result.IsOfficial) != f.official {
+		return false
+	}
+	automated := result.IsAutomated || result.IsTrusted
+	if f.automated != "" && strconv.FormatBool(automated) != f.automated {
+		return false
+	}
+	return true
+}
+
+// SearchRepositories searches the v1 index for up to limit repositories
+// matching the term and the search filters, going through the pages of the
+// results as needed.
+func (r *Session) SearchRepositories(term string, limit int, searchFilters filters.Args) (*SearchResults, error) {
+	f, err := newSearchFilters(searchFilters)
+	if err != nil {
+		return nil, err
+	}
+	results := &SearchResults{Query: term, Results: []SearchResult{}}
+	for page := 1; ; page++ {
+		res, err := r.searchRepositoriesPage(term, page, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range res.Results {
+			if f.match(result) {
+				results.Results = append(results.Results, result)
+				if len(results.Results) == limit {
+					break
+				}
+			}
+		}
+		// Indexes which don't paginate their results return a single page.
+		if len(results.Results) == limit || len(res.Results) == 0 || page >= res.NumPages {
+			break
+		}
+	}
+	results.NumResults = len(results.Results)
+	return results, nil
+}
+
+// SearchV2Repositories searches the catalog of a v2 registry for up to limit
+// repositories whose name contains the term and matching the search filters.
+// v2 registries have no descriptions, the tags of the repositories are listed
+// instead.
+func (r *Session) SearchV2Repositories(ep *Endpoint, term string, limit int, searchFilters filters.Args) (*SearchResults, error) {
+	f, err := newSearchFilters(searchFilters)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		results = &SearchResults{Query: term, Results: []SearchResult{}}
+		auth    = NewRequestAuthorization(r.GetAuthConfig(true), ep, "registry", "catalog", []string{"*"})
+		last    string
+	)
+	for len(results.Results) < limit {
+		repos, next, err := r.GetV2Catalog(ep, last, catalogPageSize, auth)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			if !strings.Contains(repo, term) {
+				continue
+			}
+			result := SearchResult{Name: repo}
+			if !f.match(result) {
+				continue
+			}
+			tagsAuth, err := r.GetV2Authorization(ep, repo, true)
+			if err != nil {
+				return nil, err
+			}
+			tags, err := r.GetV2RemoteTags(ep, repo, tagsAuth)
+			if err != nil {
+				if err == ErrDoesNotExist || err == ErrLoginRequired {
+					logrus.Debugf("Skipping repository %s of the catalog: %s", repo, err)
+					continue
+				}
+				return nil, err
+			}
+			result.Description = "Tags: " + strings.Join(tags, ", ")
+			results.Results = append(results.Results, result)
+			if len(results.Results) == limit {
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		last = next
+	}
+	results.NumResults = len(results.Results)
+	return results, nil
+}
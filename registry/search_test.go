@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/parsers/filters"
+	"github.com/docker/docker/pkg/requestdecorator"
+	"github.com/docker/docker/registry/v2"
+)
+
+func TestSearchFilters(t *testing.T) {
+	var (
+		official  = SearchResult{Name: "official", StarCount: 10, IsOfficial: true}
+		automated = SearchResult{Name: "automated", StarCount: 3, IsAutomated: true}
+		trusted   = SearchResult{Name: "trusted", IsTrusted: true}
+		other     = SearchResult{Name: "other", StarCount: 1}
+		all       = []SearchResult{official, automated, trusted, other}
+	)
+	for _, test := range []struct {
+		filters  filters.Args
+		expected []SearchResult
+	}{
+		{nil, all},
+		{filters.Args{"stars": {"3"}}, []SearchResult{official, automated}},
+		{filters.Args{"stars": {"1", "10"}}, []SearchResult{official}},
+		{filters.Args{"is-official": {"true"}}, []SearchResult{official}},
+		{filters.Args{"is-official": {"false"}}, []SearchResult{automated, trusted, other}},
+		{filters.Args{"is-automated": {"TRUE"}}, []SearchResult{automated, trusted}},
+		{filters.Args{"is-automated": {"false"}, "stars": {"1"}}, []SearchResult{official, other}},
+	} {
+		f, err := newSearchFilters(test.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var matched []SearchResult
+		for _, result := range all {
+			if f.match(result) {
+				matched = append(matched, result)
+			}
+		}
+		if fmt.Sprint(matched) != fmt.Sprint(test.expected) {
+			t.Fatalf("Expected %v to match %v, got %v", test.filters, test.expected, matched)
+		}
+	}
+
+	for _, invalid := range []filters.Args{
+		{"unknown": {"true"}},
+		{"stars": {"many"}},
+		{"stars": {"-1"}},
+		{"is-official": {"yes"}},
+	} {
+		if _, err := newSearchFilters(invalid); err == nil {
+			t.Fatalf("Expected %v to be refused", invalid)
+		}
+	}
+}
+
+// catalogRegistry is a v2 registry serving the catalog of the repositories
+// and their tags, paginated as requested.
+type catalogRegistry struct {
+	repositories map[string][]string
+	requests     int
+}
+
+func (s *catalogRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/v2/_catalog":
+		s.requests++
+		var names []string
+		for name := range s.repositories {
+			if name > r.URL.Query().Get("last") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if n > 0 && len(names) > n {
+			names = names[:n]
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=%d>; rel="next"`, names[n-1], n))
+		}
+		json.NewEncoder(w).Encode(remoteCatalog{Repositories: names})
+	case strings.HasSuffix(r.URL.Path, "/tags/list"):
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/tags/list")
+		tags, ok := s.repositories[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(remoteTags{Name: name, Tags: tags})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSearchV2Repositories(t *testing.T) {
+	reg := &catalogRegistry{repositories: map[string][]string{}}
+	for i := 0; i < 2*catalogPageSize; i++ {
+		reg.repositories[fmt.Sprintf("team/app%03d", i)] = []string{"latest"}
+	}
+	reg.repositories["team/web"] = []string{"1.0", "latest"}
+	reg.repositories["zzz/app"] = []string{"latest"}
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	ep, err := newEndpoint(server.URL+"/v2/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep.URLBuilder = v2.NewURLBuilder(ep.URL)
+	r, err := NewSession(&AuthConfig{}, requestdecorator.NewRequestFactory(), ep, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := r.SearchV2Repositories(ep, "web", DefaultSearchLimit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.NumResults != 1 || results.Results[0].Name != "team/web" || results.Results[0].Description != "Tags: 1.0, latest" {
+		t.Fatalf("Expected team/web and its tags, got %+v", results.Results)
+	}
+	if reg.requests != 3 {
+		t.Fatalf("Expected the whole catalog to be listed in 3 pages, got %d requests", reg.requests)
+	}
+
+	reg.requests = 0
+	results, err = r.SearchV2Repositories(ep, "app", 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.NumResults != 10 || results.Results[9].Name != "team/app009" {
+		t.Fatalf("Expected the first 10 apps, got %+v", results.Results)
+	}
+	if reg.requests != 1 {
+		t.Fatalf("Expected the search to stop after the first page, got %d requests", reg.requests)
+	}
+
+	results, err = r.SearchV2Repositories(ep, "app", DefaultSearchLimit, filters.Args{"stars": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.NumResults != 0 {
+		t.Fatalf("Expected no results with stars, got %+v", results.Results)
+	}
+}
@@ -1,5 +1,12 @@
 package registry
 
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/parsers/filters"
+	"github.com/docker/docker/registry/v2"
+)
+
 type Service struct {
 	Config *ServiceConfig
 }
@@ -33,9 +40,20 @@ func (s *Service) Auth(authConfig *AuthConfig) (string, error) {
 	return Login(authConfig, endpoint, HTTPRequestFactory(nil))
 }
 
-// Search queries the public registry for images matching the specified
-// search terms, and returns the results.
-func (s *Service) Search(term string, authConfig *AuthConfig, headers map[string][]string) (*SearchResults, error) {
+// Search queries the registry of the search term for up to limit images
+// matching the term and the search filters, and returns the results. The
+// index search is used for the public registry and v1 indexes, the catalog
+// of the registry for v2 registries.
+func (s *Service) Search(term string, limit int, searchFilters filters.Args, authConfig *AuthConfig, headers map[string][]string) (*SearchResults, error) {
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit < 1 || limit > MaxSearchLimit {
+		return nil, fmt.Errorf("Limit %d is outside the range of [1, %d]", limit, MaxSearchLimit)
+	}
+	if authConfig == nil {
+		authConfig = &AuthConfig{}
+	}
 	repoInfo, err := s.ResolveRepository(term)
 	if err != nil {
 		return nil, err
@@ -49,7 +67,19 @@ func (s *Service) Search(term string, authConfig *AuthConfig, headers map[string
 	if err != nil {
 		return nil, err
 	}
-	return r.SearchRepositories(repoInfo.GetSearchTerm())
+	if repoInfo.Index.Official || endpoint.Version != APIVersion2 {
+		return r.SearchRepositories(repoInfo.GetSearchTerm(), limit, searchFilters)
+	}
+	endpoint.URLBuilder = v2.NewURLBuilder(endpoint.URL)
+	results, err := r.SearchV2Repositories(endpoint, repoInfo.GetSearchTerm(), limit, searchFilters)
+	if err != nil {
+		return nil, err
+	}
+	// Results are only found in this registry, name them after it.
+	for i := range results.Results {
+		results.Results[i].Name = repoInfo.Index.Name + "/" + results.Results[i].Name
+	}
+	return results, nil
 }
 
 // ResolveRepository splits a repository name into its components
@@ -84,7 +84,7 @@ func (r *Session) GetRemoteHistory(imgID, registry string, token []string) ([]st
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		if res.StatusCode == 401 {
-			return nil, errLoginRequired
+			return nil, ErrLoginRequired
 		}
 		return nil, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to fetch remote history for %s", res.StatusCode, imgID), res)
 	}
@@ -277,7 +277,7 @@ func (r *Session) GetRepositoryData(remote string) (*RepositoryData, error) {
 	}
 	defer res.Body.Close()
 	if res.StatusCode == 401 {
-		return nil, errLoginRequired
+		return nil, ErrLoginRequired
 	}
 	// TODO: Right now we're ignoring checksums in the response body.
 	// In the future, we need to use them to check image validity.
@@ -513,7 +513,7 @@ func (r *Session) PushImageJSONIndex(remote string, imgList []*ImgData, validate
 	defer res.Body.Close()
 
 	if res.StatusCode == 401 {
-		return nil, errLoginRequired
+		return nil, ErrLoginRequired
 	}
 
 	var tokens, endpoints []string
@@ -578,9 +578,15 @@ func shouldRedirect(response *http.Response) bool {
 	return response.StatusCode >= 300 && response.StatusCode < 400
 }
 
-func (r *Session) SearchRepositories(term string) (*SearchResults, error) {
+// searchRepositoriesPage returns the given page of the results of the v1
+// index search, of n results per page.
+func (r *Session) searchRepositoriesPage(term string, page, n int) (*SearchResults, error) {
 	logrus.Debugf("Index server: %s", r.indexEndpoint)
-	u := r.indexEndpoint.VersionString(1) + "search?q=" + url.QueryEscape(term)
+	v := url.Values{}
+	v.Set("q", term)
+	v.Set("page", strconv.Itoa(page))
+	v.Set("n", strconv.Itoa(n))
+	u := r.indexEndpoint.VersionString(1) + "search?" + v.Encode()
 	req, err := r.reqFactory.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
@@ -7,7 +7,9 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/digest"
@@ -25,7 +27,6 @@ func getV2Builder(e *Endpoint) *v2.URLBuilder {
 }
 
 func (r *Session) V2RegistryEndpoint(index *IndexInfo) (ep *Endpoint, err error) {
-	// TODO check if should use Mirror
 	if index.Official {
 		ep, err = newEndpoint(REGISTRYSERVER, true)
 		if err != nil {
@@ -61,6 +62,24 @@ func (r *Session) GetV2Authorization(ep *Endpoint, imageName string, readOnly bo
 	return NewRequestAuthorization(r.GetAuthConfig(true), ep, "repository", imageName, scopes), nil
 }
 
+// GetV2MirrorAuthorization returns the anonymous authorization to pull
+// imageName from the mirror ep. The credentials of the session are those of
+// the mirrored registry, they are never sent to its mirrors.
+func (r *Session) GetV2MirrorAuthorization(ep *Endpoint, imageName string) *RequestAuthorization {
+	logrus.Debugf("Getting anonymous authorization for %s from mirror %s", imageName, ep)
+	return NewRequestAuthorization(&AuthConfig{}, ep, "repository", imageName, []string{"pull"})
+}
+
+// GetV2MountAuthorization returns the authorization to push to imageName
+// blobs mounted from the repository fromName, which the token also has to
+// grant pull access to.
+func (r *Session) GetV2MountAuthorization(ep *Endpoint, imageName, fromName string) *RequestAuthorization {
+	logrus.Debugf("Getting authorization for %s [pull push] and %s [pull]", imageName, fromName)
+	auth := NewRequestAuthorization(r.GetAuthConfig(true), ep, "repository", imageName, []string{"pull", "push"})
+	auth.extraScopes = []string{"repository:" + fromName + ":pull"}
+	return auth
+}
+
 //
 // 1) Check if TarSum of each layer exists /v2/
 //  1.a) if 200, continue
@@ -91,7 +110,7 @@ func (r *Session) GetV2ImageManifest(ep *Endpoint, imageName, tagName string, au
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		if res.StatusCode == 401 {
-			return nil, "", errLoginRequired
+			return nil, "", ErrLoginRequired
 		} else if res.StatusCode == 404 {
 			return nil, "", ErrDoesNotExist
 		}
@@ -135,7 +154,7 @@ func (r *Session) HeadV2ImageBlob(ep *Endpoint, imageName, sumType, sum string,
 		// return something indicating no push needed
 		return true, nil
 	case res.StatusCode == 401:
-		return false, errLoginRequired
+		return false, ErrLoginRequired
 	case res.StatusCode == 404:
 		// return something indicating blob push needed
 		return false, nil
@@ -166,7 +185,7 @@ func (r *Session) GetV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, b
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		if res.StatusCode == 401 {
-			return errLoginRequired
+			return ErrLoginRequired
 		}
 		return utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to pull %s blob", res.StatusCode, imageName), res)
 	}
@@ -176,37 +195,91 @@ func (r *Session) GetV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, b
 }
 
 func (r *Session) GetV2ImageBlobReader(ep *Endpoint, imageName, sumType, sum string, auth *RequestAuthorization) (io.ReadCloser, int64, error) {
+	rc, _, l, err := r.GetV2ImageBlobReaderFrom(ep, imageName, sumType, sum, 0, auth)
+	return rc, l, err
+}
+
+// GetV2ImageBlobReaderFrom returns a reader of the blob starting at offset,
+// using a Range request, along with the offset the reader actually starts at
+// and the size of the whole blob. If the registry doesn't support Range
+// requests, the reader starts at 0.
+func (r *Session) GetV2ImageBlobReaderFrom(ep *Endpoint, imageName, sumType, sum string, offset int64, auth *RequestAuthorization) (io.ReadCloser, int64, int64, error) {
 	routeURL, err := getV2Builder(ep).BuildBlobURL(imageName, sumType+":"+sum)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
 
 	method := "GET"
-	logrus.Debugf("[registry] Calling %q %s", method, routeURL)
+	logrus.Debugf("[registry] Calling %q %s from byte %d", method, routeURL, offset)
 	req, err := r.reqFactory.NewRequest(method, routeURL, nil)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
 	if err := auth.Authorize(req); err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
 	res, _, err := r.doRequest(req)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
-	if res.StatusCode != 200 {
-		if res.StatusCode == 401 {
-			return nil, 0, errLoginRequired
+	switch res.StatusCode {
+	case 200:
+		l, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			res.Body.Close()
+			return nil, 0, 0, err
 		}
-		return nil, 0, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to pull %s blob - %s:%s", res.StatusCode, imageName, sumType, sum), res)
+		return res.Body, 0, l, nil
+	case 206:
+		start, l, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err == nil && start != offset {
+			err = fmt.Errorf("expected content starting at byte %d, got %d", offset, start)
+		}
+		if err == nil && l < 0 {
+			l, err = strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+			l += offset
+		}
+		if err != nil {
+			res.Body.Close()
+			return nil, 0, 0, err
+		}
+		return res.Body, offset, l, nil
+	case 416:
+		// The partial blob is at least as large as the blob, download it
+		// again from the start. A 416 without a Range is an error.
+		if offset > 0 {
+			res.Body.Close()
+			return r.GetV2ImageBlobReaderFrom(ep, imageName, sumType, sum, 0, auth)
+		}
+	case 401:
+		res.Body.Close()
+		return nil, 0, 0, ErrLoginRequired
 	}
-	lenStr := res.Header.Get("Content-Length")
-	l, err := strconv.ParseInt(lenStr, 10, 64)
+	res.Body.Close()
+	return nil, 0, 0, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to pull %s blob - %s:%s", res.StatusCode, imageName, sumType, sum), res)
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes START-END/SIZE", returning a size of -1 if it is unknown.
+func parseContentRange(contentRange string) (int64, int64, error) {
+	var (
+		start, end int64
+		size       string
+	)
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &size); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q: %s", contentRange, err)
+	}
+	if size == "*" {
+		return start, -1, nil
+	}
+	l, err := strconv.ParseInt(size, 10, 64)
 	if err != nil {
-		return nil, 0, err
+		return 0, 0, fmt.Errorf("invalid Content-Range %q: %s", contentRange, err)
 	}
-
-	return res.Body, l, err
+	return start, l, nil
 }
 
 // Push the image to the server for storage.
@@ -238,7 +311,7 @@ func (r *Session) PutV2ImageBlob(ep *Endpoint, imageName, sumType, sumStr string
 
 	if res.StatusCode != 201 {
 		if res.StatusCode == 401 {
-			return errLoginRequired
+			return ErrLoginRequired
 		}
 		errBody, err := ioutil.ReadAll(res.Body)
 		if err != nil {
@@ -274,7 +347,7 @@ func (r *Session) initiateBlobUpload(ep *Endpoint, imageName string, auth *Reque
 
 	if res.StatusCode != http.StatusAccepted {
 		if res.StatusCode == http.StatusUnauthorized {
-			return "", errLoginRequired
+			return "", ErrLoginRequired
 		}
 		if res.StatusCode == http.StatusNotFound {
 			return "", ErrDoesNotExist
@@ -293,7 +366,197 @@ func (r *Session) initiateBlobUpload(ep *Endpoint, imageName string, auth *Reque
 		return "", fmt.Errorf("registry did not return a Location header for resumable blob upload for image %s", imageName)
 	}
 
-	return
+	return resolveLocation(req.URL, location)
+}
+
+// MountV2ImageBlob asks the registry to make the blob of the repository
+// fromName available in the repository imageName without uploading it
+// again. It returns false if the registry doesn't have the blob in fromName
+// or doesn't support cross-repository blob mounts. The authorization has to
+// grant pull access to fromName, see GetV2MountAuthorization.
+func (r *Session) MountV2ImageBlob(ep *Endpoint, imageName, fromName, sumType, sum string, auth *RequestAuthorization) (bool, error) {
+	routeURL, err := getV2Builder(ep).BuildBlobUploadURL(imageName, url.Values{
+		"mount": []string{sumType + ":" + sum},
+		"from":  []string{fromName},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	method := "POST"
+	logrus.Debugf("[registry] Calling %q %s", method, routeURL)
+	req, err := r.reqFactory.NewRequest(method, routeURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := auth.Authorize(req); err != nil {
+		return false, err
+	}
+	res, _, err := r.doRequest(req)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// The registry started a regular upload instead, cancel it.
+		if location, err := resolveLocation(req.URL, res.Header.Get("Location")); err == nil {
+			r.cancelBlobUpload(location, auth)
+		}
+		return false, nil
+	case http.StatusUnauthorized:
+		return false, ErrLoginRequired
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to mount %s blob from %s - %s:%s", res.StatusCode, imageName, fromName, sumType, sum), res)
+}
+
+// InitiateV2ImageBlobUpload starts a chunked upload of a blob to the
+// repository imageName and returns the location to upload the chunks to.
+func (r *Session) InitiateV2ImageBlobUpload(ep *Endpoint, imageName string, auth *RequestAuthorization) (string, error) {
+	return r.initiateBlobUpload(ep, imageName, auth)
+}
+
+// PatchV2ImageBlob uploads the chunk of size bytes of a blob starting at
+// offset to the upload at location. It returns the location to upload the
+// next chunk to.
+func (r *Session) PatchV2ImageBlob(location string, offset, size int64, chunk io.Reader, auth *RequestAuthorization) (string, error) {
+	method := "PATCH"
+	logrus.Debugf("[registry] Calling %q %s with bytes %d-%d", method, location, offset, offset+size-1)
+	req, err := r.reqFactory.NewRequest(method, location, ioutil.NopCloser(chunk))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+size-1))
+	if err := auth.Authorize(req); err != nil {
+		return "", err
+	}
+	res, _, err := r.doRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		if res.StatusCode == http.StatusUnauthorized {
+			return "", ErrLoginRequired
+		}
+		errBody, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return "", err
+		}
+		logrus.Debugf("Unexpected response from server: %q %#v", errBody, res.Header)
+		return "", utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to upload bytes %d-%d of blob", res.StatusCode, offset, offset+size-1), res)
+	}
+	return resolveLocation(req.URL, res.Header.Get("Location"))
+}
+
+// GetV2ImageBlobUploadStatus returns the location to resume the upload at
+// location at, and the number of bytes of the blob the registry received.
+func (r *Session) GetV2ImageBlobUploadStatus(location string, auth *RequestAuthorization) (string, int64, error) {
+	method := "GET"
+	logrus.Debugf("[registry] Calling %q %s", method, location)
+	req, err := r.reqFactory.NewRequest(method, location, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := auth.Authorize(req); err != nil {
+		return "", 0, err
+	}
+	res, _, err := r.doRequest(req)
+	if err != nil {
+		return "", 0, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		if res.StatusCode == http.StatusUnauthorized {
+			return "", 0, ErrLoginRequired
+		}
+		return "", 0, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to get the status of blob upload", res.StatusCode), res)
+	}
+	if location, err = resolveLocation(req.URL, res.Header.Get("Location")); err != nil {
+		return "", 0, err
+	}
+	// The Range header holds the inclusive range of bytes received, e.g.
+	// 0-1023, and is absent if none were.
+	var start, end int64
+	if rng := res.Header.Get("Range"); rng != "" {
+		if _, err := fmt.Sscanf(rng, "%d-%d", &start, &end); err != nil {
+			return "", 0, fmt.Errorf("invalid Range %q in blob upload status: %s", rng, err)
+		}
+		return location, end + 1, nil
+	}
+	return location, 0, nil
+}
+
+// CompleteV2ImageBlobUpload completes the upload at location once all the
+// chunks of the blob with the given digest are uploaded.
+func (r *Session) CompleteV2ImageBlobUpload(location, sumType, sumStr string, auth *RequestAuthorization) error {
+	method := "PUT"
+	logrus.Debugf("[registry] Calling %q %s", method, location)
+	req, err := r.reqFactory.NewRequest(method, location, nil)
+	if err != nil {
+		return err
+	}
+	queryParams := req.URL.Query()
+	queryParams.Add("digest", sumType+":"+sumStr)
+	req.URL.RawQuery = queryParams.Encode()
+	if err := auth.Authorize(req); err != nil {
+		return err
+	}
+	res, _, err := r.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		if res.StatusCode == http.StatusUnauthorized {
+			return ErrLoginRequired
+		}
+		errBody, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Unexpected response from server: %q %#v", errBody, res.Header)
+		return utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to complete upload of blob - %s:%s", res.StatusCode, sumType, sumStr), res)
+	}
+	return nil
+}
+
+// cancelBlobUpload cancels the upload at location, the registry eventually
+// discards abandoned uploads anyway.
+func (r *Session) cancelBlobUpload(location string, auth *RequestAuthorization) {
+	req, err := r.reqFactory.NewRequest("DELETE", location, nil)
+	if err != nil {
+		return
+	}
+	if err := auth.Authorize(req); err != nil {
+		return
+	}
+	if res, _, err := r.doRequest(req); err == nil {
+		res.Body.Close()
+	}
+}
+
+// resolveLocation resolves the Location header of a blob upload response,
+// which may be relative, against the URL of the request.
+func resolveLocation(reqURL *url.URL, location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("registry did not return a Location header for blob upload")
+	}
+	u, err := reqURL.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
 }
 
 // Finally Push the (signed) manifest of the blobs we've just pushed
@@ -321,7 +584,7 @@ func (r *Session) PutV2ImageManifest(ep *Endpoint, imageName, tagName string, si
 	// All 2xx and 3xx responses can be accepted for a put.
 	if res.StatusCode >= 400 {
 		if res.StatusCode == 401 {
-			return "", errLoginRequired
+			return "", ErrLoginRequired
 		}
 		errBody, err := ioutil.ReadAll(res.Body)
 		if err != nil {
@@ -380,7 +643,7 @@ func (r *Session) GetV2RemoteTags(ep *Endpoint, imageName string, auth *RequestA
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		if res.StatusCode == 401 {
-			return nil, errLoginRequired
+			return nil, ErrLoginRequired
 		} else if res.StatusCode == 404 {
 			return nil, ErrDoesNotExist
 		}
@@ -395,3 +658,57 @@ func (r *Session) GetV2RemoteTags(ep *Endpoint, imageName string, auth *RequestA
 	}
 	return remote.Tags, nil
 }
+
+type remoteCatalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+// GetV2Catalog returns up to n names of the repositories of the registry
+// following last in lexical order, and the name to continue listing from, or
+// an empty string if there are no more repositories.
+func (r *Session) GetV2Catalog(ep *Endpoint, last string, n int, auth *RequestAuthorization) ([]string, string, error) {
+	v := url.Values{}
+	v.Set("n", strconv.Itoa(n))
+	if last != "" {
+		v.Set("last", last)
+	}
+	routeURL, err := getV2Builder(ep).BuildCatalogURL(v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	method := "GET"
+	logrus.Debugf("[registry] Calling %q %s", method, routeURL)
+
+	req, err := r.reqFactory.NewRequest(method, routeURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := auth.Authorize(req); err != nil {
+		return nil, "", err
+	}
+	res, _, err := r.doRequest(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		if res.StatusCode == 401 {
+			return nil, "", ErrLoginRequired
+		} else if res.StatusCode == 404 {
+			return nil, "", fmt.Errorf("Registry %s does not support listing its repositories", ep.URL.Host)
+		}
+		return nil, "", utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to list the repositories", res.StatusCode), res)
+	}
+
+	var catalog remoteCatalog
+	if err := json.NewDecoder(res.Body).Decode(&catalog); err != nil {
+		return nil, "", fmt.Errorf("Error while decoding the http response: %s", err)
+	}
+	// The registry links the next page of the catalog if there is one.
+	next := ""
+	if strings.Contains(res.Header.Get("Link"), `rel="next"`) && len(catalog.Repositories) > 0 {
+		next = catalog.Repositories[len(catalog.Repositories)-1]
+	}
+	return catalog.Repositories, next, nil
+}
@@ -12,6 +12,8 @@ type SearchResult struct {
 type SearchResults struct {
 	Query      string         `json:"query"`
 	NumResults int            `json:"num_results"`
+	NumPages   int            `json:"num_pages,omitempty"`
+	Page       int            `json:"page,omitempty"`
 	Results    []SearchResult `json:"results"`
 }
 
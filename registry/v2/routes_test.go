@@ -51,6 +51,11 @@ func TestRouter(t *testing.T) {
 			RequestURI: "/v2/",
 			Vars:       map[string]string{},
 		},
+		{
+			RouteName:  RouteNameCatalog,
+			RequestURI: "/v2/_catalog",
+			Vars:       map[string]string{},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/manifests/bar",
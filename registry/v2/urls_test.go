@@ -27,6 +27,23 @@ func TestURLBuilder(t *testing.T) {
 				return urlBuilder.BuildBaseURL()
 			},
 		},
+		{
+			description:  "test catalog url",
+			expectedPath: "/v2/_catalog",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogURL()
+			},
+		},
+		{
+			description:  "test catalog url with pagination",
+			expectedPath: "/v2/_catalog?last=foo%2Fbar&n=100",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogURL(url.Values{
+					"n":    []string{"100"},
+					"last": []string{"foo/bar"},
+				})
+			},
+		},
 		{
 			description:  "test tags url",
 			expectedPath: "/v2/foo/bar/tags/list",
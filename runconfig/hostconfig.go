@@ -122,6 +122,9 @@ type HostConfig struct {
 	VolumesFrom     []string
 	Devices         []DeviceMapping
 	NetworkMode     NetworkMode
+	IPv4Address     string   // Static IPv4 address on the bridge network
+	IPv6Address     string   // Static global IPv6 address on the bridge network
+	EgressRules     []string // Destinations the container may send traffic to, unrestricted when empty
 	IpcMode         IpcMode
 	PidMode         PidMode
 	CapAdd          []string
@@ -132,6 +135,7 @@ type HostConfig struct {
 	Ulimits         []*ulimit.Ulimit
 	LogConfig       LogConfig
 	CgroupParent    string // Parent cgroup.
+	StorageOpt      map[string]string
 }
 
 // This is used by the create command when you want to set both the
@@ -180,6 +184,8 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 		Privileged:      job.GetenvBool("Privileged"),
 		PublishAllPorts: job.GetenvBool("PublishAllPorts"),
 		NetworkMode:     NetworkMode(job.Getenv("NetworkMode")),
+		IPv4Address:     job.Getenv("IPv4Address"),
+		IPv6Address:     job.Getenv("IPv6Address"),
 		IpcMode:         IpcMode(job.Getenv("IpcMode")),
 		PidMode:         PidMode(job.Getenv("PidMode")),
 		ReadonlyRootfs:  job.GetenvBool("ReadonlyRootfs"),
@@ -199,6 +205,7 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 	job.GetenvJson("RestartPolicy", &hostConfig.RestartPolicy)
 	job.GetenvJson("Ulimits", &hostConfig.Ulimits)
 	job.GetenvJson("LogConfig", &hostConfig.LogConfig)
+	job.GetenvJson("StorageOpt", &hostConfig.StorageOpt)
 	hostConfig.SecurityOpt = job.GetenvList("SecurityOpt")
 	if Binds := job.GetenvList("Binds"); Binds != nil {
 		hostConfig.Binds = Binds
@@ -215,6 +222,9 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 	if ExtraHosts := job.GetenvList("ExtraHosts"); ExtraHosts != nil {
 		hostConfig.ExtraHosts = ExtraHosts
 	}
+	if EgressRules := job.GetenvList("EgressRules"); EgressRules != nil {
+		hostConfig.EgressRules = EgressRules
+	}
 	if VolumesFrom := job.GetenvList("VolumesFrom"); VolumesFrom != nil {
 		hostConfig.VolumesFrom = VolumesFrom
 	}
@@ -2,6 +2,7 @@ package runconfig
 
 import (
 	"fmt"
+	"net"
 	"path"
 	"strconv"
 	"strings"
@@ -22,6 +23,8 @@ var (
 	ErrConflictNetworkHostname          = fmt.Errorf("Conflicting options: -h and the network mode (--net)")
 	ErrConflictHostNetworkAndDns        = fmt.Errorf("Conflicting options: --net=host can't be used with --dns. This configuration is invalid.")
 	ErrConflictHostNetworkAndLinks      = fmt.Errorf("Conflicting options: --net=host can't be used with links. This would result in undefined behavior.")
+	ErrConflictNetworkAndIP             = fmt.Errorf("Conflicting options: --ip and --ip6 can only be used with --net=bridge")
+	ErrConflictNetworkAndEgress         = fmt.Errorf("Conflicting options: --egress can only be used with --net=bridge")
 )
 
 func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSet, error) {
@@ -42,12 +45,14 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		flDns         = opts.NewListOpts(opts.ValidateIPAddress)
 		flDnsSearch   = opts.NewListOpts(opts.ValidateDnsSearch)
 		flExtraHosts  = opts.NewListOpts(opts.ValidateExtraHost)
+		flEgress      = opts.NewListOpts(opts.ValidateEgressRule)
 		flVolumesFrom = opts.NewListOpts(nil)
 		flLxcOpts     = opts.NewListOpts(nil)
 		flEnvFile     = opts.NewListOpts(nil)
 		flCapAdd      = opts.NewListOpts(nil)
 		flCapDrop     = opts.NewListOpts(nil)
 		flSecurityOpt = opts.NewListOpts(nil)
+		flStorageOpt  = opts.NewListOpts(opts.ValidateStorageOpt)
 		flLabelsFile  = opts.NewListOpts(nil)
 
 		flNetwork         = cmd.Bool([]string{"#n", "#-networking"}, true, "Enable networking for this container")
@@ -67,6 +72,8 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		flCpusetCpus      = cmd.String([]string{"#-cpuset", "-cpuset-cpus"}, "", "CPUs in which to allow execution (0-3, 0,1)")
 		flNetMode         = cmd.String([]string{"-net"}, "bridge", "Set the Network mode for the container")
 		flMacAddress      = cmd.String([]string{"-mac-address"}, "", "Container MAC address (e.g. 92:d0:c6:0a:29:33)")
+		flIPv4Address     = cmd.String([]string{"-ip"}, "", "Container IPv4 address (e.g. 172.17.0.10)")
+		flIPv6Address     = cmd.String([]string{"-ip6"}, "", "Container IPv6 address (e.g. 2001:db8::10)")
 		flIpcMode         = cmd.String([]string{"-ipc"}, "", "IPC namespace to use")
 		flRestartPolicy   = cmd.String([]string{"-restart"}, "no", "Restart policy to apply when a container exits")
 		flReadonlyRootfs  = cmd.Bool([]string{"-read-only"}, false, "Mount the container's root filesystem as read only")
@@ -87,11 +94,13 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 	cmd.Var(&flDns, []string{"#dns", "-dns"}, "Set custom DNS servers")
 	cmd.Var(&flDnsSearch, []string{"-dns-search"}, "Set custom DNS search domains")
 	cmd.Var(&flExtraHosts, []string{"-add-host"}, "Add a custom host-to-IP mapping (host:ip)")
+	cmd.Var(&flEgress, []string{"-egress"}, "Only allow outgoing traffic to a destination (ip[/mask][:port[-port][/proto]])")
 	cmd.Var(&flVolumesFrom, []string{"#volumes-from", "-volumes-from"}, "Mount volumes from the specified container(s)")
 	cmd.Var(&flLxcOpts, []string{"#lxc-conf", "-lxc-conf"}, "Add custom lxc options")
 	cmd.Var(&flCapAdd, []string{"-cap-add"}, "Add Linux capabilities")
 	cmd.Var(&flCapDrop, []string{"-cap-drop"}, "Drop Linux capabilities")
 	cmd.Var(&flSecurityOpt, []string{"-security-opt"}, "Security Options")
+	cmd.Var(&flStorageOpt, []string{"-storage-opt"}, "Set storage driver options for the container, e.g. size=20G")
 	cmd.Var(flUlimits, []string{"-ulimit"}, "Ulimit options")
 
 	cmd.Require(flag.Min, 1)
@@ -111,6 +120,18 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 			return nil, nil, cmd, fmt.Errorf("%s is not a valid mac address", *flMacAddress)
 		}
 	}
+
+	// Validate the input ip addresses
+	if *flIPv4Address != "" {
+		if ip := net.ParseIP(*flIPv4Address); ip == nil || ip.To4() == nil {
+			return nil, nil, cmd, fmt.Errorf("%s is not a valid IPv4 address", *flIPv4Address)
+		}
+	}
+	if *flIPv6Address != "" {
+		if ip := net.ParseIP(*flIPv6Address); ip == nil || ip.To4() != nil {
+			return nil, nil, cmd, fmt.Errorf("%s is not a valid IPv6 address", *flIPv6Address)
+		}
+	}
 	var (
 		attachStdin  = flAttach.Get("stdin")
 		attachStdout = flAttach.Get("stdout")
@@ -121,6 +142,14 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		return nil, nil, cmd, ErrConflictNetworkHostname
 	}
 
+	if *flNetMode != "bridge" && (*flIPv4Address != "" || *flIPv6Address != "") {
+		return nil, nil, cmd, ErrConflictNetworkAndIP
+	}
+
+	if *flNetMode != "bridge" && flEgress.Len() > 0 {
+		return nil, nil, cmd, ErrConflictNetworkAndEgress
+	}
+
 	if *flNetMode == "host" && flLinks.Len() > 0 {
 		return nil, nil, cmd, ErrConflictHostNetworkAndLinks
 	}
@@ -323,6 +352,9 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		ExtraHosts:      flExtraHosts.GetAll(),
 		VolumesFrom:     flVolumesFrom.GetAll(),
 		NetworkMode:     netMode,
+		IPv4Address:     *flIPv4Address,
+		IPv6Address:     *flIPv6Address,
+		EgressRules:     flEgress.GetAll(),
 		IpcMode:         ipcMode,
 		PidMode:         pidMode,
 		Devices:         deviceMappings,
@@ -334,6 +366,7 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		Ulimits:         flUlimits.GetList(),
 		LogConfig:       LogConfig{Type: *flLoggingDriver},
 		CgroupParent:    *flCgroupParent,
+		StorageOpt:      convertKVStringsToMap(flStorageOpt.GetAll()),
 	}
 
 	// When allocating stdin in attached mode, close stdin at client disconnect
@@ -63,3 +63,58 @@ func TestConflictContainerNetworkAndLinks(t *testing.T) {
 		t.Fatalf("Expected error ErrConflictContainerNetworkAndLinks, got: %s", err)
 	}
 }
+
+func TestParseStaticIP(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--ip=172.17.0.10", "--ip6=2001:db8::10", "img", "cmd"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if hostConfig.IPv4Address != "172.17.0.10" || hostConfig.IPv6Address != "2001:db8::10" {
+		t.Fatalf("Expected static addresses in the host config, got %q and %q", hostConfig.IPv4Address, hostConfig.IPv6Address)
+	}
+
+	for _, args := range [][]string{
+		{"--ip=2001:db8::10", "img", "cmd"},
+		{"--ip=172.17.0.300", "img", "cmd"},
+		{"--ip6=172.17.0.10", "img", "cmd"},
+	} {
+		if _, _, _, err := parseRun(args); err == nil {
+			t.Fatalf("Expected an error parsing %v", args)
+		}
+	}
+
+	if _, _, _, err := parseRun([]string{"--net=host", "--ip=172.17.0.10", "img", "cmd"}); err != ErrConflictNetworkAndIP {
+		t.Fatalf("Expected error ErrConflictNetworkAndIP, got: %s", err)
+	}
+}
+
+func TestParseEgress(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--egress=10.1.2.3/8", "--egress=192.168.1.10:443", "img", "cmd"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(hostConfig.EgressRules) != 2 || hostConfig.EgressRules[0] != "10.0.0.0/8" || hostConfig.EgressRules[1] != "192.168.1.10/32:443/tcp" {
+		t.Fatalf("Expected normalized egress rules in the host config, got %v", hostConfig.EgressRules)
+	}
+
+	if _, _, _, err := parseRun([]string{"--egress=example.com", "img", "cmd"}); err == nil {
+		t.Fatal("Expected an error parsing an invalid egress rule")
+	}
+	if _, _, _, err := parseRun([]string{"--net=host", "--egress=10.0.0.0/8", "img", "cmd"}); err != ErrConflictNetworkAndEgress {
+		t.Fatalf("Expected error ErrConflictNetworkAndEgress, got: %s", err)
+	}
+}
+
+func TestParseStorageOpt(t *testing.T) {
+	_, hostConfig, _, err := parseRun([]string{"--storage-opt=size=20G", "img", "cmd"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(hostConfig.StorageOpt) != 1 || hostConfig.StorageOpt["size"] != "20G" {
+		t.Fatalf("Expected the storage options in the host config, got %v", hostConfig.StorageOpt)
+	}
+
+	if _, _, _, err := parseRun([]string{"--storage-opt=size", "img", "cmd"}); err == nil {
+		t.Fatal("Expected an error parsing an invalid storage option")
+	}
+}
@@ -0,0 +1,168 @@
+package trust
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/docker/libtrust"
+)
+
+// Policy maps repositories to the keys their images must be signed by.
+type Policy struct {
+	Repositories []*PolicyRule `json:"repositories"`
+}
+
+// PolicyRule requires the manifests of the repositories whose name matches
+// Repository to be signed by one of Keys, or by a key certified by one of
+// CAs.
+type PolicyRule struct {
+	// Repository is a pattern, as for path.Match, matched against local
+	// repository names, e.g. registry.example.com/app/* or library/* for
+	// the official repositories.
+	Repository string `json:"repository"`
+	// Keys are files of trusted public keys, in PEM or JWK format.
+	Keys []string `json:"keys,omitempty"`
+	// CAs are files of PEM certificates trusted to certify signing keys.
+	CAs []string `json:"cas,omitempty"`
+
+	keyIDs map[string]bool
+	caPool *x509.CertPool
+}
+
+// LoadPolicy loads the trust policy stored as JSON in the given file,
+// together with the keys and certificates it refers to.
+func LoadPolicy(filename string) (*Policy, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, fmt.Errorf("Error parsing trust policy %s: %s", filename, err)
+	}
+	for _, rule := range policy.Repositories {
+		if err := rule.load(); err != nil {
+			return nil, fmt.Errorf("Error loading trust policy %s: %s", filename, err)
+		}
+	}
+	return &policy, nil
+}
+
+func (rule *PolicyRule) load() error {
+	if _, err := path.Match(rule.Repository, ""); err != nil || rule.Repository == "" {
+		return fmt.Errorf("invalid repository pattern %q", rule.Repository)
+	}
+	if len(rule.Keys) == 0 && len(rule.CAs) == 0 {
+		return fmt.Errorf("no keys or CAs trusted for %s", rule.Repository)
+	}
+	rule.keyIDs = make(map[string]bool)
+	for _, filename := range rule.Keys {
+		keys, err := libtrust.LoadKeySetFile(filename)
+		if err != nil || len(keys) == 0 {
+			// Not a key set, a single key.
+			key, err := libtrust.LoadPublicKeyFile(filename)
+			if err != nil {
+				return fmt.Errorf("unable to load key %s: %s", filename, err)
+			}
+			keys = []libtrust.PublicKey{key}
+		}
+		for _, key := range keys {
+			rule.keyIDs[key.KeyID()] = true
+		}
+	}
+	if len(rule.CAs) > 0 {
+		rule.caPool = x509.NewCertPool()
+		for _, filename := range rule.CAs {
+			certs, err := libtrust.LoadCertificateBundle(filename)
+			if err != nil {
+				return fmt.Errorf("unable to load CA %s: %s", filename, err)
+			}
+			for _, cert := range certs {
+				rule.caPool.AddCert(cert)
+			}
+		}
+	}
+	return nil
+}
+
+// Rule returns the first rule matching the repository, or nil if its images
+// don't need to be signed. The local names of official repositories, e.g.
+// busybox, are matched in the library namespace, as library/busybox.
+func (policy *Policy) Rule(name string) *PolicyRule {
+	normalized := name
+	if !strings.Contains(name, "/") {
+		normalized = "library/" + name
+	}
+	for _, rule := range policy.Repositories {
+		if matched, _ := path.Match(rule.Repository, normalized); matched {
+			return rule
+		}
+		if matched, _ := path.Match(rule.Repository, name); matched {
+			return rule
+		}
+	}
+	return nil
+}
+
+// signedReference is the part of the payload of a signed manifest naming the
+// image it was signed for.
+type signedReference struct {
+	Name string `json:"name"`
+	Tag  string `json:"tag"`
+}
+
+// Verify checks that the signed manifest is signed by a key trusted by the
+// rule and returns the ID of that key. The signed payload must name the
+// remote repository the image was pulled from and, unless tag is empty, the
+// tag pulled, so that a manifest signed for another repository matching the
+// rule can't be substituted.
+func (rule *PolicyRule) Verify(manifest []byte, remoteName, tag string) (string, error) {
+	if len(manifest) == 0 {
+		return "", fmt.Errorf("%s requires signed images and the image has no signed manifest", rule.Repository)
+	}
+	sig, err := libtrust.ParsePrettySignature(manifest, "signatures")
+	if err != nil {
+		return "", fmt.Errorf("error parsing manifest signatures: %s", err)
+	}
+	keys, err := sig.Verify()
+	if err != nil {
+		return "", fmt.Errorf("error verifying manifest signatures: %s", err)
+	}
+	payload, err := sig.Payload()
+	if err != nil {
+		return "", fmt.Errorf("error verifying manifest signatures: %s", err)
+	}
+	var ref signedReference
+	if err := json.Unmarshal(payload, &ref); err != nil {
+		return "", fmt.Errorf("error parsing manifest: %s", err)
+	}
+	if ref.Name != remoteName {
+		return "", fmt.Errorf("manifest is signed for %s, not %s", ref.Name, remoteName)
+	}
+	if tag != "" && ref.Tag != tag {
+		return "", fmt.Errorf("manifest is signed for tag %q, not %q", ref.Tag, tag)
+	}
+	for _, key := range keys {
+		if rule.keyIDs[key.KeyID()] {
+			return key.KeyID(), nil
+		}
+	}
+	if rule.caPool != nil {
+		chains, err := sig.VerifyChains(rule.caPool)
+		if err != nil {
+			return "", fmt.Errorf("manifest is not signed by a key trusted for %s: %s", rule.Repository, err)
+		}
+		if len(chains) > 0 {
+			key, err := libtrust.FromCryptoPublicKey(chains[0][0].PublicKey)
+			if err != nil {
+				return "", err
+			}
+			return key.KeyID(), nil
+		}
+	}
+	return "", fmt.Errorf("manifest is not signed by a key trusted for %s", rule.Repository)
+}
@@ -0,0 +1,240 @@
+package trust
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libtrust"
+)
+
+func generateKey(t *testing.T) libtrust.PrivateKey {
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// generateCert returns a certificate of the key issued by the issuer, or a
+// self-signed CA certificate if the issuer is nil.
+func generateCert(t *testing.T, key libtrust.PrivateKey, issuer *x509.Certificate, issuerKey libtrust.PrivateKey) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: key.KeyID()},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.CryptoPublicKey(), issuerKey.CryptoPrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func signManifest(t *testing.T, key libtrust.PrivateKey, chain []*x509.Certificate) []byte {
+	return signManifestFor(t, key, chain, "foo/bar", "latest")
+}
+
+// signManifestFor signs a manifest of the image with the given remote name
+// and tag.
+func signManifestFor(t *testing.T, key libtrust.PrivateKey, chain []*x509.Certificate, name, tag string) []byte {
+	js, err := libtrust.NewJSONSignature([]byte(`{"name": "` + name + `", "tag": "` + tag + `", "schemaVersion": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain != nil {
+		err = js.SignWithChain(key, chain)
+	} else {
+		err = js.Sign(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := js.PrettySignature("signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		trustedKey = generateKey(t)
+		otherKey   = generateKey(t)
+		caKey      = generateKey(t)
+		certKey    = generateKey(t)
+		ca         = generateCert(t, caKey, nil, nil)
+		cert       = generateCert(t, certKey, ca, caKey)
+		otherCA    = generateCert(t, otherKey, nil, nil)
+	)
+	if err := libtrust.SavePublicKey(filepath.Join(dir, "trusted.json"), trustedKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	caFile := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), caFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	policyFile := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(policyFile, []byte(`{"repositories": [
+		{"repository": "example.com/keys/*", "keys": ["`+filepath.Join(dir, "trusted.json")+`"]},
+		{"repository": "example.com/ca/*", "cas": ["`+filepath.Join(dir, "ca.pem")+`"]}
+	]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		repository string
+		manifest   []byte
+		keyID      string
+	}{
+		{"example.com/keys/app", signManifest(t, trustedKey, nil), trustedKey.KeyID()},
+		{"example.com/keys/app", signManifest(t, otherKey, nil), ""},
+		{"example.com/keys/app", nil, ""},
+		{"example.com/ca/app", signManifest(t, certKey, []*x509.Certificate{cert}), certKey.KeyID()},
+		{"example.com/ca/app", signManifest(t, otherKey, []*x509.Certificate{otherCA}), ""},
+		{"example.com/ca/app", signManifest(t, trustedKey, nil), ""},
+	} {
+		rule := policy.Rule(test.repository)
+		if rule == nil {
+			t.Fatalf("Expected a rule for %s", test.repository)
+		}
+		keyID, err := rule.Verify(test.manifest, "foo/bar", "latest")
+		if test.keyID == "" && err == nil {
+			t.Fatalf("Expected the manifest of %s to be refused, got key %s", test.repository, keyID)
+		} else if test.keyID != "" && (err != nil || keyID != test.keyID) {
+			t.Fatalf("Expected the manifest of %s to be signed by %s, got %q, %v", test.repository, test.keyID, keyID, err)
+		}
+	}
+
+	for _, name := range []string{"example.com/keys", "example.com/keys/app/nested", "busybox"} {
+		if rule := policy.Rule(name); rule != nil {
+			t.Fatalf("Expected no rule for %s, got %s", name, rule.Repository)
+		}
+	}
+}
+
+func TestLoadInvalidPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for policy, expected := range map[string]string{
+		`{"repositories": [{"repository": "[", "keys": ["key.json"]}]}`:    "invalid repository pattern",
+		`{"repositories": [{"repository": "foo/*"}]}`:                      "no keys or CAs",
+		`{"repositories": [{"repository": "foo/*", "keys": ["missing"]}]}`: "unable to load key",
+		`{"repositories": `: "Error parsing trust policy",
+	} {
+		policyFile := filepath.Join(dir, "policy.json")
+		if err := ioutil.WriteFile(policyFile, []byte(policy), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadPolicy(policyFile); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %q loading %s, got %v", expected, policy, err)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewTrustStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := generateKey(t)
+	if keyID, err := store.CheckPolicy("example.com/app", "app", "latest", nil); err != nil || keyID != "" {
+		t.Fatalf("Expected images to be accepted without a policy, got %q, %v", keyID, err)
+	}
+
+	store.SetPolicy(&Policy{Repositories: []*PolicyRule{{
+		Repository: "example.com/*",
+		keyIDs:     map[string]bool{key.KeyID(): true},
+	}}})
+	if _, err := store.CheckPolicy("example.com/app", "app", "latest", nil); err == nil {
+		t.Fatal("Expected an unsigned image to be refused")
+	}
+	if keyID, err := store.CheckPolicy("example.com/app", "foo/bar", "latest", signManifest(t, key, nil)); err != nil || keyID != key.KeyID() {
+		t.Fatalf("Expected the image to be signed by %s, got %q, %v", key.KeyID(), keyID, err)
+	}
+	if keyID, err := store.CheckPolicy("other.com/app", "app", "latest", nil); err != nil || keyID != "" {
+		t.Fatalf("Expected images of other repositories to be accepted, got %q, %v", keyID, err)
+	}
+}
+
+func TestPolicyRuleOfficialRepositories(t *testing.T) {
+	official := &PolicyRule{Repository: "library/*"}
+	local := &PolicyRule{Repository: "app"}
+	policy := &Policy{Repositories: []*PolicyRule{official, local}}
+	for name, expected := range map[string]*PolicyRule{
+		"busybox":         official,
+		"library/busybox": official,
+		"app":             official,
+		"user/app":        nil,
+		"example.com/app": nil,
+	} {
+		if rule := policy.Rule(name); rule != expected {
+			t.Fatalf("Expected %s to match %v, got %v", name, expected, rule)
+		}
+	}
+	policy.Repositories = []*PolicyRule{local}
+	if rule := policy.Rule("app"); rule != local {
+		t.Fatalf("Expected app to match its local name, got %v", rule)
+	}
+}
+
+func TestPolicyRuleSubstitutedManifest(t *testing.T) {
+	key := generateKey(t)
+	rule := &PolicyRule{
+		Repository: "example.com/app/*",
+		keyIDs:     map[string]bool{key.KeyID(): true},
+	}
+	manifest := signManifestFor(t, key, nil, "app/a", "1.0")
+
+	if keyID, err := rule.Verify(manifest, "app/a", "1.0"); err != nil || keyID != key.KeyID() {
+		t.Fatalf("Expected the manifest of app/a to be signed by %s, got %q, %v", key.KeyID(), keyID, err)
+	}
+	if keyID, err := rule.Verify(manifest, "app/a", ""); err != nil || keyID != key.KeyID() {
+		t.Fatalf("Expected the manifest of app/a pulled by digest to be signed by %s, got %q, %v", key.KeyID(), keyID, err)
+	}
+	if _, err := rule.Verify(manifest, "app/b", "1.0"); err == nil || !strings.Contains(err.Error(), "signed for app/a") {
+		t.Fatalf("Expected the manifest of app/a to be refused for app/b, got %v", err)
+	}
+	if _, err := rule.Verify(manifest, "app/a", "latest"); err == nil || !strings.Contains(err.Error(), "signed for tag") {
+		t.Fatalf("Expected the manifest of app/a:1.0 to be refused for app/a:latest, got %v", err)
+	}
+}
@@ -11,8 +11,9 @@ import (
 
 func (t *TrustStore) Install(eng *engine.Engine) error {
 	for name, handler := range map[string]engine.Handler{
-		"trust_key_check":   t.CmdCheckKey,
-		"trust_update_base": t.CmdUpdateBase,
+		"trust_key_check":    t.CmdCheckKey,
+		"trust_policy_check": t.CmdCheckPolicy,
+		"trust_update_base":  t.CmdUpdateBase,
 	} {
 		if err := eng.Register(name, handler); err != nil {
 			return fmt.Errorf("Could not register %q: %v", name, err)
@@ -67,6 +68,22 @@ func (t *TrustStore) CmdCheckKey(job *engine.Job) error {
 	return nil
 }
 
+// CmdCheckPolicy checks the signed manifest given in the Manifest env of an
+// image of the repository against the trust policy. The manifest must be
+// signed for the RemoteName env and, if set, the Tag env. It prints the ID of
+// the trusted key the manifest is signed by, if the policy applies.
+func (t *TrustStore) CmdCheckPolicy(job *engine.Job) error {
+	if n := len(job.Args); n != 1 {
+		return fmt.Errorf("Usage: %s NAME", job.Name)
+	}
+	keyID, err := t.CheckPolicy(job.Args[0], job.Getenv("RemoteName"), job.Getenv("Tag"), []byte(job.Getenv("Manifest")))
+	if err != nil {
+		return err
+	}
+	job.Stdout.Write([]byte(keyID))
+	return nil
+}
+
 func (t *TrustStore) CmdUpdateBase(job *engine.Job) error {
 	t.fetch()
 
@@ -26,6 +26,7 @@ type TrustStore struct {
 	autofetch     bool
 	httpClient    *http.Client
 	baseEndpoints map[string]*url.URL
+	policy        *Policy
 
 	sync.RWMutex
 }
@@ -70,6 +71,31 @@ func NewTrustStore(path string) (*TrustStore, error) {
 	return t, nil
 }
 
+// SetPolicy sets the policy enforced on the images of repositories.
+func (t *TrustStore) SetPolicy(policy *Policy) {
+	t.Lock()
+	defer t.Unlock()
+	t.policy = policy
+}
+
+// CheckPolicy checks that the signed manifest of an image of the repository
+// complies with the trust policy, returning the ID of the trusted key it is
+// signed by. The ID is empty if the policy doesn't apply to the repository.
+// The manifest must be signed for the remote name of the repository and, if
+// it isn't empty, the tag.
+func (t *TrustStore) CheckPolicy(name, remoteName, tag string, manifest []byte) (string, error) {
+	t.RLock()
+	defer t.RUnlock()
+	if t.policy == nil {
+		return "", nil
+	}
+	rule := t.policy.Rule(name)
+	if rule == nil {
+		return "", nil
+	}
+	return rule.Verify(manifest, remoteName, tag)
+}
+
 func (t *TrustStore) reload() error {
 	t.Lock()
 	defer t.Unlock()